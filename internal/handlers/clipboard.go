@@ -6,12 +6,13 @@ import (
 	"crypto/subtle"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"net"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -21,27 +22,54 @@ import (
 
 var tabNameRegex = regexp.MustCompile(`^[a-zA-Z0-9 _-]{1,50}$`)
 
-const maxClipboardImageSize = 16 << 20
+// maxClipboardContentSize is the largest text a clipboard tab can hold.
+const maxClipboardContentSize = 1 << 20
 
-// ImageEntry holds metadata for a single uploaded screenshot
+// ImageEntry holds metadata for a single uploaded screenshot. Every screenshot
+// belongs to the gallery of a clipboard tab and is protected by its token.
 type ImageEntry struct {
 	ID          string    `json:"id"`
+	Tab         string    `json:"tab"`
 	Size        int       `json:"size"`
 	ContentType string    `json:"contentType"`
 	UpdatedAt   time.Time `json:"updatedAt"`
+	Width       int       `json:"width,omitempty"` // 0 when the image couldn't be decoded
+	Height      int       `json:"height,omitempty"`
 	Data        []byte    `json:"-"`
+	// Thumbnail is a downscaled copy for the gallery; nil when the image
+	// is small enough or can't be decoded.
+	Thumbnail     []byte `json:"-"`
+	ThumbnailType string `json:"-"`
 }
 
 type screenshotStore struct {
-	images []ImageEntry
-	mu     sync.RWMutex
+	images    []ImageEntry // oldest first
+	mu        sync.RWMutex
+	statePath string     // screenshot list file; empty = persistence disabled
+	saveMu    sync.Mutex // serializes writes to the state directory
+	dirty     bool       // images were removed since the last save
 }
 
 // ClipboardEntry holds the content and metadata for a single clipboard tab.
 type ClipboardEntry struct {
 	Content   string
 	UpdatedAt time.Time
-	TokenHash string // SHA-256 hex of the token; empty = no protection
+	TokenHash string             // SHA-256 hex of the token; empty = no protection
+	History   []ClipboardVersion // previous contents, oldest first
+	Revision  int64              // incremented on every content change; exposed as the ETag
+	ExpiresAt time.Time          // when the tab is removed; zero = never
+	// BurnAfterRead removes the tab when its content is first read. Such tabs
+	// keep no history and can't be edited live, so nothing reveals the content
+	// without burning it.
+	BurnAfterRead bool
+	Encryption    *TabEncryption // key derivation parameters; nil = plaintext tab
+	Attachments   []TabAttachment
+
+	nextVersion    int64     // ID of the most recent History version
+	ops            []textOp  // recent changes, the last one producing Revision
+	lastOpAt       time.Time // time of the last live edit
+	attachmentsRev int64     // incremented when Attachments change; not persisted
+	screenshotsRev int64     // incremented when the tab's screenshots change; not persisted
 }
 
 // Protected reports whether this tab requires a token to access.
@@ -54,6 +82,10 @@ type clipboardStore struct {
 	tabs    map[string]*ClipboardEntry
 	mu      sync.RWMutex
 	maxTabs int
+
+	statePath   string     // file tabs are persisted to; empty = in-memory only
+	saveMu      sync.Mutex // serializes writes to statePath
+	savePending bool       // a delayed save is scheduled (see saveStateSoon)
 }
 
 func newClipboardStore(maxTabs int) *clipboardStore {
@@ -61,7 +93,7 @@ func newClipboardStore(maxTabs int) *clipboardStore {
 		tabs:    make(map[string]*ClipboardEntry),
 		maxTabs: maxTabs,
 	}
-	s.tabs["default"] = &ClipboardEntry{UpdatedAt: time.Now()}
+	s.tabs["default"] = &ClipboardEntry{UpdatedAt: time.Now(), Revision: 1}
 	return s
 }
 
@@ -107,6 +139,17 @@ func (b *clipboardBroker) unsubscribe(tabName string, ch chan struct{}) {
 	}
 }
 
+// SubscriberCount returns the number of active subscribers across all tabs.
+func (b *clipboardBroker) SubscriberCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	for _, subs := range b.subscribers {
+		n += len(subs)
+	}
+	return n
+}
+
 // Broadcast notifies all subscribers of tabName that its content changed.
 func (b *clipboardBroker) Broadcast(tabName string) {
 	b.mu.Lock()
@@ -154,30 +197,124 @@ func checkTabToken(entry *ClipboardEntry, r *http.Request) bool {
 	return subtle.ConstantTimeCompare([]byte(provided), []byte(entry.TokenHash)) == 1
 }
 
+// tabETag formats a tab revision as a strong entity tag.
+func tabETag(revision int64) string {
+	return `"` + strconv.FormatInt(revision, 10) + `"`
+}
+
+// ifMatch evaluates an If-Match header (RFC 9110 §13.1.1) against the current
+// revision of a tab. An absent header always matches; "*" matches any existing
+// tab. Weak tags never match because If-Match uses strong comparison.
+func ifMatch(header string, revision int64, exists bool) bool {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return true
+	}
+	if !exists {
+		return false
+	}
+	if header == "*" {
+		return true
+	}
+	current := tabETag(revision)
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimSpace(tag) == current {
+			return true
+		}
+	}
+	return false
+}
+
 // ClipboardHandler manages shared clipboard HTTP endpoints.
 type ClipboardHandler struct {
-	Quiet    bool
-	store    *clipboardStore
-	broker   *clipboardBroker
-	imgStore *screenshotStore
+	Quiet         bool
+	HistoryLimit  int           // previous versions kept per tab; 0 disables history
+	HistoryMaxAge time.Duration // versions replaced longer ago are dropped; 0 = no age limit
+	// ScreenshotLimits bounds the screenshot galleries. Set it before
+	// EnablePersistence so restored screenshots are held to it.
+	ScreenshotLimits ScreenshotLimits
+	// Limiter applies the clipboard-write and screenshot-upload rate limits.
+	Limiter *security.RateLimiter
+	// AllowedOrigins lists the other origins, or "*", whose pages may open
+	// live editing WebSockets, as configured with -cors-origins.
+	AllowedOrigins []string
+	store          *clipboardStore
+	broker         *clipboardBroker
+	collab         *collabHub
+	imgStore       *screenshotStore
 }
 
 // NewClipboardHandler creates a new ClipboardHandler with its own internal store.
 func NewClipboardHandler(quiet bool, maxTabs int) *ClipboardHandler {
 	return &ClipboardHandler{
-		Quiet:    quiet,
-		store:    newClipboardStore(maxTabs),
-		broker:   newClipboardBroker(),
-		imgStore: &screenshotStore{},
+		Quiet:            quiet,
+		HistoryLimit:     defaultHistoryLimit,
+		HistoryMaxAge:    defaultHistoryMaxAge,
+		ScreenshotLimits: DefaultScreenshotLimits(),
+		Limiter:          security.NewRateLimiter(nil),
+		store:            newClipboardStore(maxTabs),
+		broker:           newClipboardBroker(),
+		collab:           newCollabHub(),
+		imgStore:         &screenshotStore{},
 	}
 }
 
+// ClipboardStats is a point-in-time snapshot of clipboard resource usage.
+type ClipboardStats struct {
+	Tabs            int
+	Subscribers     int
+	LiveEditors     int
+	Screenshots     int
+	ScreenshotBytes int64
+	Attachments     int
+	AttachmentBytes int64
+}
+
+// Stats returns current tab, SSE subscriber, live editor, attachment and
+// screenshot store usage.
+func (ch *ClipboardHandler) Stats() ClipboardStats {
+	var s ClipboardStats
+
+	ch.store.mu.RLock()
+	s.Tabs = len(ch.store.tabs)
+	for _, entry := range ch.store.tabs {
+		s.Attachments += len(entry.Attachments)
+		s.AttachmentBytes += int64(entry.attachmentBytes())
+	}
+	ch.store.mu.RUnlock()
+
+	s.Subscribers = ch.broker.SubscriberCount()
+	s.LiveEditors = ch.collab.PeerCount()
+
+	ch.imgStore.mu.RLock()
+	s.Screenshots = len(ch.imgStore.images)
+	for _, img := range ch.imgStore.images {
+		s.ScreenshotBytes += int64(img.Size)
+	}
+	ch.imgStore.mu.RUnlock()
+
+	return s
+}
+
 // tabInfo is the JSON response item for /clipboard/tabs.
 type tabInfo struct {
 	Name      string    `json:"name"`
 	Size      int       `json:"size"`
 	UpdatedAt time.Time `json:"updatedAt"`
 	Protected bool      `json:"protected"`
+	Revision  int64     `json:"revision"`
+
+	ExpiresAt     *time.Time `json:"expiresAt,omitempty"`
+	ExpiresIn     int64      `json:"expiresIn,omitempty"` // seconds left
+	BurnAfterRead bool       `json:"burnAfterRead,omitempty"`
+
+	Encrypted  bool           `json:"encrypted"`
+	Encryption *TabEncryption `json:"encryption,omitempty"`
+
+	// Protected tabs only report how many attachments they hold: file names
+	// are listed after unlocking, by /clipboard/attachments.
+	AttachmentCount int             `json:"attachmentCount"`
+	Attachments     []TabAttachment `json:"attachments,omitempty"`
 }
 
 // ListTabs handles GET /clipboard/tabs — returns JSON array of tab metadata.
@@ -186,7 +323,6 @@ func (ch *ClipboardHandler) ListTabs() http.HandlerFunc {
 		if !ch.Quiet {
 			log.Printf("[%s] [%s] %s %s\n", time.Now().Format("2006-01-02 15:04:05"), r.Method, r.URL.String(), r.RemoteAddr)
 		}
-		setClipboardCORSHeaders(w)
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusOK)
 			return
@@ -196,15 +332,34 @@ func (ch *ClipboardHandler) ListTabs() http.HandlerFunc {
 			return
 		}
 
+		now := time.Now()
 		ch.store.mu.RLock()
 		list := make([]tabInfo, 0, len(ch.store.tabs))
 		for name, entry := range ch.store.tabs {
-			list = append(list, tabInfo{
-				Name:      name,
-				Size:      len(entry.Content),
-				UpdatedAt: entry.UpdatedAt,
-				Protected: entry.Protected(),
-			})
+			if entry.expired(now) {
+				continue
+			}
+			info := tabInfo{
+				Name:          name,
+				Size:          len(entry.Content),
+				UpdatedAt:     entry.UpdatedAt,
+				Protected:     entry.Protected(),
+				Revision:      entry.Revision,
+				BurnAfterRead: entry.BurnAfterRead,
+				Encrypted:     entry.Encrypted(),
+				Encryption:    entry.Encryption,
+
+				AttachmentCount: len(entry.Attachments),
+			}
+			if !entry.Protected() {
+				info.Attachments = append([]TabAttachment(nil), entry.Attachments...)
+			}
+			if !entry.ExpiresAt.IsZero() {
+				expiresAt := entry.ExpiresAt
+				info.ExpiresAt = &expiresAt
+				info.ExpiresIn = remainingSeconds(entry, now)
+			}
+			list = append(list, info)
 		}
 		ch.store.mu.RUnlock()
 
@@ -213,13 +368,12 @@ func (ch *ClipboardHandler) ListTabs() http.HandlerFunc {
 	}
 }
 
-// ListScreenshots handles GET /api/v1/screenshots
+// ListScreenshots handles GET /api/v1/screenshots?tab=<name>
 func (ch *ClipboardHandler) ListScreenshots() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if !ch.Quiet {
 			log.Printf("[%s] [%s] %s %s\n", time.Now().Format("2006-01-02 15:04:05"), r.Method, r.URL.String(), r.RemoteAddr)
 		}
-		setClipboardCORSHeaders(w)
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusOK)
 			return
@@ -229,22 +383,54 @@ func (ch *ClipboardHandler) ListScreenshots() http.HandlerFunc {
 			return
 		}
 
+		tabName := screenshotsTab(r)
+		ch.store.mu.RLock()
+		entry, ok := ch.store.lookup(tabName)
+		var hash string
+		if ok {
+			hash = entry.TokenHash
+		}
+		ch.store.mu.RUnlock()
+		if !ok {
+			http.Error(w, "Tab not found", http.StatusNotFound)
+			return
+		}
+		if !checkTabToken(&ClipboardEntry{TokenHash: hash}, r) {
+			w.Header().Set("WWW-Authenticate", `TabToken realm="Tab "`+tabName+`"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		limits := ch.ScreenshotLimits
+		resp := screenshotsResponse{
+			Tab:           tabName,
+			MaxCount:      limits.MaxCount,
+			MaxSize:       limits.MaxSize,
+			MaxBytes:      limits.MaxBytes,
+			MaxAgeSeconds: int64(limits.MaxAge / time.Second),
+			Images:        []ImageEntry{},
+		}
+		now := time.Now()
 		ch.imgStore.mu.RLock()
-		list := append([]ImageEntry(nil), ch.imgStore.images...)
+		resp.TotalBytes = ch.imgStore.totalBytes()
+		for _, img := range ch.imgStore.images {
+			if img.Tab == tabName && !limits.expired(img, now) {
+				resp.Images = append(resp.Images, img)
+			}
+		}
 		ch.imgStore.mu.RUnlock()
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(list)
+		json.NewEncoder(w).Encode(resp)
 	}
 }
 
-// UploadScreenshot handles POST /api/v1/screenshots
+// UploadScreenshot handles POST /api/v1/screenshots?tab=<name>
 func (ch *ClipboardHandler) UploadScreenshot() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if !ch.Quiet {
 			log.Printf("[%s] [%s] %s %s\n", time.Now().Format("2006-01-02 15:04:05"), r.Method, r.URL.String(), r.RemoteAddr)
 		}
-		setClipboardCORSHeaders(w)
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusOK)
 			return
@@ -254,16 +440,20 @@ func (ch *ClipboardHandler) UploadScreenshot() http.HandlerFunc {
 			return
 		}
 
-		clientIP := clipboardExtractIP(r)
-		if !security.CheckRateLimit(clientIP) {
-			http.Error(w, "Rate limit exceeded. Maximum 20 requests per minute.", http.StatusTooManyRequests)
+		if !ch.Limiter.AllowHTTP(w, security.PolicyScreenshotUpload, clipboardExtractIP(r)) {
 			return
 		}
 
-		r.Body = http.MaxBytesReader(w, r.Body, maxClipboardImageSize)
+		limits := ch.ScreenshotLimits
+		r.Body = http.MaxBytesReader(w, r.Body, limits.MaxSize)
 		defer r.Body.Close()
 
 		body, err := io.ReadAll(r.Body)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) || (limits.MaxBytes > 0 && int64(len(body)) > limits.MaxBytes) {
+			http.Error(w, "Screenshot exceeds the size limit", http.StatusRequestEntityTooLarge)
+			return
+		}
 		if err != nil {
 			http.Error(w, "Error reading data", http.StatusBadRequest)
 			return
@@ -281,21 +471,50 @@ func (ch *ClipboardHandler) UploadScreenshot() http.HandlerFunc {
 		}
 		id := plain[:16]
 
-		ch.imgStore.mu.Lock()
-		if len(ch.imgStore.images) >= 50 {
-			ch.imgStore.images = ch.imgStore.images[1:]
+		// Decoding the image is costly, so it waits until the request is known
+		// to be allowed, and runs outside the lock. The tab is checked again
+		// afterwards in case it changed meanwhile.
+		tabName := screenshotsTab(r)
+		ch.store.mu.Lock()
+		ok := ch.screenshotTabAllowedLocked(w, r, tabName)
+		ch.store.mu.Unlock()
+		if !ok {
+			return
+		}
+		width, height, thumb, thumbType := imageInfo(body, contentType)
+
+		ch.store.mu.Lock()
+		if !ch.screenshotTabAllowedLocked(w, r, tabName) {
+			ch.store.mu.Unlock()
+			return
 		}
 		entry := ImageEntry{
 			ID:          id,
+			Tab:         tabName,
 			Size:        len(body),
 			ContentType: contentType,
 			UpdatedAt:   time.Now(),
+			Width:       width,
+			Height:      height,
 			Data:        body,
+
+			Thumbnail:     thumb,
+			ThumbnailType: thumbType,
 		}
+		ch.imgStore.mu.Lock()
 		ch.imgStore.images = append(ch.imgStore.images, entry)
+		// the new screenshot may push older ones, in any tab, over the limits
+		changed := ch.imgStore.pruneLocked(limits, entry.UpdatedAt)
 		ch.imgStore.mu.Unlock()
+		changed[tabName] = true
+		ch.galleriesChangedLocked(changed)
+		ch.store.mu.Unlock()
 
-		ch.broker.Broadcast("screenshots-global")
+		if err := ch.imgStore.writeImage(entry); err != nil {
+			log.Printf("[%s] Error saving screenshot %s: %v\n", time.Now().Format("2006-01-02 15:04:05"), id, err)
+		}
+		ch.saveScreenshots()
+		ch.broadcastGalleries(changed)
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
@@ -303,13 +522,33 @@ func (ch *ClipboardHandler) UploadScreenshot() http.HandlerFunc {
 	}
 }
 
+// screenshotTabAllowedLocked reports whether r may add a screenshot to the
+// tab named tabName, writing the error response when it may not. It must be
+// called with ch.store.mu held.
+func (ch *ClipboardHandler) screenshotTabAllowedLocked(w http.ResponseWriter, r *http.Request, tabName string) bool {
+	tab, ok := ch.store.lookup(tabName)
+	if !ok {
+		http.Error(w, "Tab not found", http.StatusNotFound)
+		return false
+	}
+	if !checkTabToken(tab, r) {
+		w.Header().Set("WWW-Authenticate", `TabToken realm="Tab "`+tabName+`"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	if tab.BurnAfterRead || tab.Encrypted() {
+		http.Error(w, "Burn-after-read and encrypted tabs have no screenshot gallery", http.StatusConflict)
+		return false
+	}
+	return true
+}
+
 // GetScreenshot handles GET /api/v1/screenshots/image?id=...
 func (ch *ClipboardHandler) GetScreenshot() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if !ch.Quiet {
 			log.Printf("[%s] [%s] %s %s\n", time.Now().Format("2006-01-02 15:04:05"), r.Method, r.URL.String(), r.RemoteAddr)
 		}
-		setClipboardCORSHeaders(w)
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusOK)
 			return
@@ -324,31 +563,7 @@ func (ch *ClipboardHandler) GetScreenshot() http.HandlerFunc {
 			http.Error(w, "Missing id parameter", http.StatusBadRequest)
 			return
 		}
-
-		ch.imgStore.mu.RLock()
-		var found *ImageEntry
-		for i := range ch.imgStore.images {
-			if ch.imgStore.images[i].ID == id {
-				found = &ch.imgStore.images[i]
-				break
-			}
-		}
-		var data []byte
-		var contentType string
-		if found != nil {
-			data = append([]byte(nil), found.Data...)
-			contentType = found.ContentType
-		}
-		ch.imgStore.mu.RUnlock()
-
-		if found == nil {
-			http.Error(w, "Image not found", http.StatusNotFound)
-			return
-		}
-
-		w.Header().Set("Content-Type", contentType)
-		w.Header().Set("Cache-Control", "no-store")
-		w.Write(data)
+		ch.serveScreenshot(w, r, id, false)
 	}
 }
 
@@ -358,7 +573,6 @@ func (ch *ClipboardHandler) DeleteScreenshot() http.HandlerFunc {
 		if !ch.Quiet {
 			log.Printf("[%s] [%s] %s %s\n", time.Now().Format("2006-01-02 15:04:05"), r.Method, r.URL.String(), r.RemoteAddr)
 		}
-		setClipboardCORSHeaders(w)
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusOK)
 			return
@@ -368,9 +582,7 @@ func (ch *ClipboardHandler) DeleteScreenshot() http.HandlerFunc {
 			return
 		}
 
-		clientIP := clipboardExtractIP(r)
-		if !security.CheckRateLimit(clientIP) {
-			http.Error(w, "Rate limit exceeded. Maximum 20 requests per minute.", http.StatusTooManyRequests)
+		if !ch.Limiter.AllowHTTP(w, security.PolicyClipboardWrite, clipboardExtractIP(r)) {
 			return
 		}
 
@@ -380,6 +592,25 @@ func (ch *ClipboardHandler) DeleteScreenshot() http.HandlerFunc {
 			return
 		}
 
+		img, ok := ch.imgStore.find(id)
+		if !ok || ch.ScreenshotLimits.expired(img, time.Now()) {
+			http.Error(w, "Image not found", http.StatusNotFound)
+			return
+		}
+
+		ch.store.mu.Lock()
+		tab, ok := ch.store.lookup(img.Tab)
+		if !ok {
+			ch.store.mu.Unlock()
+			http.Error(w, "Image not found", http.StatusNotFound)
+			return
+		}
+		if !checkTabToken(tab, r) {
+			ch.store.mu.Unlock()
+			w.Header().Set("WWW-Authenticate", `TabToken realm="Tab "`+img.Tab+`"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
 		ch.imgStore.mu.Lock()
 		idx := -1
 		for i := range ch.imgStore.images {
@@ -392,13 +623,18 @@ func (ch *ClipboardHandler) DeleteScreenshot() http.HandlerFunc {
 			ch.imgStore.images = append(ch.imgStore.images[:idx], ch.imgStore.images[idx+1:]...)
 		}
 		ch.imgStore.mu.Unlock()
+		if idx != -1 {
+			ch.screenshotsChanged(img.Tab, tab)
+		}
+		ch.store.mu.Unlock()
 
 		if idx == -1 {
 			http.Error(w, "Image not found", http.StatusNotFound)
 			return
 		}
 
-		ch.broker.Broadcast("screenshots-global")
+		ch.saveScreenshots()
+		ch.broker.Broadcast(img.Tab)
 		w.WriteHeader(http.StatusOK)
 	}
 }
@@ -409,7 +645,6 @@ func (ch *ClipboardHandler) Handle() http.HandlerFunc {
 		if !ch.Quiet {
 			log.Printf("[%s] [%s] %s %s\n", time.Now().Format("2006-01-02 15:04:05"), r.Method, r.URL.String(), r.RemoteAddr)
 		}
-		setClipboardCORSHeaders(w)
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusOK)
 			return
@@ -436,11 +671,15 @@ func (ch *ClipboardHandler) Handle() http.HandlerFunc {
 func (ch *ClipboardHandler) handleGet(w http.ResponseWriter, r *http.Request, tabName string) {
 	// Copy the fields we need while holding the lock to avoid data races.
 	ch.store.mu.RLock()
-	entry, ok := ch.store.tabs[tabName]
+	entry, ok := ch.store.lookup(tabName)
 	var content, hash string
+	var revision int64
+	var burn bool
 	if ok {
 		content = entry.Content
 		hash = entry.TokenHash
+		revision = entry.Revision
+		burn = entry.BurnAfterRead && entry.Content != ""
 	}
 	ch.store.mu.RUnlock()
 
@@ -456,6 +695,18 @@ func (ch *ClipboardHandler) handleGet(w http.ResponseWriter, r *http.Request, ta
 		return
 	}
 
+	// Reading a burn-after-read tab destroys it, so only one reader gets the
+	// content. An empty tab is left alone: its writer hasn't pasted anything yet.
+	if burn {
+		if content, ok = ch.burnTab(tabName, entry); !ok {
+			http.Error(w, "Tab not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("X-Tab-Burned", "1")
+		w.Header().Set("Cache-Control", "no-store")
+	} else {
+		w.Header().Set("ETag", tabETag(revision))
+	}
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.Write([]byte(content))
 	if !ch.Quiet {
@@ -470,15 +721,14 @@ func (ch *ClipboardHandler) handlePost(w http.ResponseWriter, r *http.Request, t
 	}
 
 	clientIP := clipboardExtractIP(r)
-	if !security.CheckRateLimit(clientIP) {
-		http.Error(w, "Rate limit exceeded. Maximum 20 requests per minute.", http.StatusTooManyRequests)
+	if !ch.Limiter.AllowHTTP(w, security.PolicyClipboardWrite, clientIP) {
 		if !ch.Quiet {
 			log.Printf("[%s] Rate limit exceeded for IP: %s\n", time.Now().Format("2006-01-02 15:04:05"), clientIP)
 		}
 		return
 	}
 
-	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+	r.Body = http.MaxBytesReader(w, r.Body, maxClipboardContentSize)
 	defer r.Body.Close()
 
 	body, err := io.ReadAll(r.Body)
@@ -489,10 +739,49 @@ func (ch *ClipboardHandler) handlePost(w http.ResponseWriter, r *http.Request, t
 
 	wantsToken := r.Header.Get("X-Tab-Token-Create") == "1"
 	customToken := r.Header.Get("X-Tab-Token-Value") // optional: user-defined password
+	lifetime, err := parseTabLifetime(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	encryption, err := parseTabEncryption(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	ch.store.mu.Lock()
+	if expired, ok := ch.store.tabs[tabName]; ok && expired.expired(time.Now()) {
+		ch.removeTabLocked(tabName) // gone for good: the name can be reused
+	}
 	existing, exists := ch.store.tabs[tabName]
 
+	// A client that sends If-Match only wants to overwrite the revision it last saw.
+	// The token is checked first so a 412 never leaks the revision of a locked tab.
+	if exists && !checkTabToken(existing, r) {
+		ch.store.mu.Unlock()
+		w.Header().Set("WWW-Authenticate", `TabToken realm="Tab "`+tabName+`"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !ifMatch(r.Header.Get("If-Match"), revisionOf(existing), exists) {
+		ch.store.mu.Unlock()
+		if exists {
+			w.Header().Set("ETag", tabETag(existing.Revision))
+		}
+		http.Error(w, "Tab was modified by someone else", http.StatusPreconditionFailed)
+		if !ch.Quiet {
+			log.Printf("[%s] Clipboard tab %q: If-Match precondition failed\n", time.Now().Format("2006-01-02 15:04:05"), tabName)
+		}
+		return
+	}
+	// An encrypted tab only ever holds ciphertext, whoever writes to it.
+	if (exists && existing.Encrypted() || !exists && encryption != nil) && !validEncryptedContent(string(body)) {
+		ch.store.mu.Unlock()
+		http.Error(w, "Encrypted tabs only accept encrypted content", http.StatusBadRequest)
+		return
+	}
+
 	// Creating a new tab
 	if !exists {
 		if len(ch.store.tabs) >= ch.store.maxTabs {
@@ -500,7 +789,9 @@ func (ch *ClipboardHandler) handlePost(w http.ResponseWriter, r *http.Request, t
 			http.Error(w, "Maximum number of tabs reached", http.StatusForbidden)
 			return
 		}
-		entry := &ClipboardEntry{Content: string(body), UpdatedAt: time.Now()}
+		entry := &ClipboardEntry{Content: string(body), UpdatedAt: time.Now(), Revision: 1}
+		lifetime.apply(entry, entry.UpdatedAt)
+		entry.Encryption = encryption
 		if wantsToken {
 			if customToken != "" {
 				// User-defined token: validate minimum length then hash and store.
@@ -513,7 +804,9 @@ func (ch *ClipboardHandler) handlePost(w http.ResponseWriter, r *http.Request, t
 				entry.TokenHash = hex.EncodeToString(sum[:])
 				ch.store.tabs[tabName] = entry
 				ch.store.mu.Unlock()
+				ch.saveState()
 				// No X-Generated-Token header — user already knows their own token.
+				w.Header().Set("ETag", tabETag(entry.Revision))
 				w.WriteHeader(http.StatusCreated)
 				if !ch.Quiet {
 					log.Printf("[%s] Clipboard tab %q created (protected, custom token)\n", time.Now().Format("2006-01-02 15:04:05"), tabName)
@@ -530,7 +823,9 @@ func (ch *ClipboardHandler) handlePost(w http.ResponseWriter, r *http.Request, t
 			entry.TokenHash = hash
 			ch.store.tabs[tabName] = entry
 			ch.store.mu.Unlock()
+			ch.saveState()
 			w.Header().Set("X-Generated-Token", plain)
+			w.Header().Set("ETag", tabETag(entry.Revision))
 			w.WriteHeader(http.StatusCreated)
 			if !ch.Quiet {
 				log.Printf("[%s] Clipboard tab %q created (protected)\n", time.Now().Format("2006-01-02 15:04:05"), tabName)
@@ -539,6 +834,8 @@ func (ch *ClipboardHandler) handlePost(w http.ResponseWriter, r *http.Request, t
 		}
 		ch.store.tabs[tabName] = entry
 		ch.store.mu.Unlock()
+		ch.saveState()
+		w.Header().Set("ETag", tabETag(entry.Revision))
 		w.WriteHeader(http.StatusCreated)
 		if !ch.Quiet {
 			log.Printf("[%s] Clipboard tab %q created\n", time.Now().Format("2006-01-02 15:04:05"), tabName)
@@ -546,39 +843,51 @@ func (ch *ClipboardHandler) handlePost(w http.ResponseWriter, r *http.Request, t
 		return
 	}
 
-	// Updating an existing tab — check token before writing
-	if !checkTabToken(existing, r) {
-		ch.store.mu.Unlock()
-		w.Header().Set("WWW-Authenticate", `TabToken realm="Tab "`+tabName+`"`)
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
+	// Updating an existing tab (token already checked above)
+	now := time.Now()
+	old := existing.Content
+	if old != string(body) {
+		ch.pushHistory(existing, now)
 	}
 	existing.Content = string(body)
-	existing.UpdatedAt = time.Now()
+	existing.UpdatedAt = now
+	if old != existing.Content {
+		existing.Revision++
+		ch.recordReplace(tabName, existing, old)
+	}
+	revision := existing.Revision
 	ch.store.mu.Unlock()
 
+	ch.saveState()
 	ch.broker.Broadcast(tabName)
 
+	w.Header().Set("ETag", tabETag(revision))
 	w.WriteHeader(http.StatusOK)
 	if !ch.Quiet {
 		log.Printf("[%s] Clipboard tab %q updated (%d chars)\n", time.Now().Format("2006-01-02 15:04:05"), tabName, len(body))
 	}
 }
 
+// revisionOf returns the revision of entry, or 0 for a tab that doesn't exist.
+func revisionOf(entry *ClipboardEntry) int64 {
+	if entry == nil {
+		return 0
+	}
+	return entry.Revision
+}
+
 func (ch *ClipboardHandler) handleDelete(w http.ResponseWriter, r *http.Request, tabName string) {
 	if tabName == "default" {
 		http.Error(w, "Cannot delete default tab", http.StatusForbidden)
 		return
 	}
 
-	clientIP := clipboardExtractIP(r)
-	if !security.CheckRateLimit(clientIP) {
-		http.Error(w, "Rate limit exceeded. Maximum 20 requests per minute.", http.StatusTooManyRequests)
+	if !ch.Limiter.AllowHTTP(w, security.PolicyClipboardWrite, clipboardExtractIP(r)) {
 		return
 	}
 
 	ch.store.mu.Lock()
-	entry, ok := ch.store.tabs[tabName]
+	entry, ok := ch.store.lookup(tabName)
 	if ok {
 		if !checkTabToken(entry, r) {
 			ch.store.mu.Unlock()
@@ -586,7 +895,7 @@ func (ch *ClipboardHandler) handleDelete(w http.ResponseWriter, r *http.Request,
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
-		delete(ch.store.tabs, tabName)
+		ch.removeTabLocked(tabName)
 	}
 	ch.store.mu.Unlock()
 
@@ -594,6 +903,7 @@ func (ch *ClipboardHandler) handleDelete(w http.ResponseWriter, r *http.Request,
 		http.Error(w, "Tab not found", http.StatusNotFound)
 		return
 	}
+	ch.saveState()
 	w.WriteHeader(http.StatusOK)
 	if !ch.Quiet {
 		log.Printf("[%s] Clipboard tab %q deleted\n", time.Now().Format("2006-01-02 15:04:05"), tabName)
@@ -608,7 +918,6 @@ func (ch *ClipboardHandler) Screenshots() http.HandlerFunc {
 		} else if r.Method == http.MethodPost {
 			ch.UploadScreenshot()(w, r)
 		} else if r.Method == http.MethodOptions {
-			setClipboardCORSHeaders(w)
 			w.WriteHeader(http.StatusOK)
 		} else {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -624,7 +933,6 @@ func (ch *ClipboardHandler) ScreenshotImage() http.HandlerFunc {
 		} else if r.Method == http.MethodDelete {
 			ch.DeleteScreenshot()(w, r)
 		} else if r.Method == http.MethodOptions {
-			setClipboardCORSHeaders(w)
 			w.WriteHeader(http.StatusOK)
 		} else {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -638,7 +946,6 @@ func (ch *ClipboardHandler) ServeScreenshotDirect() http.HandlerFunc {
 		if !ch.Quiet {
 			log.Printf("[%s] [%s] %s %s\n", time.Now().Format("2006-01-02 15:04:05"), r.Method, r.URL.String(), r.RemoteAddr)
 		}
-		setClipboardCORSHeaders(w)
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusOK)
 			return
@@ -653,35 +960,47 @@ func (ch *ClipboardHandler) ServeScreenshotDirect() http.HandlerFunc {
 			http.Error(w, "Missing image ID", http.StatusBadRequest)
 			return
 		}
+		ch.serveScreenshot(w, r, id, false)
+	}
+}
 
-		ch.imgStore.mu.RLock()
-		var found *ImageEntry
-		for i := range ch.imgStore.images {
-			if ch.imgStore.images[i].ID == id {
-				found = &ch.imgStore.images[i]
-				break
-			}
-		}
-		var data []byte
-		var contentType string
-		if found != nil {
-			data = append([]byte(nil), found.Data...)
-			contentType = found.ContentType
-		}
-		ch.imgStore.mu.RUnlock()
+// serveScreenshot writes the screenshot called id, or its thumbnail when thumb
+// is set and it has one, if the request carries the token of its tab. The
+// token may also be the X-Tab-Token query parameter, so direct links and
+// <img> tags work for protected tabs.
+func (ch *ClipboardHandler) serveScreenshot(w http.ResponseWriter, r *http.Request, id string, thumb bool) {
+	img, ok := ch.imgStore.find(id)
+	if !ok || ch.ScreenshotLimits.expired(img, time.Now()) {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
 
-		if found == nil {
-			http.Error(w, "Image not found", http.StatusNotFound)
-			return
-		}
+	ch.store.mu.RLock()
+	tab, ok := ch.store.lookup(img.Tab)
+	var hash string
+	if ok {
+		hash = tab.TokenHash
+	}
+	ch.store.mu.RUnlock()
+	if !ok {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+	if !streamTokenOK(hash, r) {
+		w.Header().Set("WWW-Authenticate", `TabToken realm="Tab "`+img.Tab+`"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
 
-		w.Header().Set("Content-Type", contentType)
-		w.Header().Set("Cache-Control", "no-store")
-		w.Write(data)
+	contentType, data := img.ContentType, img.Data
+	if thumb && img.Thumbnail != nil {
+		contentType, data = img.ThumbnailType, img.Thumbnail
 	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(data)
 }
 
-
 // ClipboardStream handles GET /clipboard/stream?tab=<name> for Server-Sent Events.
 // Each connected client receives a "change" event whenever the tab content is updated.
 func (ch *ClipboardHandler) ClipboardStream() http.HandlerFunc {
@@ -703,7 +1022,7 @@ func (ch *ClipboardHandler) ClipboardStream() http.HandlerFunc {
 
 		// Verify the tab exists.
 		ch.store.mu.RLock()
-		entry, ok := ch.store.tabs[tabName]
+		entry, ok := ch.store.lookup(tabName)
 		var tokenHash string
 		if ok {
 			tokenHash = entry.TokenHash
@@ -718,21 +1037,10 @@ func (ch *ClipboardHandler) ClipboardStream() http.HandlerFunc {
 		// EventSource cannot set custom request headers, so for SSE connections
 		// the token is also accepted via the "X-Tab-Token" query parameter.
 		// Regular REST handlers continue to use the header only (via checkTabToken).
-		if tokenHash != "" {
-			provided := r.Header.Get("X-Tab-Token")
-			if provided == "" {
-				provided = r.URL.Query().Get("X-Tab-Token")
-			}
-			hashed := ""
-			if provided != "" {
-				sum := sha256.Sum256([]byte(provided))
-				hashed = hex.EncodeToString(sum[:])
-			}
-			if subtle.ConstantTimeCompare([]byte(hashed), []byte(tokenHash)) != 1 {
-				w.Header().Set("WWW-Authenticate", `TabToken realm="Tab "`+tabName+`"`)
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
+		if !streamTokenOK(tokenHash, r) {
+			w.Header().Set("WWW-Authenticate", `TabToken realm="Tab "`+tabName+`"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
 		}
 
 		// Ensure the ResponseWriter supports flushing.
@@ -745,7 +1053,6 @@ func (ch *ClipboardHandler) ClipboardStream() http.HandlerFunc {
 		// Set SSE headers.
 		// NOTE: Do NOT set "Connection: keep-alive" — it is forbidden in HTTP/2
 		// and causes ERR_HTTP2_PROTOCOL_ERROR in Chrome even on HTTP/1.1 connections.
-		setClipboardCORSHeaders(w)
 		w.Header().Set("Content-Type", "text/event-stream")
 		w.Header().Set("Cache-Control", "no-cache")
 		w.Header().Set("X-Accel-Buffering", "no") // disable nginx buffering
@@ -757,6 +1064,14 @@ func (ch *ClipboardHandler) ClipboardStream() http.HandlerFunc {
 
 		// Send an initial heartbeat so the client knows the connection is open.
 		fmt.Fprintf(w, ": connected\n\n")
+		// Each change event carries the revision as its SSE id, so a reconnecting
+		// EventSource sends Last-Event-ID. If the tab moved on while it was away,
+		// replay a change event right away instead of waiting for the next save.
+		if last := r.Header.Get("Last-Event-ID"); last != "" {
+			if ev, ok := ch.tabChange(tabName); ok && strconv.FormatInt(ev.Revision, 10) != last {
+				writeChangeEvent(w, ev)
+			}
+		}
 		flusher.Flush()
 
 		// deadlineResetter lets us extend the write deadline on each heartbeat
@@ -778,11 +1093,16 @@ func (ch *ClipboardHandler) ClipboardStream() http.HandlerFunc {
 				// Client disconnected — unsubscribe is called by defer.
 				return
 			case <-notify:
-				// Tab content changed: send a "change" event.
+				// Tab content or attachments changed: send a "change" event
+				// with the new revisions.
+				ev, ok := ch.tabChange(tabName)
+				if !ok {
+					continue // tab was deleted
+				}
 				if canReset {
 					dw.SetWriteDeadline(time.Now().Add(55 * time.Second))
 				}
-				fmt.Fprintf(w, "event: change\ndata: %s\n\n", tabName)
+				writeChangeEvent(w, ev)
 				flusher.Flush()
 			case <-ticker.C:
 				// Heartbeat comment to prevent proxy timeouts.
@@ -798,10 +1118,30 @@ func (ch *ClipboardHandler) ClipboardStream() http.HandlerFunc {
 	}
 }
 
-func setClipboardCORSHeaders(w http.ResponseWriter) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Tab-Token, X-Tab-Token-Create, X-Tab-Token-Value")
+// tabChange returns the change event describing the current state of tabName.
+func (ch *ClipboardHandler) tabChange(tabName string) (changeEvent, bool) {
+	ch.store.mu.RLock()
+	defer ch.store.mu.RUnlock()
+	entry, ok := ch.store.lookup(tabName)
+	if !ok {
+		return changeEvent{}, false
+	}
+	return changeEvent{Tab: tabName, Revision: entry.Revision, Attachments: entry.attachmentsRev, Screenshots: entry.screenshotsRev}, true
+}
+
+// changeEvent is the data payload of an SSE "change" event. Attachments and
+// Screenshots change whenever a file or screenshot is added or removed, so
+// clients know when to reload those lists.
+type changeEvent struct {
+	Tab         string `json:"tab"`
+	Revision    int64  `json:"revision"`
+	Attachments int64  `json:"attachments,omitempty"`
+	Screenshots int64  `json:"screenshots,omitempty"`
+}
+
+func writeChangeEvent(w io.Writer, ev changeEvent) {
+	data, _ := json.Marshal(ev)
+	fmt.Fprintf(w, "event: change\nid: %d\ndata: %s\n\n", ev.Revision, data)
 }
 
 func normalizeClipboardImageContentType(headerValue string, data []byte) string {
@@ -834,9 +1174,5 @@ func isAllowedClipboardImageContentType(contentType string, data []byte) bool {
 }
 
 func clipboardExtractIP(r *http.Request) string {
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return r.RemoteAddr
-	}
-	return ip
+	return security.ClientIP(r)
 }
@@ -0,0 +1,425 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/wanetty/upgopher/internal/security"
+	"github.com/wanetty/upgopher/internal/utils"
+)
+
+const (
+	// maxAttachmentSize is the largest file that can be attached to a tab.
+	maxAttachmentSize = 10 << 20
+	// maxTabAttachments and maxTabAttachmentBytes cap what a single tab holds.
+	maxTabAttachments     = 10
+	maxTabAttachmentBytes = 20 << 20
+
+	// clipboardAttachmentsDir holds one file per attachment inside -state-dir.
+	clipboardAttachmentsDir = "clipboard-attachments"
+)
+
+// blobIDRegex matches attachment and screenshot IDs, which name their files
+// under -state-dir.
+var blobIDRegex = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+// TabAttachment is a file attached to a clipboard tab.
+type TabAttachment struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Size        int       `json:"size"`
+	ContentType string    `json:"contentType"`
+	UploadedAt  time.Time `json:"uploadedAt"`
+	Data        []byte    `json:"-"`
+}
+
+// attachmentBytes returns the total size of the tab's attachments.
+func (e *ClipboardEntry) attachmentBytes() int {
+	n := 0
+	for _, a := range e.Attachments {
+		n += a.Size
+	}
+	return n
+}
+
+// attachmentsChanged tells SSE subscribers and live editors that the
+// attachments of tab changed. The caller must hold the store write lock,
+// after incrementing entry.attachmentsRev.
+func (ch *ClipboardHandler) attachmentsChanged(tab string, entry *ClipboardEntry) {
+	h := ch.collab
+	h.mu.Lock()
+	h.publish(tab, collabEvent{Type: "attachments", Attachments: entry.attachmentsRev}, nil)
+	h.mu.Unlock()
+}
+
+// cleanAttachmentName reduces a client-supplied file name to its last path
+// element and rejects names that can't be offered back as a download.
+func cleanAttachmentName(name string) (string, bool) {
+	name = path.Base(strings.ReplaceAll(strings.TrimSpace(name), `\`, "/"))
+	if name == "." || name == "/" || name == ".." || len(name) > 255 || !utf8.ValidString(name) {
+		return "", false
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return "", false
+		}
+	}
+	return name, true
+}
+
+// attachmentContentType guesses the type of an attachment from its name,
+// falling back to sniffing the data.
+func attachmentContentType(name string, data []byte) string {
+	if t := mimeTypeFor(name); t != "application/octet-stream" {
+		return t
+	}
+	return http.DetectContentType(data)
+}
+
+// Attachments handles /clipboard/attachments?tab=<name>.
+//
+//	GET  – list the tab's attachments
+//	POST – attach the request body as a file called name=<file name>
+func (ch *ClipboardHandler) Attachments() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !ch.Quiet {
+			log.Printf("[%s] [%s] %s %s\n", time.Now().Format("2006-01-02 15:04:05"), r.Method, r.URL.String(), r.RemoteAddr)
+		}
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		tabName := r.URL.Query().Get("tab")
+		if tabName == "" {
+			tabName = "default"
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			ch.handleAttachmentList(w, r, tabName)
+		case http.MethodPost:
+			ch.handleAttachmentUpload(w, r, tabName)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// AttachmentFile handles /clipboard/attachments/file?tab=<name>&id=<id>.
+//
+//	GET    – download the attachment
+//	DELETE – remove it from the tab
+func (ch *ClipboardHandler) AttachmentFile() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !ch.Quiet {
+			log.Printf("[%s] [%s] %s %s\n", time.Now().Format("2006-01-02 15:04:05"), r.Method, r.URL.String(), r.RemoteAddr)
+		}
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		tabName := r.URL.Query().Get("tab")
+		if tabName == "" {
+			tabName = "default"
+		}
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			http.Error(w, "Missing id parameter", http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			ch.handleAttachmentDownload(w, r, tabName, id)
+		case http.MethodDelete:
+			ch.handleAttachmentDelete(w, r, tabName, id)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func (ch *ClipboardHandler) handleAttachmentList(w http.ResponseWriter, r *http.Request, tabName string) {
+	ch.store.mu.RLock()
+	entry, ok := ch.store.lookup(tabName)
+	if !ok {
+		ch.store.mu.RUnlock()
+		http.Error(w, "Tab not found", http.StatusNotFound)
+		return
+	}
+	if !checkTabToken(entry, r) {
+		ch.store.mu.RUnlock()
+		w.Header().Set("WWW-Authenticate", `TabToken realm="Tab "`+tabName+`"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	list := append([]TabAttachment{}, entry.Attachments...)
+	ch.store.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(list)
+}
+
+func (ch *ClipboardHandler) handleAttachmentUpload(w http.ResponseWriter, r *http.Request, tabName string) {
+	name, ok := cleanAttachmentName(r.URL.Query().Get("name"))
+	if !ok {
+		http.Error(w, "Invalid or missing name parameter", http.StatusBadRequest)
+		return
+	}
+
+	if !ch.Limiter.AllowHTTP(w, security.PolicyClipboardWrite, clipboardExtractIP(r)) {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxAttachmentSize)
+	defer r.Body.Close()
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Attachments are limited to %d MiB", maxAttachmentSize>>20), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	plain, _, err := generateToken()
+	if err != nil {
+		http.Error(w, "Failed to generate ID", http.StatusInternalServerError)
+		return
+	}
+	a := TabAttachment{
+		ID:          plain[:16],
+		Name:        name,
+		Size:        len(data),
+		ContentType: attachmentContentType(name, data),
+		UploadedAt:  time.Now(),
+		Data:        data,
+	}
+
+	ch.store.mu.Lock()
+	entry, ok := ch.store.lookup(tabName)
+	if !ok {
+		ch.store.mu.Unlock()
+		http.Error(w, "Tab not found", http.StatusNotFound)
+		return
+	}
+	if !checkTabToken(entry, r) {
+		ch.store.mu.Unlock()
+		w.Header().Set("WWW-Authenticate", `TabToken realm="Tab "`+tabName+`"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	// Burn-after-read tabs would have to burn on download too, and the server
+	// could read the files of an encrypted tab: neither takes attachments.
+	if entry.BurnAfterRead || entry.Encrypted() {
+		ch.store.mu.Unlock()
+		http.Error(w, "Burn-after-read and encrypted tabs can't hold attachments", http.StatusConflict)
+		return
+	}
+	if len(entry.Attachments) >= maxTabAttachments {
+		ch.store.mu.Unlock()
+		http.Error(w, fmt.Sprintf("A tab holds at most %d attachments", maxTabAttachments), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if entry.attachmentBytes()+a.Size > maxTabAttachmentBytes {
+		ch.store.mu.Unlock()
+		http.Error(w, fmt.Sprintf("Attachments of a tab are limited to %d MiB in total", maxTabAttachmentBytes>>20), http.StatusRequestEntityTooLarge)
+		return
+	}
+	entry.Attachments = append(entry.Attachments, a)
+	entry.attachmentsRev++
+	ch.attachmentsChanged(tabName, entry)
+	ch.store.mu.Unlock()
+
+	if err := ch.store.writeAttachment(a); err != nil {
+		log.Printf("[%s] Error saving clipboard attachment %s: %v\n", time.Now().Format("2006-01-02 15:04:05"), a.ID, err)
+	}
+	ch.saveState()
+	ch.broker.Broadcast(tabName)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(a)
+	if !ch.Quiet {
+		log.Printf("[%s] Clipboard tab %q: attached %q (%d bytes)\n", time.Now().Format("2006-01-02 15:04:05"), tabName, name, a.Size)
+	}
+}
+
+func (ch *ClipboardHandler) handleAttachmentDownload(w http.ResponseWriter, r *http.Request, tabName, id string) {
+	ch.store.mu.RLock()
+	entry, ok := ch.store.lookup(tabName)
+	if !ok {
+		ch.store.mu.RUnlock()
+		http.Error(w, "Tab not found", http.StatusNotFound)
+		return
+	}
+	if !checkTabToken(entry, r) {
+		ch.store.mu.RUnlock()
+		w.Header().Set("WWW-Authenticate", `TabToken realm="Tab "`+tabName+`"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var found *TabAttachment
+	for i := range entry.Attachments {
+		if entry.Attachments[i].ID == id {
+			a := entry.Attachments[i] // Data is never modified in place
+			found = &a
+			break
+		}
+	}
+	ch.store.mu.RUnlock()
+
+	if found == nil {
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+
+	// Always a download: attachments are untrusted content from other clients.
+	w.Header().Set("Content-Type", found.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": found.Name}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(found.Data)
+}
+
+func (ch *ClipboardHandler) handleAttachmentDelete(w http.ResponseWriter, r *http.Request, tabName, id string) {
+	if !ch.Limiter.AllowHTTP(w, security.PolicyClipboardWrite, clipboardExtractIP(r)) {
+		return
+	}
+
+	ch.store.mu.Lock()
+	entry, ok := ch.store.lookup(tabName)
+	if !ok {
+		ch.store.mu.Unlock()
+		http.Error(w, "Tab not found", http.StatusNotFound)
+		return
+	}
+	if !checkTabToken(entry, r) {
+		ch.store.mu.Unlock()
+		w.Header().Set("WWW-Authenticate", `TabToken realm="Tab "`+tabName+`"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	idx := -1
+	for i := range entry.Attachments {
+		if entry.Attachments[i].ID == id {
+			idx = i
+			break
+		}
+	}
+	if idx == -1 {
+		ch.store.mu.Unlock()
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+	name := entry.Attachments[idx].Name
+	entry.Attachments = append(entry.Attachments[:idx:idx], entry.Attachments[idx+1:]...)
+	if len(entry.Attachments) == 0 {
+		entry.Attachments = nil
+	}
+	entry.attachmentsRev++
+	ch.attachmentsChanged(tabName, entry)
+	ch.store.mu.Unlock()
+
+	ch.saveState() // also removes the attachment's file
+	ch.broker.Broadcast(tabName)
+
+	w.WriteHeader(http.StatusOK)
+	if !ch.Quiet {
+		log.Printf("[%s] Clipboard tab %q: removed attachment %q\n", time.Now().Format("2006-01-02 15:04:05"), tabName, name)
+	}
+}
+
+// ── Persistence ───────────────────────────────────────────────────────────────
+//
+// Attachment data is kept out of the state file, which is rewritten on every
+// change: each attachment is written once to clipboardAttachmentsDir, named
+// after its ID, and files no tab refers to are removed when the state is saved.
+
+// attachmentsDir returns the attachment directory, or "" when persistence is
+// disabled. The caller must hold s.mu.
+func (s *clipboardStore) attachmentsDir() string {
+	if s.statePath == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(s.statePath), clipboardAttachmentsDir)
+}
+
+// writeAttachment stores the data of a new attachment. It holds saveMu so a
+// save that started before the attachment was added can't sweep its file.
+func (s *clipboardStore) writeAttachment(a TabAttachment) error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.RLock()
+	dir := s.attachmentsDir()
+	s.mu.RUnlock()
+	if dir == "" {
+		return nil
+	}
+	return writeBlob(dir, a.ID, a.Data)
+}
+
+// writeBlob writes data to the file id in dir, creating dir if needed.
+func writeBlob(dir, id string, data []byte) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(filepath.Join(dir, id), data, 0600)
+}
+
+// sweepBlobs removes the attachment or screenshot files in dir that are not
+// in keep. The caller must hold the saveMu of the store owning dir.
+func sweepBlobs(dir string, keep map[string]bool) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if blobIDRegex.MatchString(e.Name()) && !keep[e.Name()] {
+			if err := os.Remove(filepath.Join(dir, e.Name())); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadAttachments reads the data of every attachment in tabs from dir,
+// dropping attachments whose file is missing or invalid.
+func loadAttachments(dir string, tabs []persistedTab) {
+	for i := range tabs {
+		kept := tabs[i].Attachments[:0]
+		for _, a := range tabs[i].Attachments {
+			if !blobIDRegex.MatchString(a.ID) {
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(dir, a.ID))
+			if err != nil || len(data) != a.Size {
+				continue
+			}
+			a.Data = data
+			kept = append(kept, a)
+		}
+		if len(kept) == 0 {
+			kept = nil
+		}
+		tabs[i].Attachments = kept
+	}
+}
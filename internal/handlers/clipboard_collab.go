@@ -0,0 +1,486 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+	"unicode/utf16"
+
+	"github.com/wanetty/upgopher/internal/middleware"
+	"github.com/wanetty/upgopher/internal/security"
+	"github.com/wanetty/upgopher/internal/websocket"
+)
+
+const (
+	// maxCollabOps is how many recent operations a tab keeps to transform
+	// edits from clients that are a few revisions behind. Clients further
+	// behind are disconnected and resync on reconnect.
+	maxCollabOps = 500
+	// collabHistoryGap starts a new history version when a live edit comes
+	// this long after the previous one, so keystrokes don't flood the history.
+	collabHistoryGap = 30 * time.Second
+	// collabSaveDelay batches persistence of live edits.
+	collabSaveDelay = 2 * time.Second
+
+	collabSendBuffer   = 256
+	collabPingInterval = 25 * time.Second
+	collabIdleTimeout  = 60 * time.Second
+	collabWriteTimeout = 10 * time.Second
+
+	// closeOutOfSync tells a client its revision can no longer be reconciled;
+	// it should reconnect and start again from the current content.
+	closeOutOfSync = 4001
+)
+
+var collabNameRegex = regexp.MustCompile(`^[\p{L}\p{N} _.-]{1,32}$`)
+
+var collabColors = []string{"#e67e22", "#2980b9", "#8e44ad", "#16a085", "#c0392b", "#d35400", "#27ae60", "#7f8c8d"}
+
+var errOutOfSync = errors.New("revision is too old to transform")
+
+// collabCursor is a selection in a client's copy of the tab, in UTF-16 code units.
+type collabCursor struct {
+	Anchor int `json:"anchor"`
+	Head   int `json:"head"`
+}
+
+// collabEvent is a message of the /clipboard/ws protocol, in either direction.
+//
+//	client → server: op {revision, op}, cursor {cursor}
+//	server → client: init {client, revision, content, peers}, ack {revision},
+//	                 op {revision, op, client}, cursor, join, leave, deleted,
+//	                 attachments {attachments}, screenshots {screenshots}
+type collabEvent struct {
+	Type     string        `json:"type"`
+	Client   int64         `json:"client,omitempty"`
+	Revision int64         `json:"revision,omitempty"`
+	Op       textOp        `json:"op,omitempty"`
+	Content  *string       `json:"content,omitempty"`
+	Name     string        `json:"name,omitempty"`
+	Color    string        `json:"color,omitempty"`
+	Cursor   *collabCursor `json:"cursor,omitempty"`
+	Peers    []collabEvent `json:"peers,omitempty"`
+
+	Attachments int64 `json:"attachments,omitempty"` // revision of the tab's attachment list
+	Screenshots int64 `json:"screenshots,omitempty"` // revision of the tab's screenshot gallery
+}
+
+// collabPeer is one WebSocket client editing a tab.
+type collabPeer struct {
+	id     int64
+	tab    string
+	name   string
+	color  string
+	cursor *collabCursor
+	send   chan []byte // closed by the hub when the peer is removed
+
+	closeCode int
+	closeText string
+}
+
+func (p *collabPeer) info(typ string) collabEvent {
+	return collabEvent{Type: typ, Client: p.id, Name: p.name, Color: p.color, Cursor: p.cursor}
+}
+
+// collabHub tracks the peers of every tab. Content changes publish to it
+// while holding the clipboard store lock, so peers see operations in
+// revision order; the lock order is always store.mu, then hub.mu.
+type collabHub struct {
+	mu     sync.Mutex
+	rooms  map[string]map[*collabPeer]struct{}
+	nextID int64
+}
+
+func newCollabHub() *collabHub {
+	return &collabHub{rooms: make(map[string]map[*collabPeer]struct{})}
+}
+
+// PeerCount returns the number of connected live editors across all tabs.
+func (h *collabHub) PeerCount() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := 0
+	for _, room := range h.rooms {
+		n += len(room)
+	}
+	return n
+}
+
+// enqueue queues msg for p without blocking. A peer too slow to keep up is
+// dropped; it resyncs when it reconnects. The caller must hold h.mu.
+func (h *collabHub) enqueue(p *collabPeer, msg []byte) {
+	select {
+	case p.send <- msg:
+	default:
+		h.removeLocked(p, websocket.ClosePolicyViolation, "too slow")
+	}
+}
+
+// publish sends v to every peer of tab except skip. The caller must hold h.mu.
+func (h *collabHub) publish(tab string, v collabEvent, skip *collabPeer) {
+	msg, _ := json.Marshal(v)
+	for p := range h.rooms[tab] {
+		if p != skip {
+			h.enqueue(p, msg)
+		}
+	}
+}
+
+func (h *collabHub) removeLocked(p *collabPeer, code int, text string) {
+	room := h.rooms[p.tab]
+	if _, ok := room[p]; !ok {
+		return
+	}
+	delete(room, p)
+	if len(room) == 0 {
+		delete(h.rooms, p.tab)
+	}
+	p.closeCode, p.closeText = code, text
+	close(p.send)
+	h.publish(p.tab, collabEvent{Type: "leave", Client: p.id}, nil)
+}
+
+func (h *collabHub) remove(p *collabPeer, code int, text string) {
+	h.mu.Lock()
+	h.removeLocked(p, code, text)
+	h.mu.Unlock()
+}
+
+// recordChange makes a content change of tab visible to live editors: op is
+// kept for transforming late edits, origin gets an ack and everyone else the
+// op. It must be called with the store write lock held, after entry.Revision
+// was incremented. origin is nil for changes made over HTTP.
+func (ch *ClipboardHandler) recordChange(tab string, entry *ClipboardEntry, op textOp, origin *collabPeer) {
+	entry.ops = append(entry.ops, op)
+	if len(entry.ops) > maxCollabOps {
+		entry.ops = append([]textOp(nil), entry.ops[len(entry.ops)-maxCollabOps:]...)
+	}
+
+	h := ch.collab
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var originID int64
+	if origin != nil {
+		originID = origin.id
+		ack, _ := json.Marshal(collabEvent{Type: "ack", Revision: entry.Revision})
+		h.enqueue(origin, ack)
+	}
+	h.publish(tab, collabEvent{Type: "op", Revision: entry.Revision, Op: op, Client: originID}, origin)
+}
+
+// recordReplace records a whole-content replacement of tab, as done by
+// POST /clipboard and history restores. Same locking rules as recordChange.
+func (ch *ClipboardHandler) recordReplace(tab string, entry *ClipboardEntry, old string) {
+	ch.recordChange(tab, entry, diffOp(utf16.Encode([]rune(old)), utf16.Encode([]rune(entry.Content))), nil)
+}
+
+// closeRoom disconnects every live editor of a deleted tab. The caller must
+// hold the store write lock.
+func (ch *ClipboardHandler) closeRoom(tab string) {
+	h := ch.collab
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	msg, _ := json.Marshal(collabEvent{Type: "deleted"})
+	for p := range h.rooms[tab] {
+		select {
+		case p.send <- msg:
+		default:
+		}
+		h.removeLocked(p, websocket.CloseNormal, "tab deleted")
+	}
+}
+
+// applyCollabOp transforms op, made at revision rev by p, over the changes it
+// hasn't seen and applies it to the tab.
+func (ch *ClipboardHandler) applyCollabOp(p *collabPeer, rev int64, op textOp) error {
+	ch.store.mu.Lock()
+	defer ch.store.mu.Unlock()
+	entry, ok := ch.store.lookup(p.tab)
+	if !ok {
+		return errors.New("tab not found")
+	}
+	behind := entry.Revision - rev
+	if rev < 1 || behind < 0 || behind > int64(len(entry.ops)) {
+		return errOutOfSync
+	}
+	var err error
+	for _, concurrent := range entry.ops[len(entry.ops)-int(behind):] {
+		if op, _, err = transformOps(op, concurrent); err != nil {
+			return err
+		}
+	}
+	doc, err := op.apply(utf16.Encode([]rune(entry.Content)))
+	if err != nil {
+		return err
+	}
+	content := string(utf16.Decode(doc))
+	if len(content) > maxClipboardContentSize {
+		return fmt.Errorf("tab content would exceed %d bytes", maxClipboardContentSize)
+	}
+
+	now := time.Now()
+	if content != entry.Content && now.Sub(entry.lastOpAt) > collabHistoryGap {
+		ch.pushHistory(entry, now)
+	}
+	entry.Content = content
+	entry.UpdatedAt = now
+	entry.lastOpAt = now
+	entry.Revision++
+	ch.recordChange(p.tab, entry, op, p)
+	ch.saveStateSoon()
+	ch.broker.Broadcast(p.tab) // never blocks, so it can run under the lock
+	return nil
+}
+
+// updateCursor stores p's selection and shares it with the other peers.
+func (ch *ClipboardHandler) updateCursor(p *collabPeer, cursor *collabCursor) {
+	h := ch.collab
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.rooms[p.tab][p]; !ok {
+		return
+	}
+	p.cursor = cursor
+	h.publish(p.tab, collabEvent{Type: "cursor", Client: p.id, Cursor: cursor}, p)
+}
+
+// saveStateSoon schedules a save of the store, coalescing the many small
+// changes of live editing into one write. The caller must hold the store
+// write lock.
+func (ch *ClipboardHandler) saveStateSoon() {
+	if ch.store.statePath == "" || ch.store.savePending {
+		return
+	}
+	ch.store.savePending = true
+	time.AfterFunc(collabSaveDelay, func() {
+		ch.store.mu.Lock()
+		ch.store.savePending = false
+		ch.store.mu.Unlock()
+		ch.saveState()
+	})
+}
+
+// streamTokenOK checks the token of a protected tab for connections that
+// can't send custom headers (EventSource, WebSocket): the token is also
+// accepted as the X-Tab-Token query parameter.
+func streamTokenOK(hash string, r *http.Request) bool {
+	if hash == "" {
+		return true
+	}
+	provided := r.Header.Get("X-Tab-Token")
+	if provided == "" {
+		provided = r.URL.Query().Get("X-Tab-Token")
+	}
+	if provided == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(tokenHash(provided)), []byte(hash)) == 1
+}
+
+// originAllowed reports whether a browser request comes from a page served
+// by this host or by one of allowed, "*" excepted. WebSocket handshakes
+// aren't covered by CORS, so without this any site could open a live
+// session with the visitor's credentials. Requests without an Origin header
+// don't come from browsers and are allowed.
+func originAllowed(r *http.Request, allowed []string) bool {
+	origin := r.Header.Get("Origin")
+	return origin == "" || middleware.TrustedOrigin(r, origin, allowed)
+}
+
+// Collaborate handles GET /clipboard/ws?tab=<name>: a WebSocket on which
+// clients exchange operational-transform edits and cursor positions for a
+// tab. Clients that can't use WebSockets keep using POST /clipboard and
+// the /clipboard/stream events, which see live edits as ordinary changes.
+func (ch *ClipboardHandler) Collaborate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !ch.Quiet {
+			log.Printf("[%s] [WS] %s %s %s\n", time.Now().Format("2006-01-02 15:04:05"), r.Method, r.URL.Path, r.RemoteAddr)
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !originAllowed(r, ch.AllowedOrigins) {
+			http.Error(w, "Cross-origin WebSocket not allowed", http.StatusForbidden)
+			return
+		}
+
+		tabName := r.URL.Query().Get("tab")
+		if tabName == "" {
+			tabName = "default"
+		}
+		ch.store.mu.RLock()
+		entry, ok := ch.store.lookup(tabName)
+		var tokenHash string
+		var burn, encrypted bool
+		if ok {
+			tokenHash = entry.TokenHash
+			burn = entry.BurnAfterRead
+			encrypted = entry.Encrypted()
+		}
+		ch.store.mu.RUnlock()
+		if !ok {
+			http.Error(w, "Tab not found", http.StatusNotFound)
+			return
+		}
+		if !streamTokenOK(tokenHash, r) {
+			w.Header().Set("WWW-Authenticate", `TabToken realm="Tab "`+tabName+`"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		// Joining sends the content, which would read the tab without burning it.
+		if burn {
+			http.Error(w, "Burn-after-read tabs can't be edited live", http.StatusConflict)
+			return
+		}
+		// Character operations can't be applied to ciphertext.
+		if encrypted {
+			http.Error(w, "Encrypted tabs can't be edited live", http.StatusConflict)
+			return
+		}
+
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.MaxMessageSize = 2 * maxClipboardContentSize // JSON escaping can double an insert
+		conn.IdleTimeout = collabIdleTimeout
+
+		p, err := ch.joinCollab(tabName, r.URL.Query().Get("name"))
+		if err != nil {
+			conn.WriteClose(websocket.CloseNormal, err.Error())
+			return
+		}
+		// The reader always removes the peer before returning, which makes the
+		// writer flush its queue and send the close frame; wait for that.
+		done := make(chan struct{})
+		go func() {
+			ch.collabWriter(conn, p)
+			close(done)
+		}()
+		ch.collabReader(conn, p, clipboardExtractIP(r))
+		<-done
+	}
+}
+
+// joinCollab registers a new peer for tab and queues its init message,
+// holding the store lock so no change slips in between the snapshot and
+// the registration.
+func (ch *ClipboardHandler) joinCollab(tab, name string) (*collabPeer, error) {
+	ch.store.mu.RLock()
+	defer ch.store.mu.RUnlock()
+	entry, ok := ch.store.lookup(tab)
+	if !ok {
+		return nil, errors.New("tab deleted")
+	}
+
+	h := ch.collab
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.nextID++
+	p := &collabPeer{
+		id:    h.nextID,
+		tab:   tab,
+		color: collabColors[int(h.nextID)%len(collabColors)],
+		send:  make(chan []byte, collabSendBuffer),
+	}
+	if collabNameRegex.MatchString(name) {
+		p.name = name
+	} else {
+		p.name = fmt.Sprintf("Guest %d", p.id)
+	}
+
+	content := entry.Content
+	hello := collabEvent{Type: "init", Client: p.id, Revision: entry.Revision, Content: &content, Name: p.name, Color: p.color}
+	for other := range h.rooms[tab] {
+		hello.Peers = append(hello.Peers, other.info("peer"))
+	}
+	msg, _ := json.Marshal(hello)
+	p.send <- msg
+
+	h.publish(tab, p.info("join"), nil)
+	if h.rooms[tab] == nil {
+		h.rooms[tab] = make(map[*collabPeer]struct{})
+	}
+	h.rooms[tab][p] = struct{}{}
+	return p, nil
+}
+
+// collabReader handles incoming messages from the client at ip until the
+// connection fails. Edits count against the collab-op rate limit, like
+// POST /clipboard writes count against clipboard-write.
+func (ch *ClipboardHandler) collabReader(conn *websocket.Conn, p *collabPeer, ip string) {
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			ch.collab.remove(p, websocket.CloseGoingAway, "")
+			return
+		}
+		var msg collabEvent
+		if err := json.Unmarshal(data, &msg); err != nil {
+			ch.collab.remove(p, websocket.CloseInvalidPayload, "invalid message")
+			return
+		}
+
+		switch msg.Type {
+		case "op":
+			if !ch.Limiter.Allow(security.PolicyCollabOp, ip).Allowed {
+				if !ch.Quiet {
+					log.Printf("[%s] Live edits on clipboard tab %q rate limited for %s\n", time.Now().Format("2006-01-02 15:04:05"), p.tab, ip)
+				}
+				ch.collab.remove(p, websocket.ClosePolicyViolation, "rate limit exceeded")
+				return
+			}
+			if err := ch.applyCollabOp(p, msg.Revision, msg.Op); err != nil {
+				code := websocket.ClosePolicyViolation
+				if err == errOutOfSync || err == errOpLength {
+					code = closeOutOfSync
+				}
+				if !ch.Quiet {
+					log.Printf("[%s] Live edit on clipboard tab %q rejected: %v\n", time.Now().Format("2006-01-02 15:04:05"), p.tab, err)
+				}
+				ch.collab.remove(p, code, err.Error())
+				return
+			}
+		case "cursor":
+			ch.updateCursor(p, msg.Cursor)
+		}
+	}
+}
+
+// collabWriter sends queued messages and keep-alive pings. When the hub
+// closes the send channel it ends the session with the recorded close code.
+func (ch *ClipboardHandler) collabWriter(conn *websocket.Conn, p *collabPeer) {
+	ticker := time.NewTicker(collabPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg, ok := <-p.send:
+			conn.SetWriteDeadline(time.Now().Add(collabWriteTimeout))
+			if !ok {
+				conn.WriteClose(p.closeCode, p.closeText)
+				conn.Close()
+				return
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				conn.Close()
+				ch.collab.remove(p, websocket.CloseGoingAway, "")
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(collabWriteTimeout))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				conn.Close()
+				ch.collab.remove(p, websocket.CloseGoingAway, "")
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,441 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/wanetty/upgopher/internal/security"
+	"github.com/wanetty/upgopher/internal/websocket"
+)
+
+func u16(s string) []uint16 { return utf16.Encode([]rune(s)) }
+
+func applyString(t *testing.T, op textOp, s string) string {
+	t.Helper()
+	out, err := op.apply(u16(s))
+	if err != nil {
+		t.Fatalf("apply %v to %q: %v", op, s, err)
+	}
+	return string(utf16.Decode(out))
+}
+
+// randomOp returns a random edit of a document of n code units.
+func randomOp(rng *rand.Rand, n int) textOp {
+	var op textOp
+	for pos := 0; pos < n; {
+		k := 1 + rng.Intn(n-pos)
+		switch rng.Intn(3) {
+		case 0:
+			op.retain(k)
+		case 1:
+			op.delete(k)
+		default:
+			op.insert(string(rune('a' + rng.Intn(26))))
+			continue
+		}
+		pos += k
+	}
+	if rng.Intn(2) == 0 {
+		op.insert("é😀")
+	}
+	return op
+}
+
+// TestTransformConverges verifies that transformed operations lead both sides
+// to the same document, whatever the order they are applied in.
+func TestTransformConverges(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	doc := "hello, 🌍 world"
+	for i := 0; i < 500; i++ {
+		a, b := randomOp(rng, len(u16(doc))), randomOp(rng, len(u16(doc)))
+		aPrime, bPrime, err := transformOps(a, b)
+		if err != nil {
+			t.Fatalf("transform %v %v: %v", a, b, err)
+		}
+		left := applyString(t, bPrime, applyString(t, a, doc))
+		right := applyString(t, aPrime, applyString(t, b, doc))
+		if left != right {
+			t.Fatalf("a=%v b=%v: %q != %q", a, b, left, right)
+		}
+	}
+}
+
+func TestTransformInsertTieFavoursFirst(t *testing.T) {
+	var a, b textOp
+	a.retain(1)
+	a.insert("A")
+	b.retain(1)
+	b.insert("B")
+	aPrime, _, err := transformOps(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := applyString(t, aPrime, applyString(t, b, "x")); got != "xAB" {
+		t.Errorf("got %q, want %q", got, "xAB")
+	}
+}
+
+func TestDiffOpKeepsSurrogatePairs(t *testing.T) {
+	tests := []struct{ old, new string }{
+		{"", "abc"},
+		{"abc", ""},
+		{"abc", "abc"},
+		{"a😀c", "a😁c"},
+		{"😀", "😀😀"},
+		{"hello world", "hello brave world"},
+	}
+	for _, tt := range tests {
+		op := diffOp(u16(tt.old), u16(tt.new))
+		if got := applyString(t, op, tt.old); got != tt.new {
+			t.Errorf("diff %q → %q gave %q", tt.old, tt.new, got)
+		}
+		for _, c := range op {
+			if c.isInsert() && strings.ContainsRune(c.str, '�') {
+				t.Errorf("diff %q → %q split a surrogate pair: %v", tt.old, tt.new, op)
+			}
+		}
+	}
+}
+
+func TestTextOpJSON(t *testing.T) {
+	var op textOp
+	if err := json.Unmarshal([]byte(`[3,"hi",-2,1]`), &op); err != nil {
+		t.Fatal(err)
+	}
+	if op.baseLen() != 6 {
+		t.Errorf("baseLen = %d, want 6", op.baseLen())
+	}
+	data, _ := json.Marshal(op)
+	if string(data) != `[3,"hi",-2,1]` {
+		t.Errorf("round trip = %s", data)
+	}
+	for _, bad := range []string{`[0]`, `[""]`, `[true]`, `{}`, fmt.Sprintf("[%d]", maxOpComponent+1), fmt.Sprintf("[%d]", math.MinInt64)} {
+		if err := json.Unmarshal([]byte(bad), &op); err == nil {
+			t.Errorf("%s: expected an error", bad)
+		}
+	}
+}
+
+// TestTextOpOverflow verifies that lengths adding up past the largest int
+// are refused instead of wrapping around to the document length.
+func TestTextOpOverflow(t *testing.T) {
+	op := textOp{{n: math.MaxInt}, {n: math.MaxInt}, {n: 2}}
+	if n := op.baseLen(); n != -1 {
+		t.Errorf("baseLen = %d, want -1", n)
+	}
+	if _, err := op.apply(u16("hi")); err != errOpLength {
+		t.Errorf("apply = %v, want errOpLength", err)
+	}
+	if _, err := (textOp{{n: 3}, {n: -1}}).apply(u16("hi")); err != errOpLength {
+		t.Errorf("apply past the end = %v, want errOpLength", err)
+	}
+
+	var merged textOp
+	merged.retain(maxOpComponent)
+	merged.retain(1)
+	merged.delete(maxOpComponent)
+	merged.delete(1)
+	if len(merged) != 4 {
+		t.Errorf("components merged past maxOpComponent: %v", merged)
+	}
+}
+
+// collabServer serves the WebSocket endpoint of h and returns its ws:// URL.
+func collabServer(t *testing.T, h *ClipboardHandler) string {
+	t.Helper()
+	srv := httptest.NewServer(h.Collaborate())
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+type collabClient struct {
+	t    *testing.T
+	conn *websocket.Conn
+}
+
+func joinTab(t *testing.T, url, query string) (*collabClient, collabEvent) {
+	t.Helper()
+	conn, _, err := websocket.Dial(url+"?"+query, nil)
+	if err != nil {
+		t.Fatalf("dial %s: %v", query, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	c := &collabClient{t: t, conn: conn}
+	init := c.next()
+	if init.Type != "init" {
+		t.Fatalf("first message %q, want init", init.Type)
+	}
+	return c, init
+}
+
+func (c *collabClient) send(v collabEvent) {
+	c.t.Helper()
+	data, _ := json.Marshal(v)
+	if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *collabClient) next() collabEvent {
+	c.t.Helper()
+	type result struct {
+		data []byte
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		_, data, err := c.conn.ReadMessage()
+		ch <- result{data, err}
+	}()
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			c.t.Fatalf("read: %v", r.err)
+		}
+		var ev collabEvent
+		if err := json.Unmarshal(r.data, &ev); err != nil {
+			c.t.Fatal(err)
+		}
+		return ev
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("timed out waiting for a message")
+	}
+	return collabEvent{}
+}
+
+// nextOf skips messages until one of type typ arrives.
+func (c *collabClient) nextOf(typ string) collabEvent {
+	c.t.Helper()
+	for {
+		if ev := c.next(); ev.Type == typ {
+			return ev
+		}
+	}
+}
+
+func opFrom(t *testing.T, s string) textOp {
+	t.Helper()
+	var op textOp
+	if err := json.Unmarshal([]byte(s), &op); err != nil {
+		t.Fatal(err)
+	}
+	return op
+}
+
+// TestCollaborateRelaysOperations verifies that an edit is applied, acked to
+// its author and relayed to the other editors, and that concurrent edits at
+// the same revision converge.
+func TestCollaborateRelaysOperations(t *testing.T) {
+	h := newTestClipboardHandler()
+	conditionalPost(t, h, "default", "hello", "")
+	url := collabServer(t, h)
+
+	alice, init := joinTab(t, url, "tab=default&name=alice")
+	if *init.Content != "hello" || init.Revision != 2 || init.Name != "alice" {
+		t.Fatalf("init = %+v", init)
+	}
+	bob, init := joinTab(t, url, "tab=default")
+	if len(init.Peers) != 1 || init.Peers[0].Name != "alice" || !strings.HasPrefix(init.Name, "Guest ") {
+		t.Fatalf("bob's init = %+v", init)
+	}
+	if join := alice.next(); join.Type != "join" || join.Client != init.Client {
+		t.Fatalf("alice got %+v, want bob's join", join)
+	}
+
+	// Both edit revision 2 at once.
+	alice.send(collabEvent{Type: "op", Revision: 2, Op: opFrom(t, `[5," world"]`)})
+	bob.send(collabEvent{Type: "op", Revision: 2, Op: opFrom(t, `["oh, ",5]`)})
+
+	if ack := alice.nextOf("ack"); ack.Revision < 3 {
+		t.Errorf("alice ack = %+v", ack)
+	}
+	if ack := bob.nextOf("ack"); ack.Revision < 3 {
+		t.Errorf("bob ack = %+v", ack)
+	}
+	if got, etag := tabContent(t, h, "default"); got != "oh, hello world" || etag != `"4"` {
+		t.Errorf("content = %q (%s), want %q", got, etag, "oh, hello world")
+	}
+}
+
+// TestCollaborateSeesHTTPWrites verifies that a POST reaches live editors as
+// an operation they can apply to their copy.
+func TestCollaborateSeesHTTPWrites(t *testing.T) {
+	h := newTestClipboardHandler()
+	url := collabServer(t, h)
+	c, init := joinTab(t, url, "tab=default")
+
+	req := httptest.NewRequest(http.MethodPost, "/clipboard?tab=default", strings.NewReader("from curl"))
+	req.RemoteAddr = "10.35.0.1:10000"
+	h.Handle()(httptest.NewRecorder(), req)
+
+	ev := c.nextOf("op")
+	if ev.Revision != init.Revision+1 || ev.Client != 0 {
+		t.Errorf("op event = %+v", ev)
+	}
+	if got := applyString(t, ev.Op, *init.Content); got != "from curl" {
+		t.Errorf("applied op gives %q", got)
+	}
+}
+
+func TestCollaborateCursorsAndPresence(t *testing.T) {
+	h := newTestClipboardHandler()
+	url := collabServer(t, h)
+	alice, aliceInit := joinTab(t, url, "tab=default&name=alice")
+	bob, _ := joinTab(t, url, "tab=default&name=bob")
+	alice.nextOf("join")
+
+	bob.send(collabEvent{Type: "cursor", Cursor: &collabCursor{Anchor: 0, Head: 0}})
+	if ev := alice.nextOf("cursor"); ev.Cursor == nil || ev.Client == aliceInit.Client {
+		t.Errorf("cursor event = %+v", ev)
+	}
+	if n := h.Stats().LiveEditors; n != 2 {
+		t.Errorf("LiveEditors = %d, want 2", n)
+	}
+
+	bob.conn.WriteClose(websocket.CloseNormal, "")
+	if ev := alice.nextOf("leave"); ev.Client == aliceInit.Client {
+		t.Errorf("leave event = %+v", ev)
+	}
+}
+
+// TestCollaborateRejectsStaleRevision verifies that a client too far behind
+// is told to resync with close code 4001.
+func TestCollaborateRejectsStaleRevision(t *testing.T) {
+	h := newTestClipboardHandler()
+	url := collabServer(t, h)
+	c, init := joinTab(t, url, "tab=default")
+
+	c.send(collabEvent{Type: "op", Revision: init.Revision + 5, Op: opFrom(t, `["x"]`)})
+	_, _, err := c.conn.ReadMessage()
+	var ce *websocket.CloseError
+	if !errors.As(err, &ce) || ce.Code != closeOutOfSync {
+		t.Fatalf("got %v, want close %d", err, closeOutOfSync)
+	}
+}
+
+// TestCollaborateRateLimitsOperations verifies that live edits can't get
+// around the write rate limit of POST /clipboard.
+func TestCollaborateRateLimitsOperations(t *testing.T) {
+	h := newTestClipboardHandler()
+	h.Limiter = security.NewRateLimiter(map[string]security.RateLimitPolicy{
+		security.PolicyCollabOp: {Limit: 2, Period: time.Hour, Burst: 2},
+	})
+	url := collabServer(t, h)
+	c, init := joinTab(t, url, "tab=default")
+
+	rev := init.Revision
+	for _, op := range []string{`["x"]`, `[1,"x"]`} {
+		c.send(collabEvent{Type: "op", Revision: rev, Op: opFrom(t, op)})
+		rev = c.nextOf("ack").Revision
+	}
+	c.send(collabEvent{Type: "op", Revision: rev, Op: opFrom(t, `[2,"x"]`)})
+	var ce *websocket.CloseError
+	for {
+		if _, _, err := c.conn.ReadMessage(); err != nil {
+			if !errors.As(err, &ce) || ce.Code != websocket.ClosePolicyViolation {
+				t.Fatalf("got %v, want close %d", err, websocket.ClosePolicyViolation)
+			}
+			break
+		}
+	}
+	if got, _ := tabContent(t, h, "default"); got != "xx" {
+		t.Errorf("content = %q, want the two allowed edits only", got)
+	}
+}
+
+// TestCollaborateRejectsOverflowingOperation verifies that an operation
+// whose lengths overflow is refused and leaves the tab usable.
+func TestCollaborateRejectsOverflowingOperation(t *testing.T) {
+	h := newTestClipboardHandler()
+	conditionalPost(t, h, "default", "hi", "")
+	url := collabServer(t, h)
+	c, init := joinTab(t, url, "tab=default")
+
+	c.send(collabEvent{Type: "op", Revision: init.Revision, Op: textOp{{n: math.MaxInt}, {n: math.MaxInt}, {n: 2}}})
+	var ce *websocket.CloseError
+	if _, _, err := c.conn.ReadMessage(); !errors.As(err, &ce) || ce.Code != websocket.CloseInvalidPayload {
+		t.Fatalf("got %v, want close %d", err, websocket.CloseInvalidPayload)
+	}
+	conditionalPost(t, h, "default", "still writable", "")
+	if got, _ := tabContent(t, h, "default"); got != "still writable" {
+		t.Errorf("content = %q", got)
+	}
+}
+
+func TestCollaborateDeletedTab(t *testing.T) {
+	h := newTestClipboardHandler()
+	req := httptest.NewRequest(http.MethodPost, "/clipboard?tab=temp", strings.NewReader("x"))
+	req.RemoteAddr = "10.35.0.2:10000"
+	h.Handle()(httptest.NewRecorder(), req)
+
+	c, _ := joinTab(t, collabServer(t, h), "tab=temp")
+	req = httptest.NewRequest(http.MethodDelete, "/clipboard?tab=temp", nil)
+	req.RemoteAddr = "10.35.0.2:10000"
+	h.Handle()(httptest.NewRecorder(), req)
+
+	c.nextOf("deleted")
+}
+
+func TestCollaborateHandshakeChecks(t *testing.T) {
+	h := newTestClipboardHandler()
+	req := httptest.NewRequest(http.MethodPost, "/clipboard?tab=locked", strings.NewReader("secret"))
+	req.RemoteAddr = "10.35.0.3:10000"
+	req.Header.Set("X-Tab-Token-Create", "1")
+	req.Header.Set("X-Tab-Token-Value", "hunter22")
+	h.Handle()(httptest.NewRecorder(), req)
+	h.AllowedOrigins = []string{"https://app.example"}
+	url := collabServer(t, h)
+
+	tests := []struct {
+		name   string
+		query  string
+		origin string
+		want   int
+	}{
+		{"missing tab", "tab=nope", "", http.StatusNotFound},
+		{"protected tab without token", "tab=locked", "", http.StatusUnauthorized},
+		{"wrong token", "tab=locked&X-Tab-Token=wrong", "", http.StatusUnauthorized},
+		{"cross origin", "tab=default", "http://evil.example", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		if tt.origin != "" {
+			header.Set("Origin", tt.origin)
+		}
+		_, resp, err := websocket.Dial(url+"?"+tt.query, header)
+		if err == nil || resp == nil || resp.StatusCode != tt.want {
+			code := 0
+			if resp != nil {
+				code = resp.StatusCode
+			}
+			t.Errorf("%s: got %d (%v), want %d", tt.name, code, err, tt.want)
+		}
+	}
+
+	conn, _, err := websocket.Dial(url+"?tab=default", http.Header{"Origin": {"https://app.example"}})
+	if err != nil {
+		t.Fatalf("allowed origin refused: %v", err)
+	}
+	conn.Close()
+
+	// "*" lets any site read the API without credentials, never edit tabs
+	// with them.
+	h.AllowedOrigins = []string{"*"}
+	if _, resp, err := websocket.Dial(url+"?tab=default", http.Header{"Origin": {"http://evil.example"}}); err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("foreign origin with -cors-origins=* = %v, %v", resp, err)
+	}
+	h.AllowedOrigins = []string{"https://app.example"}
+
+	if _, init := joinTab(t, url, "tab=locked&X-Tab-Token=hunter22"); *init.Content != "secret" {
+		t.Errorf("init content = %q", *init.Content)
+	}
+}
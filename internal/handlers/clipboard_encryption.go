@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Limits for the key derivation parameters a client may choose.
+const (
+	tabKDF              = "PBKDF2-SHA256"
+	minTabKDFIterations = 100000
+	maxTabKDFIterations = 10000000
+	gcmNonceSize        = 12
+	gcmTagSize          = 16
+)
+
+// TabEncryption describes how browsers derive the AES-GCM key of an
+// end-to-end encrypted tab from its passphrase. The server stores it for
+// other clients but never sees the passphrase, the key or the plaintext:
+// the content of such a tab is always an encrypted envelope.
+type TabEncryption struct {
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       string `json:"salt"` // base64
+	// Check is an envelope of a fixed text, letting clients reject a wrong
+	// passphrase before they write with it.
+	Check string `json:"check"`
+}
+
+// parseTabEncryption reads the X-Tab-Encrypted, X-Tab-Salt,
+// X-Tab-KDF-Iterations and X-Tab-Key-Check headers of a request creating a
+// tab. It returns nil when encryption wasn't requested.
+func parseTabEncryption(r *http.Request) (*TabEncryption, error) {
+	if r.Header.Get("X-Tab-Encrypted") != "1" {
+		return nil, nil
+	}
+	salt, err := base64.StdEncoding.DecodeString(r.Header.Get("X-Tab-Salt"))
+	if err != nil || len(salt) < 16 || len(salt) > 64 {
+		return nil, errors.New("X-Tab-Salt must be 16 to 64 base64-encoded bytes")
+	}
+	iterations, err := strconv.Atoi(r.Header.Get("X-Tab-KDF-Iterations"))
+	if err != nil || iterations < minTabKDFIterations || iterations > maxTabKDFIterations {
+		return nil, fmt.Errorf("X-Tab-KDF-Iterations must be between %d and %d", minTabKDFIterations, maxTabKDFIterations)
+	}
+	check := r.Header.Get("X-Tab-Key-Check")
+	if check == "" || !validEnvelope(check) {
+		return nil, errors.New("X-Tab-Key-Check must be an encrypted envelope")
+	}
+	return &TabEncryption{KDF: tabKDF, Iterations: iterations, Salt: base64.StdEncoding.EncodeToString(salt), Check: check}, nil
+}
+
+// validEnvelope reports whether s has the form of AES-GCM output as the
+// browser encodes it: "v1:<base64 nonce>:<base64 ciphertext and tag>". It
+// keeps plaintext from being stored in an encrypted tab by mistake.
+func validEnvelope(s string) bool {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 || parts[0] != "v1" {
+		return false
+	}
+	nonce, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil || len(nonce) != gcmNonceSize {
+		return false
+	}
+	sealed, err := base64.StdEncoding.DecodeString(parts[2])
+	return err == nil && len(sealed) >= gcmTagSize
+}
+
+// validEncryptedContent accepts an empty tab or a single envelope.
+func validEncryptedContent(content string) bool {
+	return content == "" || validEnvelope(content)
+}
+
+// Encrypted reports whether the tab's content is end-to-end encrypted.
+func (e *ClipboardEntry) Encrypted() bool {
+	return e.Encryption != nil
+}
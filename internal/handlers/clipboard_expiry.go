@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxTabTTL is the longest lifetime a tab can be created with.
+	maxTabTTL = 30 * 24 * time.Hour
+	// JanitorInterval is how often expired tabs are removed by default.
+	JanitorInterval = 15 * time.Second
+)
+
+// expired reports whether the tab's lifetime is over.
+func (e *ClipboardEntry) expired(now time.Time) bool {
+	return !e.ExpiresAt.IsZero() && !now.Before(e.ExpiresAt)
+}
+
+// lookup returns the tab called name, hiding tabs whose lifetime is over but
+// that the janitor hasn't removed yet. The caller must hold s.mu.
+func (s *clipboardStore) lookup(name string) (*ClipboardEntry, bool) {
+	entry, ok := s.tabs[name]
+	if !ok || entry.expired(time.Now()) {
+		return nil, false
+	}
+	return entry, true
+}
+
+// removeTabLocked deletes a tab with its screenshots and disconnects its live
+// editors. The caller must hold the store write lock.
+func (ch *ClipboardHandler) removeTabLocked(name string) {
+	delete(ch.store.tabs, name)
+	ch.imgStore.removeTab(name)
+	ch.closeRoom(name)
+}
+
+// tabLifetime holds the lifetime options of a tab being created.
+type tabLifetime struct {
+	ttl           time.Duration // 0 = never expires
+	burnAfterRead bool
+}
+
+// parseTabLifetime reads the X-Tab-TTL and X-Tab-Burn-After-Read headers of a
+// request creating a tab. The TTL is a Go duration ("90m", "24h") or a number
+// of seconds.
+func parseTabLifetime(r *http.Request) (tabLifetime, error) {
+	var lt tabLifetime
+	if v := strings.TrimSpace(r.Header.Get("X-Tab-TTL")); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err != nil {
+			secs, convErr := strconv.ParseInt(v, 10, 64)
+			if convErr != nil {
+				return lt, errors.New("invalid X-Tab-TTL: use a duration such as 90m or a number of seconds")
+			}
+			if secs > int64(maxTabTTL/time.Second) {
+				secs = int64(maxTabTTL/time.Second) + 1
+			}
+			ttl = time.Duration(secs) * time.Second
+		}
+		if ttl <= 0 || ttl > maxTabTTL {
+			return lt, fmt.Errorf("X-Tab-TTL must be between 1s and %s", maxTabTTL)
+		}
+		lt.ttl = ttl
+	}
+	lt.burnAfterRead = r.Header.Get("X-Tab-Burn-After-Read") == "1"
+	return lt, nil
+}
+
+// apply sets the lifetime of a new tab created at now.
+func (lt tabLifetime) apply(entry *ClipboardEntry, now time.Time) {
+	if lt.ttl > 0 {
+		entry.ExpiresAt = now.Add(lt.ttl)
+	}
+	entry.BurnAfterRead = lt.burnAfterRead
+}
+
+// burnTab removes a burn-after-read tab as it is read and returns its content.
+// ok is false when another reader burned it first.
+func (ch *ClipboardHandler) burnTab(tabName string, read *ClipboardEntry) (content string, ok bool) {
+	ch.store.mu.Lock()
+	entry, exists := ch.store.lookup(tabName)
+	if !exists || entry != read {
+		ch.store.mu.Unlock()
+		return "", false
+	}
+	content = entry.Content
+	ch.removeTabLocked(tabName)
+	ch.store.mu.Unlock()
+
+	ch.saveState()
+	if !ch.Quiet {
+		log.Printf("[%s] Clipboard tab %q burned after read\n", time.Now().Format("2006-01-02 15:04:05"), tabName)
+	}
+	return content, true
+}
+
+// expireTabs removes every tab whose lifetime ended before now and returns
+// their names.
+func (ch *ClipboardHandler) expireTabs(now time.Time) []string {
+	var expired []string
+	ch.store.mu.Lock()
+	for name, entry := range ch.store.tabs {
+		if entry.expired(now) {
+			ch.removeTabLocked(name)
+			expired = append(expired, name)
+		}
+	}
+	ch.store.mu.Unlock()
+
+	if len(expired) > 0 {
+		ch.saveState()
+		if !ch.Quiet {
+			log.Printf("[%s] Expired %d clipboard tab(s): %s\n", time.Now().Format("2006-01-02 15:04:05"), len(expired), strings.Join(expired, ", "))
+		}
+	}
+	return expired
+}
+
+// StartJanitor removes expired tabs and screenshots every interval until the
+// returned stop function is called. Handlers already hide expired tabs; the
+// janitor frees their memory, ends their live sessions and drops them from
+// the state file.
+func (ch *ClipboardHandler) StartJanitor(interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case now := <-ticker.C:
+				ch.expireTabs(now)
+				ch.expireScreenshots(now)
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// remainingSeconds returns how many whole seconds entry has left, rounding
+// up so a live tab never reports 0.
+func remainingSeconds(entry *ClipboardEntry, now time.Time) int64 {
+	left := entry.ExpiresAt.Sub(now)
+	return int64((left + time.Second - 1) / time.Second)
+}
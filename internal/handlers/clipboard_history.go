@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/wanetty/upgopher/internal/security"
+)
+
+const (
+	defaultHistoryLimit  = 20
+	defaultHistoryMaxAge = 24 * time.Hour
+)
+
+// ClipboardVersion is a previous content of a clipboard tab.
+type ClipboardVersion struct {
+	ID         int64     `json:"id"`
+	Content    string    `json:"content"`
+	UpdatedAt  time.Time `json:"updatedAt"`  // when this content was saved
+	ReplacedAt time.Time `json:"replacedAt"` // when it was overwritten; age limits count from here
+}
+
+// pushHistory records the entry's current content as a version before it is
+// overwritten, then applies the count and age limits. The caller must hold
+// the store write lock.
+func (ch *ClipboardHandler) pushHistory(entry *ClipboardEntry, now time.Time) {
+	if ch.HistoryLimit <= 0 || entry.BurnAfterRead {
+		return
+	}
+	entry.nextVersion++
+	entry.History = append(entry.History, ClipboardVersion{
+		ID:         entry.nextVersion,
+		Content:    entry.Content,
+		UpdatedAt:  entry.UpdatedAt,
+		ReplacedAt: now,
+	})
+	ch.pruneHistory(entry, now)
+}
+
+// pruneHistory drops versions beyond HistoryLimit (oldest first) and versions
+// replaced more than HistoryMaxAge ago. The caller must hold the store write lock.
+func (ch *ClipboardHandler) pruneHistory(entry *ClipboardEntry, now time.Time) {
+	h := entry.History
+	if ch.HistoryLimit <= 0 {
+		h = nil
+	} else if len(h) > ch.HistoryLimit {
+		h = h[len(h)-ch.HistoryLimit:]
+	}
+	if ch.HistoryMaxAge > 0 {
+		cutoff := now.Add(-ch.HistoryMaxAge)
+		i := 0
+		for i < len(h) && h[i].ReplacedAt.Before(cutoff) {
+			i++
+		}
+		h = h[i:]
+	}
+	if len(h) == 0 {
+		h = nil
+	}
+	// Copy so trimmed versions don't stay reachable through the old backing array.
+	entry.History = append([]ClipboardVersion(nil), h...)
+}
+
+// historyResponse is the JSON body returned by GET /clipboard/history.
+type historyResponse struct {
+	Tab           string             `json:"tab"`
+	Limit         int                `json:"limit"`
+	MaxAgeSeconds int64              `json:"maxAgeSeconds"`
+	Versions      []ClipboardVersion `json:"versions"` // newest first
+}
+
+// History handles /clipboard/history?tab=<name>.
+//
+//	GET  – list previous versions of the tab, newest first
+//	POST – restore version=<id>; the current content becomes a version itself
+func (ch *ClipboardHandler) History() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !ch.Quiet {
+			log.Printf("[%s] [%s] %s %s\n", time.Now().Format("2006-01-02 15:04:05"), r.Method, r.URL.String(), r.RemoteAddr)
+		}
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		tabName := r.URL.Query().Get("tab")
+		if tabName == "" {
+			tabName = "default"
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			ch.handleHistoryList(w, r, tabName)
+		case http.MethodPost:
+			ch.handleHistoryRestore(w, r, tabName)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func (ch *ClipboardHandler) handleHistoryList(w http.ResponseWriter, r *http.Request, tabName string) {
+	ch.store.mu.Lock()
+	entry, ok := ch.store.lookup(tabName)
+	if !ok {
+		ch.store.mu.Unlock()
+		http.Error(w, "Tab not found", http.StatusNotFound)
+		return
+	}
+	if !checkTabToken(entry, r) {
+		ch.store.mu.Unlock()
+		w.Header().Set("WWW-Authenticate", `TabToken realm="Tab "`+tabName+`"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	ch.pruneHistory(entry, time.Now())
+	versions := make([]ClipboardVersion, len(entry.History))
+	for i, v := range entry.History {
+		versions[len(versions)-1-i] = v
+	}
+	ch.store.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(historyResponse{
+		Tab:           tabName,
+		Limit:         ch.HistoryLimit,
+		MaxAgeSeconds: int64(ch.HistoryMaxAge / time.Second),
+		Versions:      versions,
+	})
+}
+
+func (ch *ClipboardHandler) handleHistoryRestore(w http.ResponseWriter, r *http.Request, tabName string) {
+	id, err := strconv.ParseInt(r.URL.Query().Get("version"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid version parameter", http.StatusBadRequest)
+		return
+	}
+
+	if !ch.Limiter.AllowHTTP(w, security.PolicyClipboardWrite, clipboardExtractIP(r)) {
+		return
+	}
+
+	ch.store.mu.Lock()
+	entry, ok := ch.store.lookup(tabName)
+	if !ok {
+		ch.store.mu.Unlock()
+		http.Error(w, "Tab not found", http.StatusNotFound)
+		return
+	}
+	if !checkTabToken(entry, r) {
+		ch.store.mu.Unlock()
+		w.Header().Set("WWW-Authenticate", `TabToken realm="Tab "`+tabName+`"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	now := time.Now()
+	ch.pruneHistory(entry, now)
+	var content string
+	found := false
+	for _, v := range entry.History {
+		if v.ID == id {
+			content, found = v.Content, true
+			break
+		}
+	}
+	if !found {
+		ch.store.mu.Unlock()
+		http.Error(w, "Version not found", http.StatusNotFound)
+		return
+	}
+	if entry.Content != content {
+		old := entry.Content
+		ch.pushHistory(entry, now)
+		entry.Content = content
+		entry.UpdatedAt = now
+		entry.Revision++
+		ch.recordReplace(tabName, entry, old)
+	}
+	revision := entry.Revision
+	ch.store.mu.Unlock()
+
+	ch.saveState()
+	ch.broker.Broadcast(tabName)
+
+	w.Header().Set("ETag", tabETag(revision))
+	w.WriteHeader(http.StatusOK)
+	if !ch.Quiet {
+		log.Printf("[%s] Clipboard tab %q restored to version %d\n", time.Now().Format("2006-01-02 15:04:05"), tabName, id)
+	}
+}
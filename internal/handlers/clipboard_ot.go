@@ -0,0 +1,296 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"unicode/utf16"
+)
+
+// textOp is an operational-transform edit of a clipboard tab, in the JSON
+// format used by ot.js: an array whose items are a positive integer (keep that
+// many characters), a string (insert it) or a negative integer (delete that
+// many characters). Lengths count UTF-16 code units, the unit of JavaScript
+// strings and textarea selections, so browser positions need no conversion.
+type textOp []opComponent
+
+// opComponent is a retain (n > 0), a delete (n < 0) or an insert (n == 0).
+type opComponent struct {
+	n   int
+	str string
+}
+
+func (c opComponent) isRetain() bool { return c.n > 0 }
+func (c opComponent) isDelete() bool { return c.n < 0 }
+func (c opComponent) isInsert() bool { return c.n == 0 }
+
+var errOpLength = errors.New("operation length does not match the document")
+
+// maxOpComponent bounds the length of a retain or delete. A tab never holds
+// more UTF-16 code units than maxClipboardContentSize bytes of UTF-8, so a
+// longer one can't match any document.
+const maxOpComponent = maxClipboardContentSize
+
+// retain, insert and delete append to the operation, merging with the last
+// component and keeping inserts ahead of deletes so equal edits compare equal.
+// A retain or delete is only merged while it stays within maxOpComponent.
+func (op *textOp) retain(n int) {
+	if n <= 0 {
+		return
+	}
+	if l := len(*op); l > 0 && (*op)[l-1].isRetain() && (*op)[l-1].n <= maxOpComponent-n {
+		(*op)[l-1].n += n
+		return
+	}
+	*op = append(*op, opComponent{n: n})
+}
+
+func (op *textOp) insert(s string) {
+	if s == "" {
+		return
+	}
+	o := *op
+	l := len(o)
+	switch {
+	case l > 0 && o[l-1].isInsert():
+		o[l-1].str += s
+	case l > 0 && o[l-1].isDelete():
+		if l > 1 && o[l-2].isInsert() {
+			o[l-2].str += s
+		} else {
+			o = append(o, o[l-1])
+			o[l-1] = opComponent{str: s}
+		}
+	default:
+		o = append(o, opComponent{str: s})
+	}
+	*op = o
+}
+
+func (op *textOp) delete(n int) {
+	if n <= 0 {
+		return
+	}
+	if l := len(*op); l > 0 && (*op)[l-1].isDelete() && -(*op)[l-1].n <= maxOpComponent-n {
+		(*op)[l-1].n -= n
+		return
+	}
+	*op = append(*op, opComponent{n: -n})
+}
+
+// utf16Len returns the length of s in UTF-16 code units.
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		if r >= 0x10000 {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+// baseLen is the length of the document the operation applies to, or -1 if
+// that length overflows an int.
+func (op textOp) baseLen() int {
+	n := 0
+	for _, c := range op {
+		if !c.isInsert() {
+			if abs(c.n) > math.MaxInt-n {
+				return -1
+			}
+			n += abs(c.n)
+		}
+	}
+	return n
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// apply returns doc with the operation applied.
+func (op textOp) apply(doc []uint16) ([]uint16, error) {
+	if len(doc) != op.baseLen() {
+		return nil, errOpLength
+	}
+	out := make([]uint16, 0, len(doc))
+	pos := 0
+	for _, c := range op {
+		switch {
+		case c.isRetain():
+			if c.n > len(doc)-pos {
+				return nil, errOpLength
+			}
+			out = append(out, doc[pos:pos+c.n]...)
+			pos += c.n
+		case c.isInsert():
+			out = append(out, utf16.Encode([]rune(c.str))...)
+		default:
+			if -c.n > len(doc)-pos {
+				return nil, errOpLength
+			}
+			pos -= c.n
+		}
+	}
+	return out, nil
+}
+
+// transformOps returns a' and b' such that applying a then b' gives the same
+// document as applying b then a'. When both insert at the same position, a's
+// text comes first: the server passes the client's operation as a, matching
+// what ot.js clients expect.
+func transformOps(a, b textOp) (textOp, textOp, error) {
+	if a.baseLen() != b.baseLen() {
+		return nil, nil, errOpLength
+	}
+	var aPrime, bPrime textOp
+	i, j := 0, 0
+	var ca, cb *opComponent
+	next := func(op textOp, k *int) *opComponent {
+		if *k >= len(op) {
+			return nil
+		}
+		c := op[*k]
+		*k++
+		return &c
+	}
+	ca, cb = next(a, &i), next(b, &j)
+
+	for ca != nil || cb != nil {
+		if ca != nil && ca.isInsert() {
+			aPrime.insert(ca.str)
+			bPrime.retain(utf16Len(ca.str))
+			ca = next(a, &i)
+			continue
+		}
+		if cb != nil && cb.isInsert() {
+			aPrime.retain(utf16Len(cb.str))
+			bPrime.insert(cb.str)
+			cb = next(b, &j)
+			continue
+		}
+		if ca == nil || cb == nil {
+			return nil, nil, errOpLength
+		}
+
+		switch {
+		case ca.isRetain() && cb.isRetain():
+			n := minInt(ca.n, cb.n)
+			aPrime.retain(n)
+			bPrime.retain(n)
+			ca.n -= n
+			cb.n -= n
+		case ca.isDelete() && cb.isDelete():
+			// Both deleted the same text: nothing left to do for either side.
+			n := minInt(-ca.n, -cb.n)
+			ca.n += n
+			cb.n += n
+		case ca.isDelete() && cb.isRetain():
+			n := minInt(-ca.n, cb.n)
+			aPrime.delete(n)
+			ca.n += n
+			cb.n -= n
+		default: // retain in a, delete in b
+			n := minInt(ca.n, -cb.n)
+			bPrime.delete(n)
+			ca.n -= n
+			cb.n += n
+		}
+		if ca.n == 0 {
+			ca = next(a, &i)
+		}
+		if cb.n == 0 {
+			cb = next(b, &j)
+		}
+	}
+	return aPrime, bPrime, nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// diffOp returns an operation turning old into new: a single replaced range
+// between their common prefix and suffix. Used for whole-content writes, such
+// as POST /clipboard, so live editors receive them as ordinary edits.
+func diffOp(old, new []uint16) textOp {
+	prefix := 0
+	for prefix < len(old) && prefix < len(new) && old[prefix] == new[prefix] {
+		prefix++
+	}
+	// Never split a surrogate pair.
+	if prefix > 0 && isHighSurrogate(old[prefix-1]) {
+		prefix--
+	}
+	suffix := 0
+	for suffix < len(old)-prefix && suffix < len(new)-prefix &&
+		old[len(old)-1-suffix] == new[len(new)-1-suffix] {
+		suffix++
+	}
+	if suffix > 0 && isLowSurrogate(old[len(old)-suffix]) {
+		suffix--
+	}
+
+	var op textOp
+	op.retain(prefix)
+	op.delete(len(old) - prefix - suffix)
+	op.insert(string(utf16.Decode(new[prefix : len(new)-suffix])))
+	op.retain(suffix)
+	return op
+}
+
+func isHighSurrogate(u uint16) bool { return u >= 0xd800 && u < 0xdc00 }
+func isLowSurrogate(u uint16) bool  { return u >= 0xdc00 && u < 0xe000 }
+
+func (op textOp) MarshalJSON() ([]byte, error) {
+	items := make([]interface{}, len(op))
+	for i, c := range op {
+		if c.isInsert() {
+			items[i] = c.str
+		} else {
+			items[i] = c.n
+		}
+	}
+	return json.Marshal(items)
+}
+
+func (op *textOp) UnmarshalJSON(data []byte) error {
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return err
+	}
+	var out textOp
+	for _, item := range items {
+		var s string
+		if err := json.Unmarshal(item, &s); err == nil {
+			if s == "" {
+				return errors.New("empty insert in operation")
+			}
+			out.insert(s)
+			continue
+		}
+		var n int
+		if err := json.Unmarshal(item, &n); err != nil || n == 0 {
+			return fmt.Errorf("invalid operation component %s", item)
+		}
+		if n > maxOpComponent || n < -maxOpComponent {
+			return fmt.Errorf("operation component %s is longer than any document", item)
+		}
+		if n > 0 {
+			out.retain(n)
+		} else {
+			out.delete(-n)
+		}
+	}
+	*op = out
+	return nil
+}
@@ -0,0 +1,335 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/wanetty/upgopher/internal/utils"
+)
+
+const (
+	// screenshotStateFile lists the screenshots kept in -state-dir; their
+	// data is stored in screenshotsDir, one file per screenshot.
+	screenshotStateFile    = "screenshots.json"
+	screenshotsDir         = "screenshots"
+	screenshotStateVersion = 1
+)
+
+// ScreenshotLimits bounds the screenshot galleries of the clipboard tabs.
+type ScreenshotLimits struct {
+	MaxCount int           // screenshots kept per tab; the oldest is dropped first
+	MaxSize  int64         // largest screenshot in bytes
+	MaxBytes int64         // bytes across all galleries; 0 = no limit
+	MaxAge   time.Duration // screenshots older than this are dropped; 0 = no limit
+}
+
+// DefaultScreenshotLimits returns the limits used when none are configured.
+// All galleries together hold at most 50 screenshots of the largest size,
+// however many tabs there are.
+func DefaultScreenshotLimits() ScreenshotLimits {
+	return ScreenshotLimits{MaxCount: 50, MaxSize: 16 << 20, MaxBytes: 50 * 16 << 20}
+}
+
+// expired reports whether img is older than the age limit at now.
+func (l ScreenshotLimits) expired(img ImageEntry, now time.Time) bool {
+	return l.MaxAge > 0 && now.Sub(img.UpdatedAt) >= l.MaxAge
+}
+
+// screenshotsResponse is the JSON body returned by GET /api/v1/screenshots.
+type screenshotsResponse struct {
+	Tab           string       `json:"tab"`
+	MaxCount      int          `json:"maxCount"`
+	MaxSize       int64        `json:"maxSize"`
+	MaxBytes      int64        `json:"maxBytes"`   // 0 = no limit
+	TotalBytes    int64        `json:"totalBytes"` // used across all galleries
+	MaxAgeSeconds int64        `json:"maxAgeSeconds"`
+	Images        []ImageEntry `json:"images"` // oldest first
+}
+
+// find returns a copy of the screenshot called id. Image data is never
+// modified in place, so the copy can be read without the lock.
+func (s *screenshotStore) find(id string) (ImageEntry, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, img := range s.images {
+		if img.ID == id {
+			return img, true
+		}
+	}
+	return ImageEntry{}, false
+}
+
+// totalBytes returns the size of every stored screenshot. The caller must hold s.mu.
+func (s *screenshotStore) totalBytes() int64 {
+	var n int64
+	for _, img := range s.images {
+		n += int64(img.Size)
+	}
+	return n
+}
+
+// pruneLocked drops screenshots that are too old or beyond the per-tab count,
+// then the oldest ones until the total byte budget is met, and returns the
+// tabs that lost any. The caller must hold s.mu for writing.
+func (s *screenshotStore) pruneLocked(l ScreenshotLimits, now time.Time) map[string]bool {
+	changed := make(map[string]bool)
+	counts := make(map[string]int)
+	for _, img := range s.images {
+		if !l.expired(img, now) {
+			counts[img.Tab]++
+		}
+	}
+	kept := s.images[:0:0]
+	for _, img := range s.images {
+		if l.expired(img, now) {
+			changed[img.Tab] = true
+			continue
+		}
+		if counts[img.Tab] > l.MaxCount {
+			counts[img.Tab]--
+			changed[img.Tab] = true
+			continue
+		}
+		kept = append(kept, img)
+	}
+
+	if l.MaxBytes > 0 {
+		var total int64
+		for _, img := range kept {
+			total += int64(img.Size)
+		}
+		for total > l.MaxBytes {
+			total -= int64(kept[0].Size)
+			changed[kept[0].Tab] = true
+			kept = kept[1:]
+		}
+	}
+
+	if len(changed) > 0 {
+		s.images = kept
+		s.dirty = true
+	}
+	return changed
+}
+
+// removeTab drops every screenshot of a deleted tab.
+func (s *screenshotStore) removeTab(tab string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.images[:0:0]
+	for _, img := range s.images {
+		if img.Tab != tab {
+			kept = append(kept, img)
+		}
+	}
+	if len(kept) != len(s.images) {
+		s.images = kept
+		s.dirty = true
+	}
+}
+
+// screenshotsChanged bumps the screenshot revision of tab and tells its live
+// editors. The caller must hold the store write lock; SSE subscribers are
+// notified by the caller's Broadcast once it is released.
+func (ch *ClipboardHandler) screenshotsChanged(tabName string, entry *ClipboardEntry) {
+	entry.screenshotsRev++
+	h := ch.collab
+	h.mu.Lock()
+	h.publish(tabName, collabEvent{Type: "screenshots", Screenshots: entry.screenshotsRev}, nil)
+	h.mu.Unlock()
+}
+
+// galleriesChangedLocked calls screenshotsChanged for every tab in tabs that
+// still exists. The caller must hold the store write lock.
+func (ch *ClipboardHandler) galleriesChangedLocked(tabs map[string]bool) {
+	for name := range tabs {
+		if entry, ok := ch.store.lookup(name); ok {
+			ch.screenshotsChanged(name, entry)
+		}
+	}
+}
+
+// broadcastGalleries notifies the SSE subscribers of every tab in tabs.
+func (ch *ClipboardHandler) broadcastGalleries(tabs map[string]bool) {
+	for name := range tabs {
+		ch.broker.Broadcast(name)
+	}
+}
+
+// expireScreenshots removes screenshots older than the age limit. Listings
+// already hide them; this frees their memory and files.
+func (ch *ClipboardHandler) expireScreenshots(now time.Time) {
+	if ch.ScreenshotLimits.MaxAge <= 0 {
+		return
+	}
+	ch.store.mu.Lock()
+	ch.imgStore.mu.Lock()
+	changed := ch.imgStore.pruneLocked(ch.ScreenshotLimits, now)
+	ch.imgStore.mu.Unlock()
+	ch.galleriesChangedLocked(changed)
+	ch.store.mu.Unlock()
+
+	if len(changed) > 0 {
+		ch.saveScreenshots()
+		ch.broadcastGalleries(changed)
+		if !ch.Quiet {
+			log.Printf("[%s] Expired screenshots older than %s\n", time.Now().Format("2006-01-02 15:04:05"), ch.ScreenshotLimits.MaxAge)
+		}
+	}
+}
+
+// screenshotsTab returns the tab named by the tab query parameter, defaulting
+// to the default tab like /clipboard does.
+func screenshotsTab(r *http.Request) string {
+	if tab := r.URL.Query().Get("tab"); tab != "" {
+		return tab
+	}
+	return "default"
+}
+
+// ── Persistence ───────────────────────────────────────────────────────────────
+
+// screenshotState is the JSON document written to screenshotStateFile.
+type screenshotState struct {
+	Version int          `json:"version"`
+	Images  []ImageEntry `json:"images"`
+}
+
+// enableScreenshotPersistence loads the screenshots saved in stateDir whose
+// tab still exists, applies the current limits and saves every later change
+// there. Tabs must already be restored.
+func (ch *ClipboardHandler) enableScreenshotPersistence(stateDir string) error {
+	path := filepath.Join(stateDir, screenshotStateFile)
+	dir := filepath.Join(stateDir, screenshotsDir)
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("reading screenshot state: %v", err)
+	}
+	var restored []ImageEntry
+	if err == nil {
+		var state screenshotState
+		if err := json.Unmarshal(data, &state); err != nil {
+			return fmt.Errorf("parsing screenshot state %s: %v", path, err)
+		}
+		if state.Version != screenshotStateVersion {
+			return fmt.Errorf("unsupported screenshot state version %d in %s", state.Version, path)
+		}
+		restored = ch.loadScreenshots(dir, state.Images)
+		if !ch.Quiet {
+			log.Printf("[%s] Restored %d screenshot(s) from %s\n", time.Now().Format("2006-01-02 15:04:05"), len(restored), path)
+		}
+	}
+
+	ch.imgStore.mu.Lock()
+	ch.imgStore.images = restored
+	ch.imgStore.pruneLocked(ch.ScreenshotLimits, time.Now())
+	ch.imgStore.statePath = path
+	ch.imgStore.mu.Unlock()
+
+	return ch.imgStore.save()
+}
+
+// loadScreenshots reads the data of the saved screenshots from dir, keeping
+// those whose file is intact, whose tab can hold screenshots and that fit
+// the size limit, oldest first.
+func (ch *ClipboardHandler) loadScreenshots(dir string, images []ImageEntry) []ImageEntry {
+	ch.store.mu.RLock()
+	defer ch.store.mu.RUnlock()
+
+	var kept []ImageEntry
+	for _, img := range images {
+		tab, ok := ch.store.lookup(img.Tab)
+		if !ok || tab.BurnAfterRead || tab.Encrypted() || !blobIDRegex.MatchString(img.ID) || int64(img.Size) > ch.ScreenshotLimits.MaxSize {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, img.ID))
+		if err != nil || len(data) != img.Size || !isAllowedClipboardImageContentType(img.ContentType, data) {
+			continue
+		}
+		img.Data = data
+		// thumbnails aren't saved; they are cheaper to make again
+		img.Width, img.Height, img.Thumbnail, img.ThumbnailType = imageInfo(data, img.ContentType)
+		kept = append(kept, img)
+	}
+	sort.SliceStable(kept, func(i, j int) bool { return kept[i].UpdatedAt.Before(kept[j].UpdatedAt) })
+	return kept
+}
+
+// dir returns the directory screenshot data is written to, or "" when
+// persistence is disabled. The caller must hold s.mu.
+func (s *screenshotStore) dir() string {
+	if s.statePath == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(s.statePath), screenshotsDir)
+}
+
+// writeImage stores the data of a new screenshot. Like attachments, it holds
+// saveMu so a save that started earlier can't sweep the file.
+func (s *screenshotStore) writeImage(img ImageEntry) error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.RLock()
+	dir := s.dir()
+	s.mu.RUnlock()
+	if dir == "" {
+		return nil
+	}
+	return writeBlob(dir, img.ID, img.Data)
+}
+
+// save writes the screenshot list to the state file and removes the files of
+// screenshots that are gone. It is a no-op when persistence is disabled.
+func (s *screenshotStore) save() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.Lock()
+	path, dir := s.statePath, s.dir()
+	if path == "" {
+		s.mu.Unlock()
+		return nil
+	}
+	state := screenshotState{Version: screenshotStateVersion, Images: append([]ImageEntry{}, s.images...)}
+	s.dirty = false
+	s.mu.Unlock()
+
+	keep := make(map[string]bool, len(state.Images))
+	for _, img := range state.Images {
+		keep[img.ID] = true
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := utils.WriteFileAtomic(path, data, 0600); err != nil {
+		return err
+	}
+	return sweepBlobs(dir, keep)
+}
+
+// saveScreenshots persists the screenshot list after a change, logging
+// failures like saveState.
+func (ch *ClipboardHandler) saveScreenshots() {
+	if err := ch.imgStore.save(); err != nil {
+		log.Printf("[%s] Error saving screenshot state: %v\n", time.Now().Format("2006-01-02 15:04:05"), err)
+	}
+}
+
+// takeDirty reports whether screenshots were removed without being saved,
+// as happens when their tab is deleted, and clears the flag.
+func (s *screenshotStore) takeDirty() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dirty := s.dirty
+	s.dirty = false
+	return dirty
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/wanetty/upgopher/internal/utils"
+)
+
+// clipboardStateFile is the name of the clipboard snapshot inside -state-dir.
+const clipboardStateFile = "clipboard.json"
+
+const clipboardStateVersion = 1
+
+// persistedTab is the on-disk form of a clipboard tab. Protected tabs are
+// stored with their SHA-256 token hash only; the plaintext token is never
+// known to the server after creation.
+type persistedTab struct {
+	Name      string    `json:"name"`
+	Content   string    `json:"content"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	TokenHash string    `json:"tokenHash,omitempty"`
+	Revision  int64     `json:"revision,omitempty"`
+
+	ExpiresAt     *time.Time     `json:"expiresAt,omitempty"`
+	BurnAfterRead bool           `json:"burnAfterRead,omitempty"`
+	Encryption    *TabEncryption `json:"encryption,omitempty"`
+
+	History []ClipboardVersion `json:"history,omitempty"`
+	// Attachments are listed here; their data lives in clipboardAttachmentsDir.
+	Attachments []TabAttachment `json:"attachments,omitempty"`
+}
+
+// clipboardState is the JSON document written to clipboardStateFile.
+type clipboardState struct {
+	Version int            `json:"version"`
+	Tabs    []persistedTab `json:"tabs"`
+}
+
+// EnablePersistence loads the tabs saved in stateDir, if any, and saves every
+// later change there. It must be called before the handler serves requests.
+func (ch *ClipboardHandler) EnablePersistence(stateDir string) error {
+	path := filepath.Join(stateDir, clipboardStateFile)
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("reading clipboard state: %v", err)
+	}
+	if err == nil {
+		var state clipboardState
+		if err := json.Unmarshal(data, &state); err != nil {
+			return fmt.Errorf("parsing clipboard state %s: %v", path, err)
+		}
+		if state.Version != clipboardStateVersion {
+			return fmt.Errorf("unsupported clipboard state version %d in %s", state.Version, path)
+		}
+		loadAttachments(filepath.Join(stateDir, clipboardAttachmentsDir), state.Tabs)
+		dropped := ch.store.restore(state.Tabs)
+		ch.store.mu.Lock()
+		now := time.Now()
+		for _, entry := range ch.store.tabs {
+			ch.pruneHistory(entry, now)
+		}
+		ch.store.mu.Unlock()
+		if !ch.Quiet {
+			log.Printf("[%s] Restored %d clipboard tab(s) from %s\n", time.Now().Format("2006-01-02 15:04:05"), len(state.Tabs)-dropped, path)
+			if dropped > 0 {
+				log.Printf("[%s] Skipped %d saved clipboard tab(s): invalid or over the -max-tabs limit\n", time.Now().Format("2006-01-02 15:04:05"), dropped)
+			}
+		}
+	}
+
+	ch.store.mu.Lock()
+	ch.store.statePath = path
+	ch.store.mu.Unlock()
+
+	// Write once so a trimmed or freshly created state is on disk immediately.
+	if err := ch.store.save(); err != nil {
+		return err
+	}
+	return ch.enableScreenshotPersistence(stateDir)
+}
+
+// restore replaces the store contents with tabs, keeping the default tab and
+// the most recently updated tabs up to maxTabs. Tabs that expired while the
+// server was down are dropped. It returns how many saved tabs were skipped.
+func (s *clipboardStore) restore(tabs []persistedTab) int {
+	now := time.Now()
+	valid := make([]persistedTab, 0, len(tabs))
+	for _, t := range tabs {
+		if !tabNameRegex.MatchString(t.Name) || !validTokenHash(t.TokenHash) {
+			continue
+		}
+		if t.Name == "default" {
+			// the default tab is never protected and never expires
+			t.TokenHash = ""
+			t.ExpiresAt, t.BurnAfterRead, t.Encryption = nil, false, nil
+		} else if t.ExpiresAt != nil && !now.Before(*t.ExpiresAt) {
+			continue
+		}
+		valid = append(valid, t)
+	}
+
+	// Newest first, with the default tab ahead of everything so it survives trimming.
+	sort.SliceStable(valid, func(i, j int) bool {
+		if (valid[i].Name == "default") != (valid[j].Name == "default") {
+			return valid[i].Name == "default"
+		}
+		return valid[i].UpdatedAt.After(valid[j].UpdatedAt)
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := 0
+	for _, t := range valid {
+		if _, dup := s.tabs[t.Name]; dup && t.Name != "default" {
+			continue
+		}
+		if t.Name != "default" && len(s.tabs) >= s.maxTabs {
+			break
+		}
+		entry := &ClipboardEntry{Content: t.Content, UpdatedAt: t.UpdatedAt, TokenHash: t.TokenHash, History: t.History, Revision: t.Revision, BurnAfterRead: t.BurnAfterRead, Encryption: t.Encryption, Attachments: t.Attachments}
+		if t.ExpiresAt != nil {
+			entry.ExpiresAt = *t.ExpiresAt
+		}
+		if entry.Revision < 1 {
+			entry.Revision = 1 // state written before revisions existed
+		}
+		for _, v := range t.History {
+			if v.ID > entry.nextVersion {
+				entry.nextVersion = v.ID
+			}
+		}
+		s.tabs[t.Name] = entry
+		kept++
+	}
+	return len(tabs) - kept
+}
+
+// validTokenHash accepts an empty hash (unprotected) or a SHA-256 hex digest.
+func validTokenHash(h string) bool {
+	if h == "" {
+		return true
+	}
+	if len(h) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(h)
+	return err == nil
+}
+
+// save writes a snapshot of all tabs to the state file and removes the files
+// of attachments that are gone. It is a no-op when persistence is disabled.
+// saveMu serializes writers, and the snapshot is taken after acquiring it, so
+// the last write always holds the newest state.
+func (s *clipboardStore) save() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.RLock()
+	path := s.statePath
+	if path == "" {
+		s.mu.RUnlock()
+		return nil
+	}
+	attachmentsDir := s.attachmentsDir()
+	keep := make(map[string]bool)
+	state := clipboardState{Version: clipboardStateVersion, Tabs: make([]persistedTab, 0, len(s.tabs))}
+	for name, entry := range s.tabs {
+		t := persistedTab{
+			Name:          name,
+			Content:       entry.Content,
+			UpdatedAt:     entry.UpdatedAt,
+			TokenHash:     entry.TokenHash,
+			Revision:      entry.Revision,
+			BurnAfterRead: entry.BurnAfterRead,
+			Encryption:    entry.Encryption,
+			History:       entry.History,
+			Attachments:   entry.Attachments,
+		}
+		for _, a := range entry.Attachments {
+			keep[a.ID] = true
+		}
+		if !entry.ExpiresAt.IsZero() {
+			expiresAt := entry.ExpiresAt
+			t.ExpiresAt = &expiresAt
+		}
+		state.Tabs = append(state.Tabs, t)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(state.Tabs, func(i, j int) bool { return state.Tabs[i].Name < state.Tabs[j].Name })
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := utils.WriteFileAtomic(path, data, 0600); err != nil {
+		return err
+	}
+	return sweepBlobs(attachmentsDir, keep)
+}
+
+// saveState persists the store after a change. Failures are logged rather
+// than returned: the in-memory change already succeeded.
+func (ch *ClipboardHandler) saveState() {
+	if err := ch.store.save(); err != nil {
+		log.Printf("[%s] Error saving clipboard state: %v\n", time.Now().Format("2006-01-02 15:04:05"), err)
+	}
+	if ch.imgStore.takeDirty() {
+		ch.saveScreenshots()
+	}
+}
@@ -2,15 +2,30 @@ package handlers
 
 import (
 	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
 	"strings"
 	"sync"
 	"testing"
+	"time"
+
+	"github.com/wanetty/upgopher/internal/security"
 )
 
 func newTestClipboardHandler() *ClipboardHandler {
@@ -47,10 +62,14 @@ func TestScreenshotsPostAndGet(t *testing.T) {
 		t.Fatalf("GET list expected 200, got %d: %s", w2.Code, w2.Body.String())
 	}
 
-	var list []ImageEntry
-	if err := json.NewDecoder(w2.Body).Decode(&list); err != nil {
+	var resp screenshotsResponse
+	if err := json.NewDecoder(w2.Body).Decode(&resp); err != nil {
 		t.Fatalf("failed to decode list: %v", err)
 	}
+	list := resp.Images
+	if resp.Tab != "default" || resp.MaxCount != 50 || resp.MaxSize != 16<<20 || resp.TotalBytes != int64(len(image)) {
+		t.Errorf("listing limits = %+v", resp)
+	}
 	if len(list) != 1 || list[0].ID != created.ID {
 		t.Fatalf("list mismatch, got length %d", len(list))
 	}
@@ -119,11 +138,11 @@ func TestScreenshotsLimitFIFO(t *testing.T) {
 		t.Fatalf("GET expected 200, got %d", w.Code)
 	}
 
-	var list []ImageEntry
-	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+	var resp screenshotsResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
 		t.Fatalf("failed to decode list: %v", err)
 	}
-	if len(list) != 50 {
+	if list := resp.Images; len(list) != 50 {
 		t.Fatalf("expected exactly 50 images in list, got %d", len(list))
 	}
 }
@@ -154,9 +173,9 @@ func TestScreenshotsDelete(t *testing.T) {
 	req3 := httptest.NewRequest(http.MethodGet, "/api/v1/screenshots", nil)
 	w3 := httptest.NewRecorder()
 	h.Screenshots()(w3, req3)
-	var list []ImageEntry
-	json.NewDecoder(w3.Body).Decode(&list)
-	if len(list) != 0 {
+	var resp screenshotsResponse
+	json.NewDecoder(w3.Body).Decode(&resp)
+	if list := resp.Images; len(list) != 0 {
 		t.Fatalf("expected 0 images, got %d", len(list))
 	}
 }
@@ -585,3 +604,1449 @@ func TestClipboardConcurrentReadWrite(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func TestClipboardStats(t *testing.T) {
+	h := newTestClipboardHandler()
+
+	req := httptest.NewRequest(http.MethodPost, "/clipboard?tab=notes", strings.NewReader("x"))
+	req.RemoteAddr = "10.250.0.9:1000"
+	h.Handle()(httptest.NewRecorder(), req)
+
+	image := []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n', 0, 0}
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/screenshots", bytes.NewReader(image))
+	req.RemoteAddr = "10.250.0.9:1000"
+	req.Header.Set("Content-Type", "image/png")
+	h.Screenshots()(httptest.NewRecorder(), req)
+
+	_, unsubscribe := h.broker.Subscribe("notes")
+	defer unsubscribe()
+
+	stats := h.Stats()
+	if stats.Tabs != 2 || stats.Subscribers != 1 || stats.Screenshots != 1 || stats.ScreenshotBytes != int64(len(image)) {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+// ── Persistence tests ─────────────────────────────────────────────────────────
+
+func newPersistentClipboardHandler(t *testing.T, dir string, maxTabs int) *ClipboardHandler {
+	t.Helper()
+	h := NewClipboardHandler(true, maxTabs)
+	if err := h.EnablePersistence(dir); err != nil {
+		t.Fatalf("EnablePersistence: %v", err)
+	}
+	return h
+}
+
+func clipboardRequest(t *testing.T, h *ClipboardHandler, method, tab, body, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, "/clipboard?tab="+url.QueryEscape(tab), strings.NewReader(body))
+	req.RemoteAddr = "10.32.0.1:10000"
+	if token != "" {
+		req.Header.Set("X-Tab-Token", token)
+	}
+	w := httptest.NewRecorder()
+	h.Handle()(w, req)
+	return w
+}
+
+// TestClipboardPersistenceRoundTrip verifies tabs survive a restart and that
+// only the token hash of a protected tab reaches the disk.
+func TestClipboardPersistenceRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	h := newPersistentClipboardHandler(t, dir, 5)
+
+	if w := clipboardRequest(t, h, http.MethodPost, "notes", "remember the milk", ""); w.Code != http.StatusCreated {
+		t.Fatalf("create notes: %d", w.Code)
+	}
+	if w := clipboardRequest(t, h, http.MethodPost, "default", "default text", ""); w.Code != http.StatusOK {
+		t.Fatalf("update default: %d", w.Code)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/clipboard?tab=vault", strings.NewReader("initial"))
+	req.RemoteAddr = "10.32.0.1:10000"
+	req.Header.Set("X-Tab-Token-Create", "1")
+	w := httptest.NewRecorder()
+	h.Handle()(w, req)
+	token := w.Header().Get("X-Generated-Token")
+	if w.Code != http.StatusCreated || token == "" {
+		t.Fatalf("create protected tab: %d", w.Code)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, clipboardStateFile))
+	if err != nil {
+		t.Fatalf("state file not written: %v", err)
+	}
+	if strings.Contains(string(data), token) {
+		t.Fatal("plaintext token written to the state file")
+	}
+	if !strings.Contains(string(data), tokenHash(token)) {
+		t.Fatal("token hash missing from the state file")
+	}
+	if info, _ := os.Stat(filepath.Join(dir, clipboardStateFile)); info.Mode().Perm() != 0600 {
+		t.Errorf("state file mode = %v, want 0600", info.Mode().Perm())
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, ".*tmp*")); len(matches) != 0 {
+		t.Errorf("temporary files left behind: %v", matches)
+	}
+
+	restarted := newPersistentClipboardHandler(t, dir, 5)
+	if w := clipboardRequest(t, restarted, http.MethodGet, "notes", "", ""); w.Body.String() != "remember the milk" {
+		t.Errorf("notes after restart = %q", w.Body.String())
+	}
+	if w := clipboardRequest(t, restarted, http.MethodGet, "default", "", ""); w.Body.String() != "default text" {
+		t.Errorf("default after restart = %q", w.Body.String())
+	}
+	if w := clipboardRequest(t, restarted, http.MethodGet, "vault", "", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("protected tab without token after restart: %d, want 401", w.Code)
+	}
+	if w := clipboardRequest(t, restarted, http.MethodGet, "vault", "", token); w.Code != http.StatusOK || w.Body.String() != "initial" {
+		t.Errorf("protected tab with token after restart: %d %q", w.Code, w.Body.String())
+	}
+}
+
+// TestClipboardPersistenceDelete verifies deleted tabs stay deleted after a restart.
+func TestClipboardPersistenceDelete(t *testing.T) {
+	dir := t.TempDir()
+	h := newPersistentClipboardHandler(t, dir, 5)
+	clipboardRequest(t, h, http.MethodPost, "scratch", "temp", "")
+	if w := clipboardRequest(t, h, http.MethodDelete, "scratch", "", ""); w.Code != http.StatusOK {
+		t.Fatalf("delete: %d", w.Code)
+	}
+
+	restarted := newPersistentClipboardHandler(t, dir, 5)
+	if w := clipboardRequest(t, restarted, http.MethodGet, "scratch", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("deleted tab after restart: %d, want 404", w.Code)
+	}
+}
+
+// TestClipboardPersistenceMaxTabs verifies a state file with more tabs than
+// -max-tabs allows keeps the default tab and the most recently updated ones.
+func TestClipboardPersistenceMaxTabs(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	state := clipboardState{Version: clipboardStateVersion, Tabs: []persistedTab{
+		{Name: "default", Content: "d", UpdatedAt: now.Add(-time.Hour)},
+		{Name: "old", Content: "o", UpdatedAt: now.Add(-3 * time.Minute)},
+		{Name: "newer", Content: "n", UpdatedAt: now.Add(-2 * time.Minute)},
+		{Name: "newest", Content: "nn", UpdatedAt: now.Add(-time.Minute)},
+		{Name: "bad/name", Content: "x", UpdatedAt: now},
+		{Name: "badhash", Content: "x", UpdatedAt: now, TokenHash: "not-a-hash"},
+	}}
+	data, _ := json.Marshal(state)
+	if err := os.WriteFile(filepath.Join(dir, clipboardStateFile), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	h := newPersistentClipboardHandler(t, dir, 3)
+	for tab, want := range map[string]int{"default": 200, "newest": 200, "newer": 200, "old": 404, "badhash": 404} {
+		if w := clipboardRequest(t, h, http.MethodGet, tab, "", ""); w.Code != want {
+			t.Errorf("tab %q: got %d, want %d", tab, w.Code, want)
+		}
+	}
+
+	// The trimmed set is written back so the dropped tab doesn't reappear later.
+	restarted := newPersistentClipboardHandler(t, dir, 5)
+	if got := restarted.Stats().Tabs; got != 3 {
+		t.Errorf("tabs after second restart = %d, want 3", got)
+	}
+}
+
+// TestClipboardPersistenceCorruptState verifies an unreadable state file is reported.
+func TestClipboardPersistenceCorruptState(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, clipboardStateFile), []byte("{not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewClipboardHandler(true, 5).EnablePersistence(dir); err == nil {
+		t.Fatal("expected an error for a corrupt state file")
+	}
+}
+
+// ── History tests ─────────────────────────────────────────────────────────────
+
+func historyRequest(t *testing.T, h *ClipboardHandler, method, query, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, "/clipboard/history?"+query, nil)
+	req.RemoteAddr = "10.33.0.1:10000"
+	if token != "" {
+		req.Header.Set("X-Tab-Token", token)
+	}
+	w := httptest.NewRecorder()
+	h.History()(w, req)
+	return w
+}
+
+func tabHistory(t *testing.T, h *ClipboardHandler, tab string) []ClipboardVersion {
+	t.Helper()
+	w := historyRequest(t, h, http.MethodGet, "tab="+tab, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET history: %d %s", w.Code, w.Body.String())
+	}
+	var resp historyResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	return resp.Versions
+}
+
+func saveTab(t *testing.T, h *ClipboardHandler, tab, content string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/clipboard?tab="+tab, strings.NewReader(content))
+	req.RemoteAddr = "10.33.0.2:10000"
+	w := httptest.NewRecorder()
+	h.Handle()(w, req)
+	if w.Code != http.StatusOK && w.Code != http.StatusCreated {
+		t.Fatalf("save %q: %d", content, w.Code)
+	}
+}
+
+// TestClipboardHistoryRecordsVersions verifies each changing save keeps the
+// previous content, newest first, and that unchanged saves add nothing.
+func TestClipboardHistoryRecordsVersions(t *testing.T) {
+	h := newTestClipboardHandler()
+	saveTab(t, h, "default", "one")
+	saveTab(t, h, "default", "two")
+	saveTab(t, h, "default", "two")
+	saveTab(t, h, "default", "")
+
+	versions := tabHistory(t, h, "default")
+	var contents []string
+	for _, v := range versions {
+		contents = append(contents, v.Content)
+	}
+	if strings.Join(contents, ",") != "two,one," {
+		t.Errorf("history = %q, want [two one \"\"]", contents)
+	}
+	if versions[0].ID <= versions[1].ID {
+		t.Errorf("IDs not increasing: %d, %d", versions[1].ID, versions[0].ID)
+	}
+}
+
+// TestClipboardHistoryLimits verifies the count and age limits.
+func TestClipboardHistoryLimits(t *testing.T) {
+	h := newTestClipboardHandler()
+	h.HistoryLimit = 2
+	for _, c := range []string{"a", "b", "c", "d"} {
+		saveTab(t, h, "default", c)
+	}
+	versions := tabHistory(t, h, "default")
+	if len(versions) != 2 || versions[0].Content != "c" || versions[1].Content != "b" {
+		t.Fatalf("count limit: got %+v", versions)
+	}
+
+	h.store.mu.Lock()
+	h.store.tabs["default"].History[0].ReplacedAt = time.Now().Add(-h.HistoryMaxAge - time.Minute)
+	h.store.mu.Unlock()
+	versions = tabHistory(t, h, "default")
+	if len(versions) != 1 || versions[0].Content != "c" {
+		t.Errorf("age limit: got %+v", versions)
+	}
+
+	h.HistoryLimit = 0
+	saveTab(t, h, "default", "e")
+	if versions = tabHistory(t, h, "default"); len(versions) != 0 {
+		t.Errorf("history disabled: got %d versions", len(versions))
+	}
+}
+
+// TestClipboardHistoryRestore verifies restoring a version, and that the
+// replaced content becomes a version itself.
+func TestClipboardHistoryRestore(t *testing.T) {
+	h := newTestClipboardHandler()
+	saveTab(t, h, "default", "important notes")
+	saveTab(t, h, "default", "")
+
+	versions := tabHistory(t, h, "default")
+	w := historyRequest(t, h, http.MethodPost, fmt.Sprintf("tab=default&version=%d", versions[0].ID), "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("restore: %d %s", w.Code, w.Body.String())
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/clipboard", nil)
+	rec := httptest.NewRecorder()
+	h.Handle()(rec, req)
+	if rec.Body.String() != "important notes" {
+		t.Errorf("content after restore = %q", rec.Body.String())
+	}
+	if versions = tabHistory(t, h, "default"); len(versions) != 3 || versions[0].Content != "" || versions[1].Content != "important notes" {
+		t.Errorf("history after restore = %+v", versions)
+	}
+
+	if w := historyRequest(t, h, http.MethodPost, "tab=default&version=999", ""); w.Code != http.StatusNotFound {
+		t.Errorf("unknown version: %d, want 404", w.Code)
+	}
+	if w := historyRequest(t, h, http.MethodPost, "tab=default&version=x", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid version: %d, want 400", w.Code)
+	}
+	if w := historyRequest(t, h, http.MethodGet, "tab=missing", ""); w.Code != http.StatusNotFound {
+		t.Errorf("unknown tab: %d, want 404", w.Code)
+	}
+}
+
+// TestClipboardHistoryProtectedTab verifies history of a protected tab needs its token.
+func TestClipboardHistoryProtectedTab(t *testing.T) {
+	h := newTestClipboardHandler()
+	req := httptest.NewRequest(http.MethodPost, "/clipboard?tab=private", strings.NewReader("v1"))
+	req.RemoteAddr = "10.33.0.3:10000"
+	req.Header.Set("X-Tab-Token-Create", "1")
+	req.Header.Set("X-Tab-Token-Value", "hunter22")
+	w := httptest.NewRecorder()
+	h.Handle()(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: %d", w.Code)
+	}
+
+	if w := historyRequest(t, h, http.MethodGet, "tab=private", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET without token: %d, want 401", w.Code)
+	}
+	if w := historyRequest(t, h, http.MethodPost, "tab=private&version=1", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("restore without token: %d, want 401", w.Code)
+	}
+	if w := historyRequest(t, h, http.MethodGet, "tab=private", "hunter22"); w.Code != http.StatusOK {
+		t.Errorf("GET with token: %d, want 200", w.Code)
+	}
+}
+
+// TestClipboardHistoryPersisted verifies versions survive a restart.
+func TestClipboardHistoryPersisted(t *testing.T) {
+	dir := t.TempDir()
+	h := newPersistentClipboardHandler(t, dir, 5)
+	saveTab(t, h, "default", "first")
+	saveTab(t, h, "default", "second")
+
+	restarted := newPersistentClipboardHandler(t, dir, 5)
+	versions := tabHistory(t, restarted, "default")
+	if len(versions) != 2 || versions[0].Content != "first" {
+		t.Fatalf("history after restart = %+v", versions)
+	}
+	saveTab(t, restarted, "default", "third")
+	if v := tabHistory(t, restarted, "default"); v[0].ID <= versions[0].ID {
+		t.Errorf("version IDs restarted after reload: %d <= %d", v[0].ID, versions[0].ID)
+	}
+}
+
+// ── Revision / If-Match tests ─────────────────────────────────────────────────
+
+func conditionalPost(t *testing.T, h *ClipboardHandler, tab, body, ifMatch string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/clipboard?tab="+tab, strings.NewReader(body))
+	req.RemoteAddr = "10.34.0.1:10000"
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	w := httptest.NewRecorder()
+	h.Handle()(w, req)
+	return w
+}
+
+func tabContent(t *testing.T, h *ClipboardHandler, tab string) (string, string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.Handle()(w, httptest.NewRequest(http.MethodGet, "/clipboard?tab="+tab, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s: %d", tab, w.Code)
+	}
+	return w.Body.String(), w.Header().Get("ETag")
+}
+
+// TestClipboardIfMatch verifies that a stale If-Match is rejected with 412
+// and leaves the content alone, while a current one is accepted.
+func TestClipboardIfMatch(t *testing.T) {
+	h := newTestClipboardHandler()
+
+	_, etag := tabContent(t, h, "default")
+	if etag != `"1"` {
+		t.Fatalf("initial ETag = %s, want \"1\"", etag)
+	}
+
+	w := conditionalPost(t, h, "default", "alice", etag)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != `"2"` {
+		t.Fatalf("matching If-Match: %d ETag %s", w.Code, w.Header().Get("ETag"))
+	}
+
+	w = conditionalPost(t, h, "default", "bob", etag)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("stale If-Match: got %d, want 412", w.Code)
+	}
+	if w.Header().Get("ETag") != `"2"` {
+		t.Errorf("412 ETag = %s, want current \"2\"", w.Header().Get("ETag"))
+	}
+	if content, _ := tabContent(t, h, "default"); content != "alice" {
+		t.Errorf("content after 412 = %q, want alice", content)
+	}
+
+	if w := conditionalPost(t, h, "default", "bob", `"7", "2"`); w.Code != http.StatusOK {
+		t.Errorf("If-Match list: got %d", w.Code)
+	}
+	if w := conditionalPost(t, h, "default", "carol", `W/"3"`); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("weak If-Match: got %d, want 412", w.Code)
+	}
+	if w := conditionalPost(t, h, "default", "carol", "*"); w.Code != http.StatusOK {
+		t.Errorf("If-Match *: got %d", w.Code)
+	}
+	if w := conditionalPost(t, h, "default", "carol", ""); w.Header().Get("ETag") != `"4"` {
+		t.Errorf("unchanged save bumped revision: ETag %s", w.Header().Get("ETag"))
+	}
+
+	if w := conditionalPost(t, h, "fresh", "x", "*"); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("If-Match on missing tab: got %d, want 412", w.Code)
+	}
+	if w := conditionalPost(t, h, "fresh", "x", ""); w.Code != http.StatusCreated || w.Header().Get("ETag") != `"1"` {
+		t.Errorf("create: %d ETag %s", w.Code, w.Header().Get("ETag"))
+	}
+}
+
+// TestClipboardIfMatchProtectedTab verifies the token is checked before the
+// precondition, so a 412 never reveals a locked tab's revision.
+func TestClipboardIfMatchProtectedTab(t *testing.T) {
+	h := newTestClipboardHandler()
+	req := httptest.NewRequest(http.MethodPost, "/clipboard?tab=locked", strings.NewReader("secret"))
+	req.RemoteAddr = "10.34.0.2:10000"
+	req.Header.Set("X-Tab-Token-Create", "1")
+	req.Header.Set("X-Tab-Token-Value", "hunter22")
+	h.Handle()(httptest.NewRecorder(), req)
+
+	w := conditionalPost(t, h, "locked", "overwrite", `"99"`)
+	if w.Code != http.StatusUnauthorized || w.Header().Get("ETag") != "" {
+		t.Errorf("got %d with ETag %q, want 401 without ETag", w.Code, w.Header().Get("ETag"))
+	}
+}
+
+// TestClipboardRevisionInTabsAndState verifies the revision is listed and persisted.
+func TestClipboardRevisionInTabsAndState(t *testing.T) {
+	dir := t.TempDir()
+	h := newPersistentClipboardHandler(t, dir, 5)
+	conditionalPost(t, h, "default", "one", "")
+	conditionalPost(t, h, "default", "two", "")
+
+	restarted := newPersistentClipboardHandler(t, dir, 5)
+	if _, etag := tabContent(t, restarted, "default"); etag != `"3"` {
+		t.Errorf("ETag after restart = %s, want \"3\"", etag)
+	}
+
+	w := httptest.NewRecorder()
+	restarted.ListTabs()(w, httptest.NewRequest(http.MethodGet, "/clipboard/tabs", nil))
+	var tabs []tabInfo
+	if err := json.NewDecoder(w.Body).Decode(&tabs); err != nil {
+		t.Fatal(err)
+	}
+	if len(tabs) != 1 || tabs[0].Revision != 3 {
+		t.Errorf("tabs = %+v, want default at revision 3", tabs)
+	}
+}
+
+// TestClipboardStreamRevision verifies change events carry the new revision,
+// and that a reconnect with a stale Last-Event-ID gets an immediate event.
+func TestClipboardStreamRevision(t *testing.T) {
+	h := newTestClipboardHandler()
+	srv := httptest.NewServer(h.ClipboardStream())
+	defer srv.Close()
+
+	readEvent := func(req *http.Request) (chan string, func()) {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		events := make(chan string, 4)
+		go func() {
+			defer close(events)
+			buf := make([]byte, 4096)
+			var acc string
+			for {
+				n, err := resp.Body.Read(buf)
+				acc += string(buf[:n])
+				for {
+					i := strings.Index(acc, "\n\n")
+					if i < 0 {
+						break
+					}
+					if block := acc[:i]; strings.HasPrefix(block, "event:") {
+						events <- block
+					}
+					acc = acc[i+2:]
+				}
+				if err != nil {
+					return
+				}
+			}
+		}()
+		return events, func() { resp.Body.Close() }
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"?tab=default", nil)
+	events, stop := readEvent(req)
+	defer stop()
+
+	// Wait until the subscriber is registered before writing.
+	for i := 0; h.broker.SubscriberCount() == 0; i++ {
+		if i > 200 {
+			t.Fatal("stream never subscribed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	conditionalPost(t, h, "default", "hello", "")
+
+	want := "event: change\nid: 2\ndata: {\"tab\":\"default\",\"revision\":2}"
+	select {
+	case got := <-events:
+		if got != want {
+			t.Errorf("event = %q, want %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no change event received")
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, srv.URL+"?tab=default", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	replayed, stopReplay := readEvent(req)
+	defer stopReplay()
+	select {
+	case got := <-replayed:
+		if got != want {
+			t.Errorf("replayed event = %q, want %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no replayed event after stale Last-Event-ID")
+	}
+}
+
+// createTab creates tab with the given lifetime headers.
+func createTab(t *testing.T, h *ClipboardHandler, tab, content string, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	return createTabFrom(t, h, "10.36.0.1", tab, content, headers)
+}
+
+func createTabFrom(t *testing.T, h *ClipboardHandler, ip, tab, content string, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/clipboard?tab="+url.QueryEscape(tab), strings.NewReader(content))
+	req.RemoteAddr = ip + ":10000"
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.Handle()(w, req)
+	return w
+}
+
+func listTabs(t *testing.T, h *ClipboardHandler) map[string]tabInfo {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ListTabs()(w, httptest.NewRequest(http.MethodGet, "/clipboard/tabs", nil))
+	var tabs []tabInfo
+	if err := json.NewDecoder(w.Body).Decode(&tabs); err != nil {
+		t.Fatal(err)
+	}
+	byName := make(map[string]tabInfo)
+	for _, tab := range tabs {
+		byName[tab.Name] = tab
+	}
+	return byName
+}
+
+// TestClipboardTabTTL verifies a tab created with a TTL reports its remaining
+// lifetime and disappears once it is over, even before the janitor runs.
+func TestClipboardTabTTL(t *testing.T) {
+	h := newTestClipboardHandler()
+	if w := createTab(t, h, "creds", "hunter2", map[string]string{"X-Tab-TTL": "1h"}); w.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", w.Code, w.Body.String())
+	}
+
+	info := listTabs(t, h)["creds"]
+	if info.ExpiresAt == nil || info.ExpiresIn < 3599 || info.ExpiresIn > 3600 {
+		t.Fatalf("tab info = %+v, want about 3600s left", info)
+	}
+	if _, ok := listTabs(t, h)["default"]; !ok || listTabs(t, h)["default"].ExpiresAt != nil {
+		t.Error("default tab should be listed without expiry")
+	}
+
+	h.store.mu.Lock()
+	h.store.tabs["creds"].ExpiresAt = time.Now().Add(-time.Second)
+	h.store.mu.Unlock()
+
+	w := httptest.NewRecorder()
+	h.Handle()(w, httptest.NewRequest(http.MethodGet, "/clipboard?tab=creds", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("GET expired tab: %d, want 404", w.Code)
+	}
+	if _, ok := listTabs(t, h)["creds"]; ok {
+		t.Error("expired tab still listed")
+	}
+	if got := h.expireTabs(time.Now()); len(got) != 1 || got[0] != "creds" {
+		t.Errorf("expireTabs = %v", got)
+	}
+
+	// The name is free again, and a new tab doesn't inherit the old lifetime.
+	if w := createTab(t, h, "creds", "new", nil); w.Code != http.StatusCreated {
+		t.Fatalf("re-create: %d", w.Code)
+	}
+	if info := listTabs(t, h)["creds"]; info.ExpiresAt != nil || info.Revision != 1 {
+		t.Errorf("re-created tab = %+v", info)
+	}
+}
+
+func TestClipboardTabTTLValidation(t *testing.T) {
+	h := newTestClipboardHandler()
+	for _, ttl := range []string{"soon", "0", "-5m", "31d", "2592001"} {
+		if w := createTab(t, h, "bad", "", map[string]string{"X-Tab-TTL": ttl}); w.Code != http.StatusBadRequest {
+			t.Errorf("TTL %q: %d, want 400", ttl, w.Code)
+		}
+	}
+	if w := createTab(t, h, "secs", "", map[string]string{"X-Tab-TTL": "90"}); w.Code != http.StatusCreated {
+		t.Fatalf("TTL in seconds: %d", w.Code)
+	}
+	if info := listTabs(t, h)["secs"]; info.ExpiresIn != 90 {
+		t.Errorf("expiresIn = %d, want 90", info.ExpiresIn)
+	}
+}
+
+// TestClipboardBurnAfterRead verifies only the first read of a burn tab gets
+// its content, and that nothing else exposes it.
+func TestClipboardBurnAfterRead(t *testing.T) {
+	h := newTestClipboardHandler()
+	burn := map[string]string{"X-Tab-Burn-After-Read": "1"}
+	if w := createTab(t, h, "once", "", burn); w.Code != http.StatusCreated {
+		t.Fatalf("create: %d", w.Code)
+	}
+	if !listTabs(t, h)["once"].BurnAfterRead {
+		t.Error("burnAfterRead not listed")
+	}
+
+	// Nothing pasted yet: reading doesn't burn.
+	if body, _ := tabContent(t, h, "once"); body != "" {
+		t.Fatalf("empty tab returned %q", body)
+	}
+	createTab(t, h, "once", "first", nil)
+	createTab(t, h, "once", "secret", nil)
+	if versions := tabHistory(t, h, "once"); len(versions) != 0 {
+		t.Errorf("burn tab kept %d history versions", len(versions))
+	}
+
+	srv := httptest.NewServer(h.Collaborate())
+	defer srv.Close()
+	if resp, err := http.Get(srv.URL + "?tab=once"); err != nil || resp.StatusCode != http.StatusConflict {
+		t.Errorf("live editing a burn tab: %v %v, want 409", resp.StatusCode, err)
+	}
+
+	w := httptest.NewRecorder()
+	h.Handle()(w, httptest.NewRequest(http.MethodGet, "/clipboard?tab=once", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "secret" || w.Header().Get("X-Tab-Burned") != "1" {
+		t.Fatalf("first read: %d %q burned=%q", w.Code, w.Body.String(), w.Header().Get("X-Tab-Burned"))
+	}
+	w = httptest.NewRecorder()
+	h.Handle()(w, httptest.NewRequest(http.MethodGet, "/clipboard?tab=once", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("second read: %d, want 404", w.Code)
+	}
+}
+
+// TestClipboardBurnAfterReadConcurrent verifies concurrent readers can't both
+// get the content.
+func TestClipboardBurnAfterReadConcurrent(t *testing.T) {
+	h := newTestClipboardHandler()
+	createTab(t, h, "race", "token", map[string]string{"X-Tab-Burn-After-Read": "1"})
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	reads := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			h.Handle()(w, httptest.NewRequest(http.MethodGet, "/clipboard?tab=race", nil))
+			if w.Code == http.StatusOK {
+				mu.Lock()
+				reads++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if reads != 1 {
+		t.Errorf("%d readers got the content, want 1", reads)
+	}
+}
+
+// TestClipboardJanitor verifies the janitor removes expired tabs and ends
+// their live sessions.
+func TestClipboardJanitor(t *testing.T) {
+	h := newTestClipboardHandler()
+	createTab(t, h, "short", "x", map[string]string{"X-Tab-TTL": "1h"})
+	c, _ := joinTab(t, collabServer(t, h), "tab=short")
+
+	h.store.mu.Lock()
+	h.store.tabs["short"].ExpiresAt = time.Now().Add(50 * time.Millisecond)
+	h.store.mu.Unlock()
+	stop := h.StartJanitor(10 * time.Millisecond)
+	defer stop()
+
+	c.nextOf("deleted")
+	if h.Stats().Tabs != 1 {
+		t.Errorf("tabs = %d, want only the default tab", h.Stats().Tabs)
+	}
+}
+
+// TestClipboardLifetimePersisted verifies expiry and burn flags survive a
+// restart and that tabs expired in the meantime are dropped.
+func TestClipboardLifetimePersisted(t *testing.T) {
+	dir := t.TempDir()
+	h := newPersistentClipboardHandler(t, dir, 5)
+	createTab(t, h, "later", "a", map[string]string{"X-Tab-TTL": "1h", "X-Tab-Burn-After-Read": "1"})
+	createTab(t, h, "soon", "b", map[string]string{"X-Tab-TTL": "1h"})
+	h.store.mu.Lock()
+	h.store.tabs["soon"].ExpiresAt = time.Now().Add(-time.Minute)
+	h.store.mu.Unlock()
+	h.saveState()
+
+	tabs := listTabs(t, newPersistentClipboardHandler(t, dir, 5))
+	if _, ok := tabs["soon"]; ok {
+		t.Error("tab expired while down was restored")
+	}
+	if later := tabs["later"]; later.ExpiresAt == nil || !later.BurnAfterRead {
+		t.Errorf("restored tab = %+v", later)
+	}
+}
+
+// sealEnvelope encrypts plaintext the way the browser does for encrypted tabs.
+func sealEnvelope(t *testing.T, key []byte, plaintext string) string {
+	t.Helper()
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	rand.Read(nonce)
+	sealed := gcm.Seal(nil, nonce, []byte(plaintext), nil)
+	return "v1:" + base64.StdEncoding.EncodeToString(nonce) + ":" + base64.StdEncoding.EncodeToString(sealed)
+}
+
+func encryptionHeaders(t *testing.T, key []byte) map[string]string {
+	return map[string]string{
+		"X-Tab-Encrypted":      "1",
+		"X-Tab-Salt":           base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 16)),
+		"X-Tab-KDF-Iterations": "600000",
+		"X-Tab-Key-Check":      sealEnvelope(t, key, "upgopher"),
+	}
+}
+
+// TestClipboardEncryptedTab verifies an encrypted tab advertises its key
+// derivation parameters and only ever stores ciphertext.
+func TestClipboardEncryptedTab(t *testing.T) {
+	h := newTestClipboardHandler()
+	key := bytes.Repeat([]byte{1}, 32)
+	post := func(content string, headers map[string]string) int {
+		req := httptest.NewRequest(http.MethodPost, "/clipboard?tab=vault", strings.NewReader(content))
+		req.RemoteAddr = "10.37.0.1:10000"
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		h.Handle()(w, req)
+		return w.Code
+	}
+
+	if code := post("plaintext", encryptionHeaders(t, key)); code != http.StatusBadRequest {
+		t.Errorf("create with plaintext: %d, want 400", code)
+	}
+	if code := post(sealEnvelope(t, key, "secret"), encryptionHeaders(t, key)); code != http.StatusCreated {
+		t.Fatalf("create: %d", code)
+	}
+
+	info := listTabs(t, h)["vault"]
+	if !info.Encrypted || info.Encryption == nil || info.Encryption.KDF != "PBKDF2-SHA256" ||
+		info.Encryption.Iterations != 600000 || info.Encryption.Check == "" {
+		t.Fatalf("tab info = %+v", info)
+	}
+	if listTabs(t, h)["default"].Encrypted {
+		t.Error("default tab listed as encrypted")
+	}
+
+	for _, bad := range []string{"secret", "v1:AAAA:BBBB", "v2:" + strings.TrimPrefix(sealEnvelope(t, key, "x"), "v1:")} {
+		if code := post(bad, nil); code != http.StatusBadRequest {
+			t.Errorf("update with %q: %d, want 400", bad, code)
+		}
+	}
+	update := sealEnvelope(t, key, "new secret")
+	if code := post(update, nil); code != http.StatusOK {
+		t.Errorf("update with ciphertext: %d", code)
+	}
+	if body, _ := tabContent(t, h, "vault"); body != update {
+		t.Errorf("stored %q, want the envelope unchanged", body)
+	}
+
+	srv := httptest.NewServer(h.Collaborate())
+	defer srv.Close()
+	if resp, err := http.Get(srv.URL + "?tab=vault"); err != nil || resp.StatusCode != http.StatusConflict {
+		t.Errorf("live editing an encrypted tab: %v %v, want 409", resp.StatusCode, err)
+	}
+}
+
+func TestClipboardEncryptionValidation(t *testing.T) {
+	h := newTestClipboardHandler()
+	key := bytes.Repeat([]byte{2}, 32)
+	tests := map[string]func(map[string]string){
+		"short salt":      func(h map[string]string) { h["X-Tab-Salt"] = "c2FsdA==" },
+		"few iterations":  func(h map[string]string) { h["X-Tab-KDF-Iterations"] = "1000" },
+		"no key check":    func(h map[string]string) { delete(h, "X-Tab-Key-Check") },
+		"plain key check": func(h map[string]string) { h["X-Tab-Key-Check"] = "upgopher" },
+	}
+	for name, mutate := range tests {
+		headers := encryptionHeaders(t, key)
+		mutate(headers)
+		if w := createTabFrom(t, h, "10.37.0.2", "x", "", headers); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: %d, want 400", name, w.Code)
+		}
+	}
+}
+
+func TestClipboardEncryptionPersisted(t *testing.T) {
+	dir := t.TempDir()
+	h := newPersistentClipboardHandler(t, dir, 5)
+	key := bytes.Repeat([]byte{3}, 32)
+	headers := encryptionHeaders(t, key)
+	createTabFrom(t, h, "10.37.0.2", "vault", "", headers)
+
+	info := listTabs(t, newPersistentClipboardHandler(t, dir, 5))["vault"]
+	if !info.Encrypted || info.Encryption.Check != headers["X-Tab-Key-Check"] {
+		t.Errorf("restored tab = %+v", info)
+	}
+}
+
+// ── Attachment tests ──────────────────────────────────────────────────────────
+
+func attachmentRequest(t *testing.T, h *ClipboardHandler, ip, method, query string, body []byte, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	target, handler := "/clipboard/attachments?"+query, h.Attachments()
+	if strings.Contains(query, "id=") {
+		target, handler = "/clipboard/attachments/file?"+query, h.AttachmentFile()
+	}
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	req.RemoteAddr = ip + ":10000"
+	if token != "" {
+		req.Header.Set("X-Tab-Token", token)
+	}
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w
+}
+
+func attach(t *testing.T, h *ClipboardHandler, ip, tab, name string, data []byte, token string) TabAttachment {
+	t.Helper()
+	w := attachmentRequest(t, h, ip, http.MethodPost, "tab="+url.QueryEscape(tab)+"&name="+url.QueryEscape(name), data, token)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("attach %s to %s: %d %s", name, tab, w.Code, w.Body.String())
+	}
+	var a TabAttachment
+	if err := json.NewDecoder(w.Body).Decode(&a); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// TestClipboardAttachments verifies files can be attached to a tab, listed,
+// downloaded and removed, and that every change is announced.
+func TestClipboardAttachments(t *testing.T) {
+	h := newTestClipboardHandler()
+	const ip = "10.38.0.1"
+	notify, unsubscribe := h.broker.Subscribe("default")
+	defer unsubscribe()
+	peer, _ := joinTab(t, collabServer(t, h), "tab=default")
+
+	data := []byte("listen: 0.0.0.0:8080\n")
+	a := attach(t, h, ip, "default", `..\..\etc/app.yaml`, data, "")
+	if a.Name != "app.yaml" || a.Size != len(data) || a.ID == "" {
+		t.Fatalf("attachment = %+v", a)
+	}
+	select {
+	case <-notify:
+	default:
+		t.Error("SSE subscribers not notified of the new attachment")
+	}
+	if ev := peer.nextOf("attachments"); ev.Attachments != 1 {
+		t.Errorf("live editors got attachments revision %d, want 1", ev.Attachments)
+	}
+	if ev, _ := h.tabChange("default"); ev.Attachments != 1 || ev.Revision != 1 {
+		t.Errorf("change event = %+v", ev)
+	}
+
+	if info := listTabs(t, h)["default"]; info.AttachmentCount != 1 || len(info.Attachments) != 1 || info.Attachments[0].ID != a.ID {
+		t.Errorf("tab info attachments = %d %+v", info.AttachmentCount, info.Attachments)
+	}
+
+	w := attachmentRequest(t, h, ip, http.MethodGet, "tab=default&id="+a.ID, nil, "")
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), data) {
+		t.Fatalf("download: %d %q", w.Code, w.Body.String())
+	}
+	if cd := w.Header().Get("Content-Disposition"); cd != "attachment; filename=app.yaml" {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+	if w.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Error("download without X-Content-Type-Options: nosniff")
+	}
+
+	if w := attachmentRequest(t, h, ip, http.MethodDelete, "tab=default&id="+a.ID, nil, ""); w.Code != http.StatusOK {
+		t.Fatalf("delete: %d", w.Code)
+	}
+	if w := attachmentRequest(t, h, ip, http.MethodGet, "tab=default&id="+a.ID, nil, ""); w.Code != http.StatusNotFound {
+		t.Errorf("download after delete: %d, want 404", w.Code)
+	}
+	w = attachmentRequest(t, h, ip, http.MethodGet, "tab=default", nil, "")
+	if strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Errorf("list after delete = %s", w.Body.String())
+	}
+	if ev := peer.nextOf("attachments"); ev.Attachments != 2 {
+		t.Errorf("live editors got attachments revision %d, want 2", ev.Attachments)
+	}
+
+	for _, name := range []string{"", "..", "a\x00b", strings.Repeat("x", 256)} {
+		if w := attachmentRequest(t, h, ip, http.MethodPost, "tab=default&name="+url.QueryEscape(name), data, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("name %q: %d, want 400", name, w.Code)
+		}
+	}
+}
+
+// TestClipboardAttachmentsProtected verifies attachments of a protected tab
+// need its token and aren't named in the tab list.
+func TestClipboardAttachmentsProtected(t *testing.T) {
+	h := newTestClipboardHandler()
+	const ip, token = "10.38.0.2", "hunter22"
+	createTabFrom(t, h, ip, "vault", "", map[string]string{"X-Tab-Token-Create": "1", "X-Tab-Token-Value": token})
+
+	if w := attachmentRequest(t, h, ip, http.MethodPost, "tab=vault&name=id_rsa", []byte("key"), ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("upload without token: %d, want 401", w.Code)
+	}
+	a := attach(t, h, ip, "vault", "id_rsa", []byte("key"), token)
+	for _, req := range []struct{ method, query string }{
+		{http.MethodGet, "tab=vault"},
+		{http.MethodGet, "tab=vault&id=" + a.ID},
+		{http.MethodDelete, "tab=vault&id=" + a.ID},
+	} {
+		if w := attachmentRequest(t, h, ip, req.method, req.query, nil, ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s without token: %d, want 401", req.method, req.query, w.Code)
+		}
+	}
+	if w := attachmentRequest(t, h, ip, http.MethodGet, "tab=vault&id="+a.ID, nil, token); w.Body.String() != "key" {
+		t.Errorf("download with token = %q", w.Body.String())
+	}
+
+	info := listTabs(t, h)["vault"]
+	if info.AttachmentCount != 1 || info.Attachments != nil {
+		t.Errorf("protected tab info lists attachments: %d %+v", info.AttachmentCount, info.Attachments)
+	}
+}
+
+// TestClipboardAttachmentLimits verifies the per-file, per-tab count and
+// per-tab size limits, and that burn-after-read and encrypted tabs refuse files.
+func TestClipboardAttachmentLimits(t *testing.T) {
+	h := NewClipboardHandler(true, 10)
+
+	const countIP = "10.38.0.3"
+	for i := 0; i < maxTabAttachments; i++ {
+		attach(t, h, countIP, "default", fmt.Sprintf("f%d.txt", i), []byte("x"), "")
+	}
+	if w := attachmentRequest(t, h, countIP, http.MethodPost, "tab=default&name=one-too-many.txt", []byte("x"), ""); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("attachment over the count limit: %d, want 413", w.Code)
+	}
+
+	const ip = "10.38.0.4"
+	createTabFrom(t, h, ip, "big", "", nil)
+	if w := attachmentRequest(t, h, ip, http.MethodPost, "tab=big&name=huge.bin", make([]byte, maxAttachmentSize+1), ""); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("attachment over the size limit: %d, want 413", w.Code)
+	}
+	chunk := make([]byte, maxTabAttachmentBytes/2)
+	attach(t, h, ip, "big", "a.bin", chunk, "")
+	attach(t, h, ip, "big", "b.bin", chunk, "")
+	if w := attachmentRequest(t, h, ip, http.MethodPost, "tab=big&name=c.bin", []byte("x"), ""); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("attachment over the tab total: %d, want 413", w.Code)
+	}
+	if stats := h.Stats(); stats.Attachments != maxTabAttachments+2 || stats.AttachmentBytes != int64(maxTabAttachments+maxTabAttachmentBytes) {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	createTabFrom(t, h, ip, "burn", "", map[string]string{"X-Tab-Burn-After-Read": "1"})
+	createTabFrom(t, h, ip, "sealed", "", encryptionHeaders(t, bytes.Repeat([]byte{1}, 32)))
+	for _, tab := range []string{"burn", "sealed"} {
+		if w := attachmentRequest(t, h, ip, http.MethodPost, "tab="+tab+"&name=a.txt", []byte("x"), ""); w.Code != http.StatusConflict {
+			t.Errorf("attachment to %s: %d, want 409", tab, w.Code)
+		}
+	}
+}
+
+// TestClipboardAttachmentsPersisted verifies attachments survive a restart
+// and that the files of removed attachments and tabs are deleted.
+func TestClipboardAttachmentsPersisted(t *testing.T) {
+	dir := t.TempDir()
+	h := newPersistentClipboardHandler(t, dir, 5)
+	const ip = "10.38.0.5"
+	createTabFrom(t, h, ip, "share", "", nil)
+	kept := attach(t, h, ip, "share", "notes.md", []byte("# notes"), "")
+	removed := attach(t, h, ip, "share", "old.md", []byte("# old"), "")
+	attachmentRequest(t, h, ip, http.MethodDelete, "tab=share&id="+removed.ID, nil, "")
+
+	state, _ := os.ReadFile(filepath.Join(dir, clipboardStateFile))
+	if strings.Contains(string(state), "# notes") {
+		t.Error("attachment data written to the state file")
+	}
+	files, _ := os.ReadDir(filepath.Join(dir, clipboardAttachmentsDir))
+	if len(files) != 1 || files[0].Name() != kept.ID {
+		t.Errorf("attachment files = %v, want only %s", files, kept.ID)
+	}
+
+	restarted := newPersistentClipboardHandler(t, dir, 5)
+	w := attachmentRequest(t, restarted, ip, http.MethodGet, "tab=share&id="+kept.ID, nil, "")
+	if w.Code != http.StatusOK || w.Body.String() != "# notes" {
+		t.Errorf("attachment after restart: %d %q", w.Code, w.Body.String())
+	}
+
+	createTabFrom(t, restarted, ip, "share", "", nil) // update: attachments stay
+	if w := clipboardRequest(t, restarted, http.MethodDelete, "share", "", ""); w.Code != http.StatusOK {
+		t.Fatalf("delete tab: %d", w.Code)
+	}
+	if files, _ := os.ReadDir(filepath.Join(dir, clipboardAttachmentsDir)); len(files) != 0 {
+		t.Errorf("attachment files left after deleting the tab: %v", files)
+	}
+}
+
+// ── Per-tab screenshot tests ──────────────────────────────────────────────────
+
+func screenshotRequest(t *testing.T, h *ClipboardHandler, ip, method, target string, body []byte, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	req.RemoteAddr = ip + ":12000"
+	if body != nil {
+		req.Header.Set("Content-Type", "image/png")
+	}
+	if token != "" {
+		req.Header.Set("X-Tab-Token", token)
+	}
+	w := httptest.NewRecorder()
+	switch {
+	case strings.HasPrefix(target, "/screenshot/"):
+		req.URL.Path = strings.TrimPrefix(req.URL.Path, "/screenshot/")
+		h.ServeScreenshotDirect()(w, req)
+	case strings.HasPrefix(target, "/api/v1/screenshots/image"):
+		h.ScreenshotImage()(w, req)
+	default:
+		h.Screenshots()(w, req)
+	}
+	return w
+}
+
+// screenshotList decodes the images of a screenshot listing.
+func screenshotList(t *testing.T, w *httptest.ResponseRecorder) []ImageEntry {
+	t.Helper()
+	var resp screenshotsResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding screenshot list: %v (%d %s)", err, w.Code, w.Body.String())
+	}
+	return resp.Images
+}
+
+// TestScreenshotsPerTab verifies screenshots uploaded to a protected tab need
+// its token everywhere, are announced on the tab's own channel and go away
+// with the tab.
+func TestScreenshotsPerTab(t *testing.T) {
+	h := newTestClipboardHandler()
+	const ip, token = "10.39.0.1", "hunter22"
+	image := []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n', 0, 0, 0, 0, 'I', 'H', 'D', 'R'}
+	createTabFrom(t, h, ip, "vault", "", map[string]string{"X-Tab-Token-Create": "1", "X-Tab-Token-Value": token})
+	notify, unsubscribe := h.broker.Subscribe("vault")
+	defer unsubscribe()
+
+	if w := screenshotRequest(t, h, ip, http.MethodPost, "/api/v1/screenshots?tab=vault", image, ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("upload without token: %d, want 401", w.Code)
+	}
+	if w := screenshotRequest(t, h, ip, http.MethodPost, "/api/v1/screenshots?tab=missing", image, ""); w.Code != http.StatusNotFound {
+		t.Errorf("upload to a missing tab: %d, want 404", w.Code)
+	}
+	w := screenshotRequest(t, h, ip, http.MethodPost, "/api/v1/screenshots?tab=vault", image, token)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("upload with token: %d %s", w.Code, w.Body.String())
+	}
+	var img ImageEntry
+	json.NewDecoder(w.Body).Decode(&img)
+	if img.Tab != "vault" {
+		t.Errorf("screenshot tab = %q, want vault", img.Tab)
+	}
+	select {
+	case <-notify:
+	default:
+		t.Error("tab subscribers not notified of the new screenshot")
+	}
+	if ev, _ := h.tabChange("vault"); ev.Screenshots != 1 {
+		t.Errorf("change event = %+v, want screenshots revision 1", ev)
+	}
+
+	list := screenshotList(t, screenshotRequest(t, h, ip, http.MethodGet, "/api/v1/screenshots", nil, ""))
+	if len(list) != 0 {
+		t.Errorf("default gallery shows %d screenshots of another tab", len(list))
+	}
+	if w := screenshotRequest(t, h, ip, http.MethodGet, "/api/v1/screenshots?tab=vault", nil, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("list without token: %d, want 401", w.Code)
+	}
+	w = screenshotRequest(t, h, ip, http.MethodGet, "/api/v1/screenshots?tab=vault", nil, token)
+	if list = screenshotList(t, w); len(list) != 1 || list[0].ID != img.ID {
+		t.Errorf("vault gallery = %+v", list)
+	}
+
+	for _, target := range []string{"/api/v1/screenshots/image?id=" + img.ID, "/screenshot/" + img.ID} {
+		if w := screenshotRequest(t, h, ip, http.MethodGet, target, nil, ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("GET %s without token: %d, want 401", target, w.Code)
+		}
+		if w := screenshotRequest(t, h, ip, http.MethodGet, target, nil, token); w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), image) {
+			t.Errorf("GET %s with token: %d", target, w.Code)
+		}
+	}
+	// <img> tags and direct links can't send headers
+	if w := screenshotRequest(t, h, ip, http.MethodGet, "/screenshot/"+img.ID+"?X-Tab-Token="+token, nil, ""); w.Code != http.StatusOK {
+		t.Errorf("direct link with token parameter: %d, want 200", w.Code)
+	}
+	if w := screenshotRequest(t, h, ip, http.MethodDelete, "/api/v1/screenshots/image?id="+img.ID, nil, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("delete without token: %d, want 401", w.Code)
+	}
+
+	screenshotRequest(t, h, ip, http.MethodPost, "/api/v1/screenshots?tab=vault", image, token)
+	req := httptest.NewRequest(http.MethodDelete, "/clipboard?tab=vault", nil)
+	req.RemoteAddr = ip + ":12000"
+	req.Header.Set("X-Tab-Token", token)
+	h.Handle()(httptest.NewRecorder(), req)
+	if stats := h.Stats(); stats.Screenshots != 0 {
+		t.Errorf("%d screenshots left after deleting their tab", stats.Screenshots)
+	}
+}
+
+// TestScreenshotsPerTabLimit verifies each gallery drops only its own oldest
+// screenshots, and that burn-after-read and encrypted tabs have no gallery.
+func TestScreenshotsPerTabLimit(t *testing.T) {
+	h := newTestClipboardHandler()
+	image := []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n', 0, 0, 0, 0, 'I', 'H', 'D', 'R'}
+	createTabFrom(t, h, "10.39.0.2", "other", "", nil)
+	w := screenshotRequest(t, h, "10.39.0.2", http.MethodPost, "/api/v1/screenshots?tab=other", image, "")
+	var kept ImageEntry
+	json.NewDecoder(w.Body).Decode(&kept)
+
+	max := h.ScreenshotLimits.MaxCount
+	for i := 0; i < max+1; i++ {
+		ip := fmt.Sprintf("10.39.1.%d", i%10)
+		if w := screenshotRequest(t, h, ip, http.MethodPost, "/api/v1/screenshots", image, ""); w.Code != http.StatusCreated {
+			t.Fatalf("upload %d: %d", i, w.Code)
+		}
+	}
+	if _, ok := h.imgStore.find(kept.ID); !ok {
+		t.Error("a full default gallery evicted another tab's screenshot")
+	}
+	if stats := h.Stats(); stats.Screenshots != max+1 {
+		t.Errorf("screenshots = %d, want %d", stats.Screenshots, max+1)
+	}
+
+	createTabFrom(t, h, "10.39.0.2", "burn", "", map[string]string{"X-Tab-Burn-After-Read": "1"})
+	createTabFrom(t, h, "10.39.0.2", "sealed", "", encryptionHeaders(t, bytes.Repeat([]byte{1}, 32)))
+	for _, tab := range []string{"burn", "sealed"} {
+		if w := screenshotRequest(t, h, "10.39.0.2", http.MethodPost, "/api/v1/screenshots?tab="+tab, image, ""); w.Code != http.StatusConflict {
+			t.Errorf("screenshot to %s: %d, want 409", tab, w.Code)
+		}
+	}
+}
+
+// ── Screenshot limits and persistence tests ───────────────────────────────────
+
+// TestScreenshotLimits verifies the per-image size, per-tab count, total byte
+// budget and age limits, and that the listing reports them.
+func TestScreenshotLimits(t *testing.T) {
+	h := newTestClipboardHandler()
+	h.ScreenshotLimits = ScreenshotLimits{MaxCount: 2, MaxSize: 32, MaxBytes: 48, MaxAge: time.Hour}
+	const ip = "10.40.0.1"
+	image := []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n', 0, 0, 0, 0, 'I', 'H', 'D', 'R'}
+	upload := func(tab string) ImageEntry {
+		t.Helper()
+		w := screenshotRequest(t, h, ip, http.MethodPost, "/api/v1/screenshots?tab="+tab, image, "")
+		if w.Code != http.StatusCreated {
+			t.Fatalf("upload to %s: %d %s", tab, w.Code, w.Body.String())
+		}
+		var img ImageEntry
+		json.NewDecoder(w.Body).Decode(&img)
+		return img
+	}
+
+	oversized := append(append([]byte{}, image...), make([]byte, 17)...)
+	if w := screenshotRequest(t, h, ip, http.MethodPost, "/api/v1/screenshots", oversized, ""); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized upload: %d, want 413", w.Code)
+	}
+
+	createTabFrom(t, h, ip, "other", "", nil)
+	createTabFrom(t, h, ip, "third", "", nil)
+	first := upload("default")
+	upload("other")
+	upload("other")
+	upload("other") // over the count: the oldest screenshot of other goes
+	if list := screenshotList(t, screenshotRequest(t, h, ip, http.MethodGet, "/api/v1/screenshots?tab=other", nil, "")); len(list) != 2 {
+		t.Errorf("other gallery has %d screenshots, want 2", len(list))
+	}
+	if _, ok := h.imgStore.find(first.ID); !ok {
+		t.Fatal("the count limit of one tab evicted another tab's screenshot")
+	}
+
+	last := upload("third") // over the byte budget: the oldest screenshot overall goes
+	if _, ok := h.imgStore.find(first.ID); ok {
+		t.Error("oldest screenshot kept over the byte budget")
+	}
+	if ev, _ := h.tabChange("default"); ev.Screenshots != 2 {
+		t.Errorf("default screenshots revision = %d, want 2 after the eviction", ev.Screenshots)
+	}
+
+	var resp screenshotsResponse
+	json.NewDecoder(screenshotRequest(t, h, ip, http.MethodGet, "/api/v1/screenshots?tab=third", nil, "").Body).Decode(&resp)
+	want := screenshotsResponse{Tab: "third", MaxCount: 2, MaxSize: 32, MaxBytes: 48, TotalBytes: 48, MaxAgeSeconds: 3600}
+	if len(resp.Images) != 1 || resp.Images[0].ID != last.ID {
+		t.Errorf("third gallery = %+v", resp.Images)
+	}
+	resp.Images = nil
+	if !reflect.DeepEqual(resp, want) {
+		t.Errorf("listing = %+v, want %+v", resp, want)
+	}
+
+	h.imgStore.mu.Lock()
+	old := h.imgStore.images[0]
+	h.imgStore.images[0].UpdatedAt = time.Now().Add(-2 * time.Hour)
+	h.imgStore.mu.Unlock()
+	for _, img := range screenshotList(t, screenshotRequest(t, h, ip, http.MethodGet, "/api/v1/screenshots?tab="+old.Tab, nil, "")) {
+		if img.ID == old.ID {
+			t.Error("listing shows an expired screenshot")
+		}
+	}
+	if w := screenshotRequest(t, h, ip, http.MethodGet, "/screenshot/"+old.ID, nil, ""); w.Code != http.StatusNotFound {
+		t.Errorf("expired screenshot served: %d, want 404", w.Code)
+	}
+	h.expireScreenshots(time.Now())
+	if stats := h.Stats(); stats.Screenshots != 2 {
+		t.Errorf("screenshots after expiry = %d, want 2", stats.Screenshots)
+	}
+}
+
+// TestScreenshotsPersisted verifies screenshots survive a restart with their
+// data kept out of the state file, and their files go with them.
+func TestScreenshotsPersisted(t *testing.T) {
+	dir := t.TempDir()
+	h := newPersistentClipboardHandler(t, dir, 5)
+	const ip = "10.40.0.2"
+	image := []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n', 0, 0, 0, 0, 'I', 'H', 'D', 'R'}
+	upload := func(tab string) ImageEntry {
+		t.Helper()
+		var img ImageEntry
+		json.NewDecoder(screenshotRequest(t, h, ip, http.MethodPost, "/api/v1/screenshots?tab="+tab, image, "").Body).Decode(&img)
+		return img
+	}
+	createTabFrom(t, h, ip, "keep", "", nil)
+	createTabFrom(t, h, ip, "gone", "", nil)
+	kept := upload("keep")
+	upload("gone")
+	removed := upload("default")
+	screenshotRequest(t, h, ip, http.MethodDelete, "/api/v1/screenshots/image?id="+removed.ID, nil, "")
+	if w := clipboardRequest(t, h, http.MethodDelete, "gone", "", ""); w.Code != http.StatusOK {
+		t.Fatalf("delete tab: %d", w.Code)
+	}
+
+	state, _ := os.ReadFile(filepath.Join(dir, screenshotStateFile))
+	if !strings.Contains(string(state), kept.ID) || strings.Contains(string(state), "PNG") {
+		t.Errorf("screenshot state = %s", state)
+	}
+	files, _ := os.ReadDir(filepath.Join(dir, screenshotsDir))
+	if len(files) != 1 || files[0].Name() != kept.ID {
+		t.Errorf("screenshot files = %v, want only %s", files, kept.ID)
+	}
+
+	restarted := newPersistentClipboardHandler(t, dir, 5)
+	if w := screenshotRequest(t, restarted, ip, http.MethodGet, "/screenshot/"+kept.ID, nil, ""); w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), image) {
+		t.Errorf("screenshot after restart: %d", w.Code)
+	}
+	if list := screenshotList(t, screenshotRequest(t, restarted, ip, http.MethodGet, "/api/v1/screenshots?tab=keep", nil, "")); len(list) != 1 {
+		t.Errorf("keep gallery after restart has %d screenshots, want 1", len(list))
+	}
+
+	aged := NewClipboardHandler(true, 5)
+	aged.ScreenshotLimits.MaxAge = time.Nanosecond
+	if err := aged.EnablePersistence(dir); err != nil {
+		t.Fatalf("EnablePersistence: %v", err)
+	}
+	if stats := aged.Stats(); stats.Screenshots != 0 {
+		t.Errorf("%d screenshots restored past their age limit", stats.Screenshots)
+	}
+	if files, _ := os.ReadDir(filepath.Join(dir, screenshotsDir)); len(files) != 0 {
+		t.Errorf("screenshot files left after expiry: %v", files)
+	}
+}
+
+// ── Screenshot thumbnail tests ────────────────────────────────────────────────
+
+// encodeTestImage returns a w×h PNG or JPEG with a horizontal gradient.
+func encodeTestImage(t *testing.T, contentType string, w, h int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 255 / w), 0, 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	var err error
+	if contentType == "image/jpeg" {
+		err = jpeg.Encode(&buf, img, nil)
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		t.Fatalf("encoding test image: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// TestScreenshotThumbnails verifies uploads report their dimensions and that
+// large screenshots get a downscaled thumbnail of the same format.
+func TestScreenshotThumbnails(t *testing.T) {
+	h := newTestClipboardHandler()
+	const ip = "10.41.0.1"
+	cases := []struct {
+		contentType string
+		w, h        int
+		thumbW      int // 0 = served as is
+		thumbH      int
+	}{
+		{"image/png", 800, 400, 320, 160},
+		{"image/jpeg", 480, 960, 160, 320},
+		{"image/png", 100, 50, 0, 0},
+	}
+	for _, c := range cases {
+		data := encodeTestImage(t, c.contentType, c.w, c.h)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/screenshots", bytes.NewReader(data))
+		req.RemoteAddr = ip + ":12000"
+		req.Header.Set("Content-Type", c.contentType)
+		w := httptest.NewRecorder()
+		h.Screenshots()(w, req)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("upload %s: %d %s", c.contentType, w.Code, w.Body.String())
+		}
+		var img ImageEntry
+		json.NewDecoder(w.Body).Decode(&img)
+		if img.Width != c.w || img.Height != c.h {
+			t.Errorf("%s dimensions = %dx%d, want %dx%d", c.contentType, img.Width, img.Height, c.w, c.h)
+		}
+
+		req = httptest.NewRequest(http.MethodGet, "/api/v1/screenshots/thumbnail?id="+img.ID, nil)
+		w = httptest.NewRecorder()
+		h.ScreenshotThumbnail()(w, req)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != c.contentType {
+			t.Fatalf("thumbnail of %dx%d %s: %d %q", c.w, c.h, c.contentType, w.Code, w.Header().Get("Content-Type"))
+		}
+		if c.thumbW == 0 {
+			if !bytes.Equal(w.Body.Bytes(), data) {
+				t.Errorf("small %s not served as is", c.contentType)
+			}
+			continue
+		}
+		cfg, _, err := image.DecodeConfig(w.Body)
+		if err != nil || cfg.Width != c.thumbW || cfg.Height != c.thumbH {
+			t.Errorf("thumbnail of %dx%d = %dx%d (%v), want %dx%d", c.w, c.h, cfg.Width, cfg.Height, err, c.thumbW, c.thumbH)
+		}
+	}
+
+	if list := screenshotList(t, screenshotRequest(t, h, ip, http.MethodGet, "/api/v1/screenshots", nil, "")); len(list) != 3 || list[0].Width != 800 {
+		t.Errorf("listing = %+v, want dimensions", list)
+	}
+}
+
+// TestScreenshotThumbnailProtected verifies thumbnails need the tab's token
+// and are made again for restored screenshots.
+func TestScreenshotThumbnailProtected(t *testing.T) {
+	dir := t.TempDir()
+	h := newPersistentClipboardHandler(t, dir, 5)
+	const ip, token = "10.41.0.2", "hunter22"
+	createTabFrom(t, h, ip, "vault", "", map[string]string{"X-Tab-Token-Create": "1", "X-Tab-Token-Value": token})
+	w := screenshotRequest(t, h, ip, http.MethodPost, "/api/v1/screenshots?tab=vault", encodeTestImage(t, "image/png", 640, 640), token)
+	var img ImageEntry
+	json.NewDecoder(w.Body).Decode(&img)
+
+	thumbnail := func(h *ClipboardHandler, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		w := httptest.NewRecorder()
+		h.ScreenshotThumbnail()(w, req)
+		return w
+	}
+	if w := thumbnail(h, "/api/v1/screenshots/thumbnail?id="+img.ID); w.Code != http.StatusUnauthorized {
+		t.Errorf("thumbnail without token: %d, want 401", w.Code)
+	}
+	restarted := newPersistentClipboardHandler(t, dir, 5)
+	w = thumbnail(restarted, "/api/v1/screenshots/thumbnail?id="+img.ID+"&X-Tab-Token="+token)
+	if cfg, _, err := image.DecodeConfig(w.Body); err != nil || cfg.Width != thumbnailSize {
+		t.Errorf("thumbnail after restart: %d %v %+v", w.Code, err, cfg)
+	}
+}
+
+// TestScreenshotUploadChecksTokenFirst verifies an upload without the tab's
+// token is refused before the image is decoded for its thumbnail.
+func TestScreenshotUploadChecksTokenFirst(t *testing.T) {
+	h := newTestClipboardHandler()
+	const ip, token = "10.41.0.3", "hunter22"
+	createTabFrom(t, h, ip, "vault", "", map[string]string{"X-Tab-Token-Create": "1", "X-Tab-Token-Value": token})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 3000, 3000))); err != nil {
+		t.Fatal(err)
+	}
+
+	// Decoding allocates at least a byte per pixel of the 9 MP image.
+	allocated := func(token string, want int) uint64 {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		w := screenshotRequest(t, h, ip, http.MethodPost, "/api/v1/screenshots?tab=vault", buf.Bytes(), token)
+		runtime.ReadMemStats(&after)
+		if w.Code != want {
+			t.Fatalf("upload: %d, want %d", w.Code, want)
+		}
+		return after.TotalAlloc - before.TotalAlloc
+	}
+	if n := allocated("", http.StatusUnauthorized); n > 4<<20 {
+		t.Errorf("upload without token allocated %d bytes, want no decoding", n)
+	}
+	if n := allocated(token, http.StatusCreated); n < 9e6 {
+		t.Errorf("upload with token allocated %d bytes, want the image decoded", n)
+	}
+}
+
+// ── Rate limit tests ──────────────────────────────────────────────────────────
+
+// TestClipboardRateLimitPolicies verifies clipboard writes and screenshot
+// uploads use their own configured buckets and report them in headers.
+func TestClipboardRateLimitPolicies(t *testing.T) {
+	h := newTestClipboardHandler()
+	h.Limiter = security.NewRateLimiter(map[string]security.RateLimitPolicy{
+		security.PolicyClipboardWrite:   {Limit: 2, Period: time.Minute, Burst: 2},
+		security.PolicyScreenshotUpload: {Limit: 1, Period: time.Minute, Burst: 1},
+	})
+	const ip = "10.42.0.1"
+	image := []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n', 0, 0, 0, 0, 'I', 'H', 'D', 'R'}
+
+	for i, want := range []struct {
+		code       int
+		remaining  string
+		retryAfter string
+	}{
+		{http.StatusOK, "1", ""},
+		{http.StatusOK, "0", ""},
+		{http.StatusTooManyRequests, "0", "30"},
+	} {
+		w := createTabFrom(t, h, ip, "default", "text", nil)
+		if w.Code != want.code || w.Header().Get("RateLimit-Remaining") != want.remaining || w.Header().Get("Retry-After") != want.retryAfter {
+			t.Fatalf("write %d: %d %v, want %+v", i, w.Code, w.Header(), want)
+		}
+	}
+
+	if w := screenshotRequest(t, h, ip, http.MethodPost, "/api/v1/screenshots", image, ""); w.Code != http.StatusCreated {
+		t.Errorf("screenshot upload after clipboard writes ran out: %d, want 201", w.Code)
+	}
+	if w := screenshotRequest(t, h, ip, http.MethodPost, "/api/v1/screenshots", image, ""); w.Code != http.StatusTooManyRequests || w.Header().Get("RateLimit-Limit") != "1" {
+		t.Errorf("second screenshot upload: %d %v, want 429", w.Code, w.Header())
+	}
+}
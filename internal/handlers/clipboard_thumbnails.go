@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"bytes"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	// thumbnailSize bounds the width and height of screenshot thumbnails.
+	thumbnailSize = 320
+	// maxThumbnailPixels keeps huge or malicious images from being decoded:
+	// larger screenshots are stored without a thumbnail. Decoding one takes
+	// up to 4 bytes per pixel, so 64 MiB at most.
+	maxThumbnailPixels = 16 << 20
+	// thumbnailSamples is how many source pixels per axis are averaged into
+	// each thumbnail pixel, which bounds the work for large images.
+	thumbnailSamples = 4
+)
+
+// imageInfo returns the dimensions of a screenshot and, when it is larger
+// than thumbnailSize, a downscaled copy with its content type: JPEG for JPEG
+// screenshots, PNG for the others so transparency survives. Images the
+// standard library can't decode, such as WebP, get neither.
+func imageInfo(data []byte, contentType string) (width, height int, thumb []byte, thumbType string) {
+	var decode func([]byte) (image.Image, error)
+	var config func([]byte) (image.Config, error)
+	switch contentType {
+	case "image/png":
+		decode = func(b []byte) (image.Image, error) { return png.Decode(bytes.NewReader(b)) }
+		config = func(b []byte) (image.Config, error) { return png.DecodeConfig(bytes.NewReader(b)) }
+	case "image/jpeg":
+		decode = func(b []byte) (image.Image, error) { return jpeg.Decode(bytes.NewReader(b)) }
+		config = func(b []byte) (image.Config, error) { return jpeg.DecodeConfig(bytes.NewReader(b)) }
+	case "image/gif":
+		decode = func(b []byte) (image.Image, error) { return gif.Decode(bytes.NewReader(b)) }
+		config = func(b []byte) (image.Config, error) { return gif.DecodeConfig(bytes.NewReader(b)) }
+	default:
+		return 0, 0, nil, ""
+	}
+
+	cfg, err := config(data)
+	if err != nil || cfg.Width <= 0 || cfg.Height <= 0 {
+		return 0, 0, nil, ""
+	}
+	width, height = cfg.Width, cfg.Height
+	if (width <= thumbnailSize && height <= thumbnailSize) || int64(width)*int64(height) > maxThumbnailPixels {
+		return width, height, nil, ""
+	}
+
+	src, err := decode(data)
+	if err != nil {
+		return width, height, nil, ""
+	}
+	small := downscale(src, thumbnailSize)
+
+	var buf bytes.Buffer
+	if contentType == "image/jpeg" {
+		err = jpeg.Encode(&buf, small, &jpeg.Options{Quality: 80})
+		thumbType = "image/jpeg"
+	} else {
+		err = png.Encode(&buf, small)
+		thumbType = "image/png"
+	}
+	if err != nil {
+		return width, height, nil, ""
+	}
+	return width, height, buf.Bytes(), thumbType
+}
+
+// downscale returns src shrunk to fit in a box of size pixels, keeping its
+// aspect ratio. Each pixel averages up to thumbnailSamples² source pixels
+// spread over the area it covers.
+func downscale(src image.Image, size int) *image.RGBA {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	dw, dh := size, size
+	if sw > sh {
+		dh = sh * size / sw
+	} else {
+		dw = sw * size / sh
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for dy := 0; dy < dh; dy++ {
+		y0, y1 := b.Min.Y+dy*sh/dh, b.Min.Y+(dy+1)*sh/dh
+		for dx := 0; dx < dw; dx++ {
+			x0, x1 := b.Min.X+dx*sw/dw, b.Min.X+(dx+1)*sw/dw
+			var r, g, bl, a, n uint32
+			for y := y0; y < y1; y += stride(y1 - y0) {
+				for x := x0; x < x1; x += stride(x1 - x0) {
+					pr, pg, pb, pa := src.At(x, y).RGBA()
+					r, g, bl, a = r+pr, g+pg, bl+pb, a+pa
+					n++
+				}
+			}
+			if n == 0 {
+				continue
+			}
+			i := dst.PixOffset(dx, dy)
+			dst.Pix[i+0] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(bl / n >> 8)
+			dst.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+	return dst
+}
+
+// stride returns the step that samples at most thumbnailSamples positions
+// out of span.
+func stride(span int) int {
+	if step := span / thumbnailSamples; step > 1 {
+		return step
+	}
+	return 1
+}
+
+// ScreenshotThumbnail handles GET /api/v1/screenshots/thumbnail?id=...,
+// serving the screenshot itself when it is small enough to have none.
+func (ch *ClipboardHandler) ScreenshotThumbnail() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !ch.Quiet {
+			log.Printf("[%s] [%s] %s %s\n", time.Now().Format("2006-01-02 15:04:05"), r.Method, r.URL.String(), r.RemoteAddr)
+		}
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			http.Error(w, "Missing id parameter", http.StatusBadRequest)
+			return
+		}
+		ch.serveScreenshot(w, r, id, true)
+	}
+}
@@ -3,16 +3,16 @@ package handlers
 import (
 	"log"
 	"net/http"
-	"path/filepath"
 	"sync"
 	"time"
 
-	"github.com/wanetty/upgopher/internal/security"
+	"github.com/wanetty/upgopher/internal/storage"
 )
 
 // CustomPathHandler manages custom path creation
 type CustomPathHandler struct {
 	Dir              string
+	BasePath         string // URL prefix the app is mounted under; empty for root
 	Quiet            bool
 	CustomPaths      *map[string]string
 	CustomPathsMutex *sync.RWMutex
@@ -66,9 +66,9 @@ func (cph *CustomPathHandler) Handle() http.HandlerFunc {
 			return
 		}
 
-		fullPath := filepath.Join(cph.Dir, originalPath)
-		isSafe, err := security.IsSafePath(cph.Dir, fullPath)
-		if err != nil || !isSafe {
+		// The file is served through the share's storage, which applies the
+		// symlink policy then.
+		if _, ok := storage.Clean(originalPath); !ok {
 			http.Error(w, "Invalid file path", http.StatusForbidden)
 			return
 		}
@@ -81,7 +81,7 @@ func (cph *CustomPathHandler) Handle() http.HandlerFunc {
 			log.Printf("[%s] Custom path created: %s -> %s\n", time.Now().Format("2006-01-02 15:04:05"), customPath, originalPath)
 		}
 
-		http.Redirect(w, r, "/"+customPath, http.StatusSeeOther)
+		http.Redirect(w, r, cph.BasePath+"/"+customPath, http.StatusSeeOther)
 	}
 }
 
@@ -2,7 +2,9 @@ package handlers
 
 import (
 	"archive/zip"
+	"crypto/rand"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,15 +13,19 @@ import (
 	"log"
 	"mime"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/wanetty/upgopher/internal/security"
 	"github.com/wanetty/upgopher/internal/statics"
+	"github.com/wanetty/upgopher/internal/storage"
 	"github.com/wanetty/upgopher/internal/templates"
 	"github.com/wanetty/upgopher/internal/utils"
 )
@@ -29,26 +35,44 @@ var validFolderName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
 // FileHandlers manages file-related HTTP handlers
 type FileHandlers struct {
 	Dir                string
+	BasePath           string // URL prefix the app is mounted under, e.g. "/files"; empty for root
 	Quiet              bool
 	DisableHiddenFiles bool
 	ReadOnly           bool
 	MaxUploadSize      int64
-	ShowHiddenFiles    *bool
 	CustomPaths        *map[string]string
 	CustomPathsMutex   *sync.RWMutex
+	Limiter            *security.RateLimiter // applies the upload rate limit
+	// Storage holds the shared files: by default Dir on local disk,
+	// following the links inside it that stay there.
+	Storage storage.Storage
+	// RawOrigin is the separate origin, such as "https://raw.files.lan",
+	// serving HTML, SVG and other active files inline from /raw/. When empty
+	// they are downloaded instead.
+	RawOrigin string
 }
 
+const (
+	// userFileContentSecurityPolicy keeps files served from the share from
+	// running scripts or acting with the app's origin, whatever their type.
+	userFileContentSecurityPolicy = "sandbox; default-src 'none'; img-src 'self' data:; media-src 'self'; style-src 'unsafe-inline'"
+	// rawOriginContentSecurityPolicy lets active files run scripts on the
+	// raw origin, still in a unique origin of their own.
+	rawOriginContentSecurityPolicy = "sandbox allow-scripts allow-forms allow-popups allow-modals"
+)
+
 // NewFileHandlers creates a new FileHandlers instance
-func NewFileHandlers(dir string, quiet bool, disableHiddenFiles bool, readOnly bool, maxUploadSize int64, showHiddenFiles *bool, customPaths *map[string]string, customPathsMutex *sync.RWMutex) *FileHandlers {
+func NewFileHandlers(dir string, quiet bool, disableHiddenFiles bool, readOnly bool, maxUploadSize int64, customPaths *map[string]string, customPathsMutex *sync.RWMutex) *FileHandlers {
 	return &FileHandlers{
 		Dir:                dir,
 		Quiet:              quiet,
 		DisableHiddenFiles: disableHiddenFiles,
 		ReadOnly:           readOnly,
 		MaxUploadSize:      maxUploadSize,
-		ShowHiddenFiles:    showHiddenFiles,
 		CustomPaths:        customPaths,
 		CustomPathsMutex:   customPathsMutex,
+		Limiter:            security.NewRateLimiter(nil),
+		Storage:            storage.NewLocal(dir, security.SymlinksWithinRoot),
 	}
 }
 
@@ -60,65 +84,59 @@ func (fh *FileHandlers) List() http.HandlerFunc {
 		}
 
 		// Check if it's a custom path
-		requestPath := strings.TrimPrefix(r.URL.Path, "/")
+		requestPath := strings.TrimPrefix(r.URL.Path, fh.BasePath+"/")
 		fh.CustomPathsMutex.RLock()
 		for originalPath, customPath := range *fh.CustomPaths {
 			if requestPath == customPath {
 				fh.CustomPathsMutex.RUnlock()
 
 				// Serve the file directly for download
-				fullFilePath := filepath.Join(fh.Dir, originalPath)
-
-				// Verify path safety
-				isSafe, err := security.IsSafePath(fh.Dir, fullFilePath)
-				if err != nil || !isSafe {
+				name, ok := storage.Clean(originalPath)
+				if !ok {
 					http.Error(w, "Bad path", http.StatusForbidden)
 					return
 				}
-
-				// Check if file exists
-				if _, err := os.Stat(fullFilePath); os.IsNotExist(err) {
-					http.Error(w, "File not found", http.StatusNotFound)
+				file, info, err := fh.openFile(name)
+				if err != nil {
+					code, msg := fileErrorStatus(err)
+					http.Error(w, msg, code)
 					return
 				}
+				defer file.Close()
 
 				// Serve the file with download header
-				_, filename := filepath.Split(fullFilePath)
-				w.Header().Set("Content-Disposition", "attachment; filename="+filename)
-				http.ServeFile(w, r, fullFilePath)
+				w.Header().Set("Content-Disposition", "attachment; filename="+info.Name())
+				w.Header().Set("Content-Security-Policy", userFileContentSecurityPolicy)
+				http.ServeContent(w, r, info.Name(), info.ModTime(), file)
 				return
 			}
 		}
 		fh.CustomPathsMutex.RUnlock()
 
 		currentPath := r.URL.Query().Get("path")
-		var newdir string
-
-		if currentPath == "" {
-			newdir = fh.Dir
-		} else {
-			decodedFilePath, err := base64.StdEncoding.DecodeString(currentPath)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			newdir = filepath.Join(fh.Dir, string(decodedFilePath))
-
-			isSafe, err := security.IsSafePath(fh.Dir, newdir)
-			if err != nil || !isSafe {
-				http.Error(w, "Bad path", http.StatusForbidden)
-				return
-			}
+		decodedFilePath, err := base64.StdEncoding.DecodeString(currentPath)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		newdir, ok := storage.Clean(string(decodedFilePath))
+		if !ok {
+			http.Error(w, "Bad path", http.StatusForbidden)
+			return
 		}
 
-		fileInfo, err := os.Stat(newdir)
-		if os.IsNotExist(err) || !fileInfo.IsDir() {
+		fileInfo, err := fh.Storage.Stat(newdir)
+		if errors.Is(err, fs.ErrPermission) {
+			http.Error(w, "Bad path", http.StatusForbidden)
+			return
+		}
+		if err != nil || !fileInfo.IsDir() {
 			http.Error(w, "The path does not exist", http.StatusInternalServerError)
 			return
 		}
 
 		if r.Method == "GET" {
-			fh.handleGetRequest(w, r, newdir, currentPath)
+			fh.handleGetRequest(w, r, currentPath)
 		} else if r.Method == "POST" {
 			fh.handlePostRequest(w, r, newdir, currentPath)
 		} else {
@@ -127,15 +145,17 @@ func (fh *FileHandlers) List() http.HandlerFunc {
 	}
 }
 
-// Raw serves files without download header
+// Raw serves files without download header. Every response is sandboxed so
+// an uploaded page can't run scripts with the app's origin; HTML, SVG and
+// other active files are downloaded instead, or redirected to RawOrigin and
+// rendered there when it is set.
 func (fh *FileHandlers) Raw() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		return_code := "200"
 		path := strings.TrimPrefix(r.URL.Path, "/raw/")
-		fullPath := filepath.Join(fh.Dir, path)
 
-		isSafe, err := security.IsSafePath(fh.Dir, fullPath)
-		if err != nil || !isSafe {
+		name, ok := storage.Clean(path)
+		if !ok {
 			http.Error(w, "Bad path", http.StatusForbidden)
 			return_code = "403"
 			if !fh.Quiet {
@@ -144,20 +164,114 @@ func (fh *FileHandlers) Raw() http.HandlerFunc {
 			return
 		}
 
-		fileInfo, err := os.Stat(fullPath)
-		if os.IsNotExist(err) || fileInfo.IsDir() {
-			http.Error(w, "File not found", http.StatusNotFound)
-			return_code = "404"
+		file, fileInfo, err := fh.openFile(name)
+		if err != nil {
+			code, msg := fileErrorStatus(err)
+			http.Error(w, msg, code)
+			return_code = strconv.Itoa(code)
 			if !fh.Quiet {
 				log.Printf("[%s] [%s - %s] %s %s\n", time.Now().Format("2006-01-02 15:04:05"), r.Method, return_code, r.URL.Path, r.RemoteAddr)
 			}
 			return
 		}
+		defer file.Close()
+		contentType := rawContentType(fileInfo.Name(), file)
+		policy := userFileContentSecurityPolicy
+		if isActiveContent(contentType) {
+			switch rawHost := fh.rawHost(); {
+			case rawHost == "":
+				w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileInfo.Name()}))
+			case !strings.EqualFold(r.Host, rawHost):
+				return_code = "307"
+				if !fh.Quiet {
+					log.Printf("[%s] [%s - %s] %s %s\n", time.Now().Format("2006-01-02 15:04:05"), r.Method, return_code, r.URL.Path, r.RemoteAddr)
+				}
+				http.Redirect(w, r, fh.RawOrigin+fh.BasePath+"/raw/"+(&url.URL{Path: path}).EscapedPath(), http.StatusTemporaryRedirect)
+				return
+			default:
+				policy = rawOriginContentSecurityPolicy
+			}
+		}
 		if !fh.Quiet {
 			log.Printf("[%s] [%s - %s] %s %s\n", time.Now().Format("2006-01-02 15:04:05"), r.Method, return_code, r.URL.Path, r.RemoteAddr)
 		}
-		http.ServeFile(w, r, fullPath)
+		if contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		w.Header().Set("Content-Security-Policy", policy)
+		http.ServeContent(w, r, fileInfo.Name(), fileInfo.ModTime(), file)
+	}
+}
+
+// rawHost returns the host of RawOrigin, or "" when it is not set.
+func (fh *FileHandlers) rawHost() string {
+	if fh.RawOrigin == "" {
+		return ""
+	}
+	u, err := url.Parse(fh.RawOrigin)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// openFile opens the regular file name from the share. A directory is
+// reported as not existing.
+func (fh *FileHandlers) openFile(name string) (storage.File, fs.FileInfo, error) {
+	file, err := fh.Storage.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := file.Stat()
+	if err == nil && info.IsDir() {
+		err = &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return file, info, nil
+}
+
+// fileErrorStatus returns the status code and message answering a failure
+// to open a file from the share.
+func fileErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, fs.ErrPermission):
+		return http.StatusForbidden, "Bad path"
+	case errors.Is(err, fs.ErrNotExist):
+		return http.StatusNotFound, "File not found"
 	}
+	return http.StatusInternalServerError, "Internal error"
+}
+
+// rawContentType returns the content type http.ServeContent would send for
+// file: the one of the extension of name, or else the sniffed one. It
+// leaves file at its start.
+func rawContentType(name string, file io.ReadSeeker) string {
+	if ct := mime.TypeByExtension(filepath.Ext(name)); ct != "" {
+		return ct
+	}
+	buf := make([]byte, 512)
+	n, _ := io.ReadFull(file, buf)
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return ""
+	}
+	return http.DetectContentType(buf[:n])
+}
+
+// isActiveContent reports whether browsers may run scripts in a document of
+// contentType.
+func isActiveContent(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch mediaType {
+	case "text/html", "application/xhtml+xml", "image/svg+xml", "text/xml", "application/xml", "text/xsl", "application/xslt+xml":
+		return true
+	}
+	return false
 }
 
 // Download serves files with attachment header
@@ -174,9 +288,8 @@ func (fh *FileHandlers) Download() http.HandlerFunc {
 			return
 		}
 
-		fullFilePath := filepath.Join(fh.Dir, string(decodedFilePath))
-		isSafe, err := security.IsSafePath(fh.Dir, fullFilePath)
-		if err != nil || !isSafe {
+		name, ok := storage.Clean(string(decodedFilePath))
+		if !ok {
 			http.Error(w, "Bad path", http.StatusForbidden)
 			if !fh.Quiet {
 				log.Printf("[%s] [%s - %s] %s %s\n", time.Now().Format("2006-01-02 15:04:05"), r.Method, "403", r.URL.Path, r.RemoteAddr)
@@ -184,23 +297,33 @@ func (fh *FileHandlers) Download() http.HandlerFunc {
 			return
 		}
 
-		if _, err := os.Stat(fullFilePath); os.IsNotExist(err) {
-			http.Error(w, "File not found", http.StatusNotFound)
+		file, info, err := fh.openFile(name)
+		if err != nil {
+			code, msg := fileErrorStatus(err)
+			http.Error(w, msg, code)
 			if !fh.Quiet {
-				log.Printf("[%s] [%s - %s] %s %s\n", time.Now().Format("2006-01-02 15:04:05"), r.Method, "404", r.URL.Path, r.RemoteAddr)
+				log.Printf("[%s] [%s - %d] %s %s\n", time.Now().Format("2006-01-02 15:04:05"), r.Method, code, r.URL.Path, r.RemoteAddr)
 			}
 			return
 		}
+		defer file.Close()
 
-		_, filename := filepath.Split(fullFilePath)
-		w.Header().Set("Content-Disposition", "attachment; filename="+filename)
-		http.ServeFile(w, r, fullFilePath)
+		w.Header().Set("Content-Disposition", "attachment; filename="+info.Name())
+		w.Header().Set("Content-Security-Policy", userFileContentSecurityPolicy)
+		http.ServeContent(w, r, info.Name(), info.ModTime(), file)
 	}
 }
 
-// Delete removes a file
+// Delete removes a file. It only accepts POST and DELETE, so links and
+// embedded images on other pages can't trigger it.
 func (fh *FileHandlers) Delete() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			w.Header().Set("Allow", "POST, DELETE")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
 		if fh.ReadOnly {
 			http.Error(w, "Delete operation is disabled in readonly mode", http.StatusForbidden)
 			if !fh.Quiet {
@@ -221,26 +344,22 @@ func (fh *FileHandlers) Delete() http.HandlerFunc {
 			return
 		}
 
-		fullFilePath := filepath.Join(fh.Dir, string(decodedFilePath))
-		isSafe, err := security.IsSafePath(fh.Dir, fullFilePath)
-		if err != nil || !isSafe {
-			http.Error(w, "Bad path", http.StatusForbidden)
-			if !fh.Quiet {
-				log.Printf("[%s] [%s - %s] %s %s\n", time.Now().Format("2006-01-02 15:04:05"), r.Method, "403", r.URL.Path, r.RemoteAddr)
-			}
-			return
+		// Removing a link leaves its target alone, so the storage lets links
+		// its symlink policy refuses to follow be deleted.
+		name, ok := storage.Clean(string(decodedFilePath))
+		if ok {
+			err = fh.Storage.RemoveAll(name)
+		} else {
+			err = &fs.PathError{Op: "remove", Path: string(decodedFilePath), Err: fs.ErrPermission}
 		}
-
-		_, err = os.Stat(fullFilePath)
-		if os.IsNotExist(err) {
-			http.Error(w, "File not found", http.StatusNotFound)
+		if errors.Is(err, fs.ErrPermission) || errors.Is(err, fs.ErrNotExist) {
+			code, msg := fileErrorStatus(err)
+			http.Error(w, msg, code)
 			if !fh.Quiet {
-				log.Printf("[%s] [%s - %s] %s %s\n", time.Now().Format("2006-01-02 15:04:05"), r.Method, "404", r.URL.Path, r.RemoteAddr)
+				log.Printf("[%s] [%s - %d] %s %s\n", time.Now().Format("2006-01-02 15:04:05"), r.Method, code, r.URL.Path, r.RemoteAddr)
 			}
 			return
 		}
-
-		err = os.RemoveAll(fullFilePath)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			log.Printf("[%s] Error removing file: %v\n", time.Now().Format("2006-01-02 15:04:05"), err)
@@ -248,15 +367,15 @@ func (fh *FileHandlers) Delete() http.HandlerFunc {
 		}
 
 		if !fh.Quiet {
-			log.Printf("[%s] File deleted: %s\n", time.Now().Format("2006-01-02 15:04:05"), fullFilePath)
+			log.Printf("[%s] File deleted: %s\n", time.Now().Format("2006-01-02 15:04:05"), name)
 		}
 
 		if encodedFilePath == "" {
-			http.Redirect(w, r, "/", http.StatusSeeOther)
+			http.Redirect(w, r, fh.BasePath+"/", http.StatusSeeOther)
 		} else {
 			dirPath, _ := filepath.Split(string(decodedFilePath))
 			encodedDirPath := base64.StdEncoding.EncodeToString([]byte(dirPath))
-			http.Redirect(w, r, "/?path="+encodedDirPath, http.StatusSeeOther)
+			http.Redirect(w, r, fh.BasePath+"/?path="+encodedDirPath, http.StatusSeeOther)
 		}
 	}
 }
@@ -304,10 +423,12 @@ func (fh *FileHandlers) Mkdir() http.HandlerFunc {
 
 		// Use only the base component of folderName (belt-and-suspenders against slashes)
 		safeName := filepath.Base(folderName)
-		newDirPath := filepath.Join(fh.Dir, currentRelPath, safeName)
-
-		isSafe, err := security.IsSafePath(fh.Dir, newDirPath)
-		if err != nil || !isSafe {
+		newDirPath, ok := storage.Clean(filepath.Join(currentRelPath, safeName))
+		var err error
+		if ok {
+			err = fh.Storage.Mkdir(newDirPath)
+		}
+		if !ok || errors.Is(err, fs.ErrPermission) {
 			http.Error(w, "Bad path", http.StatusForbidden)
 			if !fh.Quiet {
 				log.Printf("[%s] [POST - 403] /mkdir %s\n", time.Now().Format("2006-01-02 15:04:05"), r.RemoteAddr)
@@ -315,8 +436,8 @@ func (fh *FileHandlers) Mkdir() http.HandlerFunc {
 			return
 		}
 
-		if err := os.Mkdir(newDirPath, 0755); err != nil {
-			if os.IsExist(err) {
+		if err != nil {
+			if errors.Is(err, fs.ErrExist) {
 				http.Error(w, "Directory already exists", http.StatusConflict)
 			} else {
 				http.Error(w, "Failed to create directory", http.StatusInternalServerError)
@@ -336,23 +457,22 @@ func (fh *FileHandlers) Mkdir() http.HandlerFunc {
 // Zip creates and serves a zip archive of a directory
 func (fh *FileHandlers) Zip() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		currentPath := r.URL.Query().Get("path")
-
-		if currentPath != "" {
-			decodedPath, err := base64.StdEncoding.DecodeString(currentPath)
-			if err != nil {
-				http.Error(w, "Invalid path encoding", http.StatusBadRequest)
-				return
-			}
-			fullPath := filepath.Join(fh.Dir, string(decodedPath))
-			isSafe, err := security.IsSafePath(fh.Dir, fullPath)
-			if err != nil || !isSafe {
-				http.Error(w, "Bad path", http.StatusForbidden)
-				return
-			}
+		decodedPath, err := base64.StdEncoding.DecodeString(r.URL.Query().Get("path"))
+		if err != nil {
+			http.Error(w, "Invalid path encoding", http.StatusBadRequest)
+			return
+		}
+		name, ok := storage.Clean(string(decodedPath))
+		if !ok {
+			http.Error(w, "Bad path", http.StatusForbidden)
+			return
+		}
+		if _, err := fh.Storage.Stat(name); errors.Is(err, fs.ErrPermission) {
+			http.Error(w, "Bad path", http.StatusForbidden)
+			return
 		}
 
-		zipFilename, err := fh.zipFiles(currentPath)
+		zipFilename, err := fh.zipFiles(name, showHiddenFiles(r, fh.DisableHiddenFiles))
 		if err != nil {
 			http.Error(w, "Unable to create zip file", http.StatusInternalServerError)
 			return
@@ -392,15 +512,26 @@ func (fh *FileHandlers) Search() http.HandlerFunc {
 			return
 		}
 
-		fullPath := filepath.Join(fh.Dir, string(decodedPath))
-
-		isSafe, err := security.IsSafePath(fh.Dir, fullPath)
-		if err != nil || !isSafe {
+		name, ok := storage.Clean(string(decodedPath))
+		var file storage.File
+		if ok {
+			file, err = fh.Storage.Open(name)
+		}
+		if !ok || errors.Is(err, fs.ErrPermission) {
 			http.Error(w, "Invalid file path", http.StatusForbidden)
 			return
 		}
+		if errors.Is(err, fs.ErrNotExist) {
+			http.Error(w, "Search error: the file does not exist", http.StatusInternalServerError)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Search error: %v", err), http.StatusInternalServerError)
+			return
+		}
+		defer file.Close()
 
-		results, err := utils.SearchInFile(fullPath, searchTerm, caseSensitive, wholeWord)
+		results, err := utils.SearchInReader(file, searchTerm, caseSensitive, wholeWord)
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Search error: %v", err), http.StatusInternalServerError)
 			return
@@ -436,17 +567,18 @@ func (fh *FileHandlers) Breadcrumbs() http.HandlerFunc {
 			return
 		}
 
-		// Validate the full resolved path stays inside the shared dir
-		fullPath := filepath.Join(fh.Dir, string(decodedPath))
-		isSafe, err := security.IsSafePath(fh.Dir, fullPath)
-		if err != nil || !isSafe {
+		// Validate the path stays inside the shared dir
+		clean, ok := storage.Clean(string(decodedPath))
+		if ok {
+			_, err = fh.Storage.Stat(clean)
+		}
+		if !ok || errors.Is(err, fs.ErrPermission) {
 			http.Error(w, "Bad path", http.StatusForbidden)
 			return
 		}
 
 		// Split relative path into segments and compute cumulative base64 paths
-		clean := filepath.ToSlash(filepath.Clean(string(decodedPath)))
-		parts := strings.Split(strings.Trim(clean, "/"), "/")
+		parts := strings.Split(clean, "/")
 
 		type segment struct {
 			Name string `json:"name"`
@@ -454,7 +586,7 @@ func (fh *FileHandlers) Breadcrumbs() http.HandlerFunc {
 		}
 		segments := make([]segment, 0, len(parts))
 		for i, part := range parts {
-			if part == "" {
+			if part == "." {
 				continue
 			}
 			cumulative := strings.Join(parts[:i+1], "/")
@@ -506,14 +638,17 @@ func (fh *FileHandlers) Tree() http.HandlerFunc {
 			relRoot = string(decoded)
 		}
 
-		absRoot := filepath.Join(fh.Dir, relRoot)
-		isSafe, err := security.IsSafePath(fh.Dir, absRoot)
-		if err != nil || !isSafe {
+		root, ok := storage.Clean(relRoot)
+		if !ok {
 			http.Error(w, "Bad path", http.StatusForbidden)
 			return
 		}
 
-		info, err := os.Stat(absRoot)
+		info, err := fh.Storage.Stat(root)
+		if errors.Is(err, fs.ErrPermission) {
+			http.Error(w, "Bad path", http.StatusForbidden)
+			return
+		}
 		if err != nil || !info.IsDir() {
 			http.Error(w, "Path is not a directory", http.StatusBadRequest)
 			return
@@ -529,33 +664,27 @@ func (fh *FileHandlers) Tree() http.HandlerFunc {
 		}
 
 		// --- build tree ---
-		root := fh.buildTreeNode(absRoot, relRoot, depth)
+		node := fh.buildTreeNode(root, depth, showHiddenFiles(r, fh.DisableHiddenFiles))
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(root) //nolint:errcheck
+		json.NewEncoder(w).Encode(node) //nolint:errcheck
 	}
 }
 
-// buildTreeNode recursively constructs the tree up to maxDepth levels deep.
+// buildTreeNode recursively constructs the tree up to maxDepth levels deep,
+// leaving out hidden directories unless showHidden is set.
 // maxDepth == 0 means "don't recurse — just check for children"; -1 means unlimited.
-func (fh *FileHandlers) buildTreeNode(absPath, relPath string, maxDepth int) *treeNode {
-	name := filepath.Base(absPath)
-	if relPath == "" {
-		name = "root"
-	}
-
-	encodedPath := ""
-	if relPath != "" {
-		encodedPath = base64.StdEncoding.EncodeToString([]byte(relPath))
-	}
-
+func (fh *FileHandlers) buildTreeNode(name string, maxDepth int, showHidden bool) *treeNode {
 	node := &treeNode{
-		Name:     name,
-		Path:     encodedPath,
+		Name:     "root",
 		Children: []*treeNode{},
 	}
+	if name != "." {
+		node.Name = path.Base(name)
+		node.Path = base64.StdEncoding.EncodeToString([]byte(name))
+	}
 
-	entries, err := os.ReadDir(absPath)
+	entries, err := fh.Storage.ReadDir(name)
 	if err != nil {
 		return node
 	}
@@ -564,16 +693,7 @@ func (fh *FileHandlers) buildTreeNode(absPath, relPath string, maxDepth int) *tr
 		if !e.IsDir() {
 			continue
 		}
-		if e.Name()[0] == '.' && (!*fh.ShowHiddenFiles || fh.DisableHiddenFiles) {
-			continue
-		}
-
-		childRel := filepath.Join(relPath, e.Name())
-		childAbs := filepath.Join(absPath, e.Name())
-
-		// Security check on each child path
-		isSafe, err := security.IsSafePath(fh.Dir, childAbs)
-		if err != nil || !isSafe {
+		if e.Name()[0] == '.' && !showHidden {
 			continue
 		}
 
@@ -589,7 +709,7 @@ func (fh *FileHandlers) buildTreeNode(absPath, relPath string, maxDepth int) *tr
 			nextDepth = -1 // unlimited
 		}
 
-		child := fh.buildTreeNode(childAbs, childRel, nextDepth)
+		child := fh.buildTreeNode(path.Join(name, e.Name()), nextDepth, showHidden)
 		node.Children = append(node.Children, child)
 	}
 
@@ -620,20 +740,16 @@ func (fh *FileHandlers) FileContent() http.HandlerFunc {
 			return
 		}
 
-		fullPath := filepath.Join(fh.Dir, string(decodedPath))
-		isSafe, err := security.IsSafePath(fh.Dir, fullPath)
-		if err != nil || !isSafe {
+		name, ok := storage.Clean(string(decodedPath))
+		if !ok {
 			http.Error(w, "Bad path", http.StatusForbidden)
 			return
 		}
 
-		fileInfo, err := os.Stat(fullPath)
-		if os.IsNotExist(err) {
-			http.Error(w, "File not found", http.StatusNotFound)
-			return
-		}
+		fileInfo, err := fh.Storage.Stat(name)
 		if err != nil {
-			http.Error(w, "Internal error", http.StatusInternalServerError)
+			code, msg := fileErrorStatus(err)
+			http.Error(w, msg, code)
 			return
 		}
 		if fileInfo.IsDir() {
@@ -641,7 +757,7 @@ func (fh *FileHandlers) FileContent() http.HandlerFunc {
 			return
 		}
 
-		_, filename := filepath.Split(fullPath)
+		filename := fileInfo.Name()
 		if !templates.IsTextFile(filename) {
 			http.Error(w, "File type not supported for viewing", http.StatusUnsupportedMediaType)
 			return
@@ -653,7 +769,13 @@ func (fh *FileHandlers) FileContent() http.HandlerFunc {
 			return
 		}
 
-		content, err := os.ReadFile(fullPath)
+		file, err := fh.Storage.Open(name)
+		if err != nil {
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		content, err := io.ReadAll(file)
+		file.Close()
 		if err != nil {
 			http.Error(w, "Internal error", http.StatusInternalServerError)
 			return
@@ -693,32 +815,33 @@ func (fh *FileHandlers) ZipSelected() http.HandlerFunc {
 		}
 
 		// Decode and validate each path individually — one bad path aborts all
-		fullPaths := make([]string, 0, len(req.Paths))
+		names := make([]string, 0, len(req.Paths))
 		for _, encodedPath := range req.Paths {
 			decoded, err := base64.StdEncoding.DecodeString(encodedPath)
 			if err != nil {
 				http.Error(w, "Invalid path encoding", http.StatusBadRequest)
 				return
 			}
-			fullPath := filepath.Join(fh.Dir, string(decoded))
-			isSafe, err := security.IsSafePath(fh.Dir, fullPath)
-			if err != nil || !isSafe {
+			name, ok := storage.Clean(string(decoded))
+			if ok {
+				_, err = fh.Storage.Stat(name)
+			}
+			if !ok || errors.Is(err, fs.ErrPermission) {
 				http.Error(w, "Bad path", http.StatusForbidden)
 				return
 			}
-			_, err = os.Stat(fullPath)
 			if err != nil {
 				continue
 			}
-			fullPaths = append(fullPaths, fullPath)
+			names = append(names, name)
 		}
 
-		if len(fullPaths) == 0 {
+		if len(names) == 0 {
 			http.Error(w, "No valid items to zip", http.StatusBadRequest)
 			return
 		}
 
-		zipFilename, err := fh.zipSpecificFiles(fullPaths)
+		zipFilename, err := fh.zipSpecificFiles(names, showHiddenFiles(r, fh.DisableHiddenFiles))
 		if err != nil {
 			http.Error(w, "Unable to create zip file", http.StatusInternalServerError)
 			return
@@ -730,25 +853,15 @@ func (fh *FileHandlers) ZipSelected() http.HandlerFunc {
 	}
 }
 
-// handleGetRequest handles GET requests for file listing
-func (fh *FileHandlers) handleGetRequest(w http.ResponseWriter, _ *http.Request, dir string, currentPath string) {
-	files, err := os.ReadDir(dir)
-	if err != nil {
-		http.Error(w, "The path does not exists", http.StatusInternalServerError)
-		return
-	}
-
+// handleGetRequest renders the page shell; the file table itself is filled
+// in by the frontend from /api/v1/files.
+func (fh *FileHandlers) handleGetRequest(w http.ResponseWriter, _ *http.Request, currentPath string) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	table, err := fh.createTable(files, dir, currentPath)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	downloadButton := templates.CreateZipButton(currentPath)
-	w.Write([]byte(statics.GetTemplates(table, currentPath, downloadButton, fh.DisableHiddenFiles, fh.ReadOnly)))
+	downloadButton := templates.CreateZipButton(fh.BasePath, currentPath)
+	w.Write([]byte(statics.GetTemplates(currentPath, downloadButton, fh.BasePath, fh.DisableHiddenFiles, fh.ReadOnly)))
 }
 
-// handlePostRequest handles file upload
+// handlePostRequest handles file upload into the directory dir of the share
 func (fh *FileHandlers) handlePostRequest(w http.ResponseWriter, r *http.Request, dir string, currentPath string) {
 	respondUploadTooLarge := func() {
 		http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
@@ -762,6 +875,10 @@ func (fh *FileHandlers) handlePostRequest(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if !fh.Limiter.AllowHTTP(w, security.PolicyUpload, security.ClientIP(r)) {
+		return
+	}
+
 	if fh.MaxUploadSize > 0 {
 		r.Body = http.MaxBytesReader(w, r.Body, fh.MaxUploadSize)
 	}
@@ -801,9 +918,8 @@ func (fh *FileHandlers) handlePostRequest(w http.ResponseWriter, r *http.Request
 			}
 			dirPath := strings.TrimRight(filepath.Clean(rawDirName), "/")
 			if dirPath != "" && !strings.HasPrefix(dirPath, "..") {
-				targetDir := filepath.Join(dir, dirPath)
-				if safe, err := security.IsSafePath(fh.Dir, targetDir); err == nil && safe {
-					if err := os.MkdirAll(targetDir, 0755); err != nil {
+				if targetDir, ok := storage.Clean(filepath.Join(dir, dirPath)); ok {
+					if err := fh.Storage.MkdirAll(targetDir); err != nil && !errors.Is(err, fs.ErrPermission) {
 						part.Close()
 						if !fh.Quiet {
 							log.Printf("[%s] Failed to create directory %s: %v\n", time.Now().Format("2006-01-02 15:04:05"), targetDir, err)
@@ -845,27 +961,25 @@ func (fh *FileHandlers) handlePostRequest(w http.ResponseWriter, r *http.Request
 			return
 		}
 
-		filename := filepath.Base(relativePath)
-		subDir := filepath.Dir(relativePath)
-
-		// Determine the actual target directory, creating subdirs if needed
-		targetDir := dir
-		if subDir != "." {
-			targetDir = filepath.Join(dir, subDir)
+		targetPath, ok := storage.Clean(filepath.Join(dir, relativePath))
+		if !ok {
+			part.Close()
+			http.Error(w, "Bad path", http.StatusForbidden)
+			return
+		}
 
-			// Validate the target directory path
-			safe, err := security.IsSafePath(fh.Dir, targetDir)
-			if err != nil || !safe {
+		// Create the target directory's missing subdirs
+		targetDir := path.Dir(targetPath)
+		if targetDir != dir {
+			if err := fh.Storage.MkdirAll(targetDir); err != nil {
 				part.Close()
-				if !fh.Quiet {
-					log.Printf("[%s] Unsafe path rejected: %s\n", time.Now().Format("2006-01-02 15:04:05"), targetDir)
+				if errors.Is(err, fs.ErrPermission) {
+					if !fh.Quiet {
+						log.Printf("[%s] Unsafe path rejected: %s\n", time.Now().Format("2006-01-02 15:04:05"), targetDir)
+					}
+					http.Error(w, "Bad path", http.StatusForbidden)
+					return
 				}
-				http.Error(w, "Bad path", http.StatusForbidden)
-				return
-			}
-
-			if err := os.MkdirAll(targetDir, 0755); err != nil {
-				part.Close()
 				if !fh.Quiet {
 					log.Printf("[%s] Failed to create directory %s: %v\n", time.Now().Format("2006-01-02 15:04:05"), targetDir, err)
 				}
@@ -874,37 +988,31 @@ func (fh *FileHandlers) handlePostRequest(w http.ResponseWriter, r *http.Request
 			}
 		}
 
-		targetPath := filepath.Join(targetDir, filename)
-		isSafe, err := security.IsSafePath(fh.Dir, targetPath)
-		if err != nil || !isSafe {
-			part.Close()
-			http.Error(w, "Bad path", http.StatusForbidden)
-			return
-		}
-
-		tempFile, err := os.CreateTemp(targetDir, ".upload-*")
+		// Write to a temporary file next to the target and rename it once
+		// complete, so nobody downloads a partial upload.
+		tempName := path.Join(targetDir, ".upload-"+uploadSuffix())
+		tempFile, err := fh.Storage.Create(tempName)
 		if err != nil {
 			part.Close()
+			if errors.Is(err, fs.ErrPermission) {
+				http.Error(w, "Bad path", http.StatusForbidden)
+				return
+			}
 			http.Error(w, "Failed to prepare upload", http.StatusInternalServerError)
 			return
 		}
-		tempName := tempFile.Name()
-		isTempSafe, err := security.IsSafePath(fh.Dir, tempName)
-		if err != nil || !isTempSafe {
-			tempFile.Close()
-			os.Remove(tempName)
-			http.Error(w, "Bad path", http.StatusForbidden)
-			return
-		}
 
 		copyErr := func() error {
 			defer part.Close()
-			defer tempFile.Close()
-			_, err = io.Copy(tempFile, part)
-			return err
+			if _, err := io.Copy(tempFile, part); err != nil {
+				// A truncated upload must not be committed as if complete.
+				storage.Abort(tempFile)
+				return err
+			}
+			return tempFile.Close()
 		}()
 		if copyErr != nil {
-			os.Remove(tempName)
+			fh.Storage.RemoveAll(tempName)
 			if errors.Is(copyErr, http.ErrBodyReadAfterClose) {
 				http.Error(w, "Upload interrupted", http.StatusRequestTimeout)
 				return
@@ -918,8 +1026,12 @@ func (fh *FileHandlers) handlePostRequest(w http.ResponseWriter, r *http.Request
 			return
 		}
 
-		if err := os.Rename(tempName, targetPath); err != nil {
-			os.Remove(tempName)
+		if err := fh.Storage.Rename(tempName, targetPath); err != nil {
+			fh.Storage.RemoveAll(tempName)
+			if errors.Is(err, fs.ErrPermission) {
+				http.Error(w, "Bad path", http.StatusForbidden)
+				return
+			}
 			http.Error(w, "Failed to finalize upload", http.StatusInternalServerError)
 			return
 		}
@@ -945,46 +1057,22 @@ func (fh *FileHandlers) handlePostRequest(w http.ResponseWriter, r *http.Request
 	}
 
 	if currentPath == "" {
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		http.Redirect(w, r, fh.BasePath+"/", http.StatusSeeOther)
 	} else {
-		http.Redirect(w, r, "/?path="+currentPath, http.StatusSeeOther)
+		http.Redirect(w, r, fh.BasePath+"/?path="+currentPath, http.StatusSeeOther)
 	}
 }
 
-// createTable creates HTML table for file listing
-func (fh *FileHandlers) createTable(files []fs.DirEntry, dir string, currentPath string) (string, error) {
-	table := ""
-	for _, file := range files {
-		if file.Name()[0] == '.' && (!*fh.ShowHiddenFiles || fh.DisableHiddenFiles) {
-			continue
-		}
-
-		fileName := file.Name()
-		filePath := filepath.Join(dir, fileName)
-		fileInfo, err := os.Stat(filePath)
-		if err != nil {
-			return "", err
-		}
-		if file.IsDir() {
-			table += templates.CreateFolderRow(file, currentPath, fileInfo, fh.ReadOnly)
-		} else {
-			fh.CustomPathsMutex.RLock()
-			customPathsCopy := make(map[string]string)
-			for k, v := range *fh.CustomPaths {
-				customPathsCopy[k] = v
-			}
-			fh.CustomPathsMutex.RUnlock()
-			table += templates.CreateFileRow(file, currentPath, fileInfo, customPathsCopy, fh.ReadOnly, utils.FormatFileSize)
-		}
-	}
-	return table, nil
+// uploadSuffix returns a random suffix naming an upload's temporary file.
+func uploadSuffix() string {
+	b := make([]byte, 8)
+	rand.Read(b) //nolint:errcheck
+	return hex.EncodeToString(b)
 }
 
-// zipFiles creates a zip file of the specified directory
-func (fh *FileHandlers) zipFiles(currentPath string) (string, error) {
-	decodedPath, _ := base64.StdEncoding.DecodeString(currentPath)
-	fullPath := filepath.Join(fh.Dir, string(decodedPath))
-
+// zipFiles creates a zip file of the directory name, leaving out hidden
+// files unless showHidden is set
+func (fh *FileHandlers) zipFiles(name string, showHidden bool) (string, error) {
 	tempFile, err := os.CreateTemp(os.TempDir(), "prefix-*.zip")
 	if err != nil {
 		return "", err
@@ -998,15 +1086,11 @@ func (fh *FileHandlers) zipFiles(currentPath string) (string, error) {
 	zipWriter := zip.NewWriter(tempFile)
 	defer zipWriter.Close()
 
-	err = filepath.Walk(fullPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			if os.IsPermission(err) {
-				return nil
+	err = storage.Walk(fh.Storage, name, func(walkName string, info fs.FileInfo) error {
+		if !showHidden && walkName != name && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return fs.SkipDir
 			}
-			return err
-		}
-
-		if fh.DisableHiddenFiles && strings.HasPrefix(info.Name(), ".") {
 			return nil
 		}
 
@@ -1015,11 +1099,12 @@ func (fh *FileHandlers) zipFiles(currentPath string) (string, error) {
 			return err
 		}
 
-		relPath, err := filepath.Rel(fullPath, path)
-		if err != nil {
-			return err
+		// Name entries relative to name, which itself is "."
+		header.Name = walkName
+		if name != "." {
+			header.Name = "." + strings.TrimPrefix(walkName, name)
+			header.Name = strings.TrimPrefix(header.Name, "./")
 		}
-		header.Name = relPath
 
 		if info.IsDir() {
 			header.Name += "/"
@@ -1033,7 +1118,7 @@ func (fh *FileHandlers) zipFiles(currentPath string) (string, error) {
 		}
 
 		if !info.IsDir() {
-			file, err := os.Open(path)
+			file, err := fh.Storage.Open(walkName)
 			if err != nil {
 				return err
 			}
@@ -1050,8 +1135,10 @@ func (fh *FileHandlers) zipFiles(currentPath string) (string, error) {
 	return filename, err
 }
 
-// zipSpecificFiles creates a zip archive containing specifically selected files
-func (fh *FileHandlers) zipSpecificFiles(fullPaths []string) (string, error) {
+// zipSpecificFiles creates a zip archive containing the specifically
+// selected files and directories names, leaving out hidden ones unless
+// showHidden is set
+func (fh *FileHandlers) zipSpecificFiles(names []string, showHidden bool) (string, error) {
 	tempFile, err := os.CreateTemp(os.TempDir(), "selected-*.zip")
 	if err != nil {
 		return "", err
@@ -1063,43 +1150,30 @@ func (fh *FileHandlers) zipSpecificFiles(fullPaths []string) (string, error) {
 	defer zipWriter.Close()
 	addedEntries := make(map[string]struct{})
 
-	for _, fullPath := range fullPaths {
-		info, err := os.Stat(fullPath)
+	for _, name := range names {
+		info, err := fh.Storage.Stat(name)
 		if err != nil {
 			continue
 		}
 
 		if info.IsDir() {
-			err = filepath.Walk(fullPath, func(path string, walkInfo os.FileInfo, walkErr error) error {
-				if walkErr != nil {
-					if os.IsPermission(walkErr) {
-						return nil
-					}
-					return walkErr
-				}
-
-				if fh.DisableHiddenFiles && strings.HasPrefix(walkInfo.Name(), ".") {
+			err = storage.Walk(fh.Storage, name, func(walkName string, walkInfo fs.FileInfo) error {
+				if !showHidden && strings.HasPrefix(walkInfo.Name(), ".") {
 					if walkInfo.IsDir() {
-						return filepath.SkipDir
+						return fs.SkipDir
 					}
 					return nil
 				}
 
-				relPath, err := filepath.Rel(fh.Dir, path)
-				if err != nil {
-					return err
-				}
-				relPath = filepath.ToSlash(relPath)
-
 				header, err := zip.FileInfoHeader(walkInfo)
 				if err != nil {
 					return err
 				}
 
 				if walkInfo.IsDir() {
-					header.Name = relPath + "/"
+					header.Name = walkName + "/"
 				} else {
-					header.Name = relPath
+					header.Name = walkName
 					header.Method = zip.Deflate
 				}
 
@@ -1117,7 +1191,7 @@ func (fh *FileHandlers) zipSpecificFiles(fullPaths []string) (string, error) {
 					return nil
 				}
 
-				file, err := os.Open(path)
+				file, err := fh.Storage.Open(walkName)
 				if err != nil {
 					return err
 				}
@@ -1135,7 +1209,7 @@ func (fh *FileHandlers) zipSpecificFiles(fullPaths []string) (string, error) {
 			continue
 		}
 
-		if fh.DisableHiddenFiles && strings.HasPrefix(info.Name(), ".") {
+		if !showHidden && strings.HasPrefix(info.Name(), ".") {
 			continue
 		}
 
@@ -1143,13 +1217,7 @@ func (fh *FileHandlers) zipSpecificFiles(fullPaths []string) (string, error) {
 		if err != nil {
 			return "", err
 		}
-
-		relPath, err := filepath.Rel(fh.Dir, fullPath)
-		if err != nil {
-			header.Name = info.Name()
-		} else {
-			header.Name = filepath.ToSlash(relPath)
-		}
+		header.Name = name
 		header.Method = zip.Deflate
 
 		if _, exists := addedEntries[header.Name]; exists {
@@ -1162,7 +1230,7 @@ func (fh *FileHandlers) zipSpecificFiles(fullPaths []string) (string, error) {
 		}
 		addedEntries[header.Name] = struct{}{}
 
-		file, err := os.Open(fullPath)
+		file, err := fh.Storage.Open(name)
 		if err != nil {
 			return "", err
 		}
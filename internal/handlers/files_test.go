@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/wanetty/upgopher/internal/storage"
+)
+
+// TestFileHandlersOnMemoryStorage runs the file handlers against an
+// in-memory storage, with no shared directory on disk.
+func TestFileHandlersOnMemoryStorage(t *testing.T) {
+	mem := storage.NewMemory()
+	if err := mem.WriteFile("docs/readme.txt", []byte("hello gopher")); err != nil {
+		t.Fatal(err)
+	}
+	paths := map[string]string{}
+	var mu sync.RWMutex
+	fh := NewFileHandlers("/nonexistent", true, false, false, 0, &paths, &mu)
+	fh.Storage = mem
+	enc := func(p string) string { return base64.StdEncoding.EncodeToString([]byte(p)) }
+
+	// Upload into docs, creating a subdirectory on the way.
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	part, _ := mw.CreateFormFile("file", "notes/todo.txt")
+	part.Write([]byte("write tests"))
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/?path="+url.QueryEscape(enc("docs")), body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	fh.List()(w, req)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("upload = %d %s", w.Code, w.Body.String())
+	}
+
+	code, resp := listFiles(t, fh, "path="+url.QueryEscape(enc("docs")))
+	if code != http.StatusOK || strings.Join(entryNames(resp.Entries), ",") != "notes,readme.txt" {
+		t.Fatalf("listing = %d %v", code, entryNames(resp.Entries))
+	}
+
+	w = httptest.NewRecorder()
+	fh.Download()(w, httptest.NewRequest(http.MethodGet, "/download/?path="+url.QueryEscape(enc("docs/notes/todo.txt")), nil))
+	if w.Code != http.StatusOK || w.Body.String() != "write tests" {
+		t.Errorf("download = %d %q", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	fh.Raw()(w, httptest.NewRequest(http.MethodGet, "/raw/docs/readme.txt", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "hello gopher" || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("raw = %d %q %q", w.Code, w.Body.String(), w.Header().Get("Content-Type"))
+	}
+
+	w = httptest.NewRecorder()
+	fh.Mkdir()(w, httptest.NewRequest(http.MethodPost, "/mkdir?folderName=empty&currentPath="+url.QueryEscape(enc("docs")), nil))
+	if w.Code != http.StatusCreated {
+		t.Errorf("mkdir = %d %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	fh.Zip()(w, httptest.NewRequest(http.MethodGet, "/zip?path="+url.QueryEscape(enc("docs")), nil))
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("zip: %v", err)
+	}
+	var zipped []string
+	for _, f := range zr.File {
+		zipped = append(zipped, f.Name)
+		if f.Name == "notes/todo.txt" {
+			rc, _ := f.Open()
+			data, _ := io.ReadAll(rc)
+			rc.Close()
+			if string(data) != "write tests" {
+				t.Errorf("zipped content = %q", data)
+			}
+		}
+	}
+	if strings.Join(zipped, ",") != "./,empty/,notes/,notes/todo.txt,readme.txt" {
+		t.Errorf("zip entries = %v", zipped)
+	}
+
+	w = httptest.NewRecorder()
+	fh.Delete()(w, httptest.NewRequest(http.MethodPost, "/delete/?path="+url.QueryEscape(enc("docs/notes")), nil))
+	if w.Code != http.StatusSeeOther {
+		t.Errorf("delete = %d %s", w.Code, w.Body.String())
+	}
+	if _, err := mem.Stat("docs/notes/todo.txt"); err == nil {
+		t.Error("deleted directory still has its file")
+	}
+
+	w = httptest.NewRecorder()
+	fh.Download()(w, httptest.NewRequest(http.MethodGet, "/download/?path="+url.QueryEscape(enc("../outside")), nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("download outside the share = %d", w.Code)
+	}
+}
+
+// statCounter counts Stat calls, which are requests of their own on
+// remote storages.
+type statCounter struct {
+	*storage.Memory
+	stats int
+}
+
+func (s *statCounter) Stat(name string) (fs.FileInfo, error) {
+	s.stats++
+	return s.Memory.Stat(name)
+}
+
+func TestListFilesUsesReadDirInfo(t *testing.T) {
+	mem := &statCounter{Memory: storage.NewMemory()}
+	for i := 0; i < 20; i++ {
+		if err := mem.WriteFile(fmt.Sprintf("big/file%02d.txt", i), []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	paths := map[string]string{}
+	var mu sync.RWMutex
+	fh := NewFileHandlers("/nonexistent", true, false, false, 0, &paths, &mu)
+	fh.Storage = mem
+
+	code, resp := listFiles(t, fh, "path="+url.QueryEscape(base64.StdEncoding.EncodeToString([]byte("big"))))
+	if code != http.StatusOK || len(resp.Entries) != 20 || resp.Entries[0].Size != 1 {
+		t.Fatalf("listing = %d %v", code, entryNames(resp.Entries))
+	}
+	if mem.stats > 1 {
+		t.Errorf("listing 20 files made %d Stat calls", mem.stats)
+	}
+}
+
+// abortRecorder notes how the writers it creates are ended.
+type abortRecorder struct {
+	*storage.Memory
+	aborted, closed int
+}
+
+type recordedWriter struct {
+	io.WriteCloser
+	s *abortRecorder
+}
+
+func (s *abortRecorder) Create(name string) (io.WriteCloser, error) {
+	w, err := s.Memory.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return &recordedWriter{WriteCloser: w, s: s}, nil
+}
+
+func (w *recordedWriter) Abort() error {
+	w.s.aborted++
+	return w.WriteCloser.Close()
+}
+
+func (w *recordedWriter) Close() error {
+	w.s.closed++
+	return w.WriteCloser.Close()
+}
+
+func TestUploadTruncatedBodyIsAborted(t *testing.T) {
+	mem := &abortRecorder{Memory: storage.NewMemory()}
+	paths := map[string]string{}
+	var mu sync.RWMutex
+	fh := NewFileHandlers("/nonexistent", true, false, false, 0, &paths, &mu)
+	fh.Storage = mem
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	part, _ := mw.CreateFormFile("file", "big.bin")
+	part.Write(bytes.Repeat([]byte("x"), 64<<10))
+	mw.Close()
+	// The client went away halfway through the file.
+	truncated := bytes.NewReader(body.Bytes()[:body.Len()/2])
+
+	req := httptest.NewRequest(http.MethodPost, "/", truncated)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	fh.List()(w, req)
+	if w.Code < 400 {
+		t.Fatalf("truncated upload = %d, want an error", w.Code)
+	}
+	if mem.aborted != 1 || mem.closed != 0 {
+		t.Errorf("writer aborted %d and closed %d times, want aborted once", mem.aborted, mem.closed)
+	}
+	if entries, err := mem.ReadDir("."); err != nil || len(entries) != 0 {
+		t.Errorf("share after a truncated upload = %v, %v", entries, err)
+	}
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/wanetty/upgopher/internal/storage"
+	"github.com/wanetty/upgopher/internal/utils"
+)
+
+// HealthHandlers serves liveness and readiness probes.
+type HealthHandlers struct {
+	Storage      storage.Storage // the share
+	ReadOnly     bool
+	MinFreeBytes uint64           // 0 disables the free space check
+	Cert         *tls.Certificate // nil when no TLS listener is configured
+}
+
+// NewHealthHandlers creates a new HealthHandlers instance
+func NewHealthHandlers(share storage.Storage, readOnly bool, minFreeBytes uint64, cert *tls.Certificate) *HealthHandlers {
+	return &HealthHandlers{
+		Storage:      share,
+		ReadOnly:     readOnly,
+		MinFreeBytes: minFreeBytes,
+		Cert:         cert,
+	}
+}
+
+// healthCheck is the JSON result of a single readiness check.
+type healthCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"` // "ok", "fail" or "skipped"
+	Detail string `json:"detail,omitempty"`
+}
+
+// healthResponse is the JSON body returned by /healthz and /readyz.
+type healthResponse struct {
+	Status string        `json:"status"`
+	Checks []healthCheck `json:"checks,omitempty"`
+}
+
+// Healthz handles GET /healthz — reports that the process is alive and serving.
+func (hh *HealthHandlers) Healthz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeHealth(w, healthResponse{Status: "ok"})
+	}
+}
+
+// Readyz handles GET /readyz — runs every readiness check and answers 200 when
+// all pass or 503 with per-check details otherwise.
+func (hh *HealthHandlers) Readyz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		checks := []healthCheck{
+			hh.checkDir(),
+			hh.checkWritable(),
+			hh.checkFreeSpace(),
+			hh.checkCertificate(time.Now()),
+		}
+
+		resp := healthResponse{Status: "ok", Checks: checks}
+		for _, c := range checks {
+			if c.Status == "fail" {
+				resp.Status = "fail"
+				break
+			}
+		}
+		writeHealth(w, resp)
+	}
+}
+
+func writeHealth(w http.ResponseWriter, resp healthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if resp.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// checkDir verifies the shared directory exists and is a directory.
+// The absolute path is never included in the response.
+func (hh *HealthHandlers) checkDir() healthCheck {
+	info, err := hh.Storage.Stat(".")
+	if err != nil {
+		return healthCheck{Name: "share_dir", Status: "fail", Detail: "shared directory is not accessible"}
+	}
+	if !info.IsDir() {
+		return healthCheck{Name: "share_dir", Status: "fail", Detail: "shared path is not a directory"}
+	}
+	return healthCheck{Name: "share_dir", Status: "ok"}
+}
+
+// checkWritable creates and removes a temporary file in the shared directory.
+func (hh *HealthHandlers) checkWritable() healthCheck {
+	if hh.ReadOnly {
+		return healthCheck{Name: "share_writable", Status: "skipped", Detail: "readonly mode"}
+	}
+	name := ".upgopher-ready-" + uploadSuffix()
+	f, err := hh.Storage.Create(name)
+	if err == nil {
+		err = f.Close()
+		hh.Storage.RemoveAll(name)
+	}
+	if err != nil {
+		return healthCheck{Name: "share_writable", Status: "fail", Detail: "shared directory is not writable"}
+	}
+	return healthCheck{Name: "share_writable", Status: "ok"}
+}
+
+// checkFreeSpace compares free space on the shared filesystem with
+// MinFreeBytes. Only a share on local disk has one.
+func (hh *HealthHandlers) checkFreeSpace() healthCheck {
+	if hh.MinFreeBytes == 0 {
+		return healthCheck{Name: "free_space", Status: "skipped", Detail: "no threshold configured"}
+	}
+	local, ok := hh.Storage.(*storage.Local)
+	if !ok {
+		return healthCheck{Name: "free_space", Status: "skipped", Detail: "share is not on local disk"}
+	}
+	free, _, err := utils.DiskUsage(local.Root())
+	if err != nil {
+		return healthCheck{Name: "free_space", Status: "fail", Detail: "unable to read filesystem usage"}
+	}
+	detail := fmt.Sprintf("%d bytes free, minimum %d", free, hh.MinFreeBytes)
+	if free < hh.MinFreeBytes {
+		return healthCheck{Name: "free_space", Status: "fail", Detail: detail}
+	}
+	return healthCheck{Name: "free_space", Status: "ok", Detail: detail}
+}
+
+// checkCertificate verifies the serving certificate is within its validity window.
+func (hh *HealthHandlers) checkCertificate(now time.Time) healthCheck {
+	if hh.Cert == nil || len(hh.Cert.Certificate) == 0 {
+		return healthCheck{Name: "tls_certificate", Status: "skipped", Detail: "TLS not enabled"}
+	}
+	leaf := hh.Cert.Leaf
+	if leaf == nil {
+		parsed, err := x509.ParseCertificate(hh.Cert.Certificate[0])
+		if err != nil {
+			return healthCheck{Name: "tls_certificate", Status: "fail", Detail: "unable to parse certificate"}
+		}
+		leaf = parsed
+	}
+	if now.Before(leaf.NotBefore) {
+		return healthCheck{Name: "tls_certificate", Status: "fail", Detail: "certificate not valid before " + leaf.NotBefore.UTC().Format(time.RFC3339)}
+	}
+	if now.After(leaf.NotAfter) {
+		return healthCheck{Name: "tls_certificate", Status: "fail", Detail: "certificate expired at " + leaf.NotAfter.UTC().Format(time.RFC3339)}
+	}
+	return healthCheck{Name: "tls_certificate", Status: "ok", Detail: "expires " + leaf.NotAfter.UTC().Format(time.RFC3339)}
+}
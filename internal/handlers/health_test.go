@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wanetty/upgopher/internal/security"
+	"github.com/wanetty/upgopher/internal/storage"
+)
+
+// localShare returns a share on local disk in dir.
+func localShare(dir string) storage.Storage {
+	return storage.NewLocal(dir, security.SymlinksWithinRoot)
+}
+
+func readyzChecks(t *testing.T, hh *HealthHandlers) (int, map[string]string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	hh.Readyz()(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	var resp healthResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	statuses := make(map[string]string)
+	for _, c := range resp.Checks {
+		statuses[c.Name] = c.Status
+	}
+	return w.Code, statuses
+}
+
+func testCertificate(t *testing.T, notBefore, notAfter time.Time) *tls.Certificate {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: notBefore, NotAfter: notAfter}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: priv}
+}
+
+func TestHealthz(t *testing.T) {
+	hh := NewHealthHandlers(localShare(filepath.Join(t.TempDir(), "missing")), false, 0, nil)
+	w := httptest.NewRecorder()
+	hh.Healthz()(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("healthz should not depend on the share dir, got %d", w.Code)
+	}
+}
+
+func TestReadyzOK(t *testing.T) {
+	cert := testCertificate(t, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	hh := NewHealthHandlers(localShare(t.TempDir()), false, 1, cert)
+
+	code, checks := readyzChecks(t, hh)
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d (%v)", code, checks)
+	}
+	for _, name := range []string{"share_dir", "share_writable", "free_space", "tls_certificate"} {
+		if checks[name] != "ok" {
+			t.Errorf("check %s = %q, want ok", name, checks[name])
+		}
+	}
+}
+
+func TestReadyzFailures(t *testing.T) {
+	t.Run("missing dir", func(t *testing.T) {
+		hh := NewHealthHandlers(localShare(filepath.Join(t.TempDir(), "missing")), false, 0, nil)
+		code, checks := readyzChecks(t, hh)
+		if code != http.StatusServiceUnavailable || checks["share_dir"] != "fail" {
+			t.Errorf("expected share_dir failure, got %d %v", code, checks)
+		}
+	})
+
+	t.Run("readonly skips write check", func(t *testing.T) {
+		hh := NewHealthHandlers(localShare(t.TempDir()), true, 0, nil)
+		code, checks := readyzChecks(t, hh)
+		if code != http.StatusOK || checks["share_writable"] != "skipped" {
+			t.Errorf("expected skipped write check, got %d %v", code, checks)
+		}
+	})
+
+	t.Run("free space below threshold", func(t *testing.T) {
+		hh := NewHealthHandlers(localShare(t.TempDir()), false, 1<<62, nil)
+		code, checks := readyzChecks(t, hh)
+		if code != http.StatusServiceUnavailable || checks["free_space"] != "fail" {
+			t.Errorf("expected free_space failure, got %d %v", code, checks)
+		}
+	})
+
+	t.Run("expired certificate", func(t *testing.T) {
+		cert := testCertificate(t, time.Now().Add(-48*time.Hour), time.Now().Add(-time.Hour))
+		hh := NewHealthHandlers(localShare(t.TempDir()), false, 0, cert)
+		code, checks := readyzChecks(t, hh)
+		if code != http.StatusServiceUnavailable || checks["tls_certificate"] != "fail" {
+			t.Errorf("expected tls_certificate failure, got %d %v", code, checks)
+		}
+	})
+}
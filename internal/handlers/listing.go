@@ -0,0 +1,311 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wanetty/upgopher/internal/storage"
+	"github.com/wanetty/upgopher/internal/templates"
+)
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// fileEntry is the JSON representation of one item returned by /api/v1/files.
+type fileEntry struct {
+	Name       string    `json:"name"`
+	Path       string    `json:"path"` // base64-encoded path relative to the shared dir
+	Type       string    `json:"type"` // "file" or "dir"
+	Size       int64     `json:"size"`
+	Mode       string    `json:"mode"`
+	ModTime    time.Time `json:"mtime"`
+	CustomPath string    `json:"customPath,omitempty"`
+	MimeType   string    `json:"mimeType,omitempty"`
+	Viewable   bool      `json:"viewable"` // true when /file-content can display it
+}
+
+// fileListResponse is the JSON body returned by /api/v1/files.
+type fileListResponse struct {
+	Path       string      `json:"path"`
+	Entries    []fileEntry `json:"entries"`
+	Total      int         `json:"total"` // entries matching the filter, across all pages
+	NextCursor string      `json:"nextCursor,omitempty"`
+}
+
+// listCursor is the decoded form of the opaque pagination cursor. It holds
+// the sort key of the last entry of the previous page, so pagination stays
+// stable even when entries are added or removed between requests.
+type listCursor struct {
+	Sort    string `json:"s"`
+	Order   string `json:"o"`
+	Name    string `json:"n"`
+	Type    string `json:"t,omitempty"`
+	Size    int64  `json:"z,omitempty"`
+	ModTime int64  `json:"m,omitempty"`
+}
+
+// ListFiles returns the contents of a directory as JSON.
+//
+// Query parameters:
+//
+//	path   – base64-encoded relative path (empty = shared root)
+//	sort   – name (default), size, mtime or type
+//	order  – asc (default) or desc
+//	glob   – optional shell pattern matched against entry names, e.g. *.log
+//	limit  – page size, 1-1000; default 100
+//	cursor – opaque value from a previous response's nextCursor
+func (fh *FileHandlers) ListFiles() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		q := r.URL.Query()
+		encodedPath := q.Get("path")
+		var relPath string
+		if encodedPath != "" {
+			decoded, err := base64.StdEncoding.DecodeString(encodedPath)
+			if err != nil {
+				http.Error(w, "Invalid path encoding", http.StatusBadRequest)
+				return
+			}
+			relPath = string(decoded)
+		}
+
+		name, ok := storage.Clean(relPath)
+		if !ok {
+			http.Error(w, "Bad path", http.StatusForbidden)
+			return
+		}
+
+		sortBy := q.Get("sort")
+		if sortBy == "" {
+			sortBy = "name"
+		}
+		if sortBy != "name" && sortBy != "size" && sortBy != "mtime" && sortBy != "type" {
+			http.Error(w, "Invalid sort parameter", http.StatusBadRequest)
+			return
+		}
+		order := q.Get("order")
+		if order == "" {
+			order = "asc"
+		}
+		if order != "asc" && order != "desc" {
+			http.Error(w, "Invalid order parameter", http.StatusBadRequest)
+			return
+		}
+
+		glob := q.Get("glob")
+		if glob != "" {
+			if _, err := filepath.Match(glob, ""); err != nil {
+				http.Error(w, "Invalid glob pattern", http.StatusBadRequest)
+				return
+			}
+		}
+
+		limit := defaultListLimit
+		if l := q.Get("limit"); l != "" {
+			n, err := strconv.Atoi(l)
+			if err != nil || n < 1 || n > maxListLimit {
+				http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+
+		var cursor *listCursor
+		if c := q.Get("cursor"); c != "" {
+			var err error
+			cursor, err = decodeListCursor(c)
+			if err != nil || cursor.Sort != sortBy || cursor.Order != order {
+				http.Error(w, "Invalid cursor", http.StatusBadRequest)
+				return
+			}
+		}
+
+		info, err := fh.Storage.Stat(name)
+		if errors.Is(err, fs.ErrPermission) {
+			http.Error(w, "Bad path", http.StatusForbidden)
+			return
+		}
+		if err != nil || !info.IsDir() {
+			http.Error(w, "Path is not a directory", http.StatusNotFound)
+			return
+		}
+
+		entries, err := fh.listEntries(name, relPath, glob, showHiddenFiles(r, fh.DisableHiddenFiles))
+		if err != nil {
+			http.Error(w, "Unable to read directory", http.StatusInternalServerError)
+			return
+		}
+
+		less := entryLess(sortBy, order)
+		sort.Slice(entries, func(i, j int) bool { return less(&entries[i], &entries[j]) })
+
+		start := 0
+		if cursor != nil {
+			last := cursor.entry()
+			start = sort.Search(len(entries), func(i int) bool { return less(&last, &entries[i]) })
+		}
+		end := start + limit
+		if end > len(entries) {
+			end = len(entries)
+		}
+
+		resp := fileListResponse{
+			Path:    encodedPath,
+			Entries: entries[start:end],
+			Total:   len(entries),
+		}
+		if end < len(entries) {
+			resp.NextCursor = encodeListCursor(sortBy, order, &entries[end-1])
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// listEntries reads the directory dir, which clients call relPath, and
+// returns its entries matching glob, hidden ones only when showHidden is set.
+func (fh *FileHandlers) listEntries(dir, relPath, glob string, showHidden bool) ([]fileEntry, error) {
+	dirEntries, err := fh.Storage.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	fh.CustomPathsMutex.RLock()
+	customPathsCopy := make(map[string]string, len(*fh.CustomPaths))
+	for k, v := range *fh.CustomPaths {
+		customPathsCopy[k] = v
+	}
+	fh.CustomPathsMutex.RUnlock()
+
+	entries := make([]fileEntry, 0, len(dirEntries))
+	for _, de := range dirEntries {
+		name := de.Name()
+		if name[0] == '.' && !showHidden {
+			continue
+		}
+		if glob != "" {
+			if matched, _ := filepath.Match(glob, name); !matched {
+				continue
+			}
+		}
+
+		// ReadDir already describes entries, so only links, whose target
+		// must be looked up, cost another call. Dangling ones are left out.
+		var info fs.FileInfo
+		var err error
+		if de.Type()&fs.ModeSymlink != 0 {
+			info, err = fh.Storage.Stat(path.Join(dir, name))
+		} else {
+			info, err = de.Info()
+		}
+		if err != nil {
+			continue
+		}
+
+		itemRel := filepath.Join(relPath, name)
+		entry := fileEntry{
+			Name:    name,
+			Path:    base64.StdEncoding.EncodeToString([]byte(itemRel)),
+			Mode:    info.Mode().String(),
+			ModTime: info.ModTime(),
+		}
+		if info.IsDir() {
+			entry.Type = "dir"
+		} else {
+			entry.Type = "file"
+			entry.Size = info.Size()
+			entry.CustomPath = customPathsCopy[itemRel]
+			entry.MimeType = mimeTypeFor(name)
+			entry.Viewable = templates.IsTextFile(name)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// mimeTypeFor guesses a MIME type from the file extension.
+func mimeTypeFor(name string) string {
+	if t := mime.TypeByExtension(strings.ToLower(filepath.Ext(name))); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// entryLess returns a strict total order over entries for the given sort
+// field and direction. Names break ties so every position is unambiguous.
+func entryLess(sortBy, order string) func(a, b *fileEntry) bool {
+	asc := func(a, b *fileEntry) bool {
+		switch sortBy {
+		case "size":
+			if a.Size != b.Size {
+				return a.Size < b.Size
+			}
+		case "mtime":
+			if !a.ModTime.Equal(b.ModTime) {
+				return a.ModTime.Before(b.ModTime)
+			}
+		case "type":
+			if a.Type != b.Type {
+				return a.Type == "dir"
+			}
+		}
+		return a.Name < b.Name
+	}
+	if order == "desc" {
+		return func(a, b *fileEntry) bool { return asc(b, a) }
+	}
+	return asc
+}
+
+func encodeListCursor(sortBy, order string, last *fileEntry) string {
+	c := listCursor{Sort: sortBy, Order: order, Name: last.Name}
+	switch sortBy {
+	case "size":
+		c.Size = last.Size
+	case "mtime":
+		c.ModTime = last.ModTime.UnixNano()
+	case "type":
+		c.Type = last.Type
+	}
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeListCursor(s string) (*listCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var c listCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// entry rebuilds the sort key of the entry the cursor points after.
+func (c *listCursor) entry() fileEntry {
+	e := fileEntry{Name: c.Name, Type: c.Type, Size: c.Size}
+	if c.ModTime != 0 {
+		e.ModTime = time.Unix(0, c.ModTime)
+	}
+	return e
+}
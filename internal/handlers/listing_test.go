@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestListing(t *testing.T) (*FileHandlers, string) {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]int{"b.txt": 30, "a.log": 10, "c.log": 20, ".secret": 5}
+	base := time.Now().Add(-time.Hour)
+	i := 0
+	for name, size := range files {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(p, base.Add(time.Duration(i)*time.Minute), base.Add(time.Duration(i)*time.Minute))
+		i++
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	paths := map[string]string{"a.log": "latest-log"}
+	var mu sync.RWMutex
+	return NewFileHandlers(dir, true, false, false, 0, &paths, &mu), dir
+}
+
+func listFiles(t *testing.T, fh *FileHandlers, query string, cookies ...*http.Cookie) (int, fileListResponse) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/files?"+query, nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	fh.ListFiles()(w, req)
+	var resp fileListResponse
+	if w.Code == http.StatusOK {
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("invalid JSON: %v", err)
+		}
+	}
+	return w.Code, resp
+}
+
+func entryNames(entries []fileEntry) []string {
+	names := make([]string, len(entries))
+	for i, e := range entries {
+		names[i] = e.Name
+	}
+	return names
+}
+
+func equalNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestListFilesSorting(t *testing.T) {
+	fh, _ := newTestListing(t)
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"a.log", "b.txt", "c.log", "sub"}},
+		{"order=desc", []string{"sub", "c.log", "b.txt", "a.log"}},
+		{"sort=size", []string{"sub", "a.log", "c.log", "b.txt"}},
+		{"sort=size&order=desc", []string{"b.txt", "c.log", "a.log", "sub"}},
+		{"sort=type", []string{"sub", "a.log", "b.txt", "c.log"}},
+	}
+	for _, tt := range tests {
+		code, resp := listFiles(t, fh, tt.query)
+		if code != http.StatusOK {
+			t.Fatalf("%q: expected 200, got %d", tt.query, code)
+		}
+		if got := entryNames(resp.Entries); !equalNames(got, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestListFilesEntryFields(t *testing.T) {
+	fh, _ := newTestListing(t)
+	_, resp := listFiles(t, fh, "glob=a.log")
+	if len(resp.Entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(resp.Entries))
+	}
+	e := resp.Entries[0]
+	if e.Type != "file" || e.Size != 10 || e.CustomPath != "latest-log" || !e.Viewable {
+		t.Errorf("unexpected entry: %+v", e)
+	}
+	if decoded, _ := base64.StdEncoding.DecodeString(e.Path); string(decoded) != "a.log" {
+		t.Errorf("path = %q, want a.log", decoded)
+	}
+}
+
+func TestListFilesGlobAndHidden(t *testing.T) {
+	fh, _ := newTestListing(t)
+	_, resp := listFiles(t, fh, "glob=*.log")
+	if got := entryNames(resp.Entries); !equalNames(got, []string{"a.log", "c.log"}) || resp.Total != 2 {
+		t.Errorf("glob *.log: got %v (total %d)", got, resp.Total)
+	}
+
+	_, resp = listFiles(t, fh, "glob=.*")
+	if len(resp.Entries) != 0 {
+		t.Errorf("hidden files listed while disabled: %v", entryNames(resp.Entries))
+	}
+
+	show := &http.Cookie{Name: hiddenFilesCookie, Value: "1"}
+	_, resp = listFiles(t, fh, "glob=.*", show)
+	if got := entryNames(resp.Entries); !equalNames(got, []string{".secret"}) {
+		t.Errorf("hidden files with preference on: got %v", got)
+	}
+
+	fh.DisableHiddenFiles = true
+	_, resp = listFiles(t, fh, "glob=.*", show)
+	if len(resp.Entries) != 0 {
+		t.Errorf("-disable-hidden-files not enforced: %v", entryNames(resp.Entries))
+	}
+}
+
+func TestListFilesPagination(t *testing.T) {
+	fh, dir := newTestListing(t)
+
+	for _, sortBy := range []string{"name", "size", "mtime", "type"} {
+		var got []string
+		cursor := ""
+		for page := 0; ; page++ {
+			if page > 10 {
+				t.Fatalf("sort=%s: pagination did not terminate", sortBy)
+			}
+			query := "sort=" + sortBy + "&limit=1"
+			if cursor != "" {
+				query += "&cursor=" + cursor
+			}
+			code, resp := listFiles(t, fh, query)
+			if code != http.StatusOK {
+				t.Fatalf("sort=%s: expected 200, got %d", sortBy, code)
+			}
+			if resp.Total != 4 {
+				t.Errorf("sort=%s: total = %d, want 4", sortBy, resp.Total)
+			}
+			got = append(got, entryNames(resp.Entries)...)
+			if resp.NextCursor == "" {
+				break
+			}
+			cursor = resp.NextCursor
+		}
+		_, all := listFiles(t, fh, "sort="+sortBy)
+		if want := entryNames(all.Entries); !equalNames(got, want) {
+			t.Errorf("sort=%s: paged %v, want %v", sortBy, got, want)
+		}
+	}
+
+	// Entries added before the cursor position don't shift later pages
+	_, first := listFiles(t, fh, "limit=2")
+	if err := os.WriteFile(filepath.Join(dir, "0first.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, second := listFiles(t, fh, "limit=2&cursor="+first.NextCursor)
+	if got := entryNames(second.Entries); !equalNames(got, []string{"c.log", "sub"}) {
+		t.Errorf("second page after insert: got %v", got)
+	}
+}
+
+func TestListFilesBadRequests(t *testing.T) {
+	fh, _ := newTestListing(t)
+	_, resp := listFiles(t, fh, "sort=name&limit=1")
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"path=@@@", http.StatusBadRequest},
+		{"path=" + base64.StdEncoding.EncodeToString([]byte("../")), http.StatusForbidden},
+		{"path=" + base64.StdEncoding.EncodeToString([]byte("b.txt")), http.StatusNotFound},
+		{"path=" + base64.StdEncoding.EncodeToString([]byte("missing")), http.StatusNotFound},
+		{"sort=owner", http.StatusBadRequest},
+		{"order=up", http.StatusBadRequest},
+		{"glob=[", http.StatusBadRequest},
+		{"limit=0", http.StatusBadRequest},
+		{"limit=1001", http.StatusBadRequest},
+		{"cursor=garbage", http.StatusBadRequest},
+		{"sort=size&cursor=" + resp.NextCursor, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if code, _ := listFiles(t, fh, tt.query); code != tt.want {
+			t.Errorf("%q: got %d, want %d", tt.query, code, tt.want)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	fh.ListFiles()(w, httptest.NewRequest(http.MethodPost, "/api/v1/files", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: got %d, want 405", w.Code)
+	}
+}
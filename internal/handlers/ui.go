@@ -2,28 +2,30 @@ package handlers
 
 import (
 	"embed"
+	"encoding/json"
 	"log"
 	"net/http"
 	"time"
+
+	"github.com/wanetty/upgopher/internal/statics"
 )
 
-// UIHandlers manages UI-related HTTP handlers (favicon, logo, settings toggle)
+// UIHandlers manages UI-related HTTP handlers (favicon, logo, settings toggle, API document)
 type UIHandlers struct {
 	Quiet              bool
 	DisableHiddenFiles bool
 	ReadOnly           bool
-	ShowHiddenFiles    *bool
 	FaviconFS          *embed.FS
 	LogoFS             *embed.FS
+	BasePath           string // URL prefix advertised as the OpenAPI server URL
 }
 
 // NewUIHandlers creates a new UIHandlers instance
-func NewUIHandlers(quiet bool, disableHiddenFiles bool, readOnly bool, showHiddenFiles *bool, faviconFS *embed.FS, logoFS *embed.FS) *UIHandlers {
+func NewUIHandlers(quiet bool, disableHiddenFiles bool, readOnly bool, faviconFS *embed.FS, logoFS *embed.FS) *UIHandlers {
 	return &UIHandlers{
 		Quiet:              quiet,
 		DisableHiddenFiles: disableHiddenFiles,
 		ReadOnly:           readOnly,
-		ShowHiddenFiles:    showHiddenFiles,
 		FaviconFS:          faviconFS,
 		LogoFS:             logoFS,
 	}
@@ -55,17 +57,32 @@ func (ui *UIHandlers) Logo() http.HandlerFunc {
 	}
 }
 
-// ToggleHiddenFiles handles GET/POST for hidden files visibility
+// hiddenFilesCookie holds a visitor's choice to see hidden files, so each
+// browser has its own.
+const hiddenFilesCookie = "upgopher_show_hidden"
+
+// showHiddenFiles reports whether the visitor sending r asked to see hidden
+// files. disabled, set by -disable-hidden-files, overrides their choice.
+func showHiddenFiles(r *http.Request, disabled bool) bool {
+	if disabled {
+		return false
+	}
+	c, err := r.Cookie(hiddenFilesCookie)
+	return err == nil && c.Value == "1"
+}
+
+// ToggleHiddenFiles handles GET/POST for the visitor's hidden files
+// visibility, kept in a cookie
 func (ui *UIHandlers) ToggleHiddenFiles() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		show := showHiddenFiles(r, ui.DisableHiddenFiles)
 		// Handle GET request - return current hidden files status
 		if r.Method == http.MethodGet {
 			if !ui.Quiet {
-				log.Printf("[%s] Getting hidden files setting: %t\n", time.Now().Format("2006-01-02 15:04:05"), *ui.ShowHiddenFiles)
+				log.Printf("[%s] Getting hidden files setting: %t\n", time.Now().Format("2006-01-02 15:04:05"), show)
 			}
-			if *ui.ShowHiddenFiles {
+			if show {
 				w.Write([]byte("true"))
-				return
 			} else {
 				w.Write([]byte("false"))
 			}
@@ -77,10 +94,54 @@ func (ui *UIHandlers) ToggleHiddenFiles() http.HandlerFunc {
 			if ui.DisableHiddenFiles {
 				http.Error(w, "You can't change this setting", http.StatusForbidden)
 				return
+			}
+			cookie := &http.Cookie{
+				Name:     hiddenFilesCookie,
+				Path:     ui.BasePath + "/",
+				HttpOnly: true,
+				Secure:   r.TLS != nil,
+				SameSite: http.SameSiteLaxMode,
+			}
+			if show {
+				cookie.MaxAge = -1
 			} else {
-				*ui.ShowHiddenFiles = !*ui.ShowHiddenFiles
-				return
+				cookie.Value = "1"
+				cookie.MaxAge = 365 * 24 * 60 * 60
 			}
+			http.SetCookie(w, cookie)
+			if show {
+				w.Write([]byte("false"))
+			} else {
+				w.Write([]byte("true"))
+			}
+		} else {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// OpenAPI serves the embedded OpenAPI document with its server URL set to BasePath
+func (ui *UIHandlers) OpenAPI() http.HandlerFunc {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(statics.OpenAPISpec(), &doc); err != nil {
+		panic("Error parsing OpenAPI document: " + err.Error())
+	}
+	serverURL := ui.BasePath
+	if serverURL == "" {
+		serverURL = "/"
+	}
+	doc["servers"] = []map[string]string{{"url": serverURL}}
+	spec, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		panic("Error encoding OpenAPI document: " + err.Error())
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
 		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(spec)
 	}
 }
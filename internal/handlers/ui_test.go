@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// toggleHidden sends a request to ToggleHiddenFiles with cookies and returns
+// the response.
+func toggleHidden(ui *UIHandlers, method string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/showhiddenfiles", nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	ui.ToggleHiddenFiles()(w, req)
+	return w
+}
+
+func TestHiddenFilesPreferenceIsPerClient(t *testing.T) {
+	ui := NewUIHandlers(true, false, false, nil, nil)
+	ui.BasePath = "/files"
+
+	w := toggleHidden(ui, http.MethodPost)
+	cookies := w.Result().Cookies()
+	if w.Code != http.StatusOK || w.Body.String() != "true" || len(cookies) != 1 {
+		t.Fatalf("turning hidden files on: %d %q, cookies %v", w.Code, w.Body.String(), cookies)
+	}
+	if c := cookies[0]; c.Path != "/files/" || !c.HttpOnly || c.SameSite != http.SameSiteLaxMode {
+		t.Errorf("preference cookie = %+v", c)
+	}
+
+	if got := toggleHidden(ui, http.MethodGet, cookies[0]).Body.String(); got != "true" {
+		t.Errorf("client that turned hidden files on sees %q", got)
+	}
+	if got := toggleHidden(ui, http.MethodGet).Body.String(); got != "false" {
+		t.Errorf("another client sees %q, want false", got)
+	}
+
+	w = toggleHidden(ui, http.MethodPost, cookies[0])
+	if w.Body.String() != "false" || len(w.Result().Cookies()) != 1 || w.Result().Cookies()[0].MaxAge >= 0 {
+		t.Errorf("turning hidden files off: %q, cookies %v", w.Body.String(), w.Result().Cookies())
+	}
+
+	ui.DisableHiddenFiles = true
+	if w := toggleHidden(ui, http.MethodPost); w.Code != http.StatusForbidden {
+		t.Errorf("toggle with -disable-hidden-files: got %d, want 403", w.Code)
+	}
+	if got := toggleHidden(ui, http.MethodGet, cookies[0]).Body.String(); got != "false" {
+		t.Errorf("-disable-hidden-files overridden by the cookie: %q", got)
+	}
+}
+
+func TestHiddenFilesPreferenceInTreeAndZip(t *testing.T) {
+	fh, dir := newTestListing(t)
+	if err := os.MkdirAll(filepath.Join(dir, ".config"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	show := &http.Cookie{Name: hiddenFilesCookie, Value: "1"}
+	get := func(handler http.HandlerFunc, target string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+	zipNames := func(w *httptest.ResponseRecorder) string {
+		zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+		if err != nil {
+			t.Fatalf("invalid zip (%d): %v", w.Code, err)
+		}
+		var names []string
+		for _, f := range zr.File {
+			names = append(names, f.Name)
+		}
+		return strings.Join(names, " ")
+	}
+
+	if body := get(fh.Tree(), "/api/v1/tree").Body.String(); strings.Contains(body, ".config") {
+		t.Errorf("tree shows hidden directories by default: %s", body)
+	}
+	if body := get(fh.Tree(), "/api/v1/tree", show).Body.String(); !strings.Contains(body, ".config") {
+		t.Errorf("tree hides hidden directories from a client that asked for them: %s", body)
+	}
+	if names := zipNames(get(fh.Zip(), "/zip")); strings.Contains(names, ".secret") || strings.Contains(names, ".config") {
+		t.Errorf("zip includes hidden files by default: %s", names)
+	}
+	if names := zipNames(get(fh.Zip(), "/zip", show)); !strings.Contains(names, ".secret") {
+		t.Errorf("zip leaves out hidden files for a client that asked for them: %s", names)
+	}
+
+	fh.DisableHiddenFiles = true
+	if names := zipNames(get(fh.Zip(), "/zip", show)); strings.Contains(names, ".secret") {
+		t.Errorf("-disable-hidden-files overridden by the cookie: %s", names)
+	}
+}
@@ -0,0 +1,265 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DurationBuckets are the upper bounds, in seconds, of the request latency histogram.
+var DurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Registry collects request statistics, counters and gauges and renders them
+// in the Prometheus text exposition format. It has no external dependencies.
+type Registry struct {
+	mu       sync.Mutex
+	requests map[requestKey]*histogram
+	received map[string]uint64 // route -> request body bytes
+	sent     map[string]uint64 // route -> response body bytes
+	counters []*Counter
+	gauges   []gauge
+}
+
+type requestKey struct {
+	route  string
+	method string
+	code   int
+}
+
+type histogram struct {
+	buckets []uint64 // cumulative counts are computed on render
+	count   uint64
+	sum     float64
+}
+
+type gauge struct {
+	name string
+	help string
+	fn   func() float64
+}
+
+// Counter is a monotonically increasing value. A nil *Counter is valid and
+// ignores updates, so callers don't need to check whether metrics are enabled.
+type Counter struct {
+	name  string
+	help  string
+	value uint64
+}
+
+// Add increases the counter by n.
+func (c *Counter) Add(n uint64) {
+	if c == nil {
+		return
+	}
+	atomic.AddUint64(&c.value, n)
+}
+
+// Inc increases the counter by one.
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		requests: make(map[requestKey]*histogram),
+		received: make(map[string]uint64),
+		sent:     make(map[string]uint64),
+	}
+}
+
+// NewCounter registers and returns a counter without labels.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	c := &Counter{name: name, help: help}
+	r.mu.Lock()
+	r.counters = append(r.counters, c)
+	r.mu.Unlock()
+	return c
+}
+
+// GaugeFunc registers a gauge whose value is computed by fn on every scrape.
+// fn may return NaN when the value is temporarily unavailable.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.mu.Lock()
+	r.gauges = append(r.gauges, gauge{name: name, help: help, fn: fn})
+	r.mu.Unlock()
+}
+
+// ObserveRequest records one completed HTTP request.
+func (r *Registry) ObserveRequest(route, method string, code int, duration time.Duration, received, sent int64) {
+	key := requestKey{route: route, method: normalizeMethod(method), code: code}
+	seconds := duration.Seconds()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h, ok := r.requests[key]
+	if !ok {
+		h = &histogram{buckets: make([]uint64, len(DurationBuckets))}
+		r.requests[key] = h
+	}
+	for i, le := range DurationBuckets {
+		if seconds <= le {
+			h.buckets[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += seconds
+	if received > 0 {
+		r.received[route] += uint64(received)
+	}
+	if sent > 0 {
+		r.sent[route] += uint64(sent)
+	}
+}
+
+// Handler serves the registry in the Prometheus text format.
+func (r *Registry) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		r.WriteTo(w)
+	}
+}
+
+// WriteTo renders all metrics in the Prometheus text exposition format.
+func (r *Registry) WriteTo(out io.Writer) (int64, error) {
+	cw := &countingWriter{w: bufio.NewWriter(out)}
+
+	r.mu.Lock()
+	keys := make([]requestKey, 0, len(r.requests))
+	for k := range r.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.code < b.code
+	})
+
+	writeHeader(cw, "upgopher_http_requests_total", "Total HTTP requests by route, method and status code.", "counter")
+	for _, k := range keys {
+		fmt.Fprintf(cw, "upgopher_http_requests_total{%s} %d\n", requestLabels(k), r.requests[k].count)
+	}
+
+	writeHeader(cw, "upgopher_http_request_duration_seconds", "HTTP request latency by route, method and status code.", "histogram")
+	for _, k := range keys {
+		h := r.requests[k]
+		labels := requestLabels(k)
+		var cumulative uint64
+		for i, le := range DurationBuckets {
+			cumulative += h.buckets[i]
+			fmt.Fprintf(cw, "upgopher_http_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", labels, formatFloat(le), cumulative)
+		}
+		fmt.Fprintf(cw, "upgopher_http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(cw, "upgopher_http_request_duration_seconds_sum{%s} %s\n", labels, formatFloat(h.sum))
+		fmt.Fprintf(cw, "upgopher_http_request_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+
+	writeRouteBytes(cw, "upgopher_http_request_bytes_total", "Request body bytes received (uploads) by route.", r.received)
+	writeRouteBytes(cw, "upgopher_http_response_bytes_total", "Response body bytes sent (downloads) by route.", r.sent)
+
+	counters := append([]*Counter(nil), r.counters...)
+	gauges := append([]gauge(nil), r.gauges...)
+	r.mu.Unlock()
+
+	for _, c := range counters {
+		writeHeader(cw, c.name, c.help, "counter")
+		fmt.Fprintf(cw, "%s %d\n", c.name, atomic.LoadUint64(&c.value))
+	}
+
+	// Gauge callbacks may take locks of their own, so they run outside r.mu.
+	for _, g := range gauges {
+		writeHeader(cw, g.name, g.help, "gauge")
+		fmt.Fprintf(cw, "%s %s\n", g.name, formatFloat(g.fn()))
+	}
+
+	if err := cw.w.Flush(); err != nil {
+		return cw.n, err
+	}
+	return cw.n, cw.err
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeRouteBytes(w io.Writer, name, help string, values map[string]uint64) {
+	routes := make([]string, 0, len(values))
+	for route := range values {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+
+	writeHeader(w, name, help, "counter")
+	for _, route := range routes {
+		fmt.Fprintf(w, "%s{route=\"%s\"} %d\n", name, escapeLabel(route), values[route])
+	}
+}
+
+func requestLabels(k requestKey) string {
+	return fmt.Sprintf(`route="%s",method="%s",code="%d"`, escapeLabel(k.route), k.method, k.code)
+}
+
+// normalizeMethod bounds the method label to the standard verbs so that
+// arbitrary client input can't create unbounded series.
+func normalizeMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	default:
+		return "OTHER"
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegistryRendersRequestHistogram(t *testing.T) {
+	reg := NewRegistry()
+	reg.ObserveRequest("/", "POST", 201, 3*time.Millisecond, 1024, 10)
+	reg.ObserveRequest("/", "POST", 201, 200*time.Millisecond, 2048, 10)
+	reg.ObserveRequest("/", "BREW", 405, time.Millisecond, 0, 0)
+
+	var buf bytes.Buffer
+	if _, err := reg.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		"# TYPE upgopher_http_requests_total counter\n",
+		`upgopher_http_requests_total{route="/",method="POST",code="201"} 2` + "\n",
+		`upgopher_http_requests_total{route="/",method="OTHER",code="405"} 1` + "\n",
+		"# TYPE upgopher_http_request_duration_seconds histogram\n",
+		`upgopher_http_request_duration_seconds_bucket{route="/",method="POST",code="201",le="0.005"} 1` + "\n",
+		`upgopher_http_request_duration_seconds_bucket{route="/",method="POST",code="201",le="0.1"} 1` + "\n",
+		`upgopher_http_request_duration_seconds_bucket{route="/",method="POST",code="201",le="0.25"} 2` + "\n",
+		`upgopher_http_request_duration_seconds_bucket{route="/",method="POST",code="201",le="+Inf"} 2` + "\n",
+		`upgopher_http_request_duration_seconds_count{route="/",method="POST",code="201"} 2` + "\n",
+		`upgopher_http_request_bytes_total{route="/"} 3072` + "\n",
+		`upgopher_http_response_bytes_total{route="/"} 20` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q\n%s", want, out)
+		}
+	}
+}
+
+func TestRegistryCountersAndGauges(t *testing.T) {
+	reg := NewRegistry()
+	c := reg.NewCounter("upgopher_test_total", "Test counter.")
+	c.Inc()
+	c.Add(4)
+	reg.GaugeFunc("upgopher_test_gauge", "Test gauge.", func() float64 { return 1.5 })
+	reg.GaugeFunc("upgopher_test_unknown", "Unavailable gauge.", func() float64 { return math.NaN() })
+
+	var nilCounter *Counter
+	nilCounter.Inc() // must not panic
+
+	var buf bytes.Buffer
+	reg.WriteTo(&buf)
+	out := buf.String()
+	for _, want := range []string{
+		"# HELP upgopher_test_total Test counter.\n# TYPE upgopher_test_total counter\nupgopher_test_total 5\n",
+		"# TYPE upgopher_test_gauge gauge\nupgopher_test_gauge 1.5\n",
+		"upgopher_test_unknown NaN\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q\n%s", want, out)
+		}
+	}
+}
+
+func TestEscapeLabel(t *testing.T) {
+	if got := escapeLabel("a\"b\\c\nd"); got != `a\"b\\c\nd` {
+		t.Errorf("escapeLabel = %q", got)
+	}
+}
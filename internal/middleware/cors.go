@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Request and response headers cross-origin API clients may use.
+const (
+	corsAllowMethods  = "GET, POST, DELETE, OPTIONS"
+	corsAllowHeaders  = "Authorization, Content-Type, If-Match, X-Requested-With, X-Tab-Token, X-Tab-Token-Create, X-Tab-Token-Value, X-Tab-TTL, X-Tab-Burn-After-Read, X-Tab-Encrypted, X-Tab-Salt, X-Tab-KDF-Iterations, X-Tab-Key-Check"
+	corsExposeHeaders = "ETag, X-Generated-Token, X-Tab-Burned, Retry-After, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset"
+)
+
+// CORSConfig lists the origins other than the app's own that may call it
+// from a browser. With no origins, only same-origin pages can read
+// responses.
+type CORSConfig struct {
+	Origins []string // "scheme://host[:port]" values, or "*" for any origin
+	// AllowCredentials lets listed origins send basic auth credentials and
+	// cookies. It never applies to "*".
+	AllowCredentials bool
+	MaxAge           time.Duration // how long browsers may cache a preflight response
+}
+
+// ParseCORSOrigins parses a comma-separated -cors-origins value. Each origin
+// must be "*" or an http(s) scheme and host without a path.
+func ParseCORSOrigins(value string) ([]string, error) {
+	var origins []string
+	for _, o := range strings.Split(value, ",") {
+		o = strings.TrimSpace(o)
+		if o == "" {
+			continue
+		}
+		if o == "*" {
+			origins = append(origins, o)
+			continue
+		}
+		u, err := url.Parse(o)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.User != nil {
+			return nil, fmt.Errorf("invalid CORS origin %q: want scheme://host[:port], such as https://example.com", o)
+		}
+		origins = append(origins, strings.ToLower(u.Scheme+"://"+u.Host))
+	}
+	return origins, nil
+}
+
+// allows reports whether origin is listed, and whether it matched "*".
+func (c CORSConfig) allows(origin string) (ok, wildcard bool) {
+	origin = strings.ToLower(origin)
+	for _, o := range c.Origins {
+		if o == origin {
+			return true, false
+		}
+		if o == "*" {
+			wildcard = true
+		}
+	}
+	return wildcard, wildcard
+}
+
+// CORS returns a middleware applying c. Requests from listed origins get
+// the Access-Control-* headers, and their preflight requests are answered
+// here, before authentication, since browsers send them without
+// credentials. Preflights from other origins are refused; their other
+// requests pass through without CORS headers, so browsers won't let the
+// calling page read the response.
+func CORS(c CORSConfig) func(http.Handler) http.Handler {
+	maxAge := strconv.Itoa(int(c.MaxAge / time.Second))
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			h := w.Header()
+			h.Add("Vary", "Origin")
+
+			ok, wildcard := c.allows(origin)
+			if !ok {
+				if preflight {
+					http.Error(w, "Origin not allowed", http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if wildcard {
+				h.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				h.Set("Access-Control-Allow-Origin", origin)
+				if c.AllowCredentials {
+					h.Set("Access-Control-Allow-Credentials", "true")
+				}
+			}
+			if !preflight {
+				h.Set("Access-Control-Expose-Headers", corsExposeHeaders)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			h.Set("Access-Control-Allow-Methods", corsAllowMethods)
+			h.Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			if c.MaxAge > 0 {
+				h.Set("Access-Control-Max-Age", maxAge)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
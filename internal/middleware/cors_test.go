@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// corsRequest sends a request with origin through CORS(c) and reports
+// whether it reached the wrapped handler.
+func corsRequest(c CORSConfig, method, origin string, preflight bool) (*httptest.ResponseRecorder, bool) {
+	reached := false
+	handler := CORS(c)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+		w.WriteHeader(http.StatusOK)
+	}))
+	req := httptest.NewRequest(method, "/clipboard?tab=default", nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	if preflight {
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w, reached
+}
+
+func TestCORSDefaultsToSameOrigin(t *testing.T) {
+	w, reached := corsRequest(CORSConfig{}, http.MethodGet, "", false)
+	if !reached || len(w.Header()) != 0 {
+		t.Errorf("request without Origin: reached=%v headers=%v", reached, w.Header())
+	}
+
+	w, reached = corsRequest(CORSConfig{}, http.MethodGet, "https://evil.example", false)
+	h := w.Header()
+	if !reached || h.Get("Access-Control-Allow-Origin") != "" || h.Get("Vary") != "Origin" {
+		t.Errorf("cross-origin request: reached=%v headers=%v", reached, h)
+	}
+
+	w, reached = corsRequest(CORSConfig{}, http.MethodOptions, "https://evil.example", true)
+	if reached || w.Code != http.StatusForbidden || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("cross-origin preflight: reached=%v code=%d headers=%v", reached, w.Code, w.Header())
+	}
+}
+
+func TestCORSAllowedOrigin(t *testing.T) {
+	c := CORSConfig{Origins: []string{"https://app.example"}, AllowCredentials: true, MaxAge: 10 * time.Minute}
+
+	w, reached := corsRequest(c, http.MethodPost, "https://App.example", false)
+	h := w.Header()
+	if !reached || h.Get("Access-Control-Allow-Origin") != "https://App.example" || h.Get("Access-Control-Allow-Credentials") != "true" {
+		t.Errorf("allowed request: reached=%v headers=%v", reached, h)
+	}
+	if h.Get("Access-Control-Expose-Headers") == "" || h.Get("Access-Control-Max-Age") != "" {
+		t.Errorf("allowed request headers = %v", h)
+	}
+
+	w, reached = corsRequest(c, http.MethodOptions, "https://app.example", true)
+	h = w.Header()
+	if reached {
+		t.Error("preflight reached the handler")
+	}
+	if w.Code != http.StatusNoContent || h.Get("Access-Control-Max-Age") != "600" || h.Get("Access-Control-Allow-Methods") == "" || h.Get("Access-Control-Allow-Headers") == "" {
+		t.Errorf("preflight = %d %v", w.Code, h)
+	}
+
+	// An OPTIONS request that isn't a preflight is left to the handler.
+	if _, reached := corsRequest(c, http.MethodOptions, "https://app.example", false); !reached {
+		t.Error("plain OPTIONS request did not reach the handler")
+	}
+}
+
+func TestCORSWildcardNeverSendsCredentials(t *testing.T) {
+	c := CORSConfig{Origins: []string{"*"}, AllowCredentials: true}
+	w, _ := corsRequest(c, http.MethodGet, "https://anyone.example", false)
+	h := w.Header()
+	if h.Get("Access-Control-Allow-Origin") != "*" || h.Get("Access-Control-Allow-Credentials") != "" {
+		t.Errorf("wildcard headers = %v", h)
+	}
+	w, _ = corsRequest(c, http.MethodOptions, "https://anyone.example", true)
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Max-Age") != "" {
+		t.Errorf("wildcard preflight without max age = %d %v", w.Code, w.Header())
+	}
+}
+
+func TestParseCORSOrigins(t *testing.T) {
+	got, err := ParseCORSOrigins(" https://App.example:8443/ ,*,, http://10.0.0.5:9090")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"https://app.example:8443", "*", "http://10.0.0.5:9090"}
+	if len(got) != len(want) {
+		t.Fatalf("origins = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("origins = %q, want %q", got, want)
+		}
+	}
+
+	if got, err := ParseCORSOrigins(""); err != nil || len(got) != 0 {
+		t.Errorf("empty value = %q, %v", got, err)
+	}
+	for _, bad := range []string{"app.example", "ftp://app.example", "https://app.example/path", "https://user@app.example", "https://"} {
+		if _, err := ParseCORSOrigins(bad); err == nil {
+			t.Errorf("ParseCORSOrigins(%q) succeeded", bad)
+		}
+	}
+}
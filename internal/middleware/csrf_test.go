@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCSRF(t *testing.T) {
+	handler := CSRF([]string{"https://app.example", "*"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		want    int
+	}{
+		{"cross-site GET", http.MethodGet, map[string]string{"Sec-Fetch-Site": "cross-site", "Origin": "https://evil.example"}, http.StatusOK},
+		{"non-browser client", http.MethodPost, nil, http.StatusOK},
+		{"same-origin fetch", http.MethodPost, map[string]string{"Sec-Fetch-Site": "same-origin", "Origin": "http://files.lan"}, http.StatusOK},
+		{"typed into the address bar", http.MethodPost, map[string]string{"Sec-Fetch-Site": "none"}, http.StatusOK},
+		{"cross-site form post", http.MethodPost, map[string]string{"Sec-Fetch-Site": "cross-site", "Origin": "https://evil.example"}, http.StatusForbidden},
+		{"same-site subdomain", http.MethodDelete, map[string]string{"Sec-Fetch-Site": "same-site", "Origin": "http://other.files.lan"}, http.StatusForbidden},
+		{"trusted origin", http.MethodPost, map[string]string{"Sec-Fetch-Site": "cross-site", "Origin": "https://APP.example"}, http.StatusOK},
+		{"old browser, same host", http.MethodPost, map[string]string{"Origin": "http://files.lan"}, http.StatusOK},
+		{"old browser, other host", http.MethodPost, map[string]string{"Origin": "https://evil.example"}, http.StatusForbidden},
+		{"opaque origin", http.MethodPost, map[string]string{"Origin": "null"}, http.StatusForbidden},
+		{"referer from this host", http.MethodPost, map[string]string{"Referer": "http://files.lan/?path=ZG9jcw=="}, http.StatusOK},
+		{"referer from another host", http.MethodPost, map[string]string{"Referer": "https://evil.example/page"}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "http://files.lan/mkdir", nil)
+		for k, v := range tt.headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// uiContentSecurityPolicy keeps other sites from framing the app, which
+// would expose it to clickjacking, and pages from changing where relative
+// URLs and forms point. Handlers serving user files replace it with a
+// sandbox.
+const uiContentSecurityPolicy = "frame-ancestors 'self'; base-uri 'self'; form-action 'self'; object-src 'none'"
+
+// SecurityHeaders returns a middleware setting the headers every response
+// should carry: no MIME sniffing, no framing by other sites, referrers kept
+// to this origin and, on TLS connections when hstsMaxAge is positive,
+// Strict-Transport-Security.
+func SecurityHeaders(hstsMaxAge time.Duration) func(http.Handler) http.Handler {
+	hsts := "max-age=" + strconv.Itoa(int(hstsMaxAge/time.Second))
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			h.Set("X-Content-Type-Options", "nosniff")
+			h.Set("X-Frame-Options", "SAMEORIGIN")
+			h.Set("Referrer-Policy", "same-origin")
+			h.Set("Content-Security-Policy", uiContentSecurityPolicy)
+			if r.TLS != nil && hstsMaxAge > 0 {
+				h.Set("Strict-Transport-Security", hsts)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ParseRawOrigin parses a -raw-origin value: the scheme and host of a
+// separate origin, such as https://raw.files.lan, from which raw HTML and SVG
+// files are served.
+func ParseRawOrigin(value string) (*url.URL, error) {
+	origins, err := ParseCORSOrigins(value)
+	if err != nil || len(origins) != 1 || origins[0] == "*" {
+		return nil, fmt.Errorf("invalid raw origin %q: want a single scheme://host[:port], such as https://raw.example.com", value)
+	}
+	return url.Parse(origins[0])
+}
+
+// RefuseHost returns a middleware answering 404 to requests for host. It
+// keeps the app off the raw origin, where pages uploaded by anyone run
+// scripts.
+func RefuseHost(host string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.EqualFold(r.Host, host) {
+				http.NotFound(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	handler := SecurityHeaders(time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/raw/page.html" {
+			w.Header().Set("Content-Security-Policy", "sandbox")
+		}
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	h := w.Header()
+	for name, want := range map[string]string{
+		"X-Content-Type-Options":    "nosniff",
+		"X-Frame-Options":           "SAMEORIGIN",
+		"Referrer-Policy":           "same-origin",
+		"Content-Security-Policy":   uiContentSecurityPolicy,
+		"Strict-Transport-Security": "",
+	} {
+		if got := h.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/raw/page.html", nil)
+	req.TLS = &tls.ConnectionState{}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if got := w.Header().Get("Strict-Transport-Security"); got != "max-age=3600" {
+		t.Errorf("HSTS over TLS = %q, want max-age=3600", got)
+	}
+	if got := w.Header().Get("Content-Security-Policy"); got != "sandbox" {
+		t.Errorf("handler CSP = %q, want it to replace the UI policy", got)
+	}
+
+	w = httptest.NewRecorder()
+	SecurityHeaders(0)(http.NotFoundHandler()).ServeHTTP(w, req)
+	if got := w.Header().Get("Strict-Transport-Security"); got != "" {
+		t.Errorf("HSTS with max age 0 = %q, want none", got)
+	}
+}
+
+func TestRefuseHost(t *testing.T) {
+	handler := RefuseHost("raw.files.lan")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for host, want := range map[string]int{
+		"files.lan":     http.StatusOK,
+		"RAW.files.lan": http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://"+host+"/clipboard", nil))
+		if w.Code != want {
+			t.Errorf("%s: got %d, want %d", host, w.Code, want)
+		}
+	}
+}
+
+func TestParseRawOrigin(t *testing.T) {
+	u, err := ParseRawOrigin("https://Raw.Files.lan:8443/")
+	if err != nil || u.String() != "https://raw.files.lan:8443" {
+		t.Errorf("ParseRawOrigin = %v, %v", u, err)
+	}
+	for _, bad := range []string{"*", "https://a.lan,https://b.lan", "raw.files.lan", ""} {
+		if _, err := ParseRawOrigin(bad); err == nil {
+			t.Errorf("ParseRawOrigin(%q) succeeded", bad)
+		}
+	}
+}
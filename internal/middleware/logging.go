@@ -1,7 +1,9 @@
 package middleware
 
 import (
+	"bufio"
 	"log"
+	"net"
 	"net/http"
 	"time"
 )
@@ -36,6 +38,29 @@ func (w statusWriterFlusher) Flush() {
 	}
 }
 
+// SetWriteDeadline forwards to the underlying writer so long-lived streams
+// (SSE) can keep extending their write deadline through the wrapper.
+func (w statusWriterFlusher) SetWriteDeadline(t time.Time) error {
+	if d, ok := w.ResponseWriter.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		return d.SetWriteDeadline(t)
+	}
+	return http.ErrNotSupported
+}
+
+// Hijack forwards to the underlying writer so WebSocket upgrades work through
+// the wrapper. A hijacked request is recorded as 101 Switching Protocols.
+func (w statusWriterFlusher) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
 // AccessLog returns a middleware that logs details of each request.
 // If quiet is true, it does not log anything.
 func AccessLog(quiet bool) func(http.Handler) http.Handler {
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/wanetty/upgopher/internal/metrics"
+)
+
+// countingBody counts the bytes the handler reads from the request body.
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// Metrics returns a middleware that records status, latency and body sizes
+// of every request under the given route label. The route should be the
+// registered pattern, never the raw URL, to keep label cardinality bounded.
+func Metrics(reg *metrics.Registry, route string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			base := &statusWriter{ResponseWriter: w}
+			var sw http.ResponseWriter = base
+			if _, ok := w.(http.Flusher); ok {
+				sw = statusWriterFlusher{base}
+			}
+
+			body := &countingBody{ReadCloser: r.Body}
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = body
+			}
+
+			next.ServeHTTP(sw, r)
+
+			if base.status == 0 {
+				base.status = http.StatusOK
+			}
+			reg.ObserveRequest(route, r.Method, base.status, time.Since(start), body.n, int64(base.length))
+		})
+	}
+}
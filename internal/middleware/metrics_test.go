@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wanetty/upgopher/internal/metrics"
+)
+
+func TestMetricsRecordsStatusAndBytes(t *testing.T) {
+	reg := metrics.NewRegistry()
+	handler := Metrics(reg, "/upload")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("ok"))
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/upload?path=secret", strings.NewReader("hello world"))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	var buf bytes.Buffer
+	reg.WriteTo(&buf)
+	out := buf.String()
+	for _, want := range []string{
+		`upgopher_http_requests_total{route="/upload",method="POST",code="201"} 1`,
+		`upgopher_http_request_bytes_total{route="/upload"} 11`,
+		`upgopher_http_response_bytes_total{route="/upload"} 2`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "secret") {
+		t.Error("raw query string leaked into metric labels")
+	}
+}
+
+func TestMetricsPassesHijackThrough(t *testing.T) {
+	reg := metrics.NewRegistry()
+	srv := httptest.NewServer(Metrics(reg, "/ws")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hj, ok := w.(http.Hijacker)
+		if !ok {
+			http.Error(w, "no hijacker", http.StatusInternalServerError)
+			return
+		}
+		conn, rw, err := hj.Hijack()
+		if err != nil {
+			return
+		}
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+		rw.Flush()
+		conn.Close()
+	})))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("got %d, want 101", resp.StatusCode)
+	}
+
+	// The handler records the request after it returns, which may be after
+	// the client has already read the response.
+	want := `upgopher_http_requests_total{route="/ws",method="GET",code="101"} 1`
+	var buf bytes.Buffer
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		buf.Reset()
+		reg.WriteTo(&buf)
+		if strings.Contains(buf.String(), want) {
+			return
+		}
+	}
+	t.Errorf("output missing %q\n%s", want, buf.String())
+}
@@ -7,16 +7,32 @@ import (
 
 // ApplyBasicAuth wraps a handler with basic authentication
 func ApplyBasicAuth(handler http.HandlerFunc, user, pass string) http.HandlerFunc {
+	return ApplyBasicAuthLimited(handler, user, pass, nil)
+}
+
+// ApplyBasicAuthLimited wraps a handler with basic authentication, refusing
+// clients that failed too often under the limiter's auth-failure policy.
+func ApplyBasicAuthLimited(handler http.HandlerFunc, user, pass string, limiter *RateLimiter) http.HandlerFunc {
 	userByte := []byte(user)
 	passByte := []byte(pass)
-	return basicAuth(handler, userByte, passByte)
+	return basicAuth(handler, userByte, passByte, limiter)
 }
 
 // basicAuth performs constant-time authentication check to prevent timing attacks
-func basicAuth(handler http.HandlerFunc, username, password []byte) http.HandlerFunc {
+func basicAuth(handler http.HandlerFunc, username, password []byte, limiter *RateLimiter) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		client := ClientIP(r)
+		if res := limiter.Peek(PolicyAuthFailure, client); !res.Allowed {
+			res.SetHeaders(w)
+			http.Error(w, "Too many failed login attempts.", http.StatusTooManyRequests)
+			return
+		}
 		user, pass, ok := r.BasicAuth()
 		if !ok || subtle.ConstantTimeCompare([]byte(user), username) != 1 || subtle.ConstantTimeCompare([]byte(pass), password) != 1 {
+			if ok {
+				// a browser's first request carries no credentials; only wrong ones count
+				limiter.Allow(PolicyAuthFailure, client)
+			}
 			w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
 			w.WriteHeader(http.StatusUnauthorized)
 			w.Write([]byte("Unauthorized.\n"))
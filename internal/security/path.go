@@ -1,6 +1,8 @@
 package security
 
 import (
+	"fmt"
+	"os"
 	"path/filepath"
 	"strings"
 )
@@ -22,3 +24,104 @@ func IsSafePath(baseDir, userPath string) (bool, error) {
 	return absUserPath == absBaseDir ||
 		strings.HasPrefix(absUserPath, absBaseDir+string(filepath.Separator)), nil
 }
+
+// SymlinkPolicy decides which symbolic links inside the shared directory
+// may be followed.
+type SymlinkPolicy int
+
+const (
+	// SymlinksWithinRoot follows links whose target, once every link on the
+	// way is resolved, is still inside the shared directory.
+	SymlinksWithinRoot SymlinkPolicy = iota
+	// SymlinksDeny refuses any path going through a link.
+	SymlinksDeny
+	// SymlinksFollowAll follows every link, wherever it points.
+	SymlinksFollowAll
+)
+
+// ParseSymlinkPolicy parses a -symlinks value: deny, follow-within-root or
+// follow-all.
+func ParseSymlinkPolicy(s string) (SymlinkPolicy, error) {
+	for _, p := range []SymlinkPolicy{SymlinksDeny, SymlinksWithinRoot, SymlinksFollowAll} {
+		if s == p.String() {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("symlink policy %q: want deny, follow-within-root or follow-all", s)
+}
+
+func (p SymlinkPolicy) String() string {
+	switch p {
+	case SymlinksDeny:
+		return "deny"
+	case SymlinksFollowAll:
+		return "follow-all"
+	default:
+		return "follow-within-root"
+	}
+}
+
+// IsSafePath is like the package function IsSafePath, then also applies p
+// to the links on the way from baseDir to userPath. Parts of userPath that
+// don't exist yet, such as an upload target, are checked lexically.
+// Link loops and links whose targets can't be resolved are refused.
+func (p SymlinkPolicy) IsSafePath(baseDir, userPath string) (bool, error) {
+	if ok, err := IsSafePath(baseDir, userPath); err != nil || !ok || p == SymlinksFollowAll {
+		return ok, err
+	}
+	absBaseDir, err := filepath.Abs(baseDir)
+	if err != nil {
+		return false, err
+	}
+	absUserPath, err := filepath.Abs(userPath)
+	if err != nil {
+		return false, err
+	}
+	rel, err := filepath.Rel(absBaseDir, absUserPath)
+	if err != nil {
+		return false, err
+	}
+
+	if p == SymlinksDeny {
+		current := absBaseDir
+		for _, part := range strings.Split(rel, string(filepath.Separator)) {
+			if part == "." {
+				continue
+			}
+			current = filepath.Join(current, part)
+			info, err := os.Lstat(current)
+			if os.IsNotExist(err) {
+				return true, nil
+			}
+			if err != nil {
+				return false, err
+			}
+			if info.Mode()&os.ModeSymlink != 0 {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+
+	// Resolve the deepest part of userPath that exists, and check that it
+	// lands inside the resolved base directory.
+	realBase, err := filepath.EvalSymlinks(absBaseDir)
+	if err != nil {
+		return false, err
+	}
+	existing := absUserPath
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return false, err
+		}
+		existing = filepath.Dir(existing)
+	}
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		// Dangling links and loops (too many levels of links) end up here.
+		return false, nil
+	}
+	return IsSafePath(realBase, resolved)
+}
@@ -0,0 +1,114 @@
+package security
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// symlinkTree builds a shared directory with links in it, next to an
+// outside directory they may point at:
+//
+//	root/docs/a.txt
+//	root/inside -> docs
+//	root/nested -> inside (a link to a link)
+//	root/escape -> ../outside
+//	root/hop -> escape (a link to a link leading out)
+//	root/docs/up -> .. (a loop back to root)
+//	root/loop1 -> loop2, root/loop2 -> loop1
+//	root/dangling -> missing
+func symlinkTree(t *testing.T) string {
+	t.Helper()
+	base := t.TempDir()
+	root := filepath.Join(base, "root")
+	for _, dir := range []string{filepath.Join(root, "docs"), filepath.Join(base, "outside")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "docs", "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(base, "outside", "secret.txt"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{
+		"inside":   "docs",
+		"nested":   "inside",
+		"escape":   "../outside",
+		"hop":      "escape",
+		"docs/up":  "..",
+		"loop1":    "loop2",
+		"loop2":    "loop1",
+		"dangling": "missing",
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Skipf("symlinks not supported: %v", err)
+		}
+	}
+	return root
+}
+
+func TestSymlinkPolicies(t *testing.T) {
+	root := symlinkTree(t)
+	tests := []struct {
+		path                        string
+		deny, withinRoot, followAll bool
+	}{
+		{"docs/a.txt", true, true, true},
+		{"docs/new.txt", true, true, true},
+		{"inside/a.txt", false, true, true},
+		{"nested/a.txt", false, true, true},
+		{"docs/up/docs/a.txt", false, true, true},
+		{"escape/secret.txt", false, false, true},
+		{"hop/secret.txt", false, false, true},
+		{"escape/new.txt", false, false, true},
+		{"loop1", false, false, true},
+		{"loop1/x", false, false, true},
+		{"dangling", false, false, true},
+		{"../outside/secret.txt", false, false, false},
+	}
+	for _, tt := range tests {
+		for _, c := range []struct {
+			policy SymlinkPolicy
+			want   bool
+		}{
+			{SymlinksDeny, tt.deny},
+			{SymlinksWithinRoot, tt.withinRoot},
+			{SymlinksFollowAll, tt.followAll},
+		} {
+			ok, _ := c.policy.IsSafePath(root, filepath.Join(root, tt.path))
+			if ok != c.want {
+				t.Errorf("%s: %s allowed = %v, want %v", c.policy, tt.path, ok, c.want)
+			}
+		}
+	}
+}
+
+func TestSymlinkPolicyRootIsLink(t *testing.T) {
+	root := symlinkTree(t)
+	link := filepath.Join(t.TempDir(), "share")
+	if err := os.Symlink(root, link); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	for _, p := range []SymlinkPolicy{SymlinksDeny, SymlinksWithinRoot} {
+		if ok, err := p.IsSafePath(link, filepath.Join(link, "docs", "a.txt")); !ok || err != nil {
+			t.Errorf("%s: file under a linked root refused: %v", p, err)
+		}
+	}
+	if ok, _ := SymlinksWithinRoot.IsSafePath(link, filepath.Join(link, "escape")); ok {
+		t.Error("escaping link under a linked root allowed")
+	}
+}
+
+func TestParseSymlinkPolicy(t *testing.T) {
+	for _, p := range []SymlinkPolicy{SymlinksDeny, SymlinksWithinRoot, SymlinksFollowAll} {
+		if got, err := ParseSymlinkPolicy(p.String()); err != nil || got != p {
+			t.Errorf("ParseSymlinkPolicy(%q) = %v, %v", p.String(), got, err)
+		}
+	}
+	if _, err := ParseSymlinkPolicy("follow"); err == nil {
+		t.Error("ParseSymlinkPolicy accepted an unknown policy")
+	}
+}
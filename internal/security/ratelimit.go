@@ -1,38 +1,272 @@
 package security
 
 import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
-// RateLimiter manages rate limiting for clipboard endpoint
-var RateLimiter sync.Map // map[string][]time.Time - IP -> request timestamps
+// Rate limit policies. Each one keeps a separate bucket per client, so
+// exhausting one kind of request doesn't block the others.
+const (
+	PolicyClipboardWrite   = "clipboard-write"   // clipboard, history and attachment changes
+	PolicyScreenshotUpload = "screenshot-upload" // screenshot uploads
+	PolicyUpload           = "upload"            // file uploads
+	PolicyAuthFailure      = "auth-failure"      // failed basic auth attempts
+	PolicyCollabOp         = "collab-op"         // live edits over the clipboard WebSocket
+)
+
+// RateLimitPolicy is a token bucket: a client may make Burst requests at
+// once, and Limit more become available every Period. A zero Limit disables
+// the policy.
+type RateLimitPolicy struct {
+	Limit  int
+	Period time.Duration
+	Burst  int
+}
+
+// DefaultRateLimitPolicies returns the policies used unless configured
+// otherwise.
+func DefaultRateLimitPolicies() map[string]RateLimitPolicy {
+	return map[string]RateLimitPolicy{
+		PolicyClipboardWrite:   {Limit: 20, Period: time.Minute, Burst: 20},
+		PolicyScreenshotUpload: {Limit: 20, Period: time.Minute, Burst: 20},
+		PolicyUpload:           {Limit: 60, Period: time.Minute, Burst: 30},
+		PolicyAuthFailure:      {Limit: 10, Period: time.Minute, Burst: 10},
+		PolicyCollabOp:         {Limit: 600, Period: time.Minute, Burst: 120},
+	}
+}
+
+func (p RateLimitPolicy) String() string {
+	if p.Limit == 0 {
+		return "off"
+	}
+	return fmt.Sprintf("%d/%s,burst=%d", p.Limit, p.Period, p.Burst)
+}
+
+// rate returns how many tokens the policy adds per second.
+func (p RateLimitPolicy) rate() float64 {
+	return float64(p.Limit) / p.Period.Seconds()
+}
+
+// ParseRateLimitPolicy parses a -rate-limit value: "name=limit/period",
+// optionally followed by ",burst=n", or "name=off". The burst defaults to
+// the limit.
+func ParseRateLimitPolicy(s string) (string, RateLimitPolicy, error) {
+	name, spec, ok := strings.Cut(s, "=")
+	name = strings.TrimSpace(name)
+	if _, known := DefaultRateLimitPolicies()[name]; !ok || !known {
+		return "", RateLimitPolicy{}, fmt.Errorf("rate limit %q: want <policy>=<limit>/<period>[,burst=<n>] with policy one of %s, %s, %s, %s or %s",
+			s, PolicyClipboardWrite, PolicyScreenshotUpload, PolicyUpload, PolicyAuthFailure, PolicyCollabOp)
+	}
+	spec = strings.TrimSpace(spec)
+	if spec == "off" {
+		return name, RateLimitPolicy{}, nil
+	}
+
+	spec, burst, hasBurst := strings.Cut(spec, ",")
+	limit, period, ok := strings.Cut(spec, "/")
+	if !ok {
+		return "", RateLimitPolicy{}, fmt.Errorf("rate limit %q: missing /<period>", s)
+	}
+	var p RateLimitPolicy
+	var err error
+	if p.Limit, err = strconv.Atoi(strings.TrimSpace(limit)); err != nil || p.Limit < 1 {
+		return "", RateLimitPolicy{}, fmt.Errorf("rate limit %q: limit must be a positive integer", s)
+	}
+	if p.Period, err = time.ParseDuration(strings.TrimSpace(period)); err != nil || p.Period <= 0 {
+		return "", RateLimitPolicy{}, fmt.Errorf("rate limit %q: period must be a positive duration such as 1m", s)
+	}
+	p.Burst = p.Limit
+	if hasBurst {
+		burst = strings.TrimSpace(burst)
+		ok := strings.HasPrefix(burst, "burst=")
+		if p.Burst, err = strconv.Atoi(strings.TrimPrefix(burst, "burst=")); !ok || err != nil || p.Burst < 1 {
+			return "", RateLimitPolicy{}, fmt.Errorf("rate limit %q: burst must be burst=<positive integer>", s)
+		}
+	}
+	return name, p, nil
+}
+
+// bucket holds the tokens a client has left under one policy, as of last.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter applies token-bucket policies per client. A nil RateLimiter
+// allows every request.
+type RateLimiter struct {
+	policies map[string]RateLimitPolicy
+	mu       sync.Mutex
+	buckets  map[string]*bucket // keyed by policy and client
+	now      func() time.Time
+}
+
+// NewRateLimiter returns a limiter using the default policies, replaced by
+// those in policies.
+func NewRateLimiter(policies map[string]RateLimitPolicy) *RateLimiter {
+	l := &RateLimiter{
+		policies: DefaultRateLimitPolicies(),
+		buckets:  make(map[string]*bucket),
+		now:      time.Now,
+	}
+	for name, p := range policies {
+		l.policies[name] = p
+	}
+	return l
+}
 
-// CheckRateLimit checks if the IP has exceeded rate limit (20 requests per minute)
-func CheckRateLimit(ip string) bool {
-	now := time.Now()
-	oneMinuteAgo := now.Add(-time.Minute)
+// RateLimitResult describes a client's bucket after a request.
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int           // bucket size; 0 when the policy is disabled
+	Remaining  int           // requests that may be made right away
+	Reset      time.Duration // until the bucket is full again
+	RetryAfter time.Duration // until the next request is allowed; 0 when allowed
+}
+
+// Allow takes a token from client's bucket under policy, if it has one.
+func (l *RateLimiter) Allow(policy, client string) RateLimitResult {
+	return l.take(policy, client, 1)
+}
+
+// Peek reports whether client could make a request under policy without
+// taking a token.
+func (l *RateLimiter) Peek(policy, client string) RateLimitResult {
+	return l.take(policy, client, 0)
+}
 
-	// Get or create timestamp list for this IP
-	value, _ := RateLimiter.LoadOrStore(ip, []time.Time{})
-	timestamps := value.([]time.Time)
+func (l *RateLimiter) take(policy, client string, n float64) RateLimitResult {
+	if l == nil {
+		return RateLimitResult{Allowed: true}
+	}
+	p, ok := l.policies[policy]
+	if !ok || p.Limit == 0 {
+		return RateLimitResult{Allowed: true}
+	}
+	rate, burst := p.rate(), float64(p.Burst)
 
-	// Filter out timestamps older than 1 minute
-	var recentRequests []time.Time
-	for _, ts := range timestamps {
-		if ts.After(oneMinuteAgo) {
-			recentRequests = append(recentRequests, ts)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	key := policy + " " + client
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	res := RateLimitResult{Limit: p.Burst}
+	need := math.Max(n, 1) // a peek asks whether a request would pass
+	if b.tokens >= need {
+		res.Allowed = true
+		b.tokens -= n
+	} else {
+		res.RetryAfter = seconds((need - b.tokens) / rate)
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = seconds((burst - b.tokens) / rate)
+	return res
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// ceilSeconds rounds d up to whole seconds, so clients never retry early.
+func ceilSeconds(d time.Duration) int64 {
+	return int64((d + time.Second - 1) / time.Second)
+}
+
+// SetHeaders writes the RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers, and Retry-After when the request was refused.
+func (res RateLimitResult) SetHeaders(w http.ResponseWriter) {
+	if res.Limit == 0 {
+		return
+	}
+	h := w.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", strconv.FormatInt(ceilSeconds(res.Reset), 10))
+	if !res.Allowed {
+		h.Set("Retry-After", strconv.FormatInt(ceilSeconds(res.RetryAfter), 10))
+	}
+}
+
+// AllowHTTP applies policy to client and sets the rate limit headers. When
+// the request is refused it writes a 429 response and returns false.
+func (l *RateLimiter) AllowHTTP(w http.ResponseWriter, policy, client string) bool {
+	res := l.Allow(policy, client)
+	res.SetHeaders(w)
+	if !res.Allowed {
+		http.Error(w, fmt.Sprintf("Rate limit exceeded. Try again in %d seconds.", ceilSeconds(res.RetryAfter)), http.StatusTooManyRequests)
+	}
+	return res.Allowed
+}
+
+// Evict forgets the clients whose buckets have refilled, which behave like
+// new ones, and returns how many were removed.
+func (l *RateLimiter) Evict() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	removed := 0
+	for key, b := range l.buckets {
+		policy, _, _ := strings.Cut(key, " ")
+		p := l.policies[policy]
+		if p.Limit == 0 || b.tokens+now.Sub(b.last).Seconds()*p.rate() >= float64(p.Burst) {
+			delete(l.buckets, key)
+			removed++
 		}
 	}
+	return removed
+}
 
-	// Check if rate limit exceeded
-	if len(recentRequests) >= 20 {
-		return false
+// Len returns how many client buckets are tracked.
+func (l *RateLimiter) Len() int {
+	if l == nil {
+		return 0
 	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
 
-	// Add current request timestamp
-	recentRequests = append(recentRequests, now)
-	RateLimiter.Store(ip, recentRequests)
+// StartEviction calls Evict every interval until the returned stop function
+// is called.
+func (l *RateLimiter) StartEviction(interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				l.Evict()
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
 
-	return true
+// ClientIP returns the IP address of the client that sent r.
+func ClientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
 }
@@ -0,0 +1,152 @@
+package security
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeClock returns a limiter with policies whose time only moves when the
+// returned advance function is called.
+func fakeClock(policies map[string]RateLimitPolicy) (*RateLimiter, func(time.Duration)) {
+	l := NewRateLimiter(policies)
+	now := time.Unix(1700000000, 0)
+	l.now = func() time.Time { return now }
+	return l, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestRateLimiterTokenBucket(t *testing.T) {
+	l, advance := fakeClock(map[string]RateLimitPolicy{
+		PolicyUpload: {Limit: 6, Period: time.Minute, Burst: 3},
+	})
+
+	for i := 0; i < 3; i++ {
+		if res := l.Allow(PolicyUpload, "10.0.0.1"); !res.Allowed || res.Remaining != 2-i {
+			t.Fatalf("request %d = %+v, want allowed with %d remaining", i, res, 2-i)
+		}
+	}
+	res := l.Allow(PolicyUpload, "10.0.0.1")
+	if res.Allowed || res.RetryAfter != 10*time.Second || res.Reset != 30*time.Second {
+		t.Fatalf("request over the burst = %+v, want refused for 10s", res)
+	}
+	if !l.Allow(PolicyUpload, "10.0.0.2").Allowed {
+		t.Error("another client shares the bucket")
+	}
+	if !l.Allow(PolicyClipboardWrite, "10.0.0.1").Allowed {
+		t.Error("another policy shares the bucket")
+	}
+
+	advance(10 * time.Second) // one token back
+	if !l.Allow(PolicyUpload, "10.0.0.1").Allowed {
+		t.Error("token not refilled after 10s")
+	}
+	if l.Allow(PolicyUpload, "10.0.0.1").Allowed {
+		t.Error("more than one token refilled after 10s")
+	}
+	advance(time.Hour)
+	if res := l.Peek(PolicyUpload, "10.0.0.1"); res.Remaining != 3 {
+		t.Errorf("bucket after an hour = %+v, want capped at the burst", res)
+	}
+}
+
+func TestRateLimiterPeekAndDisabled(t *testing.T) {
+	l, _ := fakeClock(map[string]RateLimitPolicy{
+		PolicyAuthFailure: {Limit: 1, Period: time.Minute, Burst: 1},
+		PolicyUpload:      {},
+	})
+	for i := 0; i < 3; i++ {
+		if !l.Peek(PolicyAuthFailure, "c").Allowed {
+			t.Fatal("peek took a token")
+		}
+	}
+	l.Allow(PolicyAuthFailure, "c")
+	if l.Peek(PolicyAuthFailure, "c").Allowed {
+		t.Error("peek allowed an empty bucket")
+	}
+
+	for i := 0; i < 100; i++ {
+		if res := l.Allow(PolicyUpload, "c"); !res.Allowed || res.Limit != 0 {
+			t.Fatalf("disabled policy = %+v", res)
+		}
+	}
+	var nilLimiter *RateLimiter
+	if !nilLimiter.Allow(PolicyUpload, "c").Allowed {
+		t.Error("nil limiter refused a request")
+	}
+}
+
+func TestRateLimiterEvict(t *testing.T) {
+	l, advance := fakeClock(map[string]RateLimitPolicy{
+		PolicyUpload: {Limit: 2, Period: time.Minute, Burst: 2},
+	})
+	l.Allow(PolicyUpload, "idle")
+	advance(20 * time.Second)
+	l.Allow(PolicyUpload, "busy")
+	l.Allow(PolicyUpload, "busy")
+
+	advance(10 * time.Second) // idle is full again, busy is not
+	if n := l.Evict(); n != 1 || l.Len() != 1 {
+		t.Fatalf("evicted %d, %d left; want 1 and 1", n, l.Len())
+	}
+	if l.Peek(PolicyUpload, "busy").Remaining != 0 {
+		t.Error("evicted a client that still owes tokens")
+	}
+	advance(time.Minute)
+	if n := l.Evict(); n != 1 || l.Len() != 0 {
+		t.Errorf("evicted %d, %d left; want 1 and 0", n, l.Len())
+	}
+}
+
+func TestRateLimiterHeaders(t *testing.T) {
+	l, _ := fakeClock(map[string]RateLimitPolicy{
+		PolicyClipboardWrite: {Limit: 1, Period: 90 * time.Second, Burst: 1},
+	})
+	w := httptest.NewRecorder()
+	if !l.AllowHTTP(w, PolicyClipboardWrite, "c") {
+		t.Fatal("first request refused")
+	}
+	if got := w.Header().Get("RateLimit-Remaining"); got != "0" || w.Header().Get("Retry-After") != "" {
+		t.Errorf("allowed headers = %v", w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	if l.AllowHTTP(w, PolicyClipboardWrite, "c") {
+		t.Fatal("second request allowed")
+	}
+	h := w.Header()
+	if w.Code != http.StatusTooManyRequests || h.Get("Retry-After") != "90" || h.Get("RateLimit-Limit") != "1" || h.Get("RateLimit-Reset") != "90" {
+		t.Errorf("refused response = %d %v", w.Code, h)
+	}
+}
+
+func TestBasicAuthLimitsFailures(t *testing.T) {
+	l, _ := fakeClock(map[string]RateLimitPolicy{
+		PolicyAuthFailure: {Limit: 2, Period: time.Minute, Burst: 2},
+	})
+	handler := ApplyBasicAuthLimited(func(w http.ResponseWriter, r *http.Request) {}, "admin", "secret", l)
+	request := func(user, pass string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "192.0.2.7:4000"
+		if user != "" {
+			req.SetBasicAuth(user, pass)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w.Code
+	}
+
+	for i := 0; i < 5; i++ {
+		if code := request("", ""); code != http.StatusUnauthorized {
+			t.Fatalf("request without credentials: %d, want 401", code)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if code := request("admin", "guess"); code != http.StatusUnauthorized {
+			t.Fatalf("wrong password %d: %d, want 401", i, code)
+		}
+	}
+	if code := request("admin", "secret"); code != http.StatusTooManyRequests {
+		t.Errorf("right password after too many failures: %d, want 429", code)
+	}
+}
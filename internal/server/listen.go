@@ -0,0 +1,108 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Listener describes a single address the server accepts connections on.
+type Listener struct {
+	Network string // "tcp" or "unix"
+	Address string // host:port for tcp, socket path for unix
+	TLS     bool
+}
+
+// String returns the listener in the same form accepted by ParseListener.
+func (l Listener) String() string {
+	scheme := "http://"
+	if l.TLS {
+		scheme = "https://"
+	}
+	if l.Network == "unix" {
+		return scheme + "unix:" + l.Address
+	}
+	return scheme + l.Address
+}
+
+// Port returns the TCP port of the listener, or "" for Unix sockets.
+func (l Listener) Port() string {
+	if l.Network != "tcp" {
+		return ""
+	}
+	_, port, _ := net.SplitHostPort(l.Address)
+	return port
+}
+
+// ParseListener parses a -listen entry. Accepted forms are host:port,
+// [ipv6]:port, :port and unix:/path/to.sock, optionally prefixed with
+// http:// or https:// to choose the protocol. Entries without a scheme use
+// HTTPS when defaultTLS is true.
+func ParseListener(spec string, defaultTLS bool) (Listener, error) {
+	l := Listener{Network: "tcp", TLS: defaultTLS}
+	addr := strings.TrimSpace(spec)
+
+	switch {
+	case strings.HasPrefix(addr, "https://"):
+		l.TLS = true
+		addr = strings.TrimPrefix(addr, "https://")
+	case strings.HasPrefix(addr, "http://"):
+		l.TLS = false
+		addr = strings.TrimPrefix(addr, "http://")
+	}
+
+	if strings.HasPrefix(addr, "unix:") {
+		path := strings.TrimPrefix(addr, "unix:")
+		if path == "" {
+			return l, fmt.Errorf("invalid listen address %q: missing socket path", spec)
+		}
+		l.Network = "unix"
+		l.Address = path
+		return l, nil
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return l, fmt.Errorf("invalid listen address %q: %v", spec, err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return l, fmt.Errorf("invalid listen address %q: bad port %q", spec, port)
+	}
+	l.Address = net.JoinHostPort(host, port)
+	return l, nil
+}
+
+// Listen opens the network listener. A stale Unix socket left behind by a
+// previous run is removed first; any other existing file is left untouched
+// and makes the listen fail.
+func (l Listener) Listen() (net.Listener, error) {
+	if l.Network == "unix" {
+		if info, err := os.Lstat(l.Address); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(l.Address)
+		}
+	}
+	return net.Listen(l.Network, l.Address)
+}
+
+// HTTPSRedirect returns a handler that permanently redirects every request to
+// the same host, path and query over HTTPS. The port is omitted when it is 443.
+func HTTPSRedirect(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		} else if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+			host = host[1 : len(host)-1] // an IPv6 address without a port
+		}
+		if httpsPort != "" && httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+}
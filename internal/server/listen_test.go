@@ -0,0 +1,98 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseListener(t *testing.T) {
+	tests := []struct {
+		name       string
+		spec       string
+		defaultTLS bool
+		want       Listener
+		wantErr    bool
+	}{
+		{name: "ipv4", spec: "127.0.0.1:9090", want: Listener{Network: "tcp", Address: "127.0.0.1:9090"}},
+		{name: "all interfaces", spec: ":8080", want: Listener{Network: "tcp", Address: ":8080"}},
+		{name: "ipv6", spec: "[::1]:9090", want: Listener{Network: "tcp", Address: "[::1]:9090"}},
+		{name: "default tls", spec: "0.0.0.0:443", defaultTLS: true, want: Listener{Network: "tcp", Address: "0.0.0.0:443", TLS: true}},
+		{name: "explicit https", spec: "https://[::]:8443", want: Listener{Network: "tcp", Address: "[::]:8443", TLS: true}},
+		{name: "explicit http overrides ssl", spec: "http://0.0.0.0:80", defaultTLS: true, want: Listener{Network: "tcp", Address: "0.0.0.0:80"}},
+		{name: "unix socket", spec: "unix:/run/upgopher.sock", want: Listener{Network: "unix", Address: "/run/upgopher.sock"}},
+		{name: "unix socket over tls", spec: "https://unix:/run/upgopher.sock", want: Listener{Network: "unix", Address: "/run/upgopher.sock", TLS: true}},
+		{name: "missing port", spec: "127.0.0.1", wantErr: true},
+		{name: "bad port", spec: "127.0.0.1:http", wantErr: true},
+		{name: "port out of range", spec: ":70000", wantErr: true},
+		{name: "unix without path", spec: "unix:", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseListener(tt.spec, tt.defaultTLS)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseListener(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ParseListener(%q) = %+v, want %+v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListenerUnixSocketReplacesStaleSocket(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "upgopher.sock")
+	l := Listener{Network: "unix", Address: sock}
+
+	first, err := l.Listen()
+	if err != nil {
+		t.Fatalf("first listen: %v", err)
+	}
+	// Simulate an unclean shutdown: the socket file stays on disk.
+	if ul, ok := first.(interface{ SetUnlinkOnClose(bool) }); ok {
+		ul.SetUnlinkOnClose(false)
+	}
+	first.Close()
+
+	second, err := l.Listen()
+	if err != nil {
+		t.Fatalf("listen over stale socket: %v", err)
+	}
+	second.Close()
+}
+
+func TestHTTPSRedirect(t *testing.T) {
+	tests := []struct {
+		name string
+		port string
+		host string
+		url  string
+		want string
+	}{
+		{name: "default port", port: "443", host: "files.lan:80", url: "/?path=Zm9v", want: "https://files.lan/?path=Zm9v"},
+		{name: "custom port", port: "8443", host: "files.lan:8080", url: "/raw/a.txt", want: "https://files.lan:8443/raw/a.txt"},
+		{name: "ipv6 host", port: "443", host: "[::1]:80", url: "/", want: "https://[::1]/"},
+		{name: "ipv6 host custom port", port: "8443", host: "[::1]:80", url: "/", want: "https://[::1]:8443/"},
+		{name: "ipv6 host without port", port: "443", host: "[::1]", url: "/", want: "https://[::1]/"},
+		{name: "ipv6 host without port custom port", port: "8443", host: "[::1]", url: "/", want: "https://[::1]:8443/"},
+		{name: "host without port", port: "443", host: "files.lan", url: "/", want: "https://files.lan/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			req.Host = tt.host
+			w := httptest.NewRecorder()
+			HTTPSRedirect(tt.port).ServeHTTP(w, req)
+
+			if w.Code != http.StatusMovedPermanently {
+				t.Fatalf("expected 301, got %d", w.Code)
+			}
+			if got := w.Header().Get("Location"); got != tt.want {
+				t.Errorf("Location = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
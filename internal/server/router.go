@@ -1,65 +1,242 @@
 package server
 
 import (
+	"crypto/tls"
 	"embed"
+	"fmt"
+	"math"
 	"net/http"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/wanetty/upgopher/internal/handlers"
+	"github.com/wanetty/upgopher/internal/metrics"
+	"github.com/wanetty/upgopher/internal/middleware"
 	"github.com/wanetty/upgopher/internal/security"
+	"github.com/wanetty/upgopher/internal/storage"
+	"github.com/wanetty/upgopher/internal/utils"
 )
 
-// SetupRoutes initializes all HTTP routes with optional authentication
-func SetupRoutes(
-	dir string,
-	user string,
-	pass string,
-	quiet bool,
-	disableHiddenFiles bool,
-	readOnly bool,
-	maxTabs int,
-	maxUploadSize int64,
-	showHiddenFiles *bool,
-	customPaths *map[string]string,
-	customPathsMutex *sync.RWMutex,
-	faviconFS *embed.FS,
-	logoFS *embed.FS,
-) {
-	fileHandlers := handlers.NewFileHandlers(dir, quiet, disableHiddenFiles, readOnly, maxUploadSize, showHiddenFiles, customPaths, customPathsMutex)
-	clipboardHandler := handlers.NewClipboardHandler(quiet, maxTabs)
-	customPathHandler := handlers.NewCustomPathHandler(dir, quiet, customPaths, customPathsMutex)
-	uiHandlers := handlers.NewUIHandlers(quiet, disableHiddenFiles, readOnly, showHiddenFiles, faviconFS, logoFS)
-
-	registerRoute("/", fileHandlers.List(), user, pass)
-	registerRoute("/download/", http.StripPrefix("/download/", fileHandlers.Download()), user, pass)
-	registerRoute("/delete/", http.StripPrefix("/delete/", fileHandlers.Delete()), user, pass)
-	registerRoute("/raw/", http.StripPrefix("/raw/", fileHandlers.Raw()), user, pass)
-	registerRoute("/zip", fileHandlers.Zip(), user, pass)
-	registerRoute("/zip-selected", fileHandlers.ZipSelected(), user, pass)
-	registerRoute("/file-content", fileHandlers.FileContent(), user, pass)
-	registerRoute("/search-file", fileHandlers.Search(), user, pass)
-	registerRoute("/api/v1/breadcrumbs", fileHandlers.Breadcrumbs(), user, pass)
-	registerRoute("/api/v1/tree", fileHandlers.Tree(), user, pass)
-	registerRoute("/clipboard/tabs", clipboardHandler.ListTabs(), user, pass)
-	registerRoute("/clipboard/stream", clipboardHandler.ClipboardStream(), user, pass)
-	registerRoute("/api/v1/screenshots/image", clipboardHandler.ScreenshotImage(), user, pass)
-	registerRoute("/api/v1/screenshots", clipboardHandler.Screenshots(), user, pass)
-	registerRoute("/screenshot/", http.StripPrefix("/screenshot/", clipboardHandler.ServeScreenshotDirect()), user, pass)
-	registerRoute("/clipboard", clipboardHandler.Handle(), user, pass)
-	registerRoute("/mkdir", fileHandlers.Mkdir(), user, pass)
-	registerRoute("/custom-path", customPathHandler.Handle(), user, pass)
-	registerRoute("/showhiddenfiles", uiHandlers.ToggleHiddenFiles(), user, pass)
-	registerRoute("/favicon.ico", uiHandlers.Favicon(), user, pass)
-	registerRoute("/static/logopher.webp", uiHandlers.Logo(), user, pass)
+// rateLimitEvictionInterval is how often the rate limiter forgets clients
+// whose buckets have refilled.
+const rateLimitEvictionInterval = time.Minute
+
+// Config is what SetupRoutes serves and how.
+type Config struct {
+	Dir      string          // directory of a local share, whose free space is reported
+	Share    storage.Storage // where files are served from
+	BasePath string          // normalized with NormalizeBasePath
+	User     string          // basic auth credentials; none when either is empty
+	Pass     string
+
+	Quiet              bool
+	DisableHiddenFiles bool
+	ReadOnly           bool
+	MaxUploadSize      int64  // bytes; 0 for no limit
+	MinFreeBytes       uint64 // free space /readyz requires; 0 for no check
+
+	MaxTabs          int
+	StateDir         string // where clipboard tabs and screenshots persist; "" to keep them in memory
+	HistoryLimit     int
+	HistoryMaxAge    time.Duration
+	ScreenshotLimits handlers.ScreenshotLimits
+	RateLimits       map[string]security.RateLimitPolicy // nil for the defaults
+
+	CORS       middleware.CORSConfig
+	HSTSMaxAge time.Duration
+	RawOrigin  string // separate origin serving /raw/; "" for none
+
+	EnableMetrics bool
+	TLSCert       *tls.Certificate // reported by /readyz; nil without TLS
+
+	CustomPaths      *map[string]string
+	CustomPathsMutex *sync.RWMutex
+	FaviconFS        *embed.FS
+	LogoFS           *embed.FS
+}
+
+// SetupRoutes initializes all HTTP routes described by cfg with optional
+// authentication. Every route is registered under cfg.BasePath. When
+// cfg.StateDir is not empty, clipboard tabs and screenshots are restored from
+// and persisted to it. An unreadable state or an invalid cfg.RawOrigin is
+// returned as an error.
+func SetupRoutes(cfg Config) error {
+	basePath, user, pass, share := cfg.BasePath, cfg.User, cfg.Pass, cfg.Share
+	var reg *metrics.Registry
+	if cfg.EnableMetrics {
+		reg = metrics.NewRegistry()
+	}
+
+	limiter := security.NewRateLimiter(cfg.RateLimits)
+	limiter.StartEviction(rateLimitEvictionInterval)
+	// Every response gets the security headers. Cross-origin requests are
+	// answered by CORS first, so preflights need no credentials, then refused
+	// by CSRF when they would change state. When raw files have an origin of
+	// their own, only /raw/ is served there.
+	headersMiddleware := middleware.SecurityHeaders(cfg.HSTSMaxAge)
+	corsMiddleware, csrfMiddleware := middleware.CORS(cfg.CORS), middleware.CSRF(cfg.CORS.Origins)
+	rawCrossOrigin := func(h http.Handler) http.Handler {
+		return headersMiddleware(corsMiddleware(csrfMiddleware(h)))
+	}
+	crossOrigin := rawCrossOrigin
+	rawOrigin := cfg.RawOrigin
+	if rawOrigin != "" {
+		u, err := middleware.ParseRawOrigin(rawOrigin)
+		if err != nil {
+			return err
+		}
+		rawOrigin = u.String()
+		refuseRawHost := middleware.RefuseHost(u.Host)
+		crossOrigin = func(h http.Handler) http.Handler { return rawCrossOrigin(refuseRawHost(h)) }
+	}
+
+	fileHandlers := handlers.NewFileHandlers(cfg.Dir, cfg.Quiet, cfg.DisableHiddenFiles, cfg.ReadOnly, cfg.MaxUploadSize, cfg.CustomPaths, cfg.CustomPathsMutex)
+	fileHandlers.Limiter = limiter
+	fileHandlers.BasePath = basePath
+	fileHandlers.RawOrigin = rawOrigin
+	fileHandlers.Storage = share
+	clipboardHandler := handlers.NewClipboardHandler(cfg.Quiet, cfg.MaxTabs)
+	clipboardHandler.HistoryLimit = cfg.HistoryLimit
+	clipboardHandler.HistoryMaxAge = cfg.HistoryMaxAge
+	clipboardHandler.ScreenshotLimits = cfg.ScreenshotLimits
+	clipboardHandler.Limiter = limiter
+	clipboardHandler.AllowedOrigins = cfg.CORS.Origins
+	if cfg.StateDir != "" {
+		if err := clipboardHandler.EnablePersistence(cfg.StateDir); err != nil {
+			return err
+		}
+	}
+	clipboardHandler.StartJanitor(handlers.JanitorInterval)
+	customPathHandler := handlers.NewCustomPathHandler(cfg.Dir, cfg.Quiet, cfg.CustomPaths, cfg.CustomPathsMutex)
+	customPathHandler.BasePath = basePath
+	uiHandlers := handlers.NewUIHandlers(cfg.Quiet, cfg.DisableHiddenFiles, cfg.ReadOnly, cfg.FaviconFS, cfg.LogoFS)
+	uiHandlers.BasePath = basePath
+	healthHandlers := handlers.NewHealthHandlers(share, cfg.ReadOnly, cfg.MinFreeBytes, cfg.TLSCert)
+
+	registerRoute(basePath+"/", fileHandlers.List(), user, pass, reg, limiter, crossOrigin)
+	registerRoute(basePath+"/download/", http.StripPrefix(basePath+"/download/", fileHandlers.Download()), user, pass, reg, limiter, crossOrigin)
+	registerRoute(basePath+"/delete/", http.StripPrefix(basePath+"/delete/", fileHandlers.Delete()), user, pass, reg, limiter, crossOrigin)
+	registerRoute(basePath+"/raw/", http.StripPrefix(basePath+"/raw/", fileHandlers.Raw()), user, pass, reg, limiter, rawCrossOrigin)
+	registerRoute(basePath+"/zip", fileHandlers.Zip(), user, pass, reg, limiter, crossOrigin)
+	registerRoute(basePath+"/zip-selected", fileHandlers.ZipSelected(), user, pass, reg, limiter, crossOrigin)
+	registerRoute(basePath+"/file-content", fileHandlers.FileContent(), user, pass, reg, limiter, crossOrigin)
+	registerRoute(basePath+"/search-file", fileHandlers.Search(), user, pass, reg, limiter, crossOrigin)
+	registerRoute(basePath+"/api/v1/breadcrumbs", fileHandlers.Breadcrumbs(), user, pass, reg, limiter, crossOrigin)
+	registerRoute(basePath+"/api/v1/tree", fileHandlers.Tree(), user, pass, reg, limiter, crossOrigin)
+	registerRoute(basePath+"/api/v1/files", fileHandlers.ListFiles(), user, pass, reg, limiter, crossOrigin)
+	registerRoute(basePath+"/clipboard/tabs", clipboardHandler.ListTabs(), user, pass, reg, limiter, crossOrigin)
+	registerRoute(basePath+"/clipboard/stream", clipboardHandler.ClipboardStream(), user, pass, reg, limiter, crossOrigin)
+	registerRoute(basePath+"/clipboard/ws", clipboardHandler.Collaborate(), user, pass, reg, limiter, crossOrigin)
+	registerRoute(basePath+"/clipboard/history", clipboardHandler.History(), user, pass, reg, limiter, crossOrigin)
+	registerRoute(basePath+"/clipboard/attachments/file", clipboardHandler.AttachmentFile(), user, pass, reg, limiter, crossOrigin)
+	registerRoute(basePath+"/clipboard/attachments", clipboardHandler.Attachments(), user, pass, reg, limiter, crossOrigin)
+	registerRoute(basePath+"/api/v1/screenshots/image", clipboardHandler.ScreenshotImage(), user, pass, reg, limiter, crossOrigin)
+	registerRoute(basePath+"/api/v1/screenshots/thumbnail", clipboardHandler.ScreenshotThumbnail(), user, pass, reg, limiter, crossOrigin)
+	registerRoute(basePath+"/api/v1/screenshots", clipboardHandler.Screenshots(), user, pass, reg, limiter, crossOrigin)
+	registerRoute(basePath+"/screenshot/", http.StripPrefix(basePath+"/screenshot/", clipboardHandler.ServeScreenshotDirect()), user, pass, reg, limiter, crossOrigin)
+	registerRoute(basePath+"/clipboard", clipboardHandler.Handle(), user, pass, reg, limiter, crossOrigin)
+	registerRoute(basePath+"/mkdir", fileHandlers.Mkdir(), user, pass, reg, limiter, crossOrigin)
+	registerRoute(basePath+"/custom-path", customPathHandler.Handle(), user, pass, reg, limiter, crossOrigin)
+	registerRoute(basePath+"/showhiddenfiles", uiHandlers.ToggleHiddenFiles(), user, pass, reg, limiter, crossOrigin)
+	registerRoute(basePath+"/favicon.ico", uiHandlers.Favicon(), user, pass, reg, limiter, crossOrigin)
+	registerRoute(basePath+"/static/logopher.webp", uiHandlers.Logo(), user, pass, reg, limiter, crossOrigin)
+	registerRoute(basePath+"/api/v1/openapi.json", uiHandlers.OpenAPI(), user, pass, reg, limiter, crossOrigin)
+
+	// Probes are registered without credentials so orchestrators can reach
+	// them even when basic auth protects the rest of the app.
+	registerRoute(basePath+"/healthz", healthHandlers.Healthz(), "", "", nil, nil, nil)
+	registerRoute(basePath+"/readyz", healthHandlers.Readyz(), "", "", nil, nil, nil)
+
+	if reg != nil {
+		registerClipboardGauges(reg, clipboardHandler)
+		if _, local := share.(*storage.Local); local {
+			registerDiskGauges(reg, cfg.Dir)
+		}
+		reg.GaugeFunc("upgopher_rate_limit_clients", "Clients tracked by the rate limiter.", func() float64 {
+			return float64(limiter.Len())
+		})
+		registerRoute(basePath+"/metrics", reg.Handler(), user, pass, nil, limiter, crossOrigin)
+	}
+	return nil
+}
+
+// registerClipboardGauges exposes clipboard, attachment and screenshot store usage.
+func registerClipboardGauges(reg *metrics.Registry, ch *handlers.ClipboardHandler) {
+	reg.GaugeFunc("upgopher_clipboard_tabs", "Number of shared clipboard tabs.", func() float64 {
+		return float64(ch.Stats().Tabs)
+	})
+	reg.GaugeFunc("upgopher_clipboard_sse_subscribers", "Active clipboard and screenshot SSE subscribers.", func() float64 {
+		return float64(ch.Stats().Subscribers)
+	})
+	reg.GaugeFunc("upgopher_clipboard_live_editors", "Clients editing clipboard tabs over WebSocket.", func() float64 {
+		return float64(ch.Stats().LiveEditors)
+	})
+	reg.GaugeFunc("upgopher_clipboard_attachment_bytes", "Bytes held in clipboard tab attachments.", func() float64 {
+		return float64(ch.Stats().AttachmentBytes)
+	})
+	reg.GaugeFunc("upgopher_screenshots", "Number of screenshots held in the screenshot store.", func() float64 {
+		return float64(ch.Stats().Screenshots)
+	})
+	reg.GaugeFunc("upgopher_screenshot_store_bytes", "Bytes held in the screenshot store.", func() float64 {
+		return float64(ch.Stats().ScreenshotBytes)
+	})
+}
+
+// registerDiskGauges exposes free and total space of the filesystem holding dir.
+// Values are NaN when the filesystem can't be queried.
+func registerDiskGauges(reg *metrics.Registry, dir string) {
+	reg.GaugeFunc("upgopher_disk_free_bytes", "Free space available on the shared directory filesystem.", func() float64 {
+		free, _, err := utils.DiskUsage(dir)
+		if err != nil {
+			return math.NaN()
+		}
+		return float64(free)
+	})
+	reg.GaugeFunc("upgopher_disk_total_bytes", "Total size of the shared directory filesystem.", func() float64 {
+		_, total, err := utils.DiskUsage(dir)
+		if err != nil {
+			return math.NaN()
+		}
+		return float64(total)
+	})
 }
 
-// registerRoute wraps handler with authentication if credentials are provided
-func registerRoute(pattern string, handler http.Handler, user string, pass string) {
+// NormalizeBasePath validates a -base-path value and returns it with a single
+// leading slash and no trailing slash. "" and "/" both mean the root.
+func NormalizeBasePath(basePath string) (string, error) {
+	basePath = strings.Trim(strings.TrimSpace(basePath), "/")
+	if basePath == "" {
+		return "", nil
+	}
+	for _, segment := range strings.Split(basePath, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return "", fmt.Errorf("invalid base path %q", basePath)
+		}
+		for _, c := range segment {
+			if !((c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '-' || c == '_' || c == '.') {
+				return "", fmt.Errorf("invalid base path %q: only letters, digits, '-', '_' and '.' are allowed", basePath)
+			}
+		}
+	}
+	return "/" + basePath, nil
+}
+
+// registerRoute wraps handler with authentication if credentials are provided,
+// limiting failed attempts with limiter, with request instrumentation when
+// reg is not nil, and with crossOrigin, outside authentication so preflight
+// requests are answered without credentials, when it is not nil.
+func registerRoute(pattern string, handler http.Handler, user string, pass string, reg *metrics.Registry, limiter *security.RateLimiter, crossOrigin func(http.Handler) http.Handler) {
+	if reg != nil {
+		handler = middleware.Metrics(reg, pattern)(handler)
+	}
 	if user != "" && pass != "" {
-		http.Handle(pattern, security.ApplyBasicAuth(convertToHandlerFunc(handler), user, pass))
-	} else {
-		http.Handle(pattern, handler)
+		handler = security.ApplyBasicAuthLimited(convertToHandlerFunc(handler), user, pass, limiter)
+	}
+	if crossOrigin != nil {
+		handler = crossOrigin(handler)
 	}
+	http.Handle(pattern, handler)
 }
 
 // convertToHandlerFunc converts http.Handler to http.HandlerFunc
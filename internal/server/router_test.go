@@ -0,0 +1,162 @@
+package server
+
+import (
+	"embed"
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wanetty/upgopher/internal/handlers"
+	"github.com/wanetty/upgopher/internal/security"
+	"github.com/wanetty/upgopher/internal/statics"
+	"github.com/wanetty/upgopher/internal/storage"
+)
+
+func TestNormalizeBasePath(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "", want: ""},
+		{in: "/", want: ""},
+		{in: "files", want: "/files"},
+		{in: "/files/", want: "/files"},
+		{in: "/tools/files", want: "/tools/files"},
+		{in: "/a//b", wantErr: true},
+		{in: "/../etc", wantErr: true},
+		{in: "/files?x=1", wantErr: true},
+		{in: "/fi les", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := NormalizeBasePath(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NormalizeBasePath(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("NormalizeBasePath(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// routePatterns returns the pattern of every registerRoute call in
+// SetupRoutes, with the basePath prefix removed. Reading the source instead
+// of the mux also covers routes that are only registered behind a flag.
+func routePatterns(t *testing.T) []string {
+	t.Helper()
+	file, err := parser.ParseFile(token.NewFileSet(), "router.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var patterns []string
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Name.Name != "SetupRoutes" {
+			continue
+		}
+		ast.Inspect(fn.Body, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			if ident, ok := call.Fun.(*ast.Ident); !ok || ident.Name != "registerRoute" {
+				return true
+			}
+			bin, ok := call.Args[0].(*ast.BinaryExpr)
+			lit, litOK := bin.Y.(*ast.BasicLit)
+			if !ok || !litOK || lit.Kind != token.STRING {
+				t.Fatalf("registerRoute pattern must be basePath+\"literal\"")
+			}
+			pattern, err := strconv.Unquote(lit.Value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			patterns = append(patterns, pattern)
+			return true
+		})
+	}
+	if len(patterns) == 0 {
+		t.Fatal("no registerRoute calls found in SetupRoutes")
+	}
+	return patterns
+}
+
+// specRequestPath turns an OpenAPI path template into a concrete request path.
+func specRequestPath(path string) string {
+	for strings.Contains(path, "{") {
+		start := strings.Index(path, "{")
+		end := strings.Index(path, "}")
+		path = path[:start] + "x" + path[end+1:]
+	}
+	return path
+}
+
+func TestOpenAPICoversRoutes(t *testing.T) {
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(statics.OpenAPISpec(), &doc); err != nil {
+		t.Fatalf("invalid OpenAPI document: %v", err)
+	}
+
+	// Every registered pattern is documented, either verbatim or, for
+	// subtree patterns, as a templated path below it (/raw/ -> /raw/{path}).
+	for _, pattern := range routePatterns(t) {
+		documented := false
+		for path := range doc.Paths {
+			if path == pattern || (pattern != "/" && strings.HasSuffix(pattern, "/") && strings.HasPrefix(path, pattern)) {
+				documented = true
+				break
+			}
+		}
+		if !documented {
+			t.Errorf("route %s is registered in SetupRoutes but missing from openapi.json", pattern)
+		}
+	}
+
+	// Every documented path is served by a route other than the catch-all.
+	customPaths := map[string]string{}
+	var mu sync.RWMutex
+	dir := t.TempDir()
+	err := SetupRoutes(Config{
+		Dir:              dir,
+		Share:            storage.NewLocal(dir, security.SymlinksWithinRoot),
+		Quiet:            true,
+		MaxTabs:          5,
+		HistoryLimit:     20,
+		HistoryMaxAge:    time.Hour,
+		ScreenshotLimits: handlers.DefaultScreenshotLimits(),
+		EnableMetrics:    true,
+		CustomPaths:      &customPaths,
+		CustomPathsMutex: &mu,
+		FaviconFS:        &embed.FS{},
+		LogoFS:           &embed.FS{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path := range doc.Paths {
+		_, pattern := http.DefaultServeMux.Handler(httptest.NewRequest(http.MethodGet, specRequestPath(path), nil))
+		if pattern == "" || (pattern == "/" && path != "/") {
+			t.Errorf("openapi.json documents %s but no route serves it", path)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	http.DefaultServeMux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/openapi.json", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Header().Get("Content-Type"), "application/json") {
+		t.Errorf("GET /api/v1/openapi.json = %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+}
@@ -37,14 +37,14 @@ function loadBreadcrumbs() {
     var currentPath = pathInput ? pathInput.value : '';
 
     // Always show the home crumb
-    var homeHtml = '<a class="breadcrumb-segment" href="/" title="Root"><i class="fa fa-home"></i></a>';
+    var homeHtml = '<a class="breadcrumb-segment" href="' + BASE_PATH + '/" title="Root"><i class="fa fa-home"></i></a>';
 
     if (!currentPath) {
         bar.innerHTML = homeHtml + '<span class="breadcrumb-segment active">Root</span>';
         return;
     }
 
-    fetch('/api/v1/breadcrumbs?path=' + encodeURIComponent(currentPath))
+    fetch(BASE_PATH + '/api/v1/breadcrumbs?path=' + encodeURIComponent(currentPath))
         .then(function (r) {
             if (!r.ok) throw new Error('breadcrumbs fetch failed');
             return r.json();
@@ -58,7 +58,7 @@ function loadBreadcrumbs() {
                     // Current directory — not a link
                     html += '<span class="breadcrumb-segment active">' + escapeHtml(seg.name) + '</span>';
                 } else {
-                    html += '<a class="breadcrumb-segment" href="/?path=' + encodeURIComponent(seg.path) + '">' + escapeHtml(seg.name) + '</a>';
+                    html += '<a class="breadcrumb-segment" href="' + BASE_PATH + '/?path=' + encodeURIComponent(seg.path) + '">' + escapeHtml(seg.name) + '</a>';
                 }
             });
             bar.innerHTML = html;
@@ -99,8 +99,8 @@ document.addEventListener('DOMContentLoaded', function () {
     // Initialize auto-save (SSE + debounce + toggle)
     initAutoSave();
 
-    // Restore file selection state from sessionStorage
-    initCheckboxes();
+    // Load the file listing; selection state is restored once rows render
+    initFileList();
 
     // Render the breadcrumb navigation bar
     loadBreadcrumbs();
@@ -134,8 +134,17 @@ document.addEventListener('DOMContentLoaded', function () {
         });
     }
 
+    // Encrypted tab passphrase: submit on Enter, cancel on Escape
+    var passphraseInput = document.getElementById('passphrase-input');
+    if (passphraseInput) {
+        passphraseInput.addEventListener('keydown', function (e) {
+            if (e.key === 'Enter') { e.preventDefault(); submitTabPassphrase(); }
+            if (e.key === 'Escape') hidePassphraseRow();
+        });
+    }
+
     // Code for hidden files handling
-    fetch('/showhiddenfiles')
+    fetch(BASE_PATH + '/showhiddenfiles')
         .then(response => response.json())
         .then(data => {
             if (data === true) {
@@ -149,7 +158,7 @@ document.addEventListener('DOMContentLoaded', function () {
         });
 
     checkbox.addEventListener('change', function () {
-        fetch('/showhiddenfiles', {
+        fetch(BASE_PATH + '/showhiddenfiles', {
             method: 'POST',
         })
             .then(data => {
@@ -180,25 +189,43 @@ document.addEventListener('DOMContentLoaded', function () {
 
 // Function to save text to shared clipboard
 function saveToSharedClipboard(isAutoSave) {
+    var tab = currentClipboardTab;
+    if (_liveState && _liveState.tab === tab) {
+        showToast('Changes to "' + tab + '" are saved live');
+        return;
+    }
     var clipboardText = document.getElementById('shared-clipboard-textarea').value;
     var headers = { 'Content-Type': 'text/plain' };
-    var token = clipboardTokenCache[currentClipboardTab];
+    var token = clipboardTokenCache[tab];
     if (token) headers['X-Tab-Token'] = token;
+    // Only overwrite the revision we last loaded; the server answers 412 otherwise
+    if (clipboardRevisions[tab]) headers['If-Match'] = clipboardRevisions[tab];
 
     if (isAutoSave) setAutoSaveStatus('saving');
 
-    fetch('/clipboard?tab=' + encodeURIComponent(currentClipboardTab), {
-        method: 'POST',
-        headers: headers,
-        body: clipboardText
-    })
+    encodeTabContent(tab, clipboardText)
+        .then(function (body) {
+            return fetch(BASE_PATH + '/clipboard?tab=' + encodeURIComponent(tab), {
+                method: 'POST',
+                headers: headers,
+                body: body
+            });
+        })
         .then(function (response) {
             if (response.status === 401) {
                 setClipboardEditable(false);
-                showTokenUnlockRow(currentClipboardTab, function () { saveToSharedClipboard(isAutoSave); });
+                showTokenUnlockRow(tab, function () { saveToSharedClipboard(isAutoSave); });
+                return;
+            }
+            if (response.status === 412) {
+                clearTimeout(_autoSaveTimer);
+                setAutoSaveStatus('conflict');
+                openClipboardConflict(tab);
                 return;
             }
             if (!response.ok) throw new Error('Error saving to shared clipboard');
+            clipboardRevisions[tab] = response.headers.get('ETag');
+            clipboardBaseContent[tab] = clipboardText;
             _isLocallyDirty = false; // save succeeded — SSE updates can be applied again
             if (isAutoSave) {
                 setAutoSaveStatus('saved');
@@ -252,6 +279,57 @@ function copyFromSharedClipboard() {
 
 // ── Shared screenshots gallery ────────────────────────────────────────────────
 var _screenshotBlobsCache = {};
+var screenshotsTab = 'default';  // clipboard tab whose gallery is shown
+var _screenshotsSource = null;
+var _screenshotsRev = 0;
+
+/** Headers carrying the token of the gallery's tab, when it is protected. */
+function screenshotHeaders(extra) {
+    var headers = extra || {};
+    var token = clipboardTokenCache[screenshotsTab];
+    if (token) headers['X-Tab-Token'] = token;
+    return headers;
+}
+
+/** Direct link to a screenshot; links can't send headers, so the token goes in the query. */
+function screenshotLink(id) {
+    var url = BASE_PATH + '/screenshot/' + encodeURIComponent(id);
+    var token = clipboardTokenCache[screenshotsTab];
+    if (token) url += '?X-Tab-Token=' + encodeURIComponent(token);
+    return url;
+}
+
+/** Lists the tabs that have a gallery in the gallery selector. */
+function renderScreenshotTabOptions(tabs) {
+    var select = document.getElementById('screenshots-tab-select');
+    if (!select) return;
+    var names = tabs.filter(function (t) { return !t.burnAfterRead && !t.encrypted; })
+        .map(function (t) { return t.name; })
+        .sort(function (a, b) {
+            if (a === 'default') return -1;
+            if (b === 'default') return 1;
+            return a.localeCompare(b);
+        });
+    select.innerHTML = '';
+    names.forEach(function (name) {
+        var opt = document.createElement('option');
+        opt.value = name;
+        opt.textContent = name;
+        select.appendChild(opt);
+    });
+    if (names.indexOf(screenshotsTab) === -1) {
+        selectScreenshotsTab('default');
+    }
+    select.value = screenshotsTab;
+}
+
+function selectScreenshotsTab(name) {
+    screenshotsTab = name;
+    var select = document.getElementById('screenshots-tab-select');
+    if (select) select.value = name;
+    loadScreenshots();
+    initScreenshotsRealtime();
+}
 
 function initScreenshotsPanel() {
     var drop = document.getElementById('screenshot-drop-zone');
@@ -331,11 +409,11 @@ function uploadScreenshot(file) {
 
     showToast('Uploading screenshot...', 'info');
 
-    fetch('/api/v1/screenshots', {
+    fetch(BASE_PATH + '/api/v1/screenshots?tab=' + encodeURIComponent(screenshotsTab), {
         method: 'POST',
-        headers: {
+        headers: screenshotHeaders({
             'Content-Type': file.type || 'application/octet-stream'
-        },
+        }),
         body: file
     })
     .then(function (response) {
@@ -359,19 +437,53 @@ function loadScreenshots() {
     var grid = document.getElementById('screenshots-grid');
     if (!grid) return;
 
-    fetch('/api/v1/screenshots')
+    var tab = screenshotsTab;
+    fetch(BASE_PATH + '/api/v1/screenshots?tab=' + encodeURIComponent(tab), { headers: screenshotHeaders() })
     .then(function (r) {
+        if (r.status === 401) return null;
         if (!r.ok) throw new Error('Failed to load screenshots');
         return r.json();
     })
-    .then(function (images) {
-        renderScreenshots(images);
+    .then(function (list) {
+        if (tab !== screenshotsTab) return; // another gallery was selected meanwhile
+        if (list === null) {
+            grid.innerHTML = '<div class="grid-placeholder">Tab "' + escapeHtml(tab) + '" is protected. Unlock it in the Shared Clipboard to see its screenshots.</div>';
+            return;
+        }
+        renderScreenshotLimits(list);
+        renderScreenshots(list.images);
     })
     .catch(function (err) {
         console.error('Error loading screenshots:', err);
     });
 }
 
+// formatDuration turns a number of seconds into a short text such as "2 days".
+function formatDuration(seconds) {
+    var units = [[86400, 'day'], [3600, 'hour'], [60, 'minute']];
+    for (var i = 0; i < units.length; i++) {
+        if (seconds >= units[i][0] && seconds % units[i][0] === 0) {
+            var n = seconds / units[i][0];
+            return n + ' ' + units[i][1] + (n === 1 ? '' : 's');
+        }
+    }
+    return seconds + ' seconds';
+}
+
+// renderScreenshotLimits describes the gallery limits reported by the server.
+function renderScreenshotLimits(list) {
+    var el = document.getElementById('screenshots-limits');
+    if (!el) return;
+    var text = 'Each clipboard tab has its own gallery of the last ' + list.maxCount + ' images, up to ' + formatFileSize(list.maxSize) + ' each.';
+    if (list.maxBytes > 0) {
+        text += ' All galleries share ' + formatFileSize(list.maxBytes) + ' (' + formatFileSize(list.totalBytes) + ' used).';
+    }
+    if (list.maxAgeSeconds > 0) {
+        text += ' Images are removed after ' + formatDuration(list.maxAgeSeconds) + '.';
+    }
+    el.textContent = text + ' Older ones are automatically removed, and images of a protected tab need its token.';
+}
+
 function renderScreenshots(images) {
     var grid = document.getElementById('screenshots-grid');
     if (!grid) return;
@@ -399,21 +511,14 @@ function renderScreenshots(images) {
 
         var sizeSpan = document.createElement('span');
         sizeSpan.textContent = formatFileSize(img.size);
-
-        var updateDimensions = function () {
-            if (imageEl.naturalWidth && imageEl.naturalHeight) {
-                sizeSpan.textContent = formatFileSize(img.size) + ' (' + imageEl.naturalWidth + 'x' + imageEl.naturalHeight + ')';
-            }
-        };
-        imageEl.onload = updateDimensions;
-        if (imageEl.complete) {
-            updateDimensions();
+        if (img.width && img.height) {
+            sizeSpan.textContent += ' (' + img.width + 'x' + img.height + ')';
         }
 
         loadImageBlob(img.id, imageEl);
 
         imgWrapper.onclick = function () {
-            window.open('/screenshot/' + img.id, '_blank', 'noopener');
+            window.open(screenshotLink(img.id), '_blank', 'noopener');
         };
 
         imgWrapper.appendChild(imageEl);
@@ -451,7 +556,7 @@ function renderScreenshots(images) {
             copyBtn.innerHTML = '<i class="fa fa-eye"></i> View/Copy';
             copyBtn.onclick = function (e) {
                 e.stopPropagation();
-                window.open('/screenshot/' + img.id, '_blank', 'noopener');
+                window.open(screenshotLink(img.id), '_blank', 'noopener');
                 showToast('Click derecho sobre la imagen -> "Copiar imagen" para copiarla manualmente.', 'info');
             };
         }
@@ -480,7 +585,8 @@ function loadImageBlob(id, imgElement) {
         return;
     }
 
-    fetch('/api/v1/screenshots/image?id=' + id)
+    // the grid only needs the downscaled copy; the full image opens on click
+    fetch(BASE_PATH + '/api/v1/screenshots/thumbnail?id=' + encodeURIComponent(id), { headers: screenshotHeaders() })
     .then(function (response) {
         if (!response.ok) throw new Error('Failed to load image');
         return response.blob();
@@ -498,7 +604,7 @@ function loadImageBlob(id, imgElement) {
 function copyScreenshotToClipboard(id) {
     var canWriteImage = navigator.clipboard && navigator.clipboard.write && typeof ClipboardItem !== 'undefined';
     
-    fetch('/api/v1/screenshots/image?id=' + id)
+    fetch(BASE_PATH + '/api/v1/screenshots/image?id=' + id, { headers: screenshotHeaders() })
     .then(function (response) {
         if (!response.ok) throw new Error('Failed to fetch image for copying');
         return response.blob();
@@ -536,8 +642,9 @@ function fallbackCopyScreenshot(blob) {
 function deleteScreenshot(id) {
     if (!confirm('Are you sure you want to delete this screenshot?')) return;
 
-    fetch('/api/v1/screenshots/image?id=' + id, {
-        method: 'DELETE'
+    fetch(BASE_PATH + '/api/v1/screenshots/image?id=' + id, {
+        method: 'DELETE',
+        headers: screenshotHeaders()
     })
     .then(function (response) {
         if (!response.ok) throw new Error('Failed to delete screenshot');
@@ -549,9 +656,21 @@ function deleteScreenshot(id) {
     });
 }
 
+/** Follows the gallery's tab so screenshots added or removed elsewhere show up. */
 function initScreenshotsRealtime() {
-    var sseSource = new EventSource('/clipboard/stream?tab=screenshots-global');
-    sseSource.addEventListener('change', function () {
+    if (_screenshotsSource) _screenshotsSource.close();
+    _screenshotsRev = 0;
+    var url = BASE_PATH + '/clipboard/stream?tab=' + encodeURIComponent(screenshotsTab);
+    var token = clipboardTokenCache[screenshotsTab];
+    if (token) url += '&X-Tab-Token=' + encodeURIComponent(token);
+    var sseSource = new EventSource(url);
+    _screenshotsSource = sseSource;
+    sseSource.addEventListener('change', function (e) {
+        // The tab's text changes go through this stream too: only reload the
+        // gallery when its own revision moved
+        var rev = JSON.parse(e.data).screenshots || 0;
+        if (rev === _screenshotsRev) return;
+        _screenshotsRev = rev;
         loadScreenshots();
     });
     sseSource.onerror = function() {
@@ -755,7 +874,7 @@ function uploadFiles(fileItems, emptyDirs) {
         alert('Subida cancelada');
     });
 
-    var uploadUrl = '/' + window.location.search;
+    var uploadUrl = BASE_PATH + '/' + window.location.search;
     xhr.open('POST', uploadUrl);
     xhr.setRequestHeader('X-Requested-With', 'XMLHttpRequest');
     xhr.send(formData);
@@ -781,7 +900,7 @@ function copyToClipboard(pathBase64, fileName) {
     }
 
     // Create the final URL ensuring there's only one slash after /raw/
-    const urlWithParam = baseUrl + "/raw/" + path;
+    const urlWithParam = baseUrl + BASE_PATH + "/raw/" + path;
 
     navigator.clipboard.writeText(urlWithParam);
 }
@@ -808,7 +927,7 @@ function createCustomPath() {
         return;
     }
 
-    fetch('/custom-path', {
+    fetch(BASE_PATH + '/custom-path', {
         method: 'POST',
         headers: {
             'Content-Type': 'application/x-www-form-urlencoded',
@@ -822,7 +941,7 @@ function createCustomPath() {
             return response.text();
         })
         .then(result => {
-            alert('Custom path created successfully!\nAccess your file at: ' + window.location.origin + '/' + customPath);
+            alert('Custom path created successfully!\nAccess your file at: ' + window.location.origin + BASE_PATH + '/' + customPath);
             closeCustomPathModal();
             window.location.reload();
         })
@@ -842,6 +961,9 @@ window.onclick = function (event) {
     if (event.target == document.getElementById('fileViewerModal')) {
         closeFileViewer();
     }
+    if (event.target == document.getElementById('clipboardHistoryModal')) {
+        closeClipboardHistory();
+    }
     if (event.target == document.getElementById('newFolderModal')) {
         closeNewFolderModal();
     }
@@ -856,6 +978,7 @@ document.addEventListener('keydown', function (event) {
         closeCustomPathModal();
         closeSearchModal();
         closeFileViewer();
+        closeClipboardHistory();
         closeNewFolderModal();
         closeErrorModal();
         closeTreePanel();
@@ -957,7 +1080,7 @@ function searchInFile() {
 
     // For search, use the path as received without additional encoding
     // Only apply encodeURIComponent to the search term and other parameters
-    const url = `/search-file?path=${encodeURIComponent(currentFilePath)}&term=${encodeURIComponent(searchTerm)}&caseSensitive=${encodeURIComponent(caseSensitive)}&wholeWord=${encodeURIComponent(wholeWord)}`;
+    const url = BASE_PATH + `/search-file?path=${encodeURIComponent(currentFilePath)}&term=${encodeURIComponent(searchTerm)}&caseSensitive=${encodeURIComponent(caseSensitive)}&wholeWord=${encodeURIComponent(wholeWord)}`;
 
     // Extensive logging for debugging
     console.log('Original search path:', currentFilePath);
@@ -1094,7 +1217,7 @@ function openFileViewer(filePath, fileName) {
     document.getElementById('fileViewerModal').style.display = 'flex';
     document.body.style.overflow = 'hidden';
 
-    fetch('/file-content?path=' + encodeURIComponent(filePath))
+    fetch(BASE_PATH + '/file-content?path=' + encodeURIComponent(filePath))
         .then(function (response) {
             if (!response.ok) {
                 return response.text().then(function (text) {
@@ -1207,7 +1330,7 @@ function downloadSelected() {
         return;
     }
 
-    fetch('/zip-selected', {
+    fetch(BASE_PATH + '/zip-selected', {
         method: 'POST',
         headers: { 'Content-Type': 'application/json' },
         body: JSON.stringify({ paths: selected })
@@ -1237,53 +1360,158 @@ function downloadSelected() {
         });
 }
 
-function sortTable(n, type = 'string') {
-    const table = document.querySelector(".styled-table tbody");
-    const rows = Array.from(table.rows);
-    const isAsc = table.dataset.sortOrder === 'asc';
-    const sortOrder = isAsc ? 'desc' : 'asc';
-    table.dataset.sortOrder = sortOrder;
-
-    rows.sort((rowA, rowB) => {
-        const cellA = rowA.cells[n].innerText.trim();
-        const cellB = rowB.cells[n].innerText.trim();
-
-        // Last Modified column (date sorting) — now at index 4 due to checkbox column
-        if (n === 4) {
-            // Try to parse as ISO date, fallback to string
-            const dateA = Date.parse(cellA);
-            const dateB = Date.parse(cellB);
-            if (!isNaN(dateA) && !isNaN(dateB)) {
-                return sortOrder === 'asc' ? dateA - dateB : dateB - dateA;
-            }
-            // Fallback to string compare if not valid date
-            if (cellA < cellB) return sortOrder === 'asc' ? -1 : 1;
-            if (cellA > cellB) return sortOrder === 'asc' ? 1 : -1;
-            return 0;
-        }
-        if (type === 'number') {
-            const numA = parseFloat(cellA) || 0;
-            const numB = parseFloat(cellB) || 0;
-            return sortOrder === 'asc' ? numA - numB : numB - numA;
-        } else {
-            if (cellA < cellB) return sortOrder === 'asc' ? -1 : 1;
-            if (cellA > cellB) return sortOrder === 'asc' ? 1 : -1;
-            return 0;
+// File listing state: rendered client-side from /api/v1/files
+var fileListState = { sort: 'name', order: 'asc', glob: '', cursor: '', loaded: 0 };
+
+function pad2(n) {
+    return (n < 10 ? '0' : '') + n;
+}
+
+// formatModTime renders an RFC 3339 timestamp as "YYYY-MM-DD HH:MM:SS" local time
+function formatModTime(value) {
+    var d = new Date(value);
+    if (isNaN(d.getTime())) return '-';
+    return d.getFullYear() + '-' + pad2(d.getMonth() + 1) + '-' + pad2(d.getDate()) + ' ' +
+        pad2(d.getHours()) + ':' + pad2(d.getMinutes()) + ':' + pad2(d.getSeconds());
+}
+
+// renderFileRow builds the table row for one entry of /api/v1/files.
+// Values reach onclick handlers through data-* attributes, never inline JS strings.
+function renderFileRow(entry, currentPath) {
+    var path = escapeHtml(entry.path);
+    var name = escapeHtml(entry.name);
+    var data = ' data-path="' + path + '" data-name="' + name + '" data-dir="' + escapeHtml(currentPath) + '"';
+    var html = '<tr>' +
+        '<td class="col-checkbox"><input type="checkbox" class="file-select-checkbox" data-path="' + path + '" onchange="onCheckboxChange(this)"></td>';
+
+    if (entry.type === 'dir') {
+        html += '<td><a href="' + BASE_PATH + '/?path=' + encodeURIComponent(entry.path) + '">' + name + '</a></td>' +
+            '<td>' + escapeHtml(entry.mode) + '</td>' +
+            '<td>-</td>' +
+            '<td>' + formatModTime(entry.mtime) + '</td>' +
+            '<td>-</td>' +
+            '<td><div class="action-buttons">';
+        if (!READ_ONLY) {
+            html += '<button class="action-btn delete" title="Delete folder and contents"' + data + ' onclick="deleteFolder(this.dataset.path)"><i class="fa fa-trash"></i></button>';
         }
-    });
+        return html + '</div></td></tr>';
+    }
 
-    table.innerHTML = "";
-    rows.forEach(row => table.appendChild(row));
+    html += '<td>' + name + '</td>' +
+        '<td>' + escapeHtml(entry.mode) + '</td>' +
+        '<td>' + formatFileSize(entry.size) + '</td>' +
+        '<td>' + formatModTime(entry.mtime) + '</td>' +
+        '<td>' + (entry.customPath ? escapeHtml(entry.customPath) : '-') + '</td>' +
+        '<td><div class="action-buttons">' +
+        '<button class="action-btn download" title="Download"' + data + ' onclick="window.location.href=BASE_PATH+\'/download/?path=\'+encodeURIComponent(this.dataset.path)"><i class="fa fa-download"></i></button>' +
+        '<button class="action-btn link" title="Copy URL"' + data + ' onclick="copyToClipboard(this.dataset.dir, this.dataset.name)"><i class="fa fa-link"></i></button>' +
+        '<button class="action-btn edit" title="Create Custom Path"' + data + ' onclick="showCustomPathForm(this.dataset.name, this.dataset.dir)"><i class="fa fa-magic"></i></button>';
+    if (entry.viewable) {
+        html += '<button class="action-btn view" title="View File"' + data + ' onclick="openFileViewer(this.dataset.path, this.dataset.name)"><i class="fa fa-eye"></i></button>' +
+            '<button class="action-btn search" title="Search in File"' + data + ' onclick="showSearchModal(this.dataset.path, this.dataset.name)"><i class="fa fa-search"></i></button>';
+    }
+    if (!READ_ONLY) {
+        html += '<button class="action-btn delete" title="Delete"' + data + ' onclick="deleteFile(this.dataset.path)"><i class="fa fa-trash"></i></button>';
+    }
+    return html + '</div></td></tr>';
+}
+
+// loadFileList fetches a page of the current directory. With append=false the
+// table is replaced (first page, new sort or filter); otherwise rows are added.
+function loadFileList(append) {
+    var tbody = document.getElementById('fileTableBody');
+    if (!tbody) return;
+
+    var pathInput = document.getElementById('current-path-value');
+    var currentPath = pathInput ? pathInput.value : '';
+
+    if (!append) {
+        fileListState.cursor = '';
+        fileListState.loaded = 0;
+    }
+
+    var params = new URLSearchParams();
+    if (currentPath) params.set('path', currentPath);
+    params.set('sort', fileListState.sort);
+    params.set('order', fileListState.order);
+    if (fileListState.glob) params.set('glob', fileListState.glob);
+    if (append && fileListState.cursor) params.set('cursor', fileListState.cursor);
+
+    fetch(BASE_PATH + '/api/v1/files?' + params.toString())
+        .then(function (r) {
+            if (!r.ok) {
+                return r.text().then(function (text) {
+                    throw new Error(text.trim() || 'Error loading files');
+                });
+            }
+            return r.json();
+        })
+        .then(function (data) {
+            var entries = data.entries || [];
+            var rows = entries.map(function (e) { return renderFileRow(e, currentPath); }).join('');
+            if (append) {
+                tbody.insertAdjacentHTML('beforeend', rows);
+            } else if (entries.length === 0) {
+                tbody.innerHTML = '<tr class="file-list-placeholder"><td colspan="7">' +
+                    (fileListState.glob ? 'No matching files' : 'This folder is empty') + '</td></tr>';
+            } else {
+                tbody.innerHTML = rows;
+            }
+
+            fileListState.loaded += entries.length;
+            fileListState.cursor = data.nextCursor || '';
+
+            var more = document.getElementById('file-list-more');
+            if (more) more.style.display = fileListState.cursor ? 'flex' : 'none';
+            var count = document.getElementById('file-list-count');
+            if (count) count.textContent = fileListState.loaded + ' of ' + data.total;
+
+            updateSortIcons();
+            initCheckboxes();
+        })
+        .catch(function (error) {
+            if (!append) {
+                tbody.innerHTML = '<tr class="file-list-placeholder"><td colspan="7">' + escapeHtml(error.message) + '</td></tr>';
+            } else {
+                showToast(escapeHtml(error.message), 'error');
+            }
+        });
+}
+
+// sortFileList toggles the order when the same column is clicked again
+function sortFileList(field) {
+    if (fileListState.sort === field) {
+        fileListState.order = fileListState.order === 'asc' ? 'desc' : 'asc';
+    } else {
+        fileListState.sort = field;
+        fileListState.order = 'asc';
+    }
+    loadFileList(false);
+}
+
+function updateSortIcons() {
+    ['name', 'size', 'mtime'].forEach(function (field) {
+        var icon = document.getElementById(field + '-icon');
+        if (!icon) return;
+        icon.className = field === fileListState.sort ? 'fa fa-sort-' + fileListState.order : 'fa';
+    });
+}
 
-    document.querySelectorAll("th i").forEach(icon => icon.className = 'fa');
-    const iconId = n === 1 ? 'name-icon' :
-        n === 3 ? 'size-icon' :
-            n === 4 ? 'modified-icon' :
-                n === 5 ? 'custom-path-icon' : '';
-    if (iconId) {
-        const icon = document.getElementById(iconId);
-        icon.className = "fa fa-sort-" + (sortOrder === 'asc' ? 'asc' : 'desc');
+function initFileList() {
+    var filter = document.getElementById('file-filter');
+    if (filter) {
+        filter.addEventListener('keydown', function (e) {
+            if (e.key === 'Enter') {
+                e.preventDefault();
+                var value = this.value.trim();
+                // A bare word filters by substring, like most file managers
+                if (value && !/[*?\[]/.test(value)) value = '*' + value + '*';
+                fileListState.glob = value;
+                loadFileList(false);
+            }
+        });
     }
+    loadFileList(false);
 }
 
 // Upload progress functionality
@@ -1460,11 +1688,21 @@ function initAutoSave() {
     var textarea = document.getElementById('shared-clipboard-textarea');
     if (textarea) {
         textarea.addEventListener('input', onClipboardInput);
+        ['select', 'keyup', 'click'].forEach(function (type) {
+            textarea.addEventListener(type, function () { if (_liveState) sendLiveCursor(); });
+        });
+        textarea.addEventListener('scroll', renderRemoteCursors);
+        window.addEventListener('resize', renderRemoteCursors);
     }
 }
 
 /** Called on every textarea input event. */
 function onClipboardInput() {
+    if (_liveState) {
+        onLiveInput(); // live sessions save every edit as it happens
+        return;
+    }
+    if (_liveSocket) return; // connecting: merged when the session starts
     if (!_autoSaveEnabled) return;
     _isLocallyDirty = true;        // mark that the textarea has unsaved local changes
     clearTimeout(_autoSaveTimer);
@@ -1488,12 +1726,12 @@ function toggleAutoSave(enabled) {
 
 /**
  * Updates the auto-save status indicator.
- * states: 'idle' | 'pending' | 'saving' | 'saved' | 'off'
+ * states: 'idle' | 'pending' | 'saving' | 'saved' | 'off' | 'conflict' | 'live' | 'reconnecting'
  */
 function setAutoSaveStatus(state) {
     var el = document.getElementById('autosave-status');
     if (!el) return;
-    var labels = { idle: '', pending: '…', saving: 'Saving…', saved: 'Auto-saved ✓', off: 'Auto-save off' };
+    var labels = { idle: '', pending: '…', saving: 'Saving…', saved: 'Auto-saved ✓', off: 'Auto-save off', conflict: 'Changed elsewhere', live: 'Live ✓', reconnecting: 'Reconnecting…' };
     el.textContent = labels[state] || '';
     el.className = 'autosave-status autosave-' + state;
 }
@@ -1508,12 +1746,9 @@ function setAutoSaveStatus(state) {
  */
 function connectClipboardSSE(tabName) {
     // Close previous connection
-    if (_sseSource) {
-        _sseSource.close();
-        _sseSource = null;
-    }
+    disconnectClipboardSync();
 
-    var url = '/clipboard/stream?tab=' + encodeURIComponent(tabName);
+    var url = BASE_PATH + '/clipboard/stream?tab=' + encodeURIComponent(tabName);
     // For protected tabs, we cannot pass the token via EventSource URL cleanly.
     // We rely on the tab already being accessible (token validated on GET /clipboard).
     // If the tab is protected and we don't have the token yet, skip SSE — the
@@ -1527,13 +1762,21 @@ function connectClipboardSSE(tabName) {
     _sseSource = es;
 
     es.addEventListener('change', function (e) {
-        var changedTab = e.data.trim();
+        var event = JSON.parse(e.data); // { tab, revision }
+        var changedTab = event.tab;
         // Only react if we're still on the same tab
         if (changedTab !== currentClipboardTab) return;
+        onAttachmentsRevision(changedTab, event.attachments);
+        // Our own save already recorded this revision
+        if (clipboardRevisions[changedTab] === '"' + event.revision + '"') return;
         // Don't overwrite while the user has unsaved local edits.
         // We use a dirty flag rather than checking activeElement so that
         // users who only have focus (e.g. to read/copy) still receive updates.
-        if (_isLocallyDirty) return;
+        // Warn instead: the next save will be rejected and offer a merge.
+        if (_isLocallyDirty) {
+            setAutoSaveStatus('conflict');
+            return;
+        }
         // Re-fetch content silently
         fetchClipboardContent(changedTab);
     });
@@ -1553,13 +1796,15 @@ function fetchClipboardContent(tabName) {
     var token = clipboardTokenCache[tabName];
     if (token) headers['X-Tab-Token'] = token;
 
-    fetch('/clipboard?tab=' + encodeURIComponent(tabName), { headers: headers })
+    fetch(BASE_PATH + '/clipboard?tab=' + encodeURIComponent(tabName), { headers: headers })
         .then(function (r) {
             if (!r.ok) return null;
-            return r.text();
+            clipboardRevisions[tabName] = r.headers.get('ETag');
+            return r.text().then(function (content) { return decodeTabContent(tabName, content); });
         })
         .then(function (content) {
             if (content === null || content === undefined) return;
+            clipboardBaseContent[tabName] = content;
             document.getElementById('shared-clipboard-textarea').value = content;
             document.getElementById('clipboard-char-count').textContent = 'chars: ' + content.length;
             // Refresh tab metadata to update updatedAt
@@ -1568,24 +1813,516 @@ function fetchClipboardContent(tabName) {
         .catch(function () { /* silent */ });
 }
 
+// ── Clipboard live editing (WebSocket) ───────────────────────────────────────
+//
+// Edits travel as operational-transform operations in the ot.js format:
+// arrays of retains (positive integers), inserts (strings) and deletes
+// (negative integers), counted in UTF-16 code units like textarea positions.
+// The server orders them; the client keeps at most one operation in flight
+// and buffers later edits until it is acknowledged.
+
+var _liveSocket = null;          // active WebSocket, null when not editing live
+var _liveState = null;           // OT state of the live session, set once "init" arrives
+var _liveUnsupported = !window.WebSocket; // true when WebSockets can't be used; SSE is used instead
+var _liveRetryTimer = null;
+var _liveRetryDelay = 1000;      // reconnect backoff, doubled up to LIVE_MAX_RETRY_MS
+var _liveCursorTimer = null;
+var LIVE_MAX_RETRY_MS = 30000;
+var LIVE_CURSOR_THROTTLE_MS = 100;
+
+function opRetain(op, n) {
+    if (n <= 0) return;
+    if (op.length && typeof op[op.length - 1] === 'number' && op[op.length - 1] > 0) op[op.length - 1] += n;
+    else op.push(n);
+}
+
+function opInsert(op, s) {
+    if (!s) return;
+    var last = op[op.length - 1];
+    if (typeof last === 'string') {
+        op[op.length - 1] += s;
+    } else if (typeof last === 'number' && last < 0) {
+        // Keep inserts ahead of deletes so equal edits look the same
+        if (typeof op[op.length - 2] === 'string') op[op.length - 2] += s;
+        else op.splice(op.length - 1, 0, s);
+    } else {
+        op.push(s);
+    }
+}
+
+function opDelete(op, n) {
+    if (n <= 0) return;
+    if (op.length && typeof op[op.length - 1] === 'number' && op[op.length - 1] < 0) op[op.length - 1] -= n;
+    else op.push(-n);
+}
+
+function applyOp(op, text) {
+    var out = '';
+    var pos = 0;
+    op.forEach(function (c) {
+        if (typeof c === 'string') {
+            out += c;
+        } else if (c > 0) {
+            out += text.slice(pos, pos + c);
+            pos += c;
+        } else {
+            pos -= c;
+        }
+    });
+    return out + text.slice(pos);
+}
+
+/** Returns one operation with the effect of a followed by b. */
+function composeOps(a, b) {
+    var out = [];
+    var i = 0, j = 0;
+    var ca = a[i++], cb = b[j++];
+    while (ca !== undefined || cb !== undefined) {
+        if (typeof ca === 'number' && ca < 0) { opDelete(out, -ca); ca = a[i++]; continue; }
+        if (typeof cb === 'string') { opInsert(out, cb); cb = b[j++]; continue; }
+        if (ca === undefined || cb === undefined) break;
+        var n;
+        if (typeof ca === 'string') {
+            if (cb > 0) {
+                n = Math.min(ca.length, cb);
+                opInsert(out, ca.slice(0, n));
+                ca = ca.length > n ? ca.slice(n) : a[i++];
+                cb = cb > n ? cb - n : b[j++];
+            } else {
+                n = Math.min(ca.length, -cb);
+                ca = ca.length > n ? ca.slice(n) : a[i++];
+                cb = -cb > n ? cb + n : b[j++];
+            }
+        } else if (cb > 0) {
+            n = Math.min(ca, cb);
+            opRetain(out, n);
+            ca = ca > n ? ca - n : a[i++];
+            cb = cb > n ? cb - n : b[j++];
+        } else {
+            n = Math.min(ca, -cb);
+            opDelete(out, n);
+            ca = ca > n ? ca - n : a[i++];
+            cb = -cb > n ? cb + n : b[j++];
+        }
+    }
+    return out;
+}
+
+/**
+ * Returns [a', b'] so that a then b' equals b then a'. On equal positions
+ * a's insert goes first, as on the server, which transforms our edits as a.
+ */
+function transformOps(a, b) {
+    var ap = [], bp = [];
+    var i = 0, j = 0;
+    var ca = a[i++], cb = b[j++];
+    while (ca !== undefined || cb !== undefined) {
+        if (typeof ca === 'string') { opInsert(ap, ca); opRetain(bp, ca.length); ca = a[i++]; continue; }
+        if (typeof cb === 'string') { opRetain(ap, cb.length); opInsert(bp, cb); cb = b[j++]; continue; }
+        if (ca === undefined || cb === undefined) break;
+        var n;
+        if (ca > 0 && cb > 0) {
+            n = Math.min(ca, cb);
+            opRetain(ap, n); opRetain(bp, n);
+            ca -= n; cb -= n;
+        } else if (ca < 0 && cb < 0) {
+            n = Math.min(-ca, -cb);
+            ca += n; cb += n;
+        } else if (ca < 0) {
+            n = Math.min(-ca, cb);
+            opDelete(ap, n);
+            ca += n; cb -= n;
+        } else {
+            n = Math.min(ca, -cb);
+            opDelete(bp, n);
+            ca -= n; cb += n;
+        }
+        if (ca === 0) ca = a[i++];
+        if (cb === 0) cb = b[j++];
+    }
+    return [ap, bp];
+}
+
+/** Returns an operation turning oldText into newText, never splitting a surrogate pair. */
+function diffOp(oldText, newText) {
+    var prefix = 0;
+    while (prefix < oldText.length && prefix < newText.length && oldText.charCodeAt(prefix) === newText.charCodeAt(prefix)) prefix++;
+    if (prefix > 0 && isHighSurrogate(oldText.charCodeAt(prefix - 1))) prefix--;
+    var suffix = 0;
+    while (suffix < oldText.length - prefix && suffix < newText.length - prefix &&
+        oldText.charCodeAt(oldText.length - 1 - suffix) === newText.charCodeAt(newText.length - 1 - suffix)) suffix++;
+    if (suffix > 0 && isLowSurrogate(oldText.charCodeAt(oldText.length - suffix))) suffix--;
+    var op = [];
+    opRetain(op, prefix);
+    opDelete(op, oldText.length - prefix - suffix);
+    opInsert(op, newText.slice(prefix, newText.length - suffix));
+    opRetain(op, suffix);
+    return op;
+}
+
+function isHighSurrogate(c) { return c >= 0xd800 && c < 0xdc00; }
+function isLowSurrogate(c) { return c >= 0xdc00 && c < 0xe000; }
+
+/** Moves a position in the document across op; inserts at the position push it right. */
+function transformIndex(op, index) {
+    var pos = 0, out = index;
+    for (var i = 0; i < op.length && pos <= index; i++) {
+        var c = op[i];
+        if (typeof c === 'string') {
+            out += c.length;
+        } else if (c > 0) {
+            pos += c;
+        } else {
+            out -= Math.min(-c, index - pos);
+            pos -= c;
+        }
+    }
+    return out;
+}
+
+/** Closes the live socket or the SSE stream of the previous tab, if any. */
+function disconnectClipboardSync() {
+    clearTimeout(_liveRetryTimer);
+    if (_liveSocket) {
+        var ws = _liveSocket;
+        _liveSocket = null;
+        ws.close();
+    }
+    _liveState = null;
+    renderClipboardPresence();
+    if (_sseSource) {
+        _sseSource.close();
+        _sseSource = null;
+    }
+}
+
+/**
+ * Opens a live editing session for tabName. Falls back to the SSE stream
+ * (plus saving with POST) when the browser has no WebSocket support or the
+ * connection can't be established, e.g. behind a proxy that drops upgrades.
+ * resume is the state of a dropped session whose unsent edits should be
+ * merged once reconnected.
+ */
+function connectClipboardLive(tabName, resume) {
+    disconnectClipboardSync();
+    if (_liveUnsupported) {
+        connectClipboardSSE(tabName);
+        return;
+    }
+
+    var url = (location.protocol === 'https:' ? 'wss://' : 'ws://') + location.host +
+        BASE_PATH + '/clipboard/ws?tab=' + encodeURIComponent(tabName);
+    var token = clipboardTokenCache[tabName];
+    if (token) url += '&X-Tab-Token=' + encodeURIComponent(token);
+
+    // Anything typed before "init" arrives is merged like unsent edits
+    var reconnecting = !!resume;
+    resume = resume || { tab: tabName, serverText: document.getElementById('shared-clipboard-textarea').value };
+
+    var ws;
+    try {
+        ws = new WebSocket(url);
+    } catch (e) {
+        _liveUnsupported = true;
+        connectClipboardSSE(tabName);
+        return;
+    }
+    _liveSocket = ws;
+    var initialized = false;
+    var deleted = false;
+
+    ws.onmessage = function (e) {
+        if (ws !== _liveSocket) return;
+        var msg = JSON.parse(e.data);
+        if (msg.type === 'init') {
+            initialized = true;
+            _liveRetryDelay = 1000;
+            startLiveSession(tabName, msg, resume);
+        } else if (msg.type === 'deleted') {
+            deleted = true;
+        } else if (_liveState) {
+            handleLiveMessage(msg);
+        }
+    };
+
+    ws.onclose = function (e) {
+        if (ws !== _liveSocket) return; // replaced by another tab's connection
+        _liveSocket = null;
+        if (!initialized && !reconnecting) {
+            // Never got through: use SSE for the rest of this page's life
+            _liveUnsupported = true;
+            _liveState = null;
+            renderClipboardPresence();
+            connectClipboardSSE(tabName);
+            return;
+        }
+        if (deleted) {
+            _liveState = null;
+            renderClipboardPresence();
+            showToast('Tab "' + tabName + '" was deleted', 'error');
+            if (currentClipboardTab === tabName) currentClipboardTab = 'default';
+            loadClipboardTabs(true);
+            return;
+        }
+        // Keep the session state so unsent edits can be merged after reconnecting
+        var state = initialized ? _liveState : resume;
+        setAutoSaveStatus('reconnecting');
+        _liveRetryTimer = setTimeout(function () {
+            if (currentClipboardTab === tabName && !_liveSocket) connectClipboardLive(tabName, state);
+        }, e.code === 4001 ? 0 : _liveRetryDelay);
+        _liveRetryDelay = Math.min(_liveRetryDelay * 2, LIVE_MAX_RETRY_MS);
+    };
+}
+
+/** Starts (or resumes after a reconnect) editing from the server's snapshot. */
+function startLiveSession(tabName, init, previous) {
+    var textarea = document.getElementById('shared-clipboard-textarea');
+    var local = textarea.value;
+
+    _liveState = {
+        tab: tabName,
+        client: init.client,
+        revision: init.revision,
+        serverText: init.content || '', // document at revision, without our pending edits
+        text: init.content || '',       // document as shown in the textarea
+        outstanding: null,              // sent, waiting for the ack
+        buffer: null,                   // typed since, sent after the ack
+        peers: {}
+    };
+    (init.peers || []).forEach(function (p) {
+        _liveState.peers[p.client] = { name: p.name, color: p.color, cursor: p.cursor };
+    });
+    clearTimeout(_autoSaveTimer);
+    _isLocallyDirty = false;
+    setLiveText(_liveState.text);
+    setAutoSaveStatus('live');
+    renderClipboardPresence();
+    renderRemoteCursors();
+
+    // Edits the server never confirmed are merged into its current content
+    if (previous && previous.tab === tabName && local !== previous.serverText) {
+        var merged = mergeLines(previous.serverText, local, _liveState.text);
+        setLiveText(merged.text);
+        onLiveInput();
+        if (merged.conflicts) {
+            showToast('Reconnected with ' + merged.conflicts + ' conflict(s) — resolve the marked lines', 'error');
+        }
+    }
+    sendLiveCursor();
+}
+
+function handleLiveMessage(msg) {
+    var s = _liveState;
+    switch (msg.type) {
+        case 'ack':
+            s.serverText = applyOp(s.outstanding, s.serverText);
+            s.revision = msg.revision;
+            s.outstanding = s.buffer;
+            s.buffer = null;
+            if (s.outstanding) sendLiveOp(s.outstanding);
+            break;
+        case 'op':
+            var op = msg.op;
+            s.serverText = applyOp(op, s.serverText);
+            s.revision = msg.revision;
+            if (s.outstanding) {
+                var t = transformOps(s.outstanding, op);
+                s.outstanding = t[0];
+                op = t[1];
+            }
+            if (s.buffer) {
+                var u = transformOps(s.buffer, op);
+                s.buffer = u[0];
+                op = u[1];
+            }
+            applyRemoteOp(op);
+            loadClipboardTabs(false);
+            break;
+        case 'cursor':
+            if (s.peers[msg.client]) s.peers[msg.client].cursor = msg.cursor;
+            renderRemoteCursors();
+            break;
+        case 'join':
+            s.peers[msg.client] = { name: msg.name, color: msg.color, cursor: msg.cursor };
+            renderClipboardPresence();
+            break;
+        case 'leave':
+            delete s.peers[msg.client];
+            renderClipboardPresence();
+            renderRemoteCursors();
+            break;
+        case 'attachments':
+            onAttachmentsRevision(s.tab, msg.attachments);
+            break;
+    }
+}
+
+function sendLiveOp(op) {
+    _liveSocket.send(JSON.stringify({ type: 'op', revision: _liveState.revision, op: op }));
+}
+
+/** Called on textarea input while live: sends the edit as an operation. */
+function onLiveInput() {
+    var s = _liveState;
+    var textarea = document.getElementById('shared-clipboard-textarea');
+    var op = diffOp(s.text, textarea.value);
+    s.text = textarea.value;
+    if (op.length === 1 && typeof op[0] === 'number' && op[0] > 0) return; // nothing changed
+    if (op.length === 0) return;
+    movePeerCursors(op);
+    if (!_liveSocket || _liveSocket.readyState !== WebSocket.OPEN) {
+        return; // merged into the server content after reconnecting
+    }
+    if (s.outstanding) {
+        s.buffer = s.buffer ? composeOps(s.buffer, op) : op;
+    } else {
+        s.outstanding = op;
+        sendLiveOp(op);
+    }
+    sendLiveCursor();
+}
+
+/** Applies a remote operation to the textarea, keeping the local selection in place. */
+function applyRemoteOp(op) {
+    var textarea = document.getElementById('shared-clipboard-textarea');
+    var start = transformIndex(op, textarea.selectionStart);
+    var end = transformIndex(op, textarea.selectionEnd);
+    var focused = document.activeElement === textarea;
+    var scroll = textarea.scrollTop;
+    _liveState.text = applyOp(op, _liveState.text);
+    setLiveText(_liveState.text);
+    if (focused) textarea.setSelectionRange(start, end);
+    textarea.scrollTop = scroll;
+    movePeerCursors(op);
+}
+
+function setLiveText(text) {
+    document.getElementById('shared-clipboard-textarea').value = text;
+    document.getElementById('clipboard-char-count').textContent = 'chars: ' + text.length;
+    renderRemoteCursors();
+}
+
+function movePeerCursors(op) {
+    var peers = _liveState.peers;
+    Object.keys(peers).forEach(function (id) {
+        var c = peers[id].cursor;
+        if (c) peers[id].cursor = { anchor: transformIndex(op, c.anchor), head: transformIndex(op, c.head) };
+    });
+    renderRemoteCursors();
+}
+
+/** Shares the local selection with the other editors, throttled. */
+function sendLiveCursor() {
+    if (_liveCursorTimer || !_liveSocket || _liveSocket.readyState !== WebSocket.OPEN) return;
+    _liveCursorTimer = setTimeout(function () {
+        _liveCursorTimer = null;
+        if (!_liveState || !_liveSocket || _liveSocket.readyState !== WebSocket.OPEN) return;
+        var ta = document.getElementById('shared-clipboard-textarea');
+        var backward = ta.selectionDirection === 'backward';
+        _liveSocket.send(JSON.stringify({
+            type: 'cursor',
+            cursor: { anchor: backward ? ta.selectionEnd : ta.selectionStart, head: backward ? ta.selectionStart : ta.selectionEnd }
+        }));
+    }, LIVE_CURSOR_THROTTLE_MS);
+}
+
+/** Lists the other editors of the tab next to the auto-save status. */
+function renderClipboardPresence() {
+    var el = document.getElementById('clipboard-presence');
+    if (!el) return;
+    el.innerHTML = '';
+    if (!_liveState) return;
+    var peers = _liveState.peers;
+    Object.keys(peers).forEach(function (id) {
+        var dot = document.createElement('span');
+        dot.className = 'clipboard-presence-peer';
+        dot.style.backgroundColor = peers[id].color;
+        dot.textContent = (peers[id].name || '?').charAt(0).toUpperCase();
+        dot.title = peers[id].name + ' is editing';
+        el.appendChild(dot);
+    });
+}
+
+/**
+ * Draws the other editors' carets over the textarea. Positions come from a
+ * hidden copy of the textarea with the same text layout, measured up to
+ * each caret.
+ */
+function renderRemoteCursors() {
+    var layer = document.getElementById('clipboard-cursors');
+    if (!layer) return;
+    layer.innerHTML = '';
+    if (!_liveState) return;
+    var textarea = document.getElementById('shared-clipboard-textarea');
+    var ids = Object.keys(_liveState.peers).filter(function (id) { return _liveState.peers[id].cursor; });
+    if (!ids.length) return;
+
+    var style = window.getComputedStyle(textarea);
+    var mirror = document.createElement('div');
+    ['boxSizing', 'width', 'paddingTop', 'paddingRight', 'paddingBottom', 'paddingLeft',
+        'borderTopWidth', 'borderRightWidth', 'borderBottomWidth', 'borderLeftWidth', 'borderStyle',
+        'fontFamily', 'fontSize', 'fontWeight', 'lineHeight', 'letterSpacing', 'tabSize'].forEach(function (prop) {
+        mirror.style[prop] = style[prop];
+    });
+    mirror.style.position = 'absolute';
+    mirror.style.visibility = 'hidden';
+    mirror.style.whiteSpace = 'pre-wrap';
+    mirror.style.wordWrap = 'break-word';
+    mirror.style.overflow = 'hidden';
+    document.body.appendChild(mirror);
+
+    var text = textarea.value;
+    ids.forEach(function (id) {
+        var peer = _liveState.peers[id];
+        var head = Math.min(peer.cursor.head, text.length);
+        mirror.textContent = text.slice(0, head);
+        var marker = document.createElement('span');
+        marker.textContent = '​';
+        mirror.appendChild(marker);
+
+        var top = marker.offsetTop - textarea.scrollTop;
+        if (top < 0 || top > textarea.clientHeight) return; // scrolled out of view
+        var caret = document.createElement('div');
+        caret.className = 'remote-caret';
+        caret.style.left = (textarea.offsetLeft + marker.offsetLeft - textarea.scrollLeft) + 'px';
+        caret.style.top = (textarea.offsetTop + top) + 'px';
+        caret.style.height = marker.offsetHeight + 'px';
+        caret.style.backgroundColor = peer.color;
+        var label = document.createElement('span');
+        label.className = 'remote-caret-label';
+        label.style.backgroundColor = peer.color;
+        label.textContent = peer.name;
+        caret.appendChild(label);
+        layer.appendChild(caret);
+    });
+    document.body.removeChild(mirror);
+}
+
 // ── Clipboard multi-tab management ───────────────────────────────────────────
 var currentClipboardTab = 'default';
 var clipboardTabsCache = [];
 var clipboardTokenCache = {}; // tabName → plaintext token (in-memory only)
+var clipboardRevisions = {};  // tabName → ETag of the content last loaded or saved
+var clipboardBaseContent = {}; // tabName → content at that revision, the base for merges
 var _tabSelectionSeq  = 0;   // incremented on each selectClipboardTab call to discard stale responses
 var _tokenUnlockTabName = null; // tab name for which the unlock row is currently shown
 
 function loadClipboardTabs(selectCurrent) {
     if (selectCurrent === undefined) selectCurrent = true;
 
-    fetch('/clipboard/tabs')
+    fetch(BASE_PATH + '/clipboard/tabs')
         .then(function (r) {
             if (!r.ok) throw new Error('Failed to load tabs');
             return r.json();
         })
         .then(function (tabs) {
+            var now = Date.now();
+            tabs.forEach(function (t) {
+                if (t.expiresIn) t.deadline = now + t.expiresIn * 1000;
+            });
             clipboardTabsCache = tabs;
             renderClipboardTabs(tabs);
+            renderScreenshotTabOptions(tabs);
             if (selectCurrent) {
                 var exists = tabs.some(function (t) { return t.name === currentClipboardTab; });
                 selectClipboardTab(exists ? currentClipboardTab : 'default');
@@ -1622,10 +2359,31 @@ function renderClipboardTabs(tabs) {
             var lock = document.createElement('i');
             lock.className = 'fa fa-lock tab-lock-icon';
             label.appendChild(lock);
-            label.appendChild(document.createTextNode(tab.name));
-        } else {
-            label.textContent = tab.name;
         }
+        if (tab.attachmentCount > 0) {
+            var clip = document.createElement('i');
+            clip.className = 'fa fa-paperclip tab-lock-icon';
+            clip.title = tab.attachmentCount + ' attachment' + (tab.attachmentCount === 1 ? '' : 's');
+            label.appendChild(clip);
+        }
+        if (tab.encrypted) {
+            var key = document.createElement('i');
+            key.className = 'fa fa-key tab-lock-icon';
+            key.title = 'End-to-end encrypted';
+            label.appendChild(key);
+        }
+        if (tab.burnAfterRead) {
+            var fire = document.createElement('i');
+            fire.className = 'fa fa-fire tab-lock-icon';
+            fire.title = 'Deleted when read';
+            label.appendChild(fire);
+        } else if (tab.expiresAt) {
+            var clock = document.createElement('i');
+            clock.className = 'fa fa-clock-o tab-lock-icon';
+            clock.title = 'Expires';
+            label.appendChild(clock);
+        }
+        label.appendChild(document.createTextNode(tab.name));
         label.onclick = (function (name) {
             return function () { selectClipboardTab(name); };
         })(tab.name);
@@ -1655,11 +2413,41 @@ function selectClipboardTab(name) {
         el.classList.toggle('active', el.dataset.tabName === name);
     });
 
+    // Loading a burn-after-read tab with content would delete it: ask first
+    var cached = clipboardTabsCache.find(function (t) { return t.name === name; });
+    clearClipboardAttachments();
+    hideBurnRevealRow();
+    hidePassphraseRow();
+    if (cached && cached.encrypted && !clipboardKeyCache[name]) {
+        disconnectClipboardSync();
+        document.getElementById('shared-clipboard-textarea').value = '';
+        document.getElementById('clipboard-char-count').textContent = 'chars: 0';
+        setClipboardEditable(false);
+        updateClipboardMeta(cached);
+        updateForgetTokenBtn();
+        if (!webCryptoAvailable()) {
+            showToast('Encrypted tabs need HTTPS (or localhost)', 'error');
+            return;
+        }
+        showPassphraseRow(name, function () { selectClipboardTab(name); });
+        return;
+    }
+    if (cached && cached.burnAfterRead && cached.size > 0) {
+        disconnectClipboardSync();
+        document.getElementById('shared-clipboard-textarea').value = '';
+        document.getElementById('clipboard-char-count').textContent = 'chars: 0';
+        setClipboardEditable(false);
+        updateClipboardMeta(cached);
+        updateForgetTokenBtn();
+        document.getElementById('burn-reveal-row').style.display = 'flex';
+        return;
+    }
+
     var headers = {};
     var cachedToken = clipboardTokenCache[name];
     if (cachedToken) headers['X-Tab-Token'] = cachedToken;
 
-    fetch('/clipboard?tab=' + encodeURIComponent(name), { headers: headers })
+    fetch(BASE_PATH + '/clipboard?tab=' + encodeURIComponent(name), { headers: headers })
         .then(function (r) {
             if (seq !== _tabSelectionSeq) return null; // stale response — a newer call supersedes this one
             if (r.status === 401) {
@@ -1671,11 +2459,19 @@ function selectClipboardTab(name) {
                 return null;
             }
             if (!r.ok) throw new Error('Tab not found');
-            return r.text();
+            if (r.headers.get('X-Tab-Burned')) {
+                // Someone filled the tab since the list was loaded: this read burned it
+                return r.text()
+                    .then(function (content) { return decodeTabContent(name, content); })
+                    .then(function (content) { showBurnedContent(name, content); return null; });
+            }
+            clipboardRevisions[name] = r.headers.get('ETag');
+            return r.text().then(function (content) { return decodeTabContent(name, content); });
         })
         .then(function (content) {
             if (content === null || content === undefined) return;
             if (seq !== _tabSelectionSeq) return; // stale response
+            clipboardBaseContent[name] = content;
             hideTokenUnlockRow();
             setClipboardEditable(true);
             document.getElementById('shared-clipboard-textarea').value = content;
@@ -1685,19 +2481,119 @@ function selectClipboardTab(name) {
                 updateClipboardMeta(entry);
             }
             updateForgetTokenBtn();
-            // Connect SSE stream for real-time updates from other clients
-            connectClipboardSSE(name);
+            loadClipboardAttachments(name);
+            if (entry && entry.burnAfterRead) {
+                // Any sync would read the content; the writer just saves with POST
+                disconnectClipboardSync();
+                return;
+            }
+            if (entry && entry.encrypted) {
+                // The server can't merge edits of ciphertext: follow saves over SSE
+                connectClipboardSSE(name);
+                return;
+            }
+            // Edit live with other clients (or follow their saves over SSE)
+            connectClipboardLive(name);
         })
-        .catch(function (err) { if (seq === _tabSelectionSeq) console.error('Error loading tab:', err); });
+        .catch(function (err) {
+            if (seq !== _tabSelectionSeq) return;
+            console.error('Error loading tab:', err);
+            if (cached && cached.encrypted) showToast(err.message, 'error');
+        });
 }
 
 function updateClipboardMeta(tab) {
     var updEl = document.getElementById('clipboard-updated');
+    renderClipboardExpiry(tab);
     if (!tab || !tab.updatedAt) { updEl.textContent = 'updated: --'; return; }
     var d = new Date(tab.updatedAt);
     updEl.textContent = 'updated: ' + d.toLocaleTimeString();
 }
 
+// ── Expiring and burn-after-read tabs ───────────────────────────────────────
+
+var _clipboardExpiryTimer = null;
+
+/** Shows the remaining lifetime of tab and counts it down every second. */
+function renderClipboardExpiry(tab) {
+    var el = document.getElementById('clipboard-expiry');
+    clearInterval(_clipboardExpiryTimer);
+    _clipboardExpiryTimer = null;
+    if (!el) return;
+    if (!tab || !tab.deadline) {
+        el.textContent = tab && tab.burnAfterRead ? 'deleted when read' : '';
+        return;
+    }
+    function tick() {
+        var left = Math.ceil((tab.deadline - Date.now()) / 1000);
+        if (left <= 0) {
+            clearInterval(_clipboardExpiryTimer);
+            _clipboardExpiryTimer = null;
+            el.textContent = '';
+            if (currentClipboardTab === tab.name) {
+                showToast('Tab "' + tab.name + '" expired', 'error');
+                loadClipboardTabs(true);
+            }
+            return;
+        }
+        el.textContent = 'expires in ' + formatRemaining(left) + (tab.burnAfterRead ? ' or when read' : '');
+    }
+    tick();
+    _clipboardExpiryTimer = setInterval(tick, 1000);
+}
+
+function formatRemaining(seconds) {
+    var d = Math.floor(seconds / 86400), h = Math.floor(seconds % 86400 / 3600),
+        m = Math.floor(seconds % 3600 / 60), s = seconds % 60;
+    if (d) return d + 'd ' + h + 'h';
+    if (h) return h + 'h ' + m + 'm';
+    if (m) return m + 'm ' + s + 's';
+    return s + 's';
+}
+
+function hideBurnRevealRow() {
+    document.getElementById('burn-reveal-row').style.display = 'none';
+}
+
+/** Reads the current burn-after-read tab, which deletes it on the server. */
+function revealBurnTab() {
+    var name = currentClipboardTab;
+    var headers = {};
+    if (clipboardTokenCache[name]) headers['X-Tab-Token'] = clipboardTokenCache[name];
+
+    fetch(BASE_PATH + '/clipboard?tab=' + encodeURIComponent(name), { headers: headers })
+        .then(function (r) {
+            if (r.status === 401) {
+                showTokenUnlockRow(name, revealBurnTab);
+                return;
+            }
+            if (r.status === 404) throw new Error('Someone else already read this tab');
+            if (!r.ok) throw new Error('Cannot read the tab');
+            return r.text()
+                .then(function (content) { return decodeTabContent(name, content); })
+                .then(function (content) { showBurnedContent(name, content); });
+        })
+        .catch(function (err) {
+            hideBurnRevealRow();
+            showToast(err.message, 'error');
+            loadClipboardTabs(true);
+        });
+}
+
+/** Shows the content of a tab that was just burned, read-only. */
+function showBurnedContent(name, content) {
+    hideBurnRevealRow();
+    disconnectClipboardSync();
+    setClipboardEditable(false);
+    var textarea = document.getElementById('shared-clipboard-textarea');
+    textarea.value = content;
+    textarea.placeholder = '';
+    document.getElementById('clipboard-char-count').textContent = 'chars: ' + content.length;
+    renderClipboardExpiry(null);
+    showToast('Tab "' + name + '" was deleted after reading — copy what you need now');
+    loadClipboardTabs(false);
+}
+
 function showNewTabInput() {
     var row = document.getElementById('new-tab-input-row');
     row.style.display = 'flex';
@@ -1705,11 +2601,17 @@ function showNewTabInput() {
     document.getElementById('new-tab-protect').checked = false;
     document.getElementById('new-tab-custom-token').value = '';
     document.getElementById('new-tab-custom-token').style.display = 'none';
+    document.getElementById('new-tab-ttl').value = '';
+    document.getElementById('new-tab-burn').checked = false;
+    document.getElementById('new-tab-encrypt').checked = false;
+    togglePassphraseInput(false);
     document.getElementById('new-tab-name').focus();
 }
 
 function hideNewTabInput() {
     document.getElementById('new-tab-input-row').style.display = 'none';
+    document.getElementById('new-tab-encrypt').checked = false;
+    togglePassphraseInput(false);
     document.getElementById('new-tab-protect').checked = false;
     document.getElementById('new-tab-custom-token').value = '';
     document.getElementById('new-tab-custom-token').style.display = 'none';
@@ -1727,6 +2629,10 @@ function createClipboardTab() {
     var name = nameInput.value.trim();
     var protect = document.getElementById('new-tab-protect').checked;
     var customToken = protect ? document.getElementById('new-tab-custom-token').value : '';
+    var ttl = document.getElementById('new-tab-ttl').value;
+    var burn = document.getElementById('new-tab-burn').checked;
+    var encrypt = document.getElementById('new-tab-encrypt').checked;
+    var passphrase = encrypt ? document.getElementById('new-tab-passphrase').value : '';
 
     if (!name) { showToast('Tab name cannot be empty', 'error'); return; }
     if (!/^[a-zA-Z0-9 _-]{1,50}$/.test(name)) {
@@ -1737,23 +2643,43 @@ function createClipboardTab() {
         showToast('Custom password must be at least 6 characters', 'error');
         return;
     }
+    if (encrypt && passphrase.length < 8) {
+        showToast('Encryption passphrase must be at least 8 characters', 'error');
+        return;
+    }
+    if (encrypt && !webCryptoAvailable()) {
+        showToast('Encrypted tabs need HTTPS (or localhost)', 'error');
+        return;
+    }
 
     var headers = { 'Content-Type': 'text/plain' };
     if (protect) {
         headers['X-Tab-Token-Create'] = '1';
         if (customToken) headers['X-Tab-Token-Value'] = customToken;
     }
+    if (ttl) headers['X-Tab-TTL'] = ttl;
+    if (burn) headers['X-Tab-Burn-After-Read'] = '1';
 
-    fetch('/clipboard?tab=' + encodeURIComponent(name), {
-        method: 'POST',
-        headers: headers,
-        body: ''
-    })
+    var tabKey = null;
+    var prepared = encrypt ? newEncryptedTabHeaders(passphrase) : Promise.resolve(null);
+    prepared
+        .then(function (enc) {
+            if (enc) {
+                tabKey = enc.key;
+                Object.keys(enc.headers).forEach(function (h) { headers[h] = enc.headers[h]; });
+            }
+            return fetch(BASE_PATH + '/clipboard?tab=' + encodeURIComponent(name), {
+                method: 'POST',
+                headers: headers,
+                body: ''
+            });
+        })
         .then(function (r) {
             if (!r.ok) return r.text().then(function (t) { throw new Error(t.trim()); });
             var generatedToken = r.headers.get('X-Generated-Token');
             hideNewTabInput();
             currentClipboardTab = name;
+            if (tabKey) clipboardKeyCache[name] = tabKey;
             if (generatedToken) {
                 // Auto-generated: cache and reveal in modal
                 clipboardTokenCache[name] = generatedToken;
@@ -1764,10 +2690,15 @@ function createClipboardTab() {
             document.getElementById('shared-clipboard-textarea').value = '';
             document.getElementById('clipboard-char-count').textContent = 'chars: 0';
             clearClipboardImagePreview();
+            hideBurnRevealRow();
             setClipboardEditable(true);
             loadClipboardTabs(false);
-            // Connect SSE for the newly created tab immediately.
-            connectClipboardSSE(name);
+            // Start editing the newly created tab live immediately. Burn-after-read
+            // tabs are only ever written with POST: syncing would read them.
+            // Encrypted tabs can't be merged by the server, so they use SSE.
+            if (burn) disconnectClipboardSync();
+            else if (encrypt) connectClipboardSSE(name);
+            else connectClipboardLive(name);
             if (generatedToken) {
                 showTokenRevealModal(generatedToken, name);
             } else if (protect && customToken) {
@@ -1823,7 +2754,7 @@ function deleteClipboardTab(name) {
     var token = clipboardTokenCache[name];
     if (token) headers['X-Tab-Token'] = token;
 
-    fetch('/clipboard?tab=' + encodeURIComponent(name), { method: 'DELETE', headers: headers })
+    fetch(BASE_PATH + '/clipboard?tab=' + encodeURIComponent(name), { method: 'DELETE', headers: headers })
         .then(function (r) {
             if (r.status === 401) {
                 showTokenUnlockRow(name, function () { deleteClipboardTab(name); });
@@ -1839,6 +2770,293 @@ function deleteClipboardTab(name) {
         .catch(function (err) { showToast(err.message, 'error'); });
 }
 
+// ── Clipboard tab attachments ───────────────────────────────────────────────
+
+var clipboardAttachmentsRev = {}; // tabName → attachment revision last loaded
+
+function attachmentHeaders(tab) {
+    var headers = {};
+    var token = clipboardTokenCache[tab];
+    if (token) headers['X-Tab-Token'] = token;
+    return headers;
+}
+
+/** Whether tab can hold attachments: burn-after-read and encrypted tabs can't. */
+function tabTakesAttachments(tab) {
+    var entry = tabEntry(tab);
+    return !!entry && !entry.burnAfterRead && !entry.encrypted;
+}
+
+/** Hides the attachment list and button until the tab is loaded. */
+function clearClipboardAttachments() {
+    document.getElementById('clipboard-attachments').style.display = 'none';
+    document.getElementById('clipboard-attachments-list').innerHTML = '';
+    document.getElementById('clipboard-attach-btn').style.display = 'none';
+}
+
+function loadClipboardAttachments(tab) {
+    if (!tabTakesAttachments(tab)) {
+        clearClipboardAttachments();
+        return;
+    }
+    fetch(BASE_PATH + '/clipboard/attachments?tab=' + encodeURIComponent(tab), { headers: attachmentHeaders(tab) })
+        .then(function (r) {
+            if (!r.ok) throw new Error('Cannot load attachments');
+            return r.json();
+        })
+        .then(function (list) {
+            if (tab !== currentClipboardTab) return;
+            renderClipboardAttachments(tab, list);
+        })
+        .catch(function (err) { console.error('Error loading attachments:', err); });
+}
+
+function renderClipboardAttachments(tab, list) {
+    var container = document.getElementById('clipboard-attachments');
+    var ul = document.getElementById('clipboard-attachments-list');
+    ul.innerHTML = '';
+    document.getElementById('clipboard-attach-btn').style.display = 'inline-flex';
+    container.style.display = list.length ? 'block' : 'none';
+    list.forEach(function (a) {
+        var li = document.createElement('li');
+        li.className = 'clipboard-attachment-item';
+
+        var icon = document.createElement('i');
+        icon.className = 'fa fa-paperclip';
+        li.appendChild(icon);
+
+        var link = document.createElement('a');
+        link.textContent = a.name;
+        link.title = 'Download ' + a.name;
+        link.onclick = function () { downloadClipboardAttachment(tab, a); };
+        li.appendChild(link);
+
+        var size = document.createElement('span');
+        size.className = 'clipboard-attachment-size';
+        size.textContent = formatFileSize(a.size);
+        li.appendChild(size);
+
+        var del = document.createElement('button');
+        del.className = 'btn-delete-attachment';
+        del.title = 'Remove ' + a.name;
+        del.innerHTML = '<i class="fa fa-trash"></i>';
+        del.onclick = function () { deleteClipboardAttachment(tab, a); };
+        li.appendChild(del);
+
+        ul.appendChild(li);
+    });
+}
+
+/** Attaches files to the current tab, one request per file. */
+function attachClipboardFiles(files) {
+    var tab = currentClipboardTab;
+    Array.prototype.reduce.call(files, function (chain, file) {
+        return chain.then(function () {
+            return fetch(BASE_PATH + '/clipboard/attachments?tab=' + encodeURIComponent(tab) + '&name=' + encodeURIComponent(file.name), {
+                method: 'POST',
+                headers: attachmentHeaders(tab),
+                body: file
+            }).then(function (r) {
+                if (!r.ok) return r.text().then(function (t) { throw new Error(file.name + ': ' + t.trim()); });
+                showToast('Attached ' + file.name);
+            });
+        });
+    }, Promise.resolve())
+        .catch(function (err) { showToast(err.message, 'error'); })
+        .then(function () {
+            loadClipboardAttachments(tab);
+            loadClipboardTabs(false);
+        });
+}
+
+function downloadClipboardAttachment(tab, a) {
+    var url = BASE_PATH + '/clipboard/attachments/file?tab=' + encodeURIComponent(tab) + '&id=' + encodeURIComponent(a.id);
+    // The token travels in a header, so fetch the file and save it from a blob URL
+    fetch(url, { headers: attachmentHeaders(tab) })
+        .then(function (r) {
+            if (!r.ok) throw new Error('Cannot download ' + a.name);
+            return r.blob();
+        })
+        .then(function (blob) {
+            var link = document.createElement('a');
+            link.href = URL.createObjectURL(blob);
+            link.download = a.name;
+            document.body.appendChild(link);
+            link.click();
+            link.remove();
+            setTimeout(function () { URL.revokeObjectURL(link.href); }, 1000);
+        })
+        .catch(function (err) { showToast(err.message, 'error'); });
+}
+
+function deleteClipboardAttachment(tab, a) {
+    if (!confirm('Remove "' + a.name + '" from this tab?')) return;
+    fetch(BASE_PATH + '/clipboard/attachments/file?tab=' + encodeURIComponent(tab) + '&id=' + encodeURIComponent(a.id), {
+        method: 'DELETE',
+        headers: attachmentHeaders(tab)
+    })
+        .then(function (r) {
+            if (!r.ok) return r.text().then(function (t) { throw new Error(t.trim()); });
+            loadClipboardAttachments(tab);
+            loadClipboardTabs(false);
+        })
+        .catch(function (err) { showToast(err.message, 'error'); });
+}
+
+/** Reloads the attachments of tab when a change event reports a new revision. */
+function onAttachmentsRevision(tab, revision) {
+    revision = revision || 0;
+    if ((clipboardAttachmentsRev[tab] || 0) === revision) return;
+    clipboardAttachmentsRev[tab] = revision;
+    if (tab === currentClipboardTab) {
+        loadClipboardAttachments(tab);
+        loadClipboardTabs(false);
+    }
+}
+
+// ── End-to-end encrypted tabs ───────────────────────────────────────────────
+//
+// The browser derives an AES-256-GCM key from the passphrase with PBKDF2 and
+// only ever sends ciphertext, as "v1:<base64 nonce>:<base64 ciphertext>".
+// The salt, iteration count and a key check live in the tab's metadata.
+
+var clipboardKeyCache = {};       // tabName → CryptoKey (in-memory only)
+var TAB_KDF_ITERATIONS = 600000;
+var TAB_KEY_CHECK = 'upgopher';   // encrypted as the key check of every tab
+var _passphraseCallback = null;
+var _passphraseTabName = null;
+
+function webCryptoAvailable() {
+    return !!(window.crypto && window.crypto.subtle);
+}
+
+function bytesToBase64(bytes) {
+    var bin = '';
+    for (var i = 0; i < bytes.length; i++) bin += String.fromCharCode(bytes[i]);
+    return btoa(bin);
+}
+
+function base64ToBytes(b64) {
+    var bin = atob(b64);
+    var bytes = new Uint8Array(bin.length);
+    for (var i = 0; i < bin.length; i++) bytes[i] = bin.charCodeAt(i);
+    return bytes;
+}
+
+function deriveTabKey(passphrase, saltB64, iterations) {
+    return crypto.subtle.importKey('raw', new TextEncoder().encode(passphrase), 'PBKDF2', false, ['deriveKey'])
+        .then(function (material) {
+            return crypto.subtle.deriveKey(
+                { name: 'PBKDF2', hash: 'SHA-256', salt: base64ToBytes(saltB64), iterations: iterations },
+                material, { name: 'AES-GCM', length: 256 }, false, ['encrypt', 'decrypt']);
+        });
+}
+
+function encryptTabText(key, text) {
+    if (text === '') return Promise.resolve('');
+    var nonce = crypto.getRandomValues(new Uint8Array(12));
+    return crypto.subtle.encrypt({ name: 'AES-GCM', iv: nonce }, key, new TextEncoder().encode(text))
+        .then(function (sealed) {
+            return 'v1:' + bytesToBase64(nonce) + ':' + bytesToBase64(new Uint8Array(sealed));
+        });
+}
+
+function decryptTabText(key, envelope) {
+    if (envelope === '') return Promise.resolve('');
+    var parts = envelope.split(':');
+    if (parts.length !== 3 || parts[0] !== 'v1') return Promise.reject(new Error('Not an encrypted envelope'));
+    return crypto.subtle.decrypt({ name: 'AES-GCM', iv: base64ToBytes(parts[1]) }, key, base64ToBytes(parts[2]))
+        .then(function (plain) { return new TextDecoder().decode(plain); });
+}
+
+function tabEntry(name) {
+    return clipboardTabsCache.find(function (t) { return t.name === name; });
+}
+
+/** Turns content read from the server into text, decrypting encrypted tabs. */
+function decodeTabContent(name, content) {
+    var entry = tabEntry(name);
+    if (!entry || !entry.encrypted) return Promise.resolve(content);
+    if (!clipboardKeyCache[name]) return Promise.reject(new Error('Tab "' + name + '" is locked'));
+    return decryptTabText(clipboardKeyCache[name], content).catch(function () {
+        throw new Error('Cannot decrypt tab "' + name + '"');
+    });
+}
+
+/** Turns text into the content sent to the server, encrypting encrypted tabs. */
+function encodeTabContent(name, text) {
+    var entry = tabEntry(name);
+    if (!entry || !entry.encrypted) return Promise.resolve(text);
+    if (!clipboardKeyCache[name]) return Promise.reject(new Error('Tab "' + name + '" is locked'));
+    return encryptTabText(clipboardKeyCache[name], text);
+}
+
+function togglePassphraseInput(checked) {
+    var input = document.getElementById('new-tab-passphrase');
+    input.style.display = checked ? 'block' : 'none';
+    if (!checked) input.value = '';
+}
+
+function showPassphraseRow(tabName, callback) {
+    _passphraseCallback = callback;
+    _passphraseTabName = tabName;
+    document.getElementById('passphrase-row').style.display = 'flex';
+    var input = document.getElementById('passphrase-input');
+    input.value = '';
+    input.focus();
+}
+
+function hidePassphraseRow() {
+    document.getElementById('passphrase-row').style.display = 'none';
+    _passphraseCallback = null;
+    _passphraseTabName = null;
+}
+
+/** Derives the key of the tab from the typed passphrase and checks it. */
+function submitTabPassphrase() {
+    var passphrase = document.getElementById('passphrase-input').value;
+    var tabName = _passphraseTabName || currentClipboardTab;
+    var callback = _passphraseCallback;
+    var entry = tabEntry(tabName);
+    if (!passphrase) { showToast('Please enter the passphrase', 'error'); return; }
+    if (!entry || !entry.encryption) return;
+    if (!webCryptoAvailable()) { showToast('Encrypted tabs need HTTPS (or localhost)', 'error'); return; }
+
+    var enc = entry.encryption;
+    deriveTabKey(passphrase, enc.salt, enc.iterations)
+        .then(function (key) {
+            return decryptTabText(key, enc.check).then(function (check) {
+                if (check !== TAB_KEY_CHECK) throw new Error();
+                return key;
+            });
+        })
+        .then(function (key) {
+            clipboardKeyCache[tabName] = key;
+            hidePassphraseRow();
+            updateForgetTokenBtn();
+            if (callback) callback();
+        })
+        .catch(function () { showToast('Wrong passphrase', 'error'); });
+}
+
+/** Returns the headers that create an encrypted tab whose key is derived from passphrase. */
+function newEncryptedTabHeaders(passphrase) {
+    var salt = bytesToBase64(crypto.getRandomValues(new Uint8Array(16)));
+    return deriveTabKey(passphrase, salt, TAB_KDF_ITERATIONS).then(function (key) {
+        return encryptTabText(key, TAB_KEY_CHECK).then(function (check) {
+            return {
+                key: key,
+                headers: {
+                    'X-Tab-Encrypted': '1',
+                    'X-Tab-Salt': salt,
+                    'X-Tab-KDF-Iterations': String(TAB_KDF_ITERATIONS),
+                    'X-Tab-Key-Check': check
+                }
+            };
+        });
+    });
+}
+
 // ── Token management ──────────────────────────────────────────────────────────
 var _tokenUnlockCallback = null;
 
@@ -1867,6 +3085,259 @@ function refreshClipboardTab() {
     }, 600);
 }
 
+// ── Clipboard history ───────────────────────────────────────────────────────
+
+var clipboardHistoryVersions = [];
+var clipboardHistorySelected = null;
+
+function openClipboardHistory() {
+    var tab = currentClipboardTab;
+    var headers = {};
+    if (clipboardTokenCache[tab]) headers['X-Tab-Token'] = clipboardTokenCache[tab];
+
+    fetch(BASE_PATH + '/clipboard/history?tab=' + encodeURIComponent(tab), { headers: headers })
+        .then(function (r) {
+            if (r.status === 401) {
+                showTokenUnlockRow(tab, openClipboardHistory);
+                return null;
+            }
+            if (!r.ok) throw new Error('Cannot load history');
+            return r.json();
+        })
+        .then(function (data) {
+            if (!data) return null;
+            // Versions of encrypted tabs are ciphertext too
+            return Promise.all((data.versions || []).map(function (v) {
+                return decodeTabContent(tab, v.content).then(function (content) {
+                    v.content = content;
+                    return v;
+                });
+            }));
+        })
+        .then(function (versions) {
+            if (!versions) return;
+            clipboardHistoryVersions = versions;
+            document.getElementById('clipboardHistoryTab').textContent = tab;
+            renderClipboardHistoryList();
+            selectClipboardVersion(clipboardHistoryVersions.length ? clipboardHistoryVersions[0].id : null);
+            document.getElementById('clipboardHistoryModal').style.display = 'flex';
+            document.body.style.overflow = 'hidden';
+        })
+        .catch(function (err) { showToast(err.message, 'error'); });
+}
+
+function closeClipboardHistory() {
+    document.getElementById('clipboardHistoryModal').style.display = 'none';
+    document.body.style.overflow = 'auto';
+    clipboardHistoryVersions = [];
+    clipboardHistorySelected = null;
+}
+
+function renderClipboardHistoryList() {
+    var list = document.getElementById('clipboardHistoryList');
+    list.innerHTML = '';
+    if (!clipboardHistoryVersions.length) {
+        list.innerHTML = '<li class="clipboard-history-empty">No previous versions</li>';
+        return;
+    }
+    clipboardHistoryVersions.forEach(function (v) {
+        var item = document.createElement('li');
+        item.className = 'clipboard-history-item';
+        item.dataset.versionId = v.id;
+        item.title = 'Saved ' + new Date(v.updatedAt).toLocaleString() + ', replaced ' + new Date(v.replacedAt).toLocaleString();
+        item.innerHTML = '<span class="clipboard-history-time">' + escapeHtml(new Date(v.replacedAt).toLocaleString()) + '</span>' +
+            '<span class="clipboard-history-size">' + v.content.length + ' chars</span>';
+        item.onclick = function () { selectClipboardVersion(v.id); };
+        list.appendChild(item);
+    });
+}
+
+function selectClipboardVersion(id) {
+    clipboardHistorySelected = id;
+    document.querySelectorAll('#clipboardHistoryList .clipboard-history-item').forEach(function (el) {
+        el.classList.toggle('active', Number(el.dataset.versionId) === id);
+    });
+    document.getElementById('clipboardHistoryRestore').disabled = (id === null);
+
+    var pre = document.getElementById('clipboardHistoryDiff');
+    var version = clipboardHistoryVersions.find(function (v) { return v.id === id; });
+    if (!version) {
+        pre.textContent = '';
+        return;
+    }
+    var current = document.getElementById('shared-clipboard-textarea').value;
+    pre.innerHTML = diffLines(current, version.content).map(function (d) {
+        var prefix = d.op === '+' ? '+ ' : d.op === '-' ? '- ' : '  ';
+        var cls = d.op === '+' ? 'diff-add' : d.op === '-' ? 'diff-del' : 'diff-same';
+        return '<span class="' + cls + '">' + escapeHtml(prefix + d.line) + '</span>';
+    }).join('\n');
+}
+
+var MAX_DIFF_CELLS = 4000000; // LCS table size limit for diffs and merges
+
+// lcsTable returns the suffix LCS lengths of line arrays a and b, or null when
+// the inputs are too large to compare line by line.
+function lcsTable(a, b) {
+    var n = a.length, m = b.length;
+    if (n * m > MAX_DIFF_CELLS) return null;
+    var lcs = [];
+    for (var i = 0; i <= n; i++) lcs.push(new Array(m + 1).fill(0));
+    for (var i = n - 1; i >= 0; i--) {
+        for (var j = m - 1; j >= 0; j--) {
+            lcs[i][j] = a[i] === b[j] ? lcs[i + 1][j + 1] + 1 : Math.max(lcs[i + 1][j], lcs[i][j + 1]);
+        }
+    }
+    return lcs;
+}
+
+// diffLines returns the line diff that turns `from` into `to`, using the
+// longest common subsequence. Very large inputs skip the LCS table and show
+// everything as replaced.
+function diffLines(from, to) {
+    var a = from.split('\n'), b = to.split('\n');
+    var n = a.length, m = b.length;
+    var lcs = lcsTable(a, b);
+    if (!lcs) {
+        return a.map(function (l) { return { op: '-', line: l }; })
+            .concat(b.map(function (l) { return { op: '+', line: l }; }));
+    }
+    var out = [];
+    var i = 0, j = 0;
+    while (i < n && j < m) {
+        if (a[i] === b[j]) { out.push({ op: ' ', line: a[i] }); i++; j++; }
+        else if (lcs[i + 1][j] >= lcs[i][j + 1]) { out.push({ op: '-', line: a[i++] }); }
+        else { out.push({ op: '+', line: b[j++] }); }
+    }
+    while (i < n) out.push({ op: '-', line: a[i++] });
+    while (j < m) out.push({ op: '+', line: b[j++] });
+    return out;
+}
+
+// matchLines maps each line of a to the line of b it is paired with in their
+// longest common subsequence, or -1.
+function matchLines(a, b) {
+    var match = new Array(a.length).fill(-1);
+    var lcs = lcsTable(a, b);
+    if (!lcs) return match;
+    var i = 0, j = 0;
+    while (i < a.length && j < b.length) {
+        if (a[i] === b[j]) { match[i++] = j++; }
+        else if (lcs[i + 1][j] >= lcs[i][j + 1]) { i++; }
+        else { j++; }
+    }
+    return match;
+}
+
+// mergeLines does a three-way line merge of mine and theirs against their
+// common base. Regions changed on both sides in different ways are kept as
+// conflict blocks with git-style markers.
+function mergeLines(base, mine, theirs) {
+    var o = base.split('\n'), a = mine.split('\n'), b = theirs.split('\n');
+    var ma = matchLines(o, a), mb = matchLines(o, b);
+    var out = [], conflicts = 0;
+    var i = 0, ia = 0, ib = 0;
+
+    function same(x, y) { return x.length === y.length && x.every(function (l, k) { return l === y[k]; }); }
+    function emitChunk(ca, cb, co) {
+        if (same(ca, co)) out.push.apply(out, cb);
+        else if (same(cb, co) || same(ca, cb)) out.push.apply(out, ca);
+        else {
+            conflicts++;
+            out.push('<<<<<<< yours');
+            out.push.apply(out, ca);
+            out.push('=======');
+            out.push.apply(out, cb);
+            out.push('>>>>>>> theirs');
+        }
+    }
+
+    for (var k = 0; k < o.length; k++) {
+        // A base line kept by both sides is a stable anchor between chunks
+        if (ma[k] < ia || mb[k] < ib) continue;
+        emitChunk(a.slice(ia, ma[k]), b.slice(ib, mb[k]), o.slice(i, k));
+        out.push(o[k]);
+        i = k + 1; ia = ma[k] + 1; ib = mb[k] + 1;
+    }
+    emitChunk(a.slice(ia), b.slice(ib), o.slice(i));
+    return { text: out.join('\n'), conflicts: conflicts };
+}
+
+// ── Clipboard save conflicts ────────────────────────────────────────────────
+
+var _clipboardConflictTab = null;
+
+function openClipboardConflict(tab) {
+    _clipboardConflictTab = tab;
+    document.getElementById('clipboardConflictTab').textContent = tab;
+    document.getElementById('clipboardConflictModal').style.display = 'flex';
+}
+
+function resolveClipboardConflict(choice) {
+    var tab = _clipboardConflictTab;
+    document.getElementById('clipboardConflictModal').style.display = 'none';
+    _clipboardConflictTab = null;
+    if (!tab || tab !== currentClipboardTab) return;
+
+    if (choice === 'theirs') {
+        _isLocallyDirty = false;
+        setAutoSaveStatus('idle');
+        fetchClipboardContent(tab);
+        return;
+    }
+
+    var headers = {};
+    if (clipboardTokenCache[tab]) headers['X-Tab-Token'] = clipboardTokenCache[tab];
+    fetch(BASE_PATH + '/clipboard?tab=' + encodeURIComponent(tab), { headers: headers })
+        .then(function (r) {
+            if (!r.ok) throw new Error('Cannot load the latest version');
+            var etag = r.headers.get('ETag');
+            return r.text()
+                .then(function (content) { return decodeTabContent(tab, content); })
+                .then(function (theirs) { return { etag: etag, theirs: theirs }; });
+        })
+        .then(function (latest) {
+            var textarea = document.getElementById('shared-clipboard-textarea');
+            var mine = textarea.value;
+            clipboardRevisions[tab] = latest.etag;
+            if (choice === 'mine') {
+                saveToSharedClipboard(false);
+                return;
+            }
+            var merged = mergeLines(clipboardBaseContent[tab] || '', mine, latest.theirs);
+            clipboardBaseContent[tab] = latest.theirs;
+            textarea.value = merged.text;
+            document.getElementById('clipboard-char-count').textContent = 'chars: ' + merged.text.length;
+            _isLocallyDirty = true; // not saved yet: review first
+            setAutoSaveStatus('idle');
+            if (merged.conflicts) {
+                showToast('Merged with ' + merged.conflicts + ' conflict(s) — resolve the marked lines, then save', 'error');
+            } else {
+                showToast('Changes merged — review and save');
+            }
+        })
+        .catch(function (err) { showToast(err.message, 'error'); });
+}
+
+function restoreClipboardVersion() {
+    if (clipboardHistorySelected === null) return;
+    var tab = currentClipboardTab;
+    var headers = {};
+    if (clipboardTokenCache[tab]) headers['X-Tab-Token'] = clipboardTokenCache[tab];
+
+    fetch(BASE_PATH + '/clipboard/history?tab=' + encodeURIComponent(tab) + '&version=' + clipboardHistorySelected,
+        { method: 'POST', headers: headers })
+        .then(function (r) {
+            if (!r.ok) {
+                return r.text().then(function (text) { throw new Error(text.trim() || 'Restore failed'); });
+            }
+            closeClipboardHistory();
+            selectClipboardTab(tab);
+            loadClipboardTabs(false);
+            showToast('Version restored');
+        })
+        .catch(function (err) { showToast(err.message, 'error'); });
+}
+
 function showTokenUnlockRow(tabName, callback) {
     _tokenUnlockCallback = callback;
     _tokenUnlockTabName  = tabName; // remember which tab triggered this row
@@ -1892,17 +3363,24 @@ function submitTabToken() {
     currentClipboardTab = tabName;
     hideTokenUnlockRow();
     updateForgetTokenBtn();
+    if (tabName === screenshotsTab) selectScreenshotsTab(tabName);
     if (callback) callback();
 }
 
 function forgetTabToken() {
     delete clipboardTokenCache[currentClipboardTab];
+    delete clipboardKeyCache[currentClipboardTab];
+    disconnectClipboardSync();
     document.getElementById('shared-clipboard-textarea').value = '';
     document.getElementById('clipboard-char-count').textContent = 'chars: 0';
     setClipboardEditable(false);
+    clearClipboardAttachments();
+    if (currentClipboardTab === screenshotsTab) selectScreenshotsTab(screenshotsTab);
     var entry = clipboardTabsCache.find(function (t) { return t.name === currentClipboardTab; });
     if (entry && entry.protected) {
         showTokenUnlockRow(currentClipboardTab, function () { selectClipboardTab(currentClipboardTab); });
+    } else if (entry && entry.encrypted) {
+        showPassphraseRow(currentClipboardTab, function () { selectClipboardTab(currentClipboardTab); });
     }
     updateForgetTokenBtn();
 }
@@ -1911,7 +3389,9 @@ function updateForgetTokenBtn() {
     var btn = document.getElementById('forget-token-btn');
     if (!btn) return;
     var entry = clipboardTabsCache.find(function (t) { return t.name === currentClipboardTab; });
-    btn.style.display = (entry && entry.protected && clipboardTokenCache[currentClipboardTab]) ? 'inline-flex' : 'none';
+    var unlocked = entry && ((entry.protected && clipboardTokenCache[currentClipboardTab]) ||
+        (entry.encrypted && clipboardKeyCache[currentClipboardTab]));
+    btn.style.display = unlocked ? 'inline-flex' : 'none';
 }
 
 function showTokenRevealModal(token) {
@@ -1991,7 +3471,7 @@ function createFolder() {
     var params = new URLSearchParams(window.location.search);
     var currentPath = params.get('path') || '';
 
-    fetch('/mkdir', {
+    fetch(BASE_PATH + '/mkdir', {
         method: 'POST',
         headers: { 'Content-Type': 'application/x-www-form-urlencoded' },
         body: 'folderName=' + encodeURIComponent(folderName) + '&currentPath=' + encodeURIComponent(currentPath)
@@ -2033,7 +3513,16 @@ function deleteFolder(encodedPath) {
     if (!window.confirm("¿Estás seguro de que deseas eliminar este directorio y todo su contenido?")) {
         return;
     }
-    fetch('/delete/?path=' + encodeURIComponent(encodedPath))
+    sendDelete(encodedPath, 'folder');
+}
+
+function deleteFile(encodedPath) {
+    sendDelete(encodedPath, 'file');
+}
+
+/** Deletes a file or folder with a POST, which other sites can't forge, and reloads the listing. */
+function sendDelete(encodedPath, what) {
+    fetch(BASE_PATH + '/delete/?path=' + encodeURIComponent(encodedPath), { method: 'POST' })
         .then(function (response) {
             if (response.ok || response.redirected) {
                 window.location.reload();
@@ -2041,11 +3530,11 @@ function deleteFolder(encodedPath) {
             }
             if (response.status === 403) {
                 return response.text().then(function (text) {
-                    showErrorModal(text.trim() || 'Cannot delete directory.');
+                    showErrorModal(text.trim() || 'Cannot delete ' + what + '.');
                 });
             }
             return response.text().then(function (text) {
-                showErrorModal(text.trim() || 'An error occurred while deleting the folder.');
+                showErrorModal(text.trim() || 'An error occurred while deleting the ' + what + '.');
             });
         })
         .catch(function (error) {
@@ -2094,7 +3583,7 @@ function loadTreeRoot() {
 
     container.innerHTML = '<div class="tree-loading">Loading directory tree…</div>';
 
-    fetch('/api/v1/tree?depth=1')
+    fetch(BASE_PATH + '/api/v1/tree?depth=1')
         .then(function (r) {
             if (!r.ok) throw new Error('Failed to load tree');
             return r.json();
@@ -2241,7 +3730,7 @@ function renderTreeNode(node, parentEl, isRoot) {
  * @param {Function} onDone       - called after rendering
  */
 function loadTreeChildren(encodedPath, containerEl, onDone) {
-    fetch('/api/v1/tree?path=' + encodeURIComponent(encodedPath) + '&depth=1')
+    fetch(BASE_PATH + '/api/v1/tree?path=' + encodeURIComponent(encodedPath) + '&depth=1')
         .then(function (r) {
             if (!r.ok) throw new Error('fetch failed');
             return r.json();
@@ -2272,7 +3761,7 @@ function expandAllTree() {
 
     container.innerHTML = '<div class="tree-loading">Loading full tree…</div>';
 
-    fetch('/api/v1/tree?depth=10') // cap at 10 levels for performance
+    fetch(BASE_PATH + '/api/v1/tree?depth=10') // cap at 10 levels for performance
         .then(function (r) {
             if (!r.ok) throw new Error('Failed to load tree');
             return r.json();
@@ -2324,9 +3813,9 @@ function collapseAllTree() {
  */
 function navigateToFolder(encodedPath) {
     if (encodedPath === '' || encodedPath === null || encodedPath === undefined) {
-        window.location.href = '/';
+        window.location.href = BASE_PATH + '/';
     } else {
-        window.location.href = '/?path=' + encodeURIComponent(encodedPath);
+        window.location.href = BASE_PATH + '/?path=' + encodeURIComponent(encodedPath);
     }
 }
 
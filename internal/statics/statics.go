@@ -7,7 +7,7 @@ import (
 	"strings"
 )
 
-//go:embed templates css js
+//go:embed templates css js api
 var staticFiles embed.FS
 
 // indexTemplate is the main HTML template
@@ -16,9 +16,9 @@ var indexTemplate *template.Template
 // TemplateData holds the data for the template
 type TemplateData struct {
 	CSS            template.CSS
-	Table          template.HTML
 	CurrentPath    string
 	DownloadButton template.HTML
+	BasePath       string
 	HiddenDisplay  string
 	ReadOnlyMode   bool
 	JavaScript     template.JS
@@ -33,7 +33,7 @@ func init() {
 }
 
 // GetTemplates generates HTML with embedded resources
-func GetTemplates(table string, currentPath string, downloadButton string, disableHiddenFiles bool, readOnly bool) string {
+func GetTemplates(currentPath string, downloadButton string, basePath string, disableHiddenFiles bool, readOnly bool) string {
 	cssBytes, err := fs.ReadFile(staticFiles, "css/styles.css")
 	if err != nil {
 		panic("Error reading CSS: " + err.Error())
@@ -55,9 +55,9 @@ func GetTemplates(table string, currentPath string, downloadButton string, disab
 
 	data := TemplateData{
 		CSS:            template.CSS(cssString),
-		Table:          template.HTML(table),
 		CurrentPath:    currentPath,
 		DownloadButton: template.HTML(downloadButton),
+		BasePath:       basePath,
 		HiddenDisplay:  hiddenDisplay,
 		ReadOnlyMode:   readOnly,
 		JavaScript:     template.JS(string(jsBytes)),
@@ -70,3 +70,12 @@ func GetTemplates(table string, currentPath string, downloadButton string, disab
 
 	return builder.String()
 }
+
+// OpenAPISpec returns the embedded OpenAPI 3 document describing the HTTP API
+func OpenAPISpec() []byte {
+	spec, err := fs.ReadFile(staticFiles, "api/openapi.json")
+	if err != nil {
+		panic("Error reading OpenAPI document: " + err.Error())
+	}
+	return spec
+}
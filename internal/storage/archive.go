@@ -0,0 +1,376 @@
+package storage
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// archiveExts are the file name extensions NewArchive knows, by format.
+var archiveExts = map[string]string{
+	".zip":    "zip",
+	".tar":    "tar",
+	".tar.gz": "tar.gz",
+	".tgz":    "tar.gz",
+}
+
+// archiveFormat returns the format of the archive file name, or "" if its
+// extension isn't one of archiveExts.
+func archiveFormat(name string) string {
+	lower := strings.ToLower(name)
+	for ext, format := range archiveExts {
+		if strings.HasSuffix(lower, ext) && len(lower) > len(ext) {
+			return format
+		}
+	}
+	return ""
+}
+
+// IsArchive reports whether name has the extension of an archive NewArchive
+// can serve: .zip, .tar, .tar.gz or .tgz.
+func IsArchive(name string) bool {
+	return archiveFormat(name) != ""
+}
+
+// Archive is a read-only Storage serving the contents of a zip, tar or
+// gzipped tar file without extracting it. Entries whose names lead outside
+// the archive, links and other special files are left out; directories
+// missing from the archive are implied by the names under them. Writes fail
+// with fs.ErrPermission.
+type Archive struct {
+	file     *os.File
+	entries  map[string]*archiveEntry // by name, "." being the root
+	children map[string][]string      // names of the entries of each directory, sorted
+}
+
+type archiveEntry struct {
+	info *fileInfo
+	// open returns the content of a file from its start. When the content
+	// is stored uncompressed, section gives it directly instead.
+	open    func() (io.ReadCloser, error)
+	section *io.SectionReader
+}
+
+// NewArchive opens the archive file name, whose format is told by its
+// extension, and indexes its entries. The file stays open until Close.
+func NewArchive(name string) (*Archive, error) {
+	format := archiveFormat(name)
+	if format == "" {
+		return nil, fmt.Errorf("%s is not a .zip, .tar, .tar.gz or .tgz archive", name)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	a := &Archive{
+		file:    f,
+		entries: map[string]*archiveEntry{".": {info: &fileInfo{name: ".", dir: true, modTime: info.ModTime()}}},
+	}
+	switch format {
+	case "zip":
+		err = a.indexZip(info.Size())
+	case "tar":
+		err = a.indexTar(info.Size(), false)
+	default:
+		err = a.indexTar(info.Size(), true)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("reading %s: %w", name, err)
+	}
+
+	a.children = make(map[string][]string)
+	for entry := range a.entries {
+		if entry != "." {
+			a.children[path.Dir(entry)] = append(a.children[path.Dir(entry)], entry)
+		}
+	}
+	for _, names := range a.children {
+		sort.Slice(names, func(i, j int) bool { return path.Base(names[i]) < path.Base(names[j]) })
+	}
+	return a, nil
+}
+
+// Close closes the archive file.
+func (a *Archive) Close() error {
+	return a.file.Close()
+}
+
+// add indexes the entry of the archive named raw, unless that name leads
+// outside the archive. A later file replaces an earlier one, as extracting
+// would, but a directory is never replaced by a file.
+func (a *Archive) add(raw string, e *archiveEntry) {
+	name, ok := Clean(raw)
+	if !ok || name == "." {
+		return
+	}
+	if existing, ok := a.entries[name]; ok && existing.info.dir && !e.info.dir {
+		return
+	}
+	e.info.name = path.Base(name)
+	a.entries[name] = e
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if existing, ok := a.entries[dir]; ok && existing.info.dir {
+			break
+		}
+		a.entries[dir] = &archiveEntry{info: &fileInfo{name: path.Base(dir), dir: true, modTime: e.info.modTime}}
+	}
+}
+
+func (a *Archive) indexZip(size int64) error {
+	r, err := zip.NewReader(a.file, size)
+	if err != nil {
+		return err
+	}
+	for _, zf := range r.File {
+		zf := zf
+		mode := zf.Mode()
+		if !mode.IsDir() && !mode.IsRegular() {
+			continue
+		}
+		e := &archiveEntry{info: &fileInfo{dir: mode.IsDir(), size: int64(zf.UncompressedSize64), modTime: zf.Modified}}
+		if !e.info.dir {
+			e.open = zf.Open
+			if offset, err := zf.DataOffset(); err == nil && zf.Method == zip.Store && zf.CompressedSize64 == zf.UncompressedSize64 {
+				e.section = io.NewSectionReader(a.file, offset, e.info.size)
+			}
+		}
+		a.add(zf.Name, e)
+	}
+	return nil
+}
+
+// indexTar indexes a tar archive, gzipped if gzipped is set. Files of a
+// plain tar are read in place; those of a gzipped one by decompressing the
+// archive up to them.
+func (a *Archive) indexTar(size int64, gzipped bool) error {
+	tr, r, closer, err := a.openTar(size, gzipped)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+	for index := 0; ; index++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		e := &archiveEntry{info: &fileInfo{size: hdr.Size, modTime: hdr.ModTime}}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			e.info.dir, e.info.size = true, 0
+		case tar.TypeReg, tar.TypeRegA:
+		default:
+			continue
+		}
+		if !e.info.dir {
+			index := index
+			e.open = func() (io.ReadCloser, error) { return a.openTarEntry(size, gzipped, index) }
+			if !gzipped && !isSparse(hdr) {
+				// The tar reader stops at the start of the file's data.
+				if offset, err := r.Seek(0, io.SeekCurrent); err == nil {
+					e.section = io.NewSectionReader(a.file, offset, hdr.Size)
+				}
+			}
+		}
+		a.add(hdr.Name, e)
+	}
+}
+
+// openTar returns a tar reader of the archive, the reader of the archive
+// file under it and what to close once done.
+func (a *Archive) openTar(size int64, gzipped bool) (*tar.Reader, *io.SectionReader, io.Closer, error) {
+	r := io.NewSectionReader(a.file, 0, size)
+	if !gzipped {
+		return tar.NewReader(r), r, io.NopCloser(r), nil
+	}
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return tar.NewReader(zr), r, zr, nil
+}
+
+// openTarEntry returns the content of the index-th entry of the archive.
+func (a *Archive) openTarEntry(size int64, gzipped bool, index int) (io.ReadCloser, error) {
+	tr, _, closer, err := a.openTar(size, gzipped)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i <= index; i++ {
+		if _, err := tr.Next(); err != nil {
+			closer.Close()
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{tr, closer}, nil
+}
+
+// isSparse reports whether the data of hdr's file is stored sparse, so not
+// as one run of bytes.
+func isSparse(hdr *tar.Header) bool {
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for k := range hdr.PAXRecords {
+		if strings.HasPrefix(k, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup returns the entry of name.
+func (a *Archive) lookup(op, name string) (*archiveEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	e, ok := a.entries[name]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return e, nil
+}
+
+func (a *Archive) Stat(name string) (fs.FileInfo, error) {
+	e, err := a.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return e.info, nil
+}
+
+func (a *Archive) ReadDir(name string) ([]fs.DirEntry, error) {
+	e, err := a.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !e.info.dir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errNotDir}
+	}
+	entries := make([]fs.DirEntry, 0, len(a.children[name]))
+	for _, child := range a.children[name] {
+		entries = append(entries, a.entries[child].info)
+	}
+	return entries, nil
+}
+
+func (a *Archive) Open(name string) (File, error) {
+	e, err := a.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if e.section != nil {
+		return &archiveSection{SectionReader: io.NewSectionReader(e.section, 0, e.section.Size()), info: e.info}, nil
+	}
+	return &archiveFile{entry: e}, nil
+}
+
+// readOnly returns the error of the write operation op on name.
+func readOnly(op, name string) error {
+	return &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+}
+
+func (a *Archive) Create(name string) (io.WriteCloser, error) { return nil, readOnly("create", name) }
+func (a *Archive) Rename(oldname, newname string) error       { return readOnly("rename", oldname) }
+func (a *Archive) RemoveAll(name string) error                { return readOnly("remove", name) }
+func (a *Archive) Mkdir(name string) error                    { return readOnly("mkdir", name) }
+func (a *Archive) MkdirAll(name string) error                 { return readOnly("mkdir", name) }
+
+// archiveSection is a file stored uncompressed, read in place.
+type archiveSection struct {
+	*io.SectionReader
+	info *fileInfo
+}
+
+func (f *archiveSection) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *archiveSection) Close() error               { return nil }
+
+// archiveFile is a compressed file. Compressed data can only be read from
+// its start, so seeking backwards reopens the file, and seeking forwards
+// skips what lies in between at the next Read.
+type archiveFile struct {
+	entry  *archiveEntry
+	offset int64
+	r      io.ReadCloser // nil until read
+	pos    int64         // offset r is at
+}
+
+func (f *archiveFile) Stat() (fs.FileInfo, error) { return f.entry.info, nil }
+
+func (f *archiveFile) Read(p []byte) (int, error) {
+	if f.entry.info.dir {
+		return 0, &fs.PathError{Op: "read", Path: f.entry.info.name, Err: errors.New("is a directory")}
+	}
+	if f.offset >= f.entry.info.size {
+		return 0, io.EOF
+	}
+	if f.r != nil && f.pos > f.offset {
+		f.r.Close()
+		f.r = nil
+	}
+	if f.r == nil {
+		r, err := f.entry.open()
+		if err != nil {
+			return 0, err
+		}
+		f.r, f.pos = r, 0
+	}
+	if f.pos < f.offset {
+		n, err := io.CopyN(io.Discard, f.r, f.offset-f.pos)
+		f.pos += n
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+	}
+	n, err := f.r.Read(p)
+	f.pos += int64(n)
+	f.offset = f.pos
+	return n, err
+}
+
+func (f *archiveFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.entry.info.size
+	}
+	if offset < 0 {
+		return 0, errors.New("archive: negative position")
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *archiveFile) Close() error {
+	if f.r != nil {
+		f.r.Close()
+		f.r = nil
+	}
+	return nil
+}
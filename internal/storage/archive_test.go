@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// archiveFiles are written to every test archive, in this order.
+var archiveFiles = []struct {
+	name, content string
+}{
+	{"./README.md", "# Release"},
+	{"bin/tool", strings.Repeat("tool binary ", 100)},
+	{"docs/guide/intro.txt", "hello, archive"},
+	{"../escape.txt", "outside"},
+	{"/etc/passwd", "absolute"},
+	{"docs/guide/intro.txt", "hello, again"}, // replaces the first one
+}
+
+func writeZip(t *testing.T, name string) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i, f := range archiveFiles {
+		method := zip.Deflate
+		if i%2 == 0 {
+			method = zip.Store
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: method, Modified: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, f.content)
+	}
+	if _, err := zw.Create("empty/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTar(t *testing.T, name string, gzipped bool) {
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var zw *gzip.Writer
+	if gzipped {
+		zw = gzip.NewWriter(&buf)
+		w = zw
+	}
+	tw := tar.NewWriter(w)
+	for _, f := range archiveFiles {
+		hdr := &tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.content)), ModTime: time.Now(), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(tw, f.content)
+	}
+	for _, hdr := range []*tar.Header{
+		{Name: "empty/", Mode: 0755, Typeflag: tar.TypeDir},
+		{Name: "link", Linkname: "/etc/passwd", Typeflag: tar.TypeSymlink},
+	} {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if zw != nil {
+		zw.Close()
+	}
+	if err := os.WriteFile(name, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"bundle.zip", "bundle.tar", "bundle.tar.gz", "bundle.TGZ"} {
+		file := filepath.Join(dir, name)
+		if strings.HasSuffix(name, ".zip") {
+			writeZip(t, file)
+		} else {
+			writeTar(t, file, !strings.HasSuffix(name, ".tar"))
+		}
+		a, err := NewArchive(file)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		defer a.Close()
+
+		var got []string
+		err = Walk(a, ".", func(name string, info fs.FileInfo) error {
+			got = append(got, fmt.Sprintf("%s:%v", name, info.IsDir()))
+			return nil
+		})
+		want := "[.:true README.md:false bin:true bin/tool:false docs:true docs/guide:true docs/guide/intro.txt:false empty:true etc:true etc/passwd:false]"
+		if err != nil || fmt.Sprint(got) != want {
+			t.Errorf("%s: Walk = %v, %v, want %v", name, got, err, want)
+		}
+
+		if got := readFile(t, a, "docs/guide/intro.txt"); got != "hello, again" {
+			t.Errorf("%s: replaced file = %q", name, got)
+		}
+		if info, err := a.Stat("bin/tool"); err != nil || info.Size() != 1200 {
+			t.Errorf("%s: Stat = %v, %v", name, info, err)
+		}
+
+		// Seeking back and forth works whether the file is compressed or
+		// stored.
+		for _, file := range []string{"bin/tool", "README.md"} {
+			f, err := a.Open(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			content := readFile(t, a, file)
+			for _, offset := range []int64{5, 2, 7} {
+				if _, err := f.Seek(offset, io.SeekStart); err != nil {
+					t.Fatal(err)
+				}
+				buf := make([]byte, 2)
+				if _, err := io.ReadFull(f, buf); err != nil || string(buf) != content[offset:offset+2] {
+					t.Errorf("%s: %s at %d = %q, %v", name, file, offset, buf, err)
+				}
+			}
+			if end, _ := f.Seek(0, io.SeekEnd); end != int64(len(content)) {
+				t.Errorf("%s: %s ends at %d", name, file, end)
+			}
+			f.Close()
+		}
+
+		if _, err := a.Create("new.txt"); !errors.Is(err, fs.ErrPermission) {
+			t.Errorf("%s: Create = %v", name, err)
+		}
+		if err := a.RemoveAll("README.md"); !errors.Is(err, fs.ErrPermission) {
+			t.Errorf("%s: RemoveAll = %v", name, err)
+		}
+		if _, err := a.Stat("link"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s: Stat of a link entry = %v", name, err)
+		}
+	}
+
+	if _, err := NewArchive(filepath.Join(dir, "bundle.rar")); err == nil {
+		t.Error("NewArchive of a .rar succeeded")
+	}
+	bad := filepath.Join(dir, "bad.zip")
+	os.WriteFile(bad, []byte("not a zip"), 0644)
+	if _, err := NewArchive(bad); err == nil {
+		t.Error("NewArchive of a corrupt zip succeeded")
+	}
+}
@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/wanetty/upgopher/internal/security"
+)
+
+// Local is a Storage backed by a directory on local disk. Symbolic links
+// inside it are followed according to its SymlinkPolicy.
+type Local struct {
+	root     string
+	symlinks security.SymlinkPolicy
+}
+
+// NewLocal returns a Storage serving the directory root, following links
+// as symlinks allows.
+func NewLocal(root string, symlinks security.SymlinkPolicy) *Local {
+	return &Local{root: root, symlinks: symlinks}
+}
+
+// Root returns the directory l serves.
+func (l *Local) Root() string {
+	return l.root
+}
+
+// path returns the path on disk of name, or an error if name is invalid or
+// reached through a link the symlink policy refuses.
+func (l *Local) path(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	full := filepath.Join(l.root, filepath.FromSlash(name))
+	if ok, err := l.symlinks.IsSafePath(l.root, full); err != nil || !ok {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+	}
+	return full, nil
+}
+
+func (l *Local) Stat(name string) (fs.FileInfo, error) {
+	full, err := l.path("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(full)
+}
+
+func (l *Local) ReadDir(name string) ([]fs.DirEntry, error) {
+	full, err := l.path("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(full)
+	if err != nil {
+		return nil, err
+	}
+	kept := entries[:0]
+	for _, e := range entries {
+		if e.Type()&fs.ModeSymlink != 0 {
+			if ok, err := l.symlinks.IsSafePath(l.root, filepath.Join(full, e.Name())); err != nil || !ok {
+				continue
+			}
+		}
+		kept = append(kept, e)
+	}
+	return kept, nil
+}
+
+func (l *Local) Open(name string) (File, error) {
+	full, err := l.path("open", name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(full)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Create creates name readable and writable by its owner only.
+func (l *Local) Create(name string) (io.WriteCloser, error) {
+	full, err := l.path("create", name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(full, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (l *Local) Rename(oldname, newname string) error {
+	oldFull, err := l.path("rename", oldname)
+	if err != nil {
+		return err
+	}
+	newFull, err := l.path("rename", newname)
+	if err != nil {
+		return err
+	}
+	return os.Rename(oldFull, newFull)
+}
+
+// RemoveAll only applies the symlink policy to the directory holding name,
+// so a link the policy refuses to follow may still be removed.
+func (l *Local) RemoveAll(name string) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
+	if _, err := l.path("remove", path.Dir(name)); err != nil {
+		return err
+	}
+	full := filepath.Join(l.root, filepath.FromSlash(name))
+	if _, err := os.Lstat(full); err != nil {
+		return err
+	}
+	return os.RemoveAll(full)
+}
+
+func (l *Local) Mkdir(name string) error {
+	full, err := l.path("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return os.Mkdir(full, 0755)
+}
+
+func (l *Local) MkdirAll(name string) error {
+	full, err := l.path("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(full, 0755)
+}
@@ -0,0 +1,235 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory is a Storage held in memory, for tests. It has no symbolic links.
+type Memory struct {
+	mu    sync.RWMutex
+	nodes map[string]*memNode // by name, "." being the root
+}
+
+type memNode struct {
+	dir     bool
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemory returns an empty Memory storage.
+func NewMemory() *Memory {
+	return &Memory{nodes: map[string]*memNode{".": {dir: true, modTime: time.Now()}}}
+}
+
+// errNotDir is returned when a name's parent is a file.
+var errNotDir = errors.New("not a directory")
+
+// lookup returns the node of name. The caller holds m.mu.
+func (m *Memory) lookup(op, name string) (*memNode, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	n, ok := m.nodes[name]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return n, nil
+}
+
+// add creates the node of name, whose parent must be an existing
+// directory. The caller holds m.mu for writing.
+func (m *Memory) add(op, name string, n *memNode) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if _, ok := m.nodes[name]; ok {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrExist}
+	}
+	parent, ok := m.nodes[path.Dir(name)]
+	if !ok {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	if !parent.dir {
+		return &fs.PathError{Op: op, Path: name, Err: errNotDir}
+	}
+	m.nodes[name] = n
+	return nil
+}
+
+// WriteFile creates or replaces the file name, creating its parents.
+func (m *Memory) WriteFile(name string, data []byte) error {
+	if err := m.MkdirAll(path.Dir(name)); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if n, ok := m.nodes[name]; ok && !n.dir {
+		n.data, n.modTime = append([]byte(nil), data...), time.Now()
+		return nil
+	}
+	return m.add("write", name, &memNode{data: append([]byte(nil), data...), modTime: time.Now()})
+}
+
+func (m *Memory) Stat(name string) (fs.FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	n, err := m.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return n.info(name), nil
+}
+
+func (m *Memory) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	n, err := m.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !n.dir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errNotDir}
+	}
+	var entries []fs.DirEntry
+	for child, cn := range m.nodes {
+		if child != "." && path.Dir(child) == name {
+			entries = append(entries, cn.info(child))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (m *Memory) Open(name string) (File, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	n, err := m.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return &memFile{Reader: bytes.NewReader(n.data), info: n.info(name)}, nil
+}
+
+func (m *Memory) Create(name string) (io.WriteCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := &memNode{modTime: time.Now()}
+	if err := m.add("create", name, n); err != nil {
+		return nil, err
+	}
+	return &memWriter{m: m, node: n}, nil
+}
+
+func (m *Memory) Rename(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, err := m.lookup("rename", oldname)
+	if err != nil {
+		return err
+	}
+	if oldname == "." || oldname == newname || strings.HasPrefix(newname, oldname+"/") {
+		return &fs.PathError{Op: "rename", Path: newname, Err: fs.ErrInvalid}
+	}
+	if existing, ok := m.nodes[newname]; ok {
+		if existing.dir || n.dir {
+			return &fs.PathError{Op: "rename", Path: newname, Err: fs.ErrExist}
+		}
+		delete(m.nodes, newname)
+	}
+	if err := m.add("rename", newname, n); err != nil {
+		return err
+	}
+	delete(m.nodes, oldname)
+	for child, cn := range m.nodes {
+		if strings.HasPrefix(child, oldname+"/") {
+			delete(m.nodes, child)
+			m.nodes[newname+strings.TrimPrefix(child, oldname)] = cn
+		}
+	}
+	return nil
+}
+
+func (m *Memory) RemoveAll(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := m.lookup("remove", name); err != nil {
+		return err
+	}
+	for child := range m.nodes {
+		if name == "." || child == name || strings.HasPrefix(child, name+"/") {
+			delete(m.nodes, child)
+		}
+	}
+	if name == "." {
+		m.nodes["."] = &memNode{dir: true, modTime: time.Now()}
+	}
+	return nil
+}
+
+func (m *Memory) Mkdir(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.add("mkdir", name, &memNode{dir: true, modTime: time.Now()})
+}
+
+func (m *Memory) MkdirAll(name string) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if name == "." {
+		return nil
+	}
+	parts := strings.Split(name, "/")
+	for i := range parts {
+		dir := strings.Join(parts[:i+1], "/")
+		if n, ok := m.nodes[dir]; ok {
+			if !n.dir {
+				return &fs.PathError{Op: "mkdir", Path: dir, Err: errNotDir}
+			}
+			continue
+		}
+		if err := m.add("mkdir", dir, &memNode{dir: true, modTime: time.Now()}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// info describes n, whose name is name.
+func (n *memNode) info(name string) *fileInfo {
+	return &fileInfo{name: path.Base(name), dir: n.dir, size: int64(len(n.data)), modTime: n.modTime}
+}
+
+type memFile struct {
+	*bytes.Reader
+	info *fileInfo
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memFile) Close() error               { return nil }
+
+// memWriter fills the node it was created for when closed.
+type memWriter struct {
+	m    *Memory
+	node *memNode
+	buf  bytes.Buffer
+}
+
+func (w *memWriter) Write(p []byte) (int, error) { return w.buf.Write(p) }
+
+func (w *memWriter) Close() error {
+	w.m.mu.Lock()
+	defer w.m.mu.Unlock()
+	w.node.data, w.node.modTime = w.buf.Bytes(), time.Now()
+	return nil
+}
@@ -0,0 +1,765 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// s3PartSize is the size of the parts uploads are streamed in; S3 wants
+	// at least 5 MiB for all but the last one.
+	s3PartSize = 8 << 20
+	// s3MaxCopySize is the largest object a single CopyObject may copy;
+	// larger ones are copied part by part.
+	s3MaxCopySize = 5 << 30
+	// s3CopyPartSize is the size of the parts of those copies.
+	s3CopyPartSize = 512 << 20
+	// s3MaxDeleteKeys is how many keys a DeleteObjects request may hold.
+	s3MaxDeleteKeys = 1000
+)
+
+// S3Config configures an S3 storage.
+type S3Config struct {
+	// Endpoint is the scheme://host[:port] of the S3 API, such as
+	// https://s3.eu-west-1.amazonaws.com or http://localhost:9000 for MinIO.
+	// Buckets are addressed in the path, which every S3 implementation
+	// accepts.
+	Endpoint     string
+	Region       string
+	Bucket       string
+	Prefix       string // key prefix the share lives under, such as "team/"; empty for the whole bucket
+	AccessKey    string
+	SecretKey    string
+	SessionToken string       // temporary credentials only
+	Client       *http.Client // nil for http.DefaultClient
+}
+
+// S3 is a Storage kept in an S3 bucket. Object keys are the prefix then the
+// slash-separated names. S3 has no directories: a directory exists while
+// keys start with its name and a slash, and Mkdir creates an empty marker
+// object named after the directory with a trailing slash to keep empty
+// ones. Renaming copies objects, then deletes the originals.
+type S3 struct {
+	endpoint *url.URL
+	bucket   string
+	prefix   string // "" or ending with a slash
+	signer   sigV4
+	client   *http.Client
+	// Part sizes and the single copy limit, lowered by tests.
+	partSize, copyPartSize, maxCopySize int64
+}
+
+// NewS3 returns a Storage serving the bucket described by cfg.
+func NewS3(cfg S3Config) (*S3, error) {
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.Trim(u.Path, "/") != "" || u.RawQuery != "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q: want scheme://host[:port], such as https://s3.us-east-1.amazonaws.com", cfg.Endpoint)
+	}
+	u.Path = ""
+	if cfg.Bucket == "" || strings.Contains(cfg.Bucket, "/") {
+		return nil, fmt.Errorf("invalid S3 bucket %q", cfg.Bucket)
+	}
+	if cfg.Region == "" {
+		return nil, errors.New("missing S3 region")
+	}
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, errors.New("missing S3 credentials")
+	}
+	prefix := strings.Trim(cfg.Prefix, "/")
+	if prefix != "" {
+		if !fs.ValidPath(prefix) {
+			return nil, fmt.Errorf("invalid S3 prefix %q", cfg.Prefix)
+		}
+		prefix += "/"
+	}
+	client := cfg.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &S3{
+		endpoint: u,
+		bucket:   cfg.Bucket,
+		prefix:   prefix,
+		signer: sigV4{
+			accessKey:    cfg.AccessKey,
+			secretKey:    cfg.SecretKey,
+			sessionToken: cfg.SessionToken,
+			region:       cfg.Region,
+			service:      "s3",
+		},
+		client:       client,
+		partSize:     s3PartSize,
+		copyPartSize: s3CopyPartSize,
+		maxCopySize:  s3MaxCopySize,
+	}, nil
+}
+
+// s3Error is an error response of the S3 API.
+type s3Error struct {
+	Status  int    `xml:"-"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *s3Error) Error() string {
+	if e.Code == "" {
+		return "s3: " + http.StatusText(e.Status)
+	}
+	return "s3: " + e.Code + ": " + e.Message
+}
+
+// Is lets errors.Is match S3 errors against the fs errors they stand for.
+func (e *s3Error) Is(target error) bool {
+	switch target {
+	case fs.ErrNotExist:
+		return e.Status == http.StatusNotFound
+	case fs.ErrPermission:
+		return e.Status == http.StatusForbidden
+	}
+	return false
+}
+
+// key returns the object key of name.
+func (s *S3) key(name string) string {
+	if name == "." {
+		return s.prefix
+	}
+	return s.prefix + name
+}
+
+// do sends a signed request about key, which is empty for the bucket
+// itself. Responses with an error status are closed and returned as an
+// *s3Error.
+func (s *S3) do(method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u := *s.endpoint
+	u.Path = "/" + s.bucket + "/" + key
+	u.RawPath = "/" + uriEncode(s.bucket, false) + "/" + uriEncode(key, true)
+	u.RawQuery = canonicalQuery(query.Encode())
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	s.signer.sign(req, payloadHash(body), time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, readS3Error(resp.StatusCode, resp.Body)
+	}
+	return resp, nil
+}
+
+// readS3Error reads the error response body r, of a request answered with
+// status.
+func readS3Error(status int, r io.Reader) error {
+	e := &s3Error{Status: status}
+	data, _ := io.ReadAll(io.LimitReader(r, 64<<10))
+	xml.Unmarshal(data, e) //nolint:errcheck
+	return e
+}
+
+// doXML is like do, then decodes the XML response body into v. Requests
+// that S3 may fail after answering 200 OK, such as copies, get their error
+// returned too.
+func (s *S3) doXML(method, key string, query url.Values, header http.Header, body []byte, v interface{}) error {
+	resp, err := s.do(method, key, query, header, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(data, []byte("<Error>")) {
+		return readS3Error(http.StatusInternalServerError, bytes.NewReader(data))
+	}
+	if v == nil {
+		return nil
+	}
+	return xml.Unmarshal(data, v)
+}
+
+// head returns the info of the object key, named name.
+func (s *S3) head(name, key string) (*fileInfo, error) {
+	resp, err := s.do(http.MethodHead, key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return &fileInfo{name: path.Base(name), size: resp.ContentLength, modTime: modTime}, nil
+}
+
+// s3Object is an object of a listing.
+type s3Object struct {
+	Key          string    `xml:"Key"`
+	Size         int64     `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+// list returns the objects whose keys start with prefix, and with a
+// delimiter the prefixes up to the next delimiter of the others, following
+// continuation tokens until limit objects and prefixes are found; limit 0
+// lists them all.
+func (s *S3) list(prefix, delimiter string, limit int) ([]s3Object, []string, error) {
+	var objects []s3Object
+	var prefixes []string
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if delimiter != "" {
+			query.Set("delimiter", delimiter)
+		}
+		if limit > 0 {
+			query.Set("max-keys", strconv.Itoa(limit))
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		var result struct {
+			Contents              []s3Object `xml:"Contents"`
+			CommonPrefixes        []string   `xml:"CommonPrefixes>Prefix"`
+			IsTruncated           bool       `xml:"IsTruncated"`
+			NextContinuationToken string     `xml:"NextContinuationToken"`
+		}
+		if err := s.doXML(http.MethodGet, "", query, nil, nil, &result); err != nil {
+			return nil, nil, err
+		}
+		objects = append(objects, result.Contents...)
+		prefixes = append(prefixes, result.CommonPrefixes...)
+		if !result.IsTruncated || result.NextContinuationToken == "" || (limit > 0 && len(objects)+len(prefixes) >= limit) {
+			return objects, prefixes, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// isDir reports whether keys start with the key of the directory name.
+func (s *S3) isDir(name string) (bool, error) {
+	if name == "." {
+		// Listing fails when the bucket is missing or unreachable.
+		_, _, err := s.list(s.prefix, "/", 1)
+		return err == nil, err
+	}
+	objects, prefixes, err := s.list(s.key(name)+"/", "/", 1)
+	return len(objects)+len(prefixes) > 0, err
+}
+
+func (s *S3) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	if name != "." {
+		info, err := s.head(name, s.key(name))
+		if err == nil {
+			return info, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+		}
+	}
+	dir, err := s.isDir(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	if !dir {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return &fileInfo{name: path.Base(name), dir: true}, nil
+}
+
+func (s *S3) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	prefix := s.prefix
+	if name != "." {
+		prefix = s.key(name) + "/"
+	}
+	objects, prefixes, err := s.list(prefix, "/", 0)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	if name != "." && len(objects)+len(prefixes) == 0 {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	// A key may be both a file and, followed by a slash, a directory; the
+	// directory wins.
+	byName := make(map[string]*fileInfo)
+	for _, p := range prefixes {
+		child := strings.TrimSuffix(strings.TrimPrefix(p, prefix), "/")
+		if fs.ValidPath(child) && !strings.Contains(child, "/") {
+			byName[child] = &fileInfo{name: child, dir: true}
+		}
+	}
+	for _, o := range objects {
+		child := strings.TrimPrefix(o.Key, prefix)
+		if _, dir := byName[child]; dir || child == "" || !fs.ValidPath(child) || strings.Contains(child, "/") {
+			continue
+		}
+		byName[child] = &fileInfo{name: child, size: o.Size, modTime: o.LastModified}
+	}
+	entries := make([]fs.DirEntry, 0, len(byName))
+	for _, info := range byName {
+		entries = append(entries, info)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (s *S3) Open(name string) (File, error) {
+	info, err := s.Stat(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errors.Unwrap(err)}
+	}
+	return &s3File{s: s, key: s.key(name), info: info}, nil
+}
+
+// Create fails if name exists. Its content is sent in parts while it is
+// written, as a multipart upload completed when the writer is closed, so
+// the object only appears once whole.
+func (s *S3) Create(name string) (io.WriteCloser, error) {
+	if !fs.ValidPath(name) || name == "." {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrInvalid}
+	}
+	if _, err := s.Stat(name); err == nil {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrExist}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err := s.checkParent("create", name); err != nil {
+		return nil, err
+	}
+	return &s3Writer{s: s, key: s.key(name)}, nil
+}
+
+// checkParent returns an error unless the parent of name is a directory.
+func (s *S3) checkParent(op, name string) error {
+	parent := path.Dir(name)
+	if parent == "." {
+		return nil
+	}
+	info, err := s.Stat(parent)
+	if err != nil {
+		return &fs.PathError{Op: op, Path: name, Err: errors.Unwrap(err)}
+	}
+	if !info.IsDir() {
+		return &fs.PathError{Op: op, Path: name, Err: errNotDir}
+	}
+	return nil
+}
+
+func (s *S3) Rename(oldname, newname string) error {
+	if !fs.ValidPath(oldname) || !fs.ValidPath(newname) || oldname == "." || newname == "." || strings.HasPrefix(newname, oldname+"/") {
+		return &fs.PathError{Op: "rename", Path: newname, Err: fs.ErrInvalid}
+	}
+	if oldname == newname {
+		return nil
+	}
+	if err := s.checkParent("rename", newname); err != nil {
+		return err
+	}
+
+	info, err := s.head(oldname, s.key(oldname))
+	if err == nil {
+		if err := s.copy(s.key(oldname), s.key(newname), info.size); err != nil {
+			return &fs.PathError{Op: "rename", Path: oldname, Err: err}
+		}
+		if err := s.deleteKeys([]string{s.key(oldname)}); err != nil {
+			return &fs.PathError{Op: "rename", Path: oldname, Err: err}
+		}
+		return nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return &fs.PathError{Op: "rename", Path: oldname, Err: err}
+	}
+
+	// A directory: move every key under it.
+	oldPrefix, newPrefix := s.key(oldname)+"/", s.key(newname)+"/"
+	if existing, _, err := s.list(newPrefix, "", 1); err != nil || len(existing) > 0 {
+		if err == nil {
+			err = fs.ErrExist
+		}
+		return &fs.PathError{Op: "rename", Path: newname, Err: err}
+	}
+	objects, _, err := s.list(oldPrefix, "", 0)
+	if err == nil && len(objects) == 0 {
+		err = fs.ErrNotExist
+	}
+	if err != nil {
+		return &fs.PathError{Op: "rename", Path: oldname, Err: err}
+	}
+	keys := make([]string, len(objects))
+	for i, o := range objects {
+		if err := s.copy(o.Key, newPrefix+strings.TrimPrefix(o.Key, oldPrefix), o.Size); err != nil {
+			return &fs.PathError{Op: "rename", Path: oldname, Err: err}
+		}
+		keys[i] = o.Key
+	}
+	if err := s.deleteKeys(keys); err != nil {
+		return &fs.PathError{Op: "rename", Path: oldname, Err: err}
+	}
+	return nil
+}
+
+// copy copies the object src, of size bytes, to dst.
+func (s *S3) copy(src, dst string, size int64) error {
+	source := http.Header{"X-Amz-Copy-Source": {"/" + uriEncode(s.bucket, false) + "/" + uriEncode(src, true)}}
+	if size <= s.maxCopySize {
+		return s.doXML(http.MethodPut, dst, nil, source, nil, nil)
+	}
+
+	upload, err := s.createMultipartUpload(dst)
+	if err != nil {
+		return err
+	}
+	var parts []s3Part
+	for start := int64(0); start < size; start += s.copyPartSize {
+		end := start + s.copyPartSize - 1
+		if end >= size {
+			end = size - 1
+		}
+		header := http.Header{
+			"X-Amz-Copy-Source":       source["X-Amz-Copy-Source"],
+			"X-Amz-Copy-Source-Range": {fmt.Sprintf("bytes=%d-%d", start, end)},
+		}
+		var result struct {
+			ETag string `xml:"ETag"`
+		}
+		query := url.Values{"partNumber": {strconv.Itoa(len(parts) + 1)}, "uploadId": {upload}}
+		if err := s.doXML(http.MethodPut, dst, query, header, nil, &result); err != nil {
+			s.abortMultipartUpload(dst, upload)
+			return err
+		}
+		parts = append(parts, s3Part{Number: len(parts) + 1, ETag: result.ETag})
+	}
+	return s.completeMultipartUpload(dst, upload, parts)
+}
+
+// s3Part is an uploaded part of a multipart upload.
+type s3Part struct {
+	Number int    `xml:"PartNumber"`
+	ETag   string `xml:"ETag"`
+}
+
+func (s *S3) createMultipartUpload(key string) (string, error) {
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := s.doXML(http.MethodPost, key, url.Values{"uploads": {""}}, nil, nil, &result); err != nil {
+		return "", err
+	}
+	return result.UploadID, nil
+}
+
+func (s *S3) completeMultipartUpload(key, upload string, parts []s3Part) error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []s3Part `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	if err := s.doXML(http.MethodPost, key, url.Values{"uploadId": {upload}}, nil, body, nil); err != nil {
+		s.abortMultipartUpload(key, upload)
+		return err
+	}
+	return nil
+}
+
+func (s *S3) abortMultipartUpload(key, upload string) {
+	if resp, err := s.do(http.MethodDelete, key, url.Values{"uploadId": {upload}}, nil, nil); err == nil {
+		resp.Body.Close()
+	}
+}
+
+// deleteKeys deletes the objects keys, in batches.
+func (s *S3) deleteKeys(keys []string) error {
+	type object struct {
+		Key string `xml:"Key"`
+	}
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > s3MaxDeleteKeys {
+			n = s3MaxDeleteKeys
+		}
+		request := struct {
+			XMLName xml.Name `xml:"Delete"`
+			Quiet   bool     `xml:"Quiet"`
+			Objects []object `xml:"Object"`
+		}{Quiet: true}
+		for _, k := range keys[:n] {
+			request.Objects = append(request.Objects, object{Key: k})
+		}
+		body, err := xml.Marshal(request)
+		if err != nil {
+			return err
+		}
+		sum := md5.Sum(body)
+		header := http.Header{"Content-Md5": {base64.StdEncoding.EncodeToString(sum[:])}}
+		var result struct {
+			Errors []struct {
+				Key     string `xml:"Key"`
+				Code    string `xml:"Code"`
+				Message string `xml:"Message"`
+			} `xml:"Error"`
+		}
+		if err := s.doXML(http.MethodPost, "", url.Values{"delete": {""}}, header, body, &result); err != nil {
+			return err
+		}
+		if len(result.Errors) > 0 {
+			e := result.Errors[0]
+			return fmt.Errorf("s3: deleting %s: %s: %s", e.Key, e.Code, e.Message)
+		}
+		keys = keys[n:]
+	}
+	return nil
+}
+
+// RemoveAll removes the object name and every object under it.
+func (s *S3) RemoveAll(name string) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
+	var keys []string
+	prefix := s.prefix
+	if name != "." {
+		prefix = s.key(name) + "/"
+		if _, err := s.head(name, s.key(name)); err == nil {
+			keys = append(keys, s.key(name))
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return &fs.PathError{Op: "remove", Path: name, Err: err}
+		}
+	}
+	objects, _, err := s.list(prefix, "", 0)
+	if err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
+	for _, o := range objects {
+		keys = append(keys, o.Key)
+	}
+	if len(keys) == 0 && name != "." {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	if err := s.deleteKeys(keys); err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
+	return nil
+}
+
+func (s *S3) Mkdir(name string) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+	}
+	if _, err := s.Stat(name); err == nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := s.checkParent("mkdir", name); err != nil {
+		return err
+	}
+	return s.putMarker(name)
+}
+
+func (s *S3) MkdirAll(name string) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return nil
+	}
+	parts := strings.Split(name, "/")
+	for i := range parts {
+		dir := strings.Join(parts[:i+1], "/")
+		info, err := s.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				return &fs.PathError{Op: "mkdir", Path: dir, Err: errNotDir}
+			}
+			continue
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if err := s.putMarker(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putMarker creates the empty object keeping the directory name.
+func (s *S3) putMarker(name string) error {
+	resp, err := s.do(http.MethodPut, s.key(name)+"/", nil, nil, []byte{})
+	if err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// s3File reads an object with ranged GET requests, starting a new one
+// from the current offset after each Seek.
+type s3File struct {
+	s      *S3
+	key    string
+	info   fs.FileInfo
+	offset int64
+	body   io.ReadCloser
+}
+
+func (f *s3File) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *s3File) Read(p []byte) (int, error) {
+	if f.info.IsDir() {
+		return 0, &fs.PathError{Op: "read", Path: f.info.Name(), Err: errors.New("is a directory")}
+	}
+	if f.offset >= f.info.Size() {
+		return 0, io.EOF
+	}
+	if f.body == nil {
+		header := http.Header{"Range": {fmt.Sprintf("bytes=%d-", f.offset)}}
+		resp, err := f.s.do(http.MethodGet, f.key, nil, header, nil)
+		if err != nil {
+			return 0, err
+		}
+		if resp.StatusCode != http.StatusPartialContent && f.offset > 0 {
+			resp.Body.Close()
+			return 0, fmt.Errorf("s3: range request answered with %s", resp.Status)
+		}
+		f.body = resp.Body
+	}
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	if err == io.EOF && f.offset < f.info.Size() {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (f *s3File) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.Size()
+	}
+	if offset < 0 {
+		return 0, errors.New("s3: negative position")
+	}
+	if offset != f.offset && f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *s3File) Close() error {
+	if f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+	return nil
+}
+
+// s3Writer uploads what is written to it: in a single request if it fits
+// in one part, or else as a multipart upload, sending each part once full.
+type s3Writer struct {
+	s      *S3
+	key    string
+	buf    bytes.Buffer
+	upload string // multipart upload ID, once started
+	parts  []s3Part
+	err    error
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.buf.Write(p)
+	for int64(w.buf.Len()) >= w.s.partSize {
+		if w.err = w.sendPart(w.buf.Next(int(w.s.partSize))); w.err != nil {
+			return 0, w.err
+		}
+	}
+	return len(p), nil
+}
+
+// sendPart uploads the next part of a multipart upload, starting it first
+// if needed.
+func (w *s3Writer) sendPart(data []byte) error {
+	if w.upload == "" {
+		upload, err := w.s.createMultipartUpload(w.key)
+		if err != nil {
+			return err
+		}
+		w.upload = upload
+	}
+	query := url.Values{"partNumber": {strconv.Itoa(len(w.parts) + 1)}, "uploadId": {w.upload}}
+	resp, err := w.s.do(http.MethodPut, w.key, query, nil, data)
+	if err != nil {
+		w.s.abortMultipartUpload(w.key, w.upload)
+		return err
+	}
+	resp.Body.Close()
+	w.parts = append(w.parts, s3Part{Number: len(w.parts) + 1, ETag: resp.Header.Get("ETag")})
+	return nil
+}
+
+// Abort drops what was written: no object is created, and a multipart
+// upload in progress is aborted so its parts don't linger in the bucket.
+func (w *s3Writer) Abort() error {
+	w.err = errors.New("s3: write after abort")
+	w.buf.Reset()
+	if w.upload != "" {
+		w.s.abortMultipartUpload(w.key, w.upload)
+		w.upload = ""
+	}
+	return nil
+}
+
+func (w *s3Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.err = errors.New("s3: write after close")
+	if w.upload == "" {
+		resp, err := w.s.do(http.MethodPut, w.key, nil, nil, w.buf.Bytes())
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+	if w.buf.Len() > 0 {
+		if err := w.sendPart(w.buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return w.s.completeMultipartUpload(w.key, w.upload, w.parts)
+}
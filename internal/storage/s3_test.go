@@ -0,0 +1,362 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testBucket    = "shared"
+	testAccessKey = "AKIDEXAMPLE"
+	testSecretKey = "secret"
+	testRegion    = "eu-west-1"
+)
+
+type fakeObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+// fakeS3 is an in-memory S3 server holding one bucket. It checks the
+// signature of every request and answers listings two entries at a time,
+// so clients must follow continuation tokens.
+type fakeS3 struct {
+	t       *testing.T
+	mu      sync.Mutex
+	objects map[string]fakeObject
+	uploads map[string]map[int][]byte
+	nextID  int
+	ranges  []string // Range headers of GET requests
+	parts   int      // parts uploaded or copied
+}
+
+func newFakeS3(t *testing.T, prefix string) (*S3, *fakeS3) {
+	fake := &fakeS3{t: t, objects: map[string]fakeObject{}, uploads: map[string]map[int][]byte{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	s, err := NewS3(S3Config{
+		Endpoint:  server.URL,
+		Region:    testRegion,
+		Bucket:    testBucket,
+		Prefix:    prefix,
+		AccessKey: testAccessKey,
+		SecretKey: testSecretKey,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, fake
+}
+
+func (f *fakeS3) fail(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+// authorized checks the signature and payload hash of r.
+func (f *fakeS3) authorized(r *http.Request, body []byte) bool {
+	fields := map[string]string{}
+	for _, field := range strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), sigV4Algorithm+" "), ", ") {
+		if k, v, ok := strings.Cut(field, "="); ok {
+			fields[k] = v
+		}
+	}
+	amzDate := r.Header.Get("X-Amz-Date")
+	signer := sigV4{secretKey: testSecretKey, region: testRegion, service: "s3"}
+	if len(amzDate) < 8 || fields["Credential"] != testAccessKey+"/"+signer.scope(amzDate) {
+		return false
+	}
+	hash := r.Header.Get("X-Amz-Content-Sha256")
+	return hash == payloadHash(body) && fields["Signature"] == signer.signature(r, strings.Split(fields["SignedHeaders"], ";"), hash, amzDate)
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if !f.authorized(r, body) {
+		f.fail(w, http.StatusForbidden, "SignatureDoesNotMatch")
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, "/"+testBucket+"/") {
+		f.fail(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/"+testBucket+"/")
+	q := r.URL.Query()
+	uploadID := q.Get("uploadId")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.Method == http.MethodGet && key == "" && q.Get("list-type") == "2":
+		f.list(w, q)
+	case r.Method == http.MethodPost && q.Has("delete"):
+		sum := md5.Sum(body)
+		if r.Header.Get("Content-Md5") != base64.StdEncoding.EncodeToString(sum[:]) {
+			f.fail(w, http.StatusBadRequest, "InvalidDigest")
+			return
+		}
+		var req struct {
+			Keys []string `xml:"Object>Key"`
+		}
+		xml.Unmarshal(body, &req) //nolint:errcheck
+		for _, k := range req.Keys {
+			delete(f.objects, k)
+		}
+		fmt.Fprint(w, "<DeleteResult></DeleteResult>")
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		f.nextID++
+		id := strconv.Itoa(f.nextID)
+		f.uploads[id] = map[int][]byte{}
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", id)
+	case r.Method == http.MethodPost && uploadID != "":
+		var req struct {
+			Parts []s3Part `xml:"Part"`
+		}
+		xml.Unmarshal(body, &req) //nolint:errcheck
+		var data []byte
+		for i, p := range req.Parts {
+			part, ok := f.uploads[uploadID][p.Number]
+			if !ok || p.Number != i+1 || p.ETag != partETag(p.Number) {
+				f.fail(w, http.StatusBadRequest, "InvalidPart")
+				return
+			}
+			data = append(data, part...)
+		}
+		delete(f.uploads, uploadID)
+		f.objects[key] = fakeObject{data: data, modTime: time.Now()}
+		fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+	case r.Method == http.MethodPut && uploadID != "":
+		n, _ := strconv.Atoi(q.Get("partNumber"))
+		data := body
+		if source := r.Header.Get("X-Amz-Copy-Source"); source != "" {
+			obj, ok := f.source(source)
+			var start, end int
+			if _, err := fmt.Sscanf(r.Header.Get("X-Amz-Copy-Source-Range"), "bytes=%d-%d", &start, &end); !ok || err != nil || end >= len(obj.data) {
+				f.fail(w, http.StatusBadRequest, "InvalidRequest")
+				return
+			}
+			data = obj.data[start : end+1]
+			fmt.Fprintf(w, "<CopyPartResult><ETag>%s</ETag></CopyPartResult>", partETag(n))
+		} else {
+			w.Header().Set("ETag", partETag(n))
+		}
+		f.uploads[uploadID][n] = data
+		f.parts++
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		obj, ok := f.source(r.Header.Get("X-Amz-Copy-Source"))
+		if !ok {
+			// Real servers fail copies after answering 200 OK too.
+			fmt.Fprint(w, "<Error><Code>NoSuchKey</Code><Message>gone</Message></Error>")
+			return
+		}
+		f.objects[key] = fakeObject{data: obj.data, modTime: time.Now()}
+		fmt.Fprint(w, "<CopyObjectResult></CopyObjectResult>")
+	case r.Method == http.MethodPut:
+		f.objects[key] = fakeObject{data: body, modTime: time.Now()}
+	case r.Method == http.MethodDelete && uploadID != "":
+		delete(f.uploads, uploadID)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		obj, ok := f.objects[key]
+		if !ok {
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			f.fail(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		if r.Method == http.MethodGet {
+			f.ranges = append(f.ranges, r.Header.Get("Range"))
+		}
+		http.ServeContent(w, r, "", obj.modTime, bytes.NewReader(obj.data))
+	default:
+		f.fail(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func partETag(n int) string { return fmt.Sprintf(`"part-%d"`, n) }
+
+// source returns the object an X-Amz-Copy-Source header names.
+func (f *fakeS3) source(header string) (fakeObject, bool) {
+	source, err := url.PathUnescape(header)
+	if err != nil || !strings.HasPrefix(source, "/"+testBucket+"/") {
+		return fakeObject{}, false
+	}
+	obj, ok := f.objects[strings.TrimPrefix(source, "/"+testBucket+"/")]
+	return obj, ok
+}
+
+// list answers a ListObjectsV2 request. Continuation tokens hold the last
+// key or common prefix returned.
+func (f *fakeS3) list(w http.ResponseWriter, q url.Values) {
+	const pageSize = 2
+	prefix, delimiter := q.Get("prefix"), q.Get("delimiter")
+	after := ""
+	if token := q.Get("continuation-token"); token != "" {
+		raw, _ := base64.StdEncoding.DecodeString(token)
+		after = string(raw)
+	}
+	keys := make([]string, 0, len(f.objects))
+	for k := range f.objects {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	type commonPrefix struct {
+		Prefix string `xml:"Prefix"`
+	}
+	result := struct {
+		XMLName               xml.Name       `xml:"ListBucketResult"`
+		Contents              []s3Object     `xml:"Contents"`
+		CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+		IsTruncated           bool           `xml:"IsTruncated"`
+		NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	}{}
+	count, last := 0, ""
+	for _, k := range keys {
+		item, common := k, false
+		if delimiter != "" {
+			if i := strings.Index(k[len(prefix):], delimiter); i >= 0 {
+				item, common = k[:len(prefix)+i+len(delimiter)], true
+			}
+		}
+		if item <= after || item == last {
+			continue
+		}
+		if count == pageSize {
+			result.IsTruncated = true
+			result.NextContinuationToken = base64.StdEncoding.EncodeToString([]byte(last))
+			break
+		}
+		if !common {
+			result.Contents = append(result.Contents, s3Object{Key: k, Size: int64(len(f.objects[k].data)), LastModified: f.objects[k].modTime})
+		} else {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: item})
+		}
+		count++
+		last = item
+	}
+	xml.NewEncoder(w).Encode(result) //nolint:errcheck
+}
+
+func TestS3MultipartUploadAndRanges(t *testing.T) {
+	s, fake := newFakeS3(t, "team/")
+	s.partSize = 4
+
+	writeFile(t, s, "big.bin", "hello world, gopher")
+	if fake.parts != 5 {
+		t.Errorf("uploaded in %d parts, want 5", fake.parts)
+	}
+	if _, ok := fake.objects["team/big.bin"]; !ok {
+		t.Fatalf("object keys = %v, want team/big.bin", fake.objects)
+	}
+	if got := readFile(t, s, "big.bin"); got != "hello world, gopher" {
+		t.Errorf("content = %q", got)
+	}
+
+	f, err := s.Open("big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Seek(6, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(f, buf); err != nil || string(buf) != "world" {
+		t.Errorf("ranged read = %q, %v", buf, err)
+	}
+	if got := fake.ranges[len(fake.ranges)-1]; got != "bytes=6-" {
+		t.Errorf("Range = %q, want bytes=6-", got)
+	}
+	if end, _ := f.Seek(0, io.SeekEnd); end != 19 {
+		t.Errorf("size from Seek = %d", end)
+	}
+
+	// Objects too large for a single copy are copied part by part.
+	s.maxCopySize, s.copyPartSize = 8, 8
+	fake.parts = 0
+	if err := s.Rename("big.bin", "moved.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if fake.parts != 3 {
+		t.Errorf("copied in %d parts, want 3", fake.parts)
+	}
+	if got := readFile(t, s, "moved.bin"); got != "hello world, gopher" {
+		t.Errorf("content after copy = %q", got)
+	}
+}
+
+func TestS3AbortedUpload(t *testing.T) {
+	s, fake := newFakeS3(t, "")
+	s.partSize = 4
+
+	w, err := s.Create("partial.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, "cut short"); err != nil {
+		t.Fatal(err)
+	}
+	if fake.parts == 0 {
+		t.Fatal("no part was sent before the abort")
+	}
+	if err := Abort(w); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Stat("partial.bin"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat after Abort = %v, want not exist", err)
+	}
+	if len(fake.uploads) != 0 {
+		t.Errorf("%d multipart uploads left in the bucket", len(fake.uploads))
+	}
+	if _, err := w.Write([]byte("more")); err == nil {
+		t.Error("Write after Abort succeeded")
+	}
+}
+
+func TestS3Errors(t *testing.T) {
+	s, fake := newFakeS3(t, "")
+	fake.objects["odd name+ü.txt"] = fakeObject{data: []byte("x"), modTime: time.Now()}
+	if info, err := s.Stat("odd name+ü.txt"); err != nil || info.Size() != 1 {
+		t.Errorf("Stat of a key needing escaping = %v, %v", info, err)
+	}
+
+	s.signer.secretKey = "wrong"
+	if _, err := s.Stat("odd name+ü.txt"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("Stat with bad credentials = %v", err)
+	}
+
+	for _, cfg := range []S3Config{
+		{Endpoint: "localhost:9000", Region: "r", Bucket: "b", AccessKey: "a", SecretKey: "s"},
+		{Endpoint: "http://localhost:9000/path", Region: "r", Bucket: "b", AccessKey: "a", SecretKey: "s"},
+		{Endpoint: "http://localhost:9000", Region: "r", Bucket: "", AccessKey: "a", SecretKey: "s"},
+		{Endpoint: "http://localhost:9000", Region: "r", Bucket: "b", AccessKey: "", SecretKey: ""},
+		{Endpoint: "http://localhost:9000", Region: "r", Bucket: "b", Prefix: "a/../b", AccessKey: "a", SecretKey: "s"},
+	} {
+		if _, err := NewS3(cfg); err == nil {
+			t.Errorf("NewS3(%+v) succeeded", cfg)
+		}
+	}
+}
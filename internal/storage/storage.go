@@ -0,0 +1,155 @@
+// Package storage abstracts the tree of files upgopher shares, so the file
+// handlers work the same whichever backend holds it.
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Storage is a tree of files and directories. Names are slash-separated,
+// relative to the root of the tree and valid according to fs.ValidPath, "."
+// being the root; Clean turns client paths into such names.
+//
+// Errors wrap fs.ErrNotExist, fs.ErrExist or fs.ErrPermission where those
+// apply. fs.ErrPermission also reports names the storage refuses to reach,
+// such as through a symbolic link its policy forbids.
+type Storage interface {
+	// Stat describes name, following symbolic links.
+	Stat(name string) (fs.FileInfo, error)
+	// ReadDir lists the directory name sorted by file name, leaving out
+	// entries that may not be reached.
+	ReadDir(name string) ([]fs.DirEntry, error)
+	// Open opens the file name for reading.
+	Open(name string) (File, error)
+	// Create creates the file name for writing, failing if it exists. Its
+	// content is complete once the returned writer is closed.
+	Create(name string) (io.WriteCloser, error)
+	// Rename moves oldname to newname, replacing newname if it is a file.
+	Rename(oldname, newname string) error
+	// RemoveAll removes name and everything it contains. Removing a
+	// symbolic link leaves its target alone.
+	RemoveAll(name string) error
+	// Mkdir creates the directory name, whose parent must exist.
+	Mkdir(name string) error
+	// MkdirAll creates the directory name along with any missing parents.
+	MkdirAll(name string) error
+}
+
+// Abort ends w, a writer returned by Create, when what was written must
+// not be kept, such as after a failed copy. Writers that would commit the
+// content on Close, like the multipart uploads of S3, drop it instead;
+// others are closed. The caller removes the file afterwards.
+func Abort(w io.WriteCloser) error {
+	if a, ok := w.(interface{ Abort() error }); ok {
+		return a.Abort()
+	}
+	return w.Close()
+}
+
+// File is a file opened for reading.
+type File interface {
+	io.ReadSeekCloser
+	Stat() (fs.FileInfo, error)
+}
+
+// Clean turns a path relative to the root of a Storage, as sent by clients,
+// into a name. A leading slash is dropped; ok is false when the path leads
+// outside the root.
+func Clean(p string) (name string, ok bool) {
+	name = strings.TrimLeft(path.Clean(filepath.ToSlash(p)), "/")
+	if name == ".." || strings.HasPrefix(name, "../") {
+		return "", false
+	}
+	if name == "" {
+		name = "."
+	}
+	return name, fs.ValidPath(name)
+}
+
+// Walk calls fn for root and everything below it in lexical order, like
+// fs.WalkDir. Symbolic links the storage lets through are followed, fn
+// getting the target's FileInfo under the link's name; a link to a
+// directory being walked is skipped, which breaks loops. Entries that can't
+// be read are skipped too. fn may return fs.SkipDir to skip a directory.
+func Walk(s Storage, root string, fn func(name string, info fs.FileInfo) error) error {
+	info, err := s.Stat(root)
+	if err != nil {
+		return err
+	}
+	var ancestors []fs.FileInfo // directories being walked
+	var walk func(name string, info fs.FileInfo) error
+	walk = func(name string, info fs.FileInfo) error {
+		if info.IsDir() {
+			for _, a := range ancestors {
+				if os.SameFile(a, info) {
+					return nil
+				}
+			}
+		}
+		if err := fn(name, info); err != nil || !info.IsDir() {
+			if err == fs.SkipDir {
+				return nil
+			}
+			return err
+		}
+
+		entries, err := s.ReadDir(name)
+		if err != nil {
+			if errors.Is(err, fs.ErrPermission) {
+				return nil
+			}
+			return err
+		}
+		ancestors = append(ancestors, info)
+		defer func() { ancestors = ancestors[:len(ancestors)-1] }()
+		for _, e := range entries {
+			child := path.Join(name, e.Name())
+			var childInfo fs.FileInfo
+			if e.Type()&fs.ModeSymlink != 0 {
+				childInfo, err = s.Stat(child)
+			} else {
+				childInfo, err = e.Info()
+			}
+			if err != nil {
+				continue
+			}
+			if err := walk(child, childInfo); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(root, info)
+}
+
+// fileInfo describes a file or directory of a backend keeping no more than
+// its size and modification time. It is both an fs.FileInfo and an
+// fs.DirEntry.
+type fileInfo struct {
+	name    string
+	dir     bool
+	size    int64
+	modTime time.Time
+}
+
+func (i *fileInfo) Name() string               { return i.name }
+func (i *fileInfo) Size() int64                { return i.size }
+func (i *fileInfo) ModTime() time.Time         { return i.modTime }
+func (i *fileInfo) IsDir() bool                { return i.dir }
+func (i *fileInfo) Sys() interface{}           { return nil }
+func (i *fileInfo) Type() fs.FileMode          { return i.Mode().Type() }
+func (i *fileInfo) Info() (fs.FileInfo, error) { return i, nil }
+
+func (i *fileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
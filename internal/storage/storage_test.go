@@ -0,0 +1,242 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/wanetty/upgopher/internal/security"
+)
+
+// backends returns an empty storage of each kind.
+func backends(t *testing.T) map[string]Storage {
+	s3, _ := newFakeS3(t, "share")
+	return map[string]Storage{
+		"local":  NewLocal(t.TempDir(), security.SymlinksWithinRoot),
+		"memory": NewMemory(),
+		"s3":     s3,
+	}
+}
+
+func writeFile(t *testing.T, s Storage, name, content string) {
+	t.Helper()
+	w, err := s.Create(name)
+	if err != nil {
+		t.Fatalf("Create(%q): %v", name, err)
+	}
+	if _, err := io.WriteString(w, content); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, s Storage, name string) string {
+	t.Helper()
+	f, err := s.Open(name)
+	if err != nil {
+		t.Fatalf("Open(%q): %v", name, err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestStorageBackends(t *testing.T) {
+	for kind, s := range backends(t) {
+		if err := s.MkdirAll("docs/deep"); err != nil {
+			t.Fatalf("%s: MkdirAll: %v", kind, err)
+		}
+		if err := s.Mkdir("docs"); !errors.Is(err, fs.ErrExist) {
+			t.Errorf("%s: Mkdir of an existing directory = %v", kind, err)
+		}
+		if err := s.Mkdir("missing/dir"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s: Mkdir without parent = %v", kind, err)
+		}
+
+		writeFile(t, s, "docs/a.txt", "hello")
+		if _, err := s.Create("docs/a.txt"); !errors.Is(err, fs.ErrExist) {
+			t.Errorf("%s: Create of an existing file = %v", kind, err)
+		}
+		if got := readFile(t, s, "docs/a.txt"); got != "hello" {
+			t.Errorf("%s: content = %q", kind, got)
+		}
+		info, err := s.Stat("docs/a.txt")
+		if err != nil || info.IsDir() || info.Size() != 5 || info.Name() != "a.txt" {
+			t.Errorf("%s: Stat = %v, %v", kind, info, err)
+		}
+		if _, err := s.Stat("docs/nope"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s: Stat of a missing file = %v", kind, err)
+		}
+		if _, err := s.Stat("../etc"); err == nil {
+			t.Errorf("%s: Stat of an invalid name succeeded", kind)
+		}
+
+		f, err := s.Open("docs/a.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Seek(1, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		rest, _ := io.ReadAll(f)
+		f.Close()
+		if string(rest) != "ello" {
+			t.Errorf("%s: read after Seek = %q", kind, rest)
+		}
+
+		writeFile(t, s, "docs/.b", "")
+		entries, err := s.ReadDir("docs")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		if !sort.StringsAreSorted(names) || len(names) != 3 || names[0] != ".b" || names[2] != "deep" || !entries[2].IsDir() {
+			t.Errorf("%s: ReadDir = %v", kind, names)
+		}
+
+		if err := s.Rename("docs/a.txt", "docs/deep/c.txt"); err != nil {
+			t.Fatalf("%s: Rename: %v", kind, err)
+		}
+		if _, err := s.Stat("docs/a.txt"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s: renamed file still there: %v", kind, err)
+		}
+		if err := s.Rename("docs", "papers"); err != nil {
+			t.Fatalf("%s: Rename of a directory: %v", kind, err)
+		}
+		if got := readFile(t, s, "papers/deep/c.txt"); got != "hello" {
+			t.Errorf("%s: content after renaming its directory = %q", kind, got)
+		}
+
+		if err := s.RemoveAll("papers"); err != nil {
+			t.Fatalf("%s: RemoveAll: %v", kind, err)
+		}
+		if _, err := s.Stat("papers/deep/c.txt"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s: file left after RemoveAll: %v", kind, err)
+		}
+		if err := s.RemoveAll("papers"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s: RemoveAll of a missing name = %v", kind, err)
+		}
+		if entries, err := s.ReadDir("."); err != nil || len(entries) != 0 {
+			t.Errorf("%s: root after RemoveAll = %v, %v", kind, entries, err)
+		}
+	}
+}
+
+func TestWalk(t *testing.T) {
+	for kind, s := range backends(t) {
+		writeFile(t, s, "x.txt", "")
+		if err := s.MkdirAll("a/skip"); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, s, "a/y.txt", "")
+		writeFile(t, s, "a/skip/z.txt", "")
+
+		var got []string
+		err := Walk(s, ".", func(name string, info fs.FileInfo) error {
+			got = append(got, name)
+			if name == "a/skip" {
+				return fs.SkipDir
+			}
+			return nil
+		})
+		want := []string{".", "a", "a/skip", "a/y.txt", "x.txt"}
+		if err != nil || fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s: Walk = %v, %v, want %v", kind, got, err, want)
+		}
+	}
+}
+
+func TestLocalSymlinks(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	for _, dir := range []string{"docs", "inside"} {
+		if err := os.Mkdir(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{
+		"escape":    outside,
+		"docs/up":   "..",
+		"docs/side": "../inside",
+	} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Skipf("symlinks unavailable: %v", err)
+		}
+	}
+
+	s := NewLocal(root, security.SymlinksWithinRoot)
+	if _, err := s.Stat("escape"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("Stat through an escaping link = %v", err)
+	}
+	if _, err := s.Create("escape/x"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("Create through an escaping link = %v", err)
+	}
+	entries, _ := s.ReadDir(".")
+	for _, e := range entries {
+		if e.Name() == "escape" {
+			t.Error("ReadDir listed the escaping link")
+		}
+	}
+
+	// Walking follows docs/side but not docs/up, which loops back.
+	var names []string
+	if err := Walk(s, ".", func(name string, info fs.FileInfo) error {
+		names = append(names, name)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{".", "docs", "docs/side", "inside"}
+	if fmt.Sprint(names) != fmt.Sprint(want) {
+		t.Fatalf("Walk = %v, want %v", names, want)
+	}
+
+	// A link the policy refuses may still be removed, leaving its target.
+	if err := s.RemoveAll("escape"); err != nil {
+		t.Fatalf("RemoveAll of the escaping link: %v", err)
+	}
+	if _, err := os.Stat(outside); err != nil {
+		t.Errorf("link target removed: %v", err)
+	}
+
+	deny := NewLocal(root, security.SymlinksDeny)
+	if _, err := deny.Stat("docs/side"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("Stat through a link with -symlinks deny = %v", err)
+	}
+}
+
+func TestClean(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"", ".", true},
+		{"/", ".", true},
+		{"docs/a.txt", "docs/a.txt", true},
+		{"/docs//a.txt/", "docs/a.txt", true},
+		{"docs/../a.txt", "a.txt", true},
+		{"..", "", false},
+		{"../etc/passwd", "", false},
+		{"docs/../../etc", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := Clean(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Clean(%q) = %q, %v; want %q, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
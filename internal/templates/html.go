@@ -2,10 +2,7 @@ package templates
 
 import (
 	"encoding/base64"
-	"fmt"
 	"html"
-	"io/fs"
-	"os"
 	"path/filepath"
 	"strings"
 )
@@ -31,102 +28,12 @@ func IsTextFile(fileName string) bool {
 	return textExtensions[ext]
 }
 
-// CreateFolderRow generates HTML for a folder row in the file listing
-func CreateFolderRow(file fs.DirEntry, currentPath string, fileInfo os.FileInfo, readOnly bool) string {
-	encodedPath := CreateEncodedPath(currentPath, file.Name())
-	escapedencodedFilePath := html.EscapeString(encodedPath)
-
-	escapedFolderName := html.EscapeString(file.Name())
-	folderLink := fmt.Sprintf(`<a href="/?path=%s">%s</a>`, escapedencodedFilePath, escapedFolderName)
-	lastModified := fileInfo.ModTime().Format("2006-01-02 15:04:05")
-
-	deleteBtn := ""
-	if !readOnly {
-		deleteBtn = fmt.Sprintf(`<button class="action-btn delete" title="Delete folder and contents" onclick="deleteFolder('%s')"><i class="fa fa-trash"></i></button>`, escapedencodedFilePath)
-	}
-
-	return fmt.Sprintf(`
-		<tr>
-			<td class="col-checkbox"><input type="checkbox" class="file-select-checkbox" data-path="%s" onchange="onCheckboxChange(this)"></td>
-			<td>%s</td>
-			<td>%s</td>
-			<td>-</td>
-			<td>%s</td>
-			<td>-</td>
-			<td>
-				<div class="action-buttons">
-					%s
-				</div>
-			</td>
-		</tr>
-	`, escapedencodedFilePath, folderLink, fileInfo.Mode(), lastModified, deleteBtn)
-}
-
-// CreateFileRow generates HTML for a file row in the file listing
-func CreateFileRow(file fs.DirEntry, currentPath string, fileInfo os.FileInfo, customPaths map[string]string, readOnly bool, formatFileSize func(int64) (float64, string)) string {
-	encodedFilePath := CreateEncodedPath(currentPath, file.Name())
-
-	escapedFileName := html.EscapeString(file.Name())
-	escapedencodedFilePath := html.EscapeString(encodedFilePath)
-
-	decodedPath, _ := base64.StdEncoding.DecodeString(currentPath)
-
-	// Search custom path for this file
-	customPathDisplay := "-"
-	filePath := filepath.Join(string(decodedPath), file.Name())
-	customPath, exists := customPaths[filePath]
-	if exists {
-		customPathDisplay = html.EscapeString(customPath)
-	}
-
-	isReadableFile := IsTextFile(file.Name())
-
-	// Use action-buttons and appropriate button styles
-	downloadLink := fmt.Sprintf(`<button class="action-btn download" title="Download" onclick="window.location.href='/download/?path=%s'"><i class="fa fa-download"></i></button>`, escapedencodedFilePath)
-	copyURLButton := fmt.Sprintf(`<button class="action-btn link" title="Copy URL" onclick="copyToClipboard('%s', '%s')"><i class="fa fa-link"></i></button>`, currentPath, escapedFileName)
-	customPathButton := fmt.Sprintf(`<button class="action-btn edit" title="Create Custom Path" onclick="showCustomPathForm('%s', '%s')"><i class="fa fa-magic"></i></button>`, escapedFileName, currentPath)
-
-	// Delete button only shown when not in readonly mode
-	deleteLink := ""
-	if !readOnly {
-		deleteLink = fmt.Sprintf(`<button class="action-btn delete" title="Delete" onclick="window.location.href='/delete/?path=%s'"><i class="fa fa-trash"></i></button>`, escapedencodedFilePath)
-	}
-
-	// Search and view buttons only for readable text files
-	searchButton := ""
-	viewButton := ""
-	if isReadableFile {
-		searchButton = fmt.Sprintf(`<button class="action-btn search" title="Search in File" onclick="showSearchModal('%s', '%s')"><i class="fa fa-search"></i></button>`, escapedencodedFilePath, escapedFileName)
-		viewButton = fmt.Sprintf(`<button class="action-btn view" title="View File" onclick="openFileViewer('%s', '%s')"><i class="fa fa-eye"></i></button>`, escapedencodedFilePath, escapedFileName)
-	}
-
-	fileSize, units := formatFileSize(fileInfo.Size())
-	lastModified := fileInfo.ModTime().Format("2006-01-02 15:04:05")
-
-	return fmt.Sprintf(`
-		<tr>
-			<td class="col-checkbox"><input type="checkbox" class="file-select-checkbox" data-path="%s" onchange="onCheckboxChange(this)"></td>
-			<td>%s</td>
-			<td>%s</td>
-			<td>%.2f %s</td>
-			<td>%s</td>
-			<td>%s</td>
-			<td>
-				<div class="action-buttons">
-					%s%s%s%s%s%s
-				</div>
-			</td>
-		</tr>
-	`, escapedencodedFilePath, escapedFileName, fileInfo.Mode(), fileSize, units, lastModified, customPathDisplay,
-		downloadLink, copyURLButton, customPathButton, viewButton, searchButton, deleteLink)
-}
-
-
 // CreateZipButton generates HTML for the zip download button
-func CreateZipButton(currentPath string) string {
+func CreateZipButton(basePath string, currentPath string) string {
+	escapedBasePath := html.EscapeString(basePath)
 	if currentPath != "" {
-		return `<button class="btn" onclick="window.location.href='/zip?path=` + currentPath + `'"><i class="fa fa-download"></i> Download Zip</button>`
+		return `<button class="btn" onclick="window.location.href='` + escapedBasePath + `/zip?path=` + currentPath + `'"><i class="fa fa-download"></i> Download Zip</button>`
 	} else {
-		return `<button class="btn" onclick="window.location.href='/zip'"><i class="fa fa-download"></i> Download Zip</button>`
+		return `<button class="btn" onclick="window.location.href='` + escapedBasePath + `/zip'"><i class="fa fa-download"></i> Download Zip</button>`
 	}
 }
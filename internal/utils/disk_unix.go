@@ -0,0 +1,15 @@
+//go:build !windows
+
+package utils
+
+import "syscall"
+
+// DiskUsage returns the free space available to unprivileged users and the
+// total size, in bytes, of the filesystem holding path.
+func DiskUsage(path string) (free uint64, total uint64, err error) {
+	var st syscall.Statfs_t
+	if err = syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), uint64(st.Blocks) * uint64(st.Bsize), nil
+}
@@ -0,0 +1,30 @@
+//go:build windows
+
+package utils
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var procGetDiskFreeSpaceExW = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// DiskUsage returns the free space available to the calling user and the
+// total size, in bytes, of the volume holding path.
+func DiskUsage(path string) (free uint64, total uint64, err error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	var totalFree uint64
+	r, _, callErr := procGetDiskFreeSpaceExW.Call(
+		uintptr(unsafe.Pointer(p)),
+		uintptr(unsafe.Pointer(&free)),
+		uintptr(unsafe.Pointer(&total)),
+		uintptr(unsafe.Pointer(&totalFree)),
+	)
+	if r == 0 {
+		return 0, 0, callErr
+	}
+	return free, total, nil
+}
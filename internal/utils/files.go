@@ -3,8 +3,10 @@ package utils
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 )
@@ -42,7 +44,12 @@ func SearchInFile(filePath, searchTerm string, caseSensitive, wholeWord bool) ([
 	}
 	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
+	return SearchInReader(file, searchTerm, caseSensitive, wholeWord)
+}
+
+// SearchInReader is like SearchInFile, reading the lines from r
+func SearchInReader(r io.Reader, searchTerm string, caseSensitive, wholeWord bool) ([]SearchResult, error) {
+	scanner := bufio.NewScanner(r)
 	lineNumber := 0
 	var results []SearchResult
 
@@ -120,3 +127,30 @@ func SearchInFile(filePath, searchTerm string, caseSensitive, wholeWord bool) ([
 
 	return results, nil
 }
+
+// WriteFileAtomic writes data to a temporary file in the same directory and
+// renames it over path, so readers never observe a partially written file.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName) // no-op once the rename succeeded
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpName, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, path)
+}
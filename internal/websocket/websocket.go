@@ -0,0 +1,472 @@
+// Package websocket implements the WebSocket protocol (RFC 6455) on top of
+// net/http: the server-side opening handshake, message framing with
+// fragmentation and control frames, and a minimal ws:// client used by tests.
+// Extensions (such as permessage-deflate) and subprotocols are not supported.
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Message types, which are also the frame opcodes.
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+
+	continuationFrame = 0
+)
+
+// Close status codes (RFC 6455 §7.4.1).
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseNoStatus        = 1005
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+// DefaultMaxMessageSize is the largest reassembled message a Conn accepts
+// unless MaxMessageSize is changed.
+const DefaultMaxMessageSize = 1 << 20
+
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var (
+	// ErrBadHandshake is returned when the opening handshake fails.
+	ErrBadHandshake = errors.New("websocket: bad handshake")
+	// ErrCloseSent is returned when writing after a close frame was sent.
+	ErrCloseSent = errors.New("websocket: close sent")
+)
+
+// CloseError reports that the connection is closing with the given status,
+// either because the peer sent a close frame or because it broke the protocol.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: close %d %s", e.Code, e.Text)
+}
+
+// Conn is a WebSocket connection. ReadMessage must be called from a single
+// goroutine; writes may come from any goroutine.
+type Conn struct {
+	conn   net.Conn
+	br     *bufio.Reader
+	client bool // frames we send are masked, frames we receive must not be
+
+	// MaxMessageSize limits the size of a message read from the peer.
+	MaxMessageSize int64
+	// IdleTimeout, when set, closes the connection if no frame arrives for
+	// that long. Pings sent by the application keep a healthy peer talking.
+	IdleTimeout time.Duration
+
+	wmu       sync.Mutex // serializes frame writes
+	closeSent bool
+}
+
+// Upgrade performs the server side of the opening handshake and takes over
+// the connection. On failure it has already written an HTTP error response.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return nil, ErrBadHandshake
+	}
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		w.Header().Set("Upgrade", "websocket")
+		http.Error(w, "WebSocket upgrade required", http.StatusUpgradeRequired)
+		return nil, ErrBadHandshake
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, ErrBadHandshake
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		http.Error(w, "Invalid Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket not supported", http.StatusInternalServerError)
+		return nil, ErrBadHandshake
+	}
+	netConn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	// The server's ReadTimeout and WriteTimeout deadlines survive the hijack;
+	// from here on the connection owner manages its own.
+	netConn.SetDeadline(time.Time{})
+
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n"
+	netConn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := netConn.Write([]byte(resp)); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	netConn.SetWriteDeadline(time.Time{})
+
+	return &Conn{conn: netConn, br: rw.Reader, MaxMessageSize: DefaultMaxMessageSize}, nil
+}
+
+// Dial opens a client connection to a ws:// URL. TLS is not supported; it
+// exists so handlers can be tested end to end.
+func Dial(rawURL string, header http.Header) (*Conn, *http.Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	if u.Scheme != "ws" {
+		return nil, nil, fmt.Errorf("websocket: unsupported scheme %q", u.Scheme)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "80")
+	}
+	netConn, err := net.DialTimeout("tcp", host, 10*time.Second)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		netConn.Close()
+		return nil, nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	var req strings.Builder
+	fmt.Fprintf(&req, "GET %s HTTP/1.1\r\nHost: %s\r\n", u.RequestURI(), u.Host)
+	req.WriteString("Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Version: 13\r\n")
+	req.WriteString("Sec-WebSocket-Key: " + key + "\r\n")
+	header.Write(&req)
+	req.WriteString("\r\n")
+
+	netConn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := io.WriteString(netConn, req.String()); err != nil {
+		netConn.Close()
+		return nil, nil, err
+	}
+	br := bufio.NewReader(netConn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		netConn.Close()
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		netConn.Close()
+		return nil, resp, ErrBadHandshake
+	}
+	netConn.SetDeadline(time.Time{})
+	return &Conn{conn: netConn, br: br, client: true, MaxMessageSize: DefaultMaxMessageSize}, resp, nil
+}
+
+// acceptKey computes Sec-WebSocket-Accept for a Sec-WebSocket-Key.
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// headerHasToken reports whether a comma-separated header contains token,
+// ignoring case.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type frameHeader struct {
+	fin    bool
+	opcode int
+	masked bool
+	mask   [4]byte
+	length int64
+}
+
+// ReadMessage returns the next text or binary message. Pings are answered
+// and pongs discarded along the way. When the peer closes the connection, or
+// breaks the protocol, the close handshake is answered and a *CloseError is
+// returned; the caller should then Close the Conn.
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	msgType := 0
+	var msg []byte
+	for {
+		h, err := c.readFrameHeader()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if h.opcode >= CloseMessage {
+			// Control frames may arrive between the fragments of a message.
+			payload, err := c.readPayload(h)
+			if err != nil {
+				return 0, nil, err
+			}
+			switch h.opcode {
+			case PingMessage:
+				if err := c.writeFrame(PongMessage, payload); err != nil && err != ErrCloseSent {
+					return 0, nil, err
+				}
+			case CloseMessage:
+				return 0, nil, c.handleClose(payload)
+			}
+			continue
+		}
+
+		if h.opcode == continuationFrame {
+			if msgType == 0 {
+				return 0, nil, c.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+		} else {
+			if msgType != 0 {
+				return 0, nil, c.fail(CloseProtocolError, "expected continuation frame")
+			}
+			msgType = h.opcode
+		}
+		if int64(len(msg))+h.length > c.MaxMessageSize {
+			return 0, nil, c.fail(CloseMessageTooBig, "message too big")
+		}
+		payload, err := c.readPayload(h)
+		if err != nil {
+			return 0, nil, err
+		}
+		msg = append(msg, payload...)
+
+		if h.fin {
+			if msgType == TextMessage && !utf8.Valid(msg) {
+				return 0, nil, c.fail(CloseInvalidPayload, "invalid UTF-8 in text message")
+			}
+			return msgType, msg, nil
+		}
+	}
+}
+
+func (c *Conn) readFrameHeader() (frameHeader, error) {
+	var h frameHeader
+	if c.IdleTimeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.IdleTimeout))
+	}
+
+	var b [8]byte
+	if _, err := io.ReadFull(c.br, b[:2]); err != nil {
+		return h, err
+	}
+	if b[0]&0x70 != 0 {
+		return h, c.fail(CloseProtocolError, "reserved bits set")
+	}
+	h.fin = b[0]&0x80 != 0
+	h.opcode = int(b[0] & 0x0f)
+	h.masked = b[1]&0x80 != 0
+	h.length = int64(b[1] & 0x7f)
+
+	switch h.length {
+	case 126:
+		if _, err := io.ReadFull(c.br, b[:2]); err != nil {
+			return h, err
+		}
+		h.length = int64(binary.BigEndian.Uint16(b[:2]))
+		if h.length < 126 {
+			return h, c.fail(CloseProtocolError, "non-minimal payload length")
+		}
+	case 127:
+		if _, err := io.ReadFull(c.br, b[:8]); err != nil {
+			return h, err
+		}
+		n := binary.BigEndian.Uint64(b[:8])
+		if n>>63 != 0 || n <= 0xffff {
+			return h, c.fail(CloseProtocolError, "invalid payload length")
+		}
+		h.length = int64(n)
+	}
+	if h.masked {
+		if _, err := io.ReadFull(c.br, h.mask[:]); err != nil {
+			return h, err
+		}
+	}
+
+	// Clients must mask every frame; servers must never mask.
+	if h.masked == c.client {
+		return h, c.fail(CloseProtocolError, "wrong frame masking")
+	}
+	switch h.opcode {
+	case continuationFrame, TextMessage, BinaryMessage:
+	case CloseMessage, PingMessage, PongMessage:
+		if !h.fin || h.length > 125 {
+			return h, c.fail(CloseProtocolError, "invalid control frame")
+		}
+	default:
+		return h, c.fail(CloseProtocolError, "unknown opcode")
+	}
+	return h, nil
+}
+
+func (c *Conn) readPayload(h frameHeader) ([]byte, error) {
+	payload := make([]byte, h.length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return nil, err
+	}
+	if h.masked {
+		maskBytes(h.mask, payload)
+	}
+	return payload, nil
+}
+
+// handleClose answers a close frame from the peer and returns it as an error.
+func (c *Conn) handleClose(payload []byte) error {
+	code, text := CloseNoStatus, ""
+	switch {
+	case len(payload) == 1:
+		return c.fail(CloseProtocolError, "invalid close payload")
+	case len(payload) >= 2:
+		code = int(binary.BigEndian.Uint16(payload))
+		text = string(payload[2:])
+		if !validCloseCode(code) {
+			return c.fail(CloseProtocolError, "invalid close code")
+		}
+		if !utf8.ValidString(text) {
+			return c.fail(CloseInvalidPayload, "invalid UTF-8 in close reason")
+		}
+	}
+	if code == CloseNoStatus {
+		c.writeFrame(CloseMessage, nil)
+	} else {
+		c.WriteClose(code, "")
+	}
+	return &CloseError{Code: code, Text: text}
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// fail sends a close frame for a protocol violation and returns it as an error.
+func (c *Conn) fail(code int, text string) error {
+	c.WriteClose(code, text)
+	return &CloseError{Code: code, Text: text}
+}
+
+// WriteMessage sends a single-frame message. Control messages (ping, pong)
+// are limited to 125 bytes; use WriteClose to close.
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	switch messageType {
+	case TextMessage, BinaryMessage:
+	case PingMessage, PongMessage:
+		if len(data) > 125 {
+			return errors.New("websocket: control message too long")
+		}
+	default:
+		return fmt.Errorf("websocket: invalid message type %d", messageType)
+	}
+	return c.writeFrame(messageType, data)
+}
+
+// WriteClose starts the closing handshake. Further writes fail with ErrCloseSent.
+func (c *Conn) WriteClose(code int, text string) error {
+	if len(text) > 123 {
+		text = text[:123]
+	}
+	payload := make([]byte, 2+len(text))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	copy(payload[2:], text)
+	return c.writeFrame(CloseMessage, payload)
+}
+
+func (c *Conn) writeFrame(opcode int, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return ErrCloseSent
+	}
+	if opcode == CloseMessage {
+		c.closeSent = true
+	}
+
+	buf := make([]byte, 0, 14+len(payload))
+	buf = append(buf, 0x80|byte(opcode))
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		buf = append(buf, maskBit|byte(n))
+	case n <= 0xffff:
+		buf = append(buf, maskBit|126, byte(n>>8), byte(n))
+	default:
+		buf = append(buf, maskBit|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(n))
+	}
+	if c.client {
+		var key [4]byte
+		if _, err := rand.Read(key[:]); err != nil {
+			return err
+		}
+		buf = append(buf, key[:]...)
+		start := len(buf)
+		buf = append(buf, payload...)
+		maskBytes(key, buf[start:])
+	} else {
+		buf = append(buf, payload...)
+	}
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+func maskBytes(key [4]byte, b []byte) {
+	for i := range b {
+		b[i] ^= key[i&3]
+	}
+}
+
+// SetWriteDeadline sets the deadline for future writes.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// RemoteAddr returns the address of the peer.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Close closes the underlying connection without a closing handshake.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,202 @@
+package websocket
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newEchoServer starts a server that echoes every message back.
+func newEchoServer(t *testing.T) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.MaxMessageSize = 64
+		for {
+			typ, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(typ, msg); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func dial(t *testing.T, url string) *Conn {
+	t.Helper()
+	conn, _, err := Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+// writeRaw sends a hand-built frame, masked with a zero key when mask is set.
+func writeRaw(t *testing.T, c *Conn, fin bool, opcode int, payload []byte, mask bool) {
+	t.Helper()
+	b0 := byte(opcode)
+	if fin {
+		b0 |= 0x80
+	}
+	frame := []byte{b0, byte(len(payload))}
+	if mask {
+		frame[1] |= 0x80
+		frame = append(frame, 0, 0, 0, 0)
+	}
+	frame = append(frame, payload...)
+	if _, err := c.conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expectClose reads until the server's close frame and checks its code.
+func expectClose(t *testing.T, c *Conn, code int) {
+	t.Helper()
+	_, _, err := c.ReadMessage()
+	var ce *CloseError
+	if !errors.As(err, &ce) || ce.Code != code {
+		t.Fatalf("got %v, want close %d", err, code)
+	}
+}
+
+func TestAcceptKey(t *testing.T) {
+	// Example from RFC 6455 §1.3.
+	if got := acceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("acceptKey = %s", got)
+	}
+}
+
+func TestEcho(t *testing.T) {
+	conn := dial(t, newEchoServer(t))
+
+	for _, msg := range []string{"hello", "", strings.Repeat("x", 64)} {
+		if err := conn.WriteMessage(TextMessage, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		typ, got, err := conn.ReadMessage()
+		if err != nil || typ != TextMessage || string(got) != msg {
+			t.Fatalf("echo %q: got %d %q %v", msg, typ, got, err)
+		}
+	}
+
+	if err := conn.WriteClose(CloseNormal, "bye"); err != nil {
+		t.Fatal(err)
+	}
+	expectClose(t, conn, CloseNormal)
+}
+
+func TestFragmentsAndControlFrames(t *testing.T) {
+	conn := dial(t, newEchoServer(t))
+
+	// A ping between two fragments is answered without breaking the message.
+	writeRaw(t, conn, false, TextMessage, []byte("frag"), true)
+	writeRaw(t, conn, true, PingMessage, []byte("p"), true)
+	writeRaw(t, conn, true, continuationFrame, []byte("mented"), true)
+
+	h, err := conn.readFrameHeader()
+	if err != nil || h.opcode != PongMessage {
+		t.Fatalf("expected pong, got opcode %d (%v)", h.opcode, err)
+	}
+	if p, _ := conn.readPayload(h); string(p) != "p" {
+		t.Errorf("pong payload = %q", p)
+	}
+	if _, msg, err := conn.ReadMessage(); err != nil || string(msg) != "fragmented" {
+		t.Fatalf("reassembled = %q %v", msg, err)
+	}
+}
+
+func TestLongPayloadLengths(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(BinaryMessage, bytes.Repeat([]byte{1}, 300))
+		conn.WriteMessage(BinaryMessage, bytes.Repeat([]byte{2}, 70000))
+	}))
+	defer srv.Close()
+
+	conn := dial(t, "ws"+strings.TrimPrefix(srv.URL, "http"))
+	for _, n := range []int{300, 70000} {
+		typ, msg, err := conn.ReadMessage()
+		if err != nil || typ != BinaryMessage || len(msg) != n {
+			t.Fatalf("want %d bytes, got %d (%v)", n, len(msg), err)
+		}
+	}
+}
+
+func TestProtocolViolations(t *testing.T) {
+	url := newEchoServer(t)
+
+	tests := []struct {
+		name string
+		send func(c *Conn)
+		code int
+	}{
+		{"unmasked frame", func(c *Conn) { writeRaw(t, c, true, TextMessage, []byte("x"), false) }, CloseProtocolError},
+		{"reserved bits", func(c *Conn) { c.conn.Write([]byte{0xc1, 0x80, 0, 0, 0, 0}) }, CloseProtocolError},
+		{"unknown opcode", func(c *Conn) { writeRaw(t, c, true, 3, nil, true) }, CloseProtocolError},
+		{"fragmented ping", func(c *Conn) { writeRaw(t, c, false, PingMessage, nil, true) }, CloseProtocolError},
+		{"stray continuation", func(c *Conn) { writeRaw(t, c, true, continuationFrame, []byte("x"), true) }, CloseProtocolError},
+		{"interleaved message", func(c *Conn) {
+			writeRaw(t, c, false, TextMessage, []byte("a"), true)
+			writeRaw(t, c, true, TextMessage, []byte("b"), true)
+		}, CloseProtocolError},
+		{"invalid UTF-8", func(c *Conn) { writeRaw(t, c, true, TextMessage, []byte{0xff, 0xfe}, true) }, CloseInvalidPayload},
+		{"too big", func(c *Conn) { writeRaw(t, c, true, BinaryMessage, make([]byte, 65), true) }, CloseMessageTooBig},
+		{"bad close code", func(c *Conn) { writeRaw(t, c, true, CloseMessage, []byte{0x03, 0xed}, true) }, CloseProtocolError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := dial(t, url)
+			tt.send(conn)
+			expectClose(t, conn, tt.code)
+		})
+	}
+}
+
+func TestUpgradeRejectsBadHandshakes(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if conn, err := Upgrade(w, r); err == nil {
+			conn.Close()
+		}
+	})
+
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		want    int
+	}{
+		{"plain GET", http.MethodGet, nil, http.StatusUpgradeRequired},
+		{"POST", http.MethodPost, nil, http.StatusMethodNotAllowed},
+		{"old version", http.MethodGet, map[string]string{"Connection": "keep-alive, Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "8", "Sec-WebSocket-Key": "dGhlIHNhbXBsZSBub25jZQ=="}, http.StatusUpgradeRequired},
+		{"short key", http.MethodGet, map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "13", "Sec-WebSocket-Key": "c2hvcnQ="}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/", nil)
+		for k, v := range tt.headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
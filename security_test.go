@@ -20,6 +20,7 @@ import (
 
 	"github.com/wanetty/upgopher/internal/handlers"
 	"github.com/wanetty/upgopher/internal/security"
+	"github.com/wanetty/upgopher/internal/storage"
 )
 
 // TestPathTraversalAttacks tests various path traversal attack vectors
@@ -113,10 +114,10 @@ func TestRecursiveDirectoryDeletion(t *testing.T) {
 
 	encodedPath := base64.StdEncoding.EncodeToString([]byte("testdir"))
 
-	fh := handlers.NewFileHandlers(tempDir, true, false, false, 0, &showHiddenFiles, &customPaths, &customPathsMutex)
+	fh := handlers.NewFileHandlers(tempDir, true, false, false, 0, &customPaths, &customPathsMutex)
 	handler := fh.Delete()
 
-	req := httptest.NewRequest("GET", "/delete/?path="+encodedPath, nil)
+	req := httptest.NewRequest("POST", "/delete/?path="+encodedPath, nil)
 	w := httptest.NewRecorder()
 
 	handler(w, req)
@@ -143,10 +144,10 @@ func TestDeleteEmptyDirectory(t *testing.T) {
 
 	encodedPath := base64.StdEncoding.EncodeToString([]byte("emptydir"))
 
-	fh := handlers.NewFileHandlers(tempDir, true, false, false, 0, &showHiddenFiles, &customPaths, &customPathsMutex)
+	fh := handlers.NewFileHandlers(tempDir, true, false, false, 0, &customPaths, &customPathsMutex)
 	handler := fh.Delete()
 
-	req := httptest.NewRequest("GET", "/delete/?path="+encodedPath, nil)
+	req := httptest.NewRequest("POST", "/delete/?path="+encodedPath, nil)
 	w := httptest.NewRecorder()
 	handler(w, req)
 
@@ -170,10 +171,10 @@ func TestDeleteDirectoryReadOnly(t *testing.T) {
 
 	encodedPath := base64.StdEncoding.EncodeToString([]byte("emptydir"))
 
-	fh := handlers.NewFileHandlers(tempDir, true, false, true, 0, &showHiddenFiles, &customPaths, &customPathsMutex)
+	fh := handlers.NewFileHandlers(tempDir, true, false, true, 0, &customPaths, &customPathsMutex)
 	handler := fh.Delete()
 
-	req := httptest.NewRequest("GET", "/delete/?path="+encodedPath, nil)
+	req := httptest.NewRequest("POST", "/delete/?path="+encodedPath, nil)
 	w := httptest.NewRecorder()
 	handler(w, req)
 
@@ -186,10 +187,38 @@ func TestDeleteDirectoryReadOnly(t *testing.T) {
 	}
 }
 
+// TestDeleteRequiresPost tests that a GET, which any page can trigger with a
+// link or an image, doesn't delete anything
+func TestDeleteRequiresPost(t *testing.T) {
+	tempDir := t.TempDir()
+
+	testFile := filepath.Join(tempDir, "keep.txt")
+	if err := os.WriteFile(testFile, []byte("test"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	encodedPath := base64.StdEncoding.EncodeToString([]byte("keep.txt"))
+
+	fh := handlers.NewFileHandlers(tempDir, true, false, false, 0, &customPaths, &customPathsMutex)
+	handler := fh.Delete()
+
+	req := httptest.NewRequest("GET", "/delete/?path="+encodedPath, nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "POST, DELETE" {
+		t.Errorf("Expected 405 with Allow: POST, DELETE, got %d %q", w.Code, w.Header().Get("Allow"))
+	}
+
+	if _, err := os.Stat(testFile); err != nil {
+		t.Error("File was deleted by a GET request!")
+	}
+}
+
 // TestMkdirHandler tests the Mkdir handler
 func TestMkdirHandler(t *testing.T) {
 	tempDir := t.TempDir()
-	fh := handlers.NewFileHandlers(tempDir, true, false, false, 0, &showHiddenFiles, &customPaths, &customPathsMutex)
+	fh := handlers.NewFileHandlers(tempDir, true, false, false, 0, &customPaths, &customPathsMutex)
 	handler := fh.Mkdir()
 
 	tests := []struct {
@@ -275,7 +304,7 @@ func TestMkdirHandler(t *testing.T) {
 // TestMkdirReadOnly tests that Mkdir is blocked in readonly mode
 func TestMkdirReadOnly(t *testing.T) {
 	tempDir := t.TempDir()
-	fh := handlers.NewFileHandlers(tempDir, true, false, true, 0, &showHiddenFiles, &customPaths, &customPathsMutex)
+	fh := handlers.NewFileHandlers(tempDir, true, false, true, 0, &customPaths, &customPathsMutex)
 	handler := fh.Mkdir()
 
 	body := "folderName=test-folder&currentPath="
@@ -297,7 +326,7 @@ func TestMkdirReadOnly(t *testing.T) {
 // TestMkdirPathTraversal tests path traversal attacks via currentPath
 func TestMkdirPathTraversal(t *testing.T) {
 	tempDir := t.TempDir()
-	fh := handlers.NewFileHandlers(tempDir, true, false, false, 0, &showHiddenFiles, &customPaths, &customPathsMutex)
+	fh := handlers.NewFileHandlers(tempDir, true, false, false, 0, &customPaths, &customPathsMutex)
 	handler := fh.Mkdir()
 
 	attacks := []string{
@@ -338,7 +367,7 @@ func TestMkdirDuplicate(t *testing.T) {
 		t.Fatalf("Failed to create existing dir: %v", err)
 	}
 
-	fh := handlers.NewFileHandlers(tempDir, true, false, false, 0, &showHiddenFiles, &customPaths, &customPathsMutex)
+	fh := handlers.NewFileHandlers(tempDir, true, false, false, 0, &customPaths, &customPathsMutex)
 	handler := fh.Mkdir()
 
 	body := "folderName=existing&currentPath="
@@ -356,7 +385,7 @@ func TestMkdirDuplicate(t *testing.T) {
 // TestMkdirMethodNotAllowed tests that GET requests are rejected
 func TestMkdirMethodNotAllowed(t *testing.T) {
 	tempDir := t.TempDir()
-	fh := handlers.NewFileHandlers(tempDir, true, false, false, 0, &showHiddenFiles, &customPaths, &customPathsMutex)
+	fh := handlers.NewFileHandlers(tempDir, true, false, false, 0, &customPaths, &customPathsMutex)
 	handler := fh.Mkdir()
 
 	req := httptest.NewRequest("GET", "/mkdir?folderName=test", nil)
@@ -372,13 +401,14 @@ func TestMkdirMethodNotAllowed(t *testing.T) {
 func TestRateLimitingSequential(t *testing.T) {
 	// Use unique IP for this test
 	testIP := "sequential.test.192.168.1.50"
+	limiter := security.NewRateLimiter(nil)
 
 	successCount := 0
 	blockedCount := 0
 
 	// Send 25 sequential requests (should allow 20, block 5)
 	for i := 0; i < 25; i++ {
-		allowed := security.CheckRateLimit(testIP)
+		allowed := limiter.Allow(security.PolicyClipboardWrite, testIP).Allowed
 		if allowed {
 			successCount++
 		} else {
@@ -470,7 +500,7 @@ func TestRawHandlerPathSecurity(t *testing.T) {
 		t.Fatalf("Failed to create test file: %v", err)
 	}
 
-	fh := handlers.NewFileHandlers(tempDir, true, false, false, 0, &showHiddenFiles, &customPaths, &customPathsMutex)
+	fh := handlers.NewFileHandlers(tempDir, true, false, false, 0, &customPaths, &customPathsMutex)
 	handler := fh.Raw()
 
 	attacks := []string{
@@ -501,6 +531,163 @@ func TestRawHandlerPathSecurity(t *testing.T) {
 	}
 }
 
+// TestRawHandlerActiveContent tests that uploaded pages can't run scripts
+// with the app's origin
+func TestRawHandlerActiveContent(t *testing.T) {
+	tempDir := t.TempDir()
+	files := map[string]string{
+		"notes.txt": "plain text",
+		"page.html": "<script>alert(1)</script>",
+		"logo.svg":  `<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`,
+		"noext":     "<html><script>alert(1)</script></html>",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tempDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	fh := handlers.NewFileHandlers(tempDir, true, false, false, 0, &customPaths, &customPathsMutex)
+	serve := func(host, name string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://"+host+"/raw/"+name, nil)
+		w := httptest.NewRecorder()
+		fh.Raw()(w, req)
+		return w
+	}
+
+	for name := range files {
+		w := serve("files.lan", name)
+		if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Security-Policy"), "sandbox;") {
+			t.Errorf("%s: got %d with CSP %q, want 200 sandboxed", name, w.Code, w.Header().Get("Content-Security-Policy"))
+		}
+		download := strings.HasPrefix(w.Header().Get("Content-Disposition"), "attachment")
+		if want := name != "notes.txt"; download != want {
+			t.Errorf("%s: downloaded = %v, want %v", name, download, want)
+		}
+	}
+	if ct := serve("files.lan", "noext").Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("noext Content-Type = %q, want the sniffed text/html", ct)
+	}
+
+	fh.RawOrigin = "https://raw.files.lan"
+	w := serve("files.lan", "page.html")
+	if w.Code != http.StatusTemporaryRedirect || w.Header().Get("Location") != "https://raw.files.lan/raw/page.html" {
+		t.Errorf("Active file on the app origin: got %d to %q, want a redirect to the raw origin", w.Code, w.Header().Get("Location"))
+	}
+	w = serve("raw.files.lan", "page.html")
+	csp := w.Header().Get("Content-Security-Policy")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Disposition") != "" || !strings.Contains(csp, "allow-scripts") || strings.Contains(csp, "allow-same-origin") {
+		t.Errorf("Active file on the raw origin: got %d, Content-Disposition %q, CSP %q", w.Code, w.Header().Get("Content-Disposition"), csp)
+	}
+	if w := serve("files.lan", "notes.txt"); w.Code != http.StatusOK {
+		t.Errorf("Plain file with a raw origin: got %d, want 200", w.Code)
+	}
+}
+
+// TestSymlinkEscape tests that links out of the shared directory can't be
+// followed by default, in handlers and zip archives alike
+func TestSymlinkEscape(t *testing.T) {
+	base := t.TempDir()
+	tempDir := filepath.Join(base, "share")
+	outside := filepath.Join(base, "outside")
+	for _, dir := range []string{filepath.Join(tempDir, "docs"), outside} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create test directory: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "docs", "a.txt"), []byte("inside"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("root:x:0:0"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	for link, target := range map[string]string{
+		"escape":     outside,
+		"secret.txt": filepath.Join(outside, "secret.txt"),
+		"inside":     "docs",
+		"docs/up":    "..",
+		"nested":     "inside",
+	} {
+		if err := os.Symlink(target, filepath.Join(tempDir, link)); err != nil {
+			t.Skipf("symlinks not supported: %v", err)
+		}
+	}
+
+	fh := handlers.NewFileHandlers(tempDir, true, false, false, 0, &customPaths, &customPathsMutex)
+	get := func(handler http.HandlerFunc, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", target, nil))
+		return w
+	}
+	encode := func(p string) string { return url.QueryEscape(base64.StdEncoding.EncodeToString([]byte(p))) }
+
+	for _, target := range []string{"/raw/escape/secret.txt", "/raw/secret.txt"} {
+		if w := get(fh.Raw(), target); w.Code != http.StatusForbidden {
+			t.Errorf("Raw %s: got %d, want 403", target, w.Code)
+		}
+	}
+	if w := get(fh.Download(), "/download/?path="+encode("secret.txt")); w.Code != http.StatusForbidden {
+		t.Errorf("Download through an escaping link: got %d, want 403", w.Code)
+	}
+	if w := get(fh.Raw(), "/raw/inside/a.txt"); w.Code != http.StatusOK || w.Body.String() != "inside" {
+		t.Errorf("Raw through a link inside the share: got %d %q", w.Code, w.Body.String())
+	}
+	if w := get(fh.Tree(), "/api/v1/tree?path="+encode("escape")); w.Code != http.StatusForbidden {
+		t.Errorf("Tree through an escaping link: got %d, want 403", w.Code)
+	}
+
+	w := get(fh.ListFiles(), "/api/v1/files")
+	if strings.Contains(w.Body.String(), `"escape"`) || !strings.Contains(w.Body.String(), `"inside"`) {
+		t.Errorf("Listing should hide escaping links and show the others: %s", w.Body.String())
+	}
+
+	// The archive follows links inside the share, including nested ones,
+	// skips the loop back to the root and leaves out the escaping links.
+	w = get(fh.Zip(), "/zip")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Zip: got %d: %s", w.Code, w.Body.String())
+	}
+	entries := readZipEntries(t, w.Body.Bytes())
+	for _, name := range []string{"docs/a.txt", "inside/a.txt", "nested/a.txt"} {
+		if entries[name] != 1 {
+			t.Errorf("Zip is missing %s: %v", name, entries)
+		}
+	}
+	for name := range entries {
+		if strings.Contains(name, "secret") || strings.HasPrefix(name, "escape") || strings.HasPrefix(name, "docs/up/") {
+			t.Errorf("Zip contains %s", name)
+		}
+	}
+
+	fh.Storage = storage.NewLocal(tempDir, security.SymlinksDeny)
+	if w := get(fh.Raw(), "/raw/inside/a.txt"); w.Code != http.StatusForbidden {
+		t.Errorf("Raw through a link with links denied: got %d, want 403", w.Code)
+	}
+	entries = readZipEntries(t, get(fh.Zip(), "/zip").Body.Bytes())
+	if entries["docs/a.txt"] != 1 || entries["inside/a.txt"] != 0 || entries["nested/a.txt"] != 0 {
+		t.Errorf("Zip with links denied: %v", entries)
+	}
+
+	// Deleting an escaping link removes the link, not its target.
+	req := httptest.NewRequest("POST", "/delete/?path="+encode("escape"), nil)
+	w = httptest.NewRecorder()
+	fh.Delete()(w, req)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("Delete of an escaping link: got %d: %s", w.Code, w.Body.String())
+	}
+	if _, err := os.Lstat(filepath.Join(tempDir, "escape")); !os.IsNotExist(err) {
+		t.Error("Link was not deleted")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "secret.txt")); err != nil {
+		t.Error("Deleting the link removed its target")
+	}
+
+	fh.Storage = storage.NewLocal(tempDir, security.SymlinksFollowAll)
+	if w := get(fh.Raw(), "/raw/secret.txt"); w.Code != http.StatusOK {
+		t.Errorf("Raw through an escaping link with follow-all: got %d, want 200", w.Code)
+	}
+}
+
 // TestClipboardRateLimitRecovery tests that rate limit resets after time window
 func TestClipboardRateLimitRecovery(t *testing.T) {
 	if testing.Short() {
@@ -508,14 +695,15 @@ func TestClipboardRateLimitRecovery(t *testing.T) {
 	}
 
 	testIP := "recovery.test.192.168.1.75"
+	limiter := security.NewRateLimiter(nil)
 
 	// Fill up the rate limit
 	for i := 0; i < 20; i++ {
-		security.CheckRateLimit(testIP)
+		limiter.Allow(security.PolicyClipboardWrite, testIP)
 	}
 
 	// 21st request should be blocked
-	if security.CheckRateLimit(testIP) {
+	if limiter.Allow(security.PolicyClipboardWrite, testIP).Allowed {
 		t.Error("Expected rate limit to be exhausted")
 	}
 
@@ -524,7 +712,7 @@ func TestClipboardRateLimitRecovery(t *testing.T) {
 	time.Sleep(65 * time.Second)
 
 	// Should be able to make requests again (?tab=default route)
-	if !security.CheckRateLimit(testIP) {
+	if !limiter.Allow(security.PolicyClipboardWrite, testIP).Allowed {
 		t.Error("Rate limit should have reset after time window")
 	}
 }
@@ -540,7 +728,7 @@ func TestSearchHandlerPathSecurity(t *testing.T) {
 		t.Fatalf("Failed to create test file: %v", err)
 	}
 
-	fh := handlers.NewFileHandlers(tempDir, true, false, false, 0, &showHiddenFiles, &customPaths, &customPathsMutex)
+	fh := handlers.NewFileHandlers(tempDir, true, false, false, 0, &customPaths, &customPathsMutex)
 	handler := fh.Search()
 
 	// Attempt path traversal via search
@@ -566,7 +754,7 @@ func TestSearchHandlerPathSecurity(t *testing.T) {
 // TestUploadPathTraversal tests that uploaded filenames cannot escape the upload directory
 func TestUploadPathTraversal(t *testing.T) {
 	tempDir := t.TempDir()
-	fh := handlers.NewFileHandlers(tempDir, true, false, false, 0, &showHiddenFiles, &customPaths, &customPathsMutex)
+	fh := handlers.NewFileHandlers(tempDir, true, false, false, 0, &customPaths, &customPathsMutex)
 	handler := fh.List()
 
 	maliciousName := "../../outside.txt"
@@ -598,7 +786,7 @@ func TestUploadPathTraversal(t *testing.T) {
 // TestZipPathTraversal tests that the zip endpoint cannot traverse outside the base directory
 func TestZipPathTraversal(t *testing.T) {
 	tempDir := t.TempDir()
-	fh := handlers.NewFileHandlers(tempDir, true, false, false, 0, &showHiddenFiles, &customPaths, &customPathsMutex)
+	fh := handlers.NewFileHandlers(tempDir, true, false, false, 0, &customPaths, &customPathsMutex)
 	handler := fh.Zip()
 
 	encodedMalicious := base64.StdEncoding.EncodeToString([]byte("../../"))
@@ -614,7 +802,7 @@ func TestZipPathTraversal(t *testing.T) {
 
 func TestZipSelectedInvalidPathEncoding(t *testing.T) {
 	tempDir := t.TempDir()
-	fh := handlers.NewFileHandlers(tempDir, true, false, false, 0, &showHiddenFiles, &customPaths, &customPathsMutex)
+	fh := handlers.NewFileHandlers(tempDir, true, false, false, 0, &customPaths, &customPathsMutex)
 	handler := fh.ZipSelected()
 
 	body, err := json.Marshal(map[string][]string{"paths": {"%%%"}})
@@ -635,7 +823,7 @@ func TestZipSelectedInvalidPathEncoding(t *testing.T) {
 
 func TestZipSelectedPathTraversal(t *testing.T) {
 	tempDir := t.TempDir()
-	fh := handlers.NewFileHandlers(tempDir, true, false, false, 0, &showHiddenFiles, &customPaths, &customPathsMutex)
+	fh := handlers.NewFileHandlers(tempDir, true, false, false, 0, &customPaths, &customPathsMutex)
 	handler := fh.ZipSelected()
 
 	malicious := base64.StdEncoding.EncodeToString([]byte("../../"))
@@ -682,7 +870,7 @@ func TestZipSelectedWithDirectoryAndDedup(t *testing.T) {
 	nestedFilePath := base64.StdEncoding.EncodeToString([]byte(filepath.ToSlash(filepath.Join("tree", "nested", "hello.txt"))))
 	standalonePath := base64.StdEncoding.EncodeToString([]byte("single.txt"))
 
-	fh := handlers.NewFileHandlers(tempDir, true, false, false, 0, &showHiddenFiles, &customPaths, &customPathsMutex)
+	fh := handlers.NewFileHandlers(tempDir, true, false, false, 0, &customPaths, &customPathsMutex)
 	handler := fh.ZipSelected()
 
 	body, err := json.Marshal(map[string][]string{"paths": {treePath, nestedFilePath, standalonePath}})
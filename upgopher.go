@@ -14,12 +14,19 @@ import (
 	"log"
 	"math"
 	"math/big"
+	"net"
 	"net/http"
 	"os"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/wanetty/upgopher/internal/handlers"
+	"github.com/wanetty/upgopher/internal/middleware"
+	"github.com/wanetty/upgopher/internal/security"
 	"github.com/wanetty/upgopher/internal/server"
+	"github.com/wanetty/upgopher/internal/storage"
 )
 
 //go:embed static/favicon.ico
@@ -30,17 +37,59 @@ var logo embed.FS
 
 var quiet bool = false
 var version = "1.19.1"
-var showHiddenFiles bool = false
 var disableHiddenFiles bool = false
 var readOnly bool = false
 
 var customPaths = make(map[string]string) // map[originalPath]customPath
 var customPathsMutex sync.RWMutex         // protects customPaths from concurrent access
 
+// listenFlag collects -listen values. The flag may be repeated and each value
+// may hold several comma-separated addresses.
+type listenFlag []string
+
+func (l *listenFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listenFlag) Set(value string) error {
+	for _, addr := range strings.Split(value, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			*l = append(*l, addr)
+		}
+	}
+	return nil
+}
+
+// rateLimitFlag collects -rate-limit policies. The flag may be repeated; a
+// later value for the same policy replaces an earlier one.
+type rateLimitFlag map[string]security.RateLimitPolicy
+
+func (f rateLimitFlag) String() string {
+	var parts []string
+	for name, p := range f {
+		parts = append(parts, name+"="+p.String())
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, " ")
+}
+
+func (f rateLimitFlag) Set(value string) error {
+	name, p, err := security.ParseRateLimitPolicy(value)
+	if err != nil {
+		return err
+	}
+	f[name] = p
+	return nil
+}
+
 // main initializes the server configuration and starts listening.
 func main() {
 	port := flag.Int("port", 9090, "port number")
-	dir := flag.String("dir", "./uploads", "directory path")
+	dir := flag.String("dir", "./uploads", "directory path, or a .zip, .tar, .tar.gz or .tgz archive to serve read-only")
+	s3Bucket := flag.String("s3-bucket", "", "serve files from this S3 bucket instead of -dir, with credentials from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN")
+	s3Endpoint := flag.String("s3-endpoint", "", "S3 API endpoint as scheme://host[:port], such as http://localhost:9000 for MinIO (default https://s3.<region>.amazonaws.com)")
+	s3Region := flag.String("s3-region", "us-east-1", "region of the S3 bucket")
+	s3Prefix := flag.String("s3-prefix", "", "key prefix to serve in the S3 bucket, such as team/ (default the whole bucket)")
 	user := flag.String("user", "", "username for authentication")
 	pass := flag.String("pass", "", "password for authentication")
 	useTLS := flag.Bool("ssl", false, "use HTTPS on port 443 by default. (If you don't put cert and key, it will generate a self-signed certificate)")
@@ -49,11 +98,32 @@ func main() {
 	quietarg := flag.Bool("q", false, "quiet mode")
 	disableHiddenFilesarg := flag.Bool("disable-hidden-files", false, "disable showing hidden files")
 	readOnlyarg := flag.Bool("readonly", false, "readonly mode (disable upload and delete operations)")
+	symlinks := flag.String("symlinks", "follow-within-root", "which symbolic links in -dir to follow: deny, follow-within-root (only those pointing inside -dir) or follow-all")
 	maxTabs := flag.Int("max-tabs", 10, "maximum number of shared clipboard tabs")
+	historyLimit := flag.Int("clipboard-history", 20, "previous versions kept per shared clipboard tab (0 disables history)")
+	historyMaxAge := flag.Duration("clipboard-history-age", 24*time.Hour, "drop clipboard versions replaced longer ago than this (0 means no age limit)")
+	stateDir := flag.String("state-dir", "", "directory to persist shared clipboard tabs and screenshots across restarts (disabled when empty)")
+	screenshotLimit := flag.Int("screenshot-limit", 50, "screenshots kept per clipboard tab; the oldest is dropped first")
+	screenshotMaxSizeMB := flag.Int64("screenshot-max-size", 16, "maximum size of a screenshot in MB")
+	screenshotTotalMB := flag.Int64("screenshot-total-size", 800, "maximum size of all screenshots together in MB; the oldest are dropped first (0 means unlimited)")
+	screenshotMaxAge := flag.Duration("screenshot-age", 0, "drop screenshots taken longer ago than this (0 means no age limit)")
 	maxUploadSizeGB := flag.Int64("max-upload-size", 0, "maximum upload size in GB (0 means unlimited)")
+	minFreeSpaceMB := flag.Int64("min-free-space", 0, "minimum free space in MB on the -dir filesystem for /readyz to report ready (0 disables the check)")
 	readTimeout := flag.Duration("read-timeout", 0, "server read timeout (0 means unlimited)")
 	readHeaderTimeout := flag.Duration("read-header-timeout", 10*time.Second, "server read header timeout")
 	writeTimeout := flag.Duration("write-timeout", 0, "server write timeout (0 means unlimited)")
+	var listenAddrs listenFlag
+	flag.Var(&listenAddrs, "listen", "address to listen on, repeatable or comma-separated: host:port, [ipv6]:port or unix:/path.sock, optionally prefixed with http:// or https:// (overrides -port)")
+	httpsRedirect := flag.Bool("https-redirect", false, "redirect requests on plain HTTP TCP listeners to the first HTTPS listener")
+	enableMetrics := flag.Bool("metrics", false, "expose Prometheus metrics on /metrics")
+	rateLimits := rateLimitFlag{}
+	flag.Var(rateLimits, "rate-limit", "rate limit policy as name=limit/period[,burst=n] or name=off, repeatable; policies: clipboard-write, screenshot-upload, upload, auth-failure, collab-op (defaults: 20/1m, 20/1m, 60/1m with burst 30, 10/1m, 600/1m with burst 120)")
+	corsOrigins := flag.String("cors-origins", "", "comma-separated origins (scheme://host[:port], or * for any) whose pages may call the API from a browser (default same-origin only)")
+	corsCredentials := flag.Bool("cors-credentials", false, "let -cors-origins pages send basic auth credentials (never applies to *)")
+	corsMaxAge := flag.Duration("cors-max-age", 10*time.Minute, "how long browsers may cache CORS preflight responses (0 disables caching)")
+	hstsMaxAge := flag.Duration("hsts-max-age", 180*24*time.Hour, "Strict-Transport-Security max-age sent over HTTPS with a -cert certificate, never with a self-signed one (0 disables it)")
+	rawOrigin := flag.String("raw-origin", "", "separate origin (scheme://host[:port]) pointing at this server that renders HTML, SVG and other active files from /raw/ (by default they are downloaded)")
+	basePathArg := flag.String("base-path", "", "URL path prefix to serve the app under when running behind a reverse proxy (e.g. /files)")
 	flag.Parse()
 	quiet = *quietarg
 	readOnly = *readOnlyarg
@@ -62,6 +132,88 @@ func main() {
 		log.Fatalf("max-upload-size must be >= 0")
 	}
 
+	if *historyLimit < 0 || *historyMaxAge < 0 {
+		log.Fatalf("clipboard-history and clipboard-history-age must be >= 0")
+	}
+
+	if *screenshotLimit < 1 || *screenshotMaxSizeMB < 1 {
+		log.Fatalf("screenshot-limit and screenshot-max-size must be >= 1")
+	}
+	if *screenshotTotalMB < 0 || *screenshotMaxAge < 0 {
+		log.Fatalf("screenshot-total-size and screenshot-age must be >= 0")
+	}
+	const oneMiB int64 = 1024 * 1024
+	if *screenshotMaxSizeMB > math.MaxInt64/oneMiB || *screenshotTotalMB > math.MaxInt64/oneMiB {
+		log.Fatalf("screenshot-max-size and screenshot-total-size are too large")
+	}
+	screenshotLimits := handlers.ScreenshotLimits{
+		MaxCount: *screenshotLimit,
+		MaxSize:  *screenshotMaxSizeMB * oneMiB,
+		MaxBytes: *screenshotTotalMB * oneMiB,
+		MaxAge:   *screenshotMaxAge,
+	}
+
+	origins, err := middleware.ParseCORSOrigins(*corsOrigins)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if *corsMaxAge < 0 {
+		log.Fatalf("cors-max-age must be >= 0")
+	}
+	cors := middleware.CORSConfig{
+		Origins:          origins,
+		AllowCredentials: *corsCredentials,
+		MaxAge:           *corsMaxAge,
+	}
+
+	symlinkPolicy, err := security.ParseSymlinkPolicy(*symlinks)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	var share storage.Storage = storage.NewLocal(*dir, symlinkPolicy)
+	if *s3Bucket != "" {
+		endpoint := *s3Endpoint
+		if endpoint == "" {
+			endpoint = "https://s3." + *s3Region + ".amazonaws.com"
+		}
+		share, err = storage.NewS3(storage.S3Config{
+			Endpoint:     endpoint,
+			Region:       *s3Region,
+			Bucket:       *s3Bucket,
+			Prefix:       *s3Prefix,
+			AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		})
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+	} else if info, err := os.Stat(*dir); storage.IsArchive(*dir) && (err != nil || !info.IsDir()) {
+		// An archive is served as it is, so it can't be written to.
+		if share, err = storage.NewArchive(*dir); err != nil {
+			log.Fatalf("%v", err)
+		}
+		readOnly = true
+	}
+	_, localShare := share.(*storage.Local)
+
+	if *hstsMaxAge < 0 {
+		log.Fatalf("hsts-max-age must be >= 0")
+	}
+	if *rawOrigin != "" {
+		if _, err := middleware.ParseRawOrigin(*rawOrigin); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+
+	if *minFreeSpaceMB < 0 {
+		log.Fatalf("min-free-space must be >= 0")
+	}
+	if *minFreeSpaceMB > 0 && !localShare {
+		log.Fatalf("min-free-space only applies to a -dir directory, not to an archive or s3-bucket")
+	}
+	minFreeBytes := uint64(*minFreeSpaceMB) * 1024 * 1024
+
 	const oneGiB int64 = 1024 * 1024 * 1024
 	var maxUploadSizeBytes int64
 	if *maxUploadSizeGB > 0 {
@@ -76,12 +228,23 @@ func main() {
 		if readOnly {
 			log.Printf("Running in READONLY mode - uploads and deletions are disabled")
 		}
+		if *s3Bucket != "" {
+			log.Printf("Serving files from S3 bucket %s", *s3Bucket)
+		} else if !localShare {
+			log.Printf("Serving files from archive %s", *dir)
+		}
 	}
 
-	if _, err := os.Stat(*dir); os.IsNotExist(err) {
+	if _, err := os.Stat(*dir); os.IsNotExist(err) && localShare {
 		os.MkdirAll(*dir, 0755)
 	}
 
+	if *stateDir != "" {
+		if err := os.MkdirAll(*stateDir, 0700); err != nil {
+			log.Fatalf("Unable to create state directory: %v", err)
+		}
+	}
+
 	if (*user != "" && *pass == "") || (*user == "" && *pass != "") {
 		log.Fatalf("If you use the username or password you have to use both.")
 		return
@@ -90,73 +253,109 @@ func main() {
 		disableHiddenFiles = true
 	}
 
-	// Setup all routes using centralized router
-	server.SetupRoutes(
-		*dir,
-		*user,
-		*pass,
-		quiet,
-		disableHiddenFiles,
-		readOnly,
-		*maxTabs,
-		maxUploadSizeBytes,
-		&showHiddenFiles,
-		&customPaths,
-		&customPathsMutex,
-		&favicon,
-		&logo,
-	)
+	basePath, err := server.NormalizeBasePath(*basePathArg)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
 
 	if !isFlagPassed("port") && *useTLS {
 		*port = 443
 	}
-	addr := fmt.Sprintf("0.0.0.0:%d", *port)
-	startServer(addr, *useTLS, *certFile, *keyFile, *readTimeout, *readHeaderTimeout, *writeTimeout)
-}
+	if len(listenAddrs) == 0 {
+		listenAddrs = listenFlag{fmt.Sprintf("0.0.0.0:%d", *port)}
+	}
 
-func startServer(addr string, useTLS bool, certFile, keyFile string, readTimeout time.Duration, readHeaderTimeout time.Duration, writeTimeout time.Duration) {
-	if useTLS {
-		var cert tls.Certificate
-		var err error
+	listeners := make([]server.Listener, 0, len(listenAddrs))
+	var tlsCert *tls.Certificate
+	for _, addr := range listenAddrs {
+		l, err := server.ParseListener(addr, *useTLS)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		if l.TLS && tlsCert == nil {
+			cert := loadCertificate(*certFile, *keyFile)
+			tlsCert = &cert
+		}
+		listeners = append(listeners, l)
+	}
+	// Browsers offer no way past a certificate warning on an HSTS host, so a
+	// self-signed certificate would lock visitors out.
+	if *certFile == "" || *keyFile == "" {
+		*hstsMaxAge = 0
+	}
 
-		if certFile != "" && keyFile != "" {
-			cert, err = tls.LoadX509KeyPair(certFile, keyFile)
-			if err != nil {
-				log.Fatalf("Failed to load certificate and key pair: %v", err)
-			}
-		} else {
-			log.Println("No certificate or key file provided, generating a self-signed certificate.")
-			certPEM, keyPEM, err := generateSelfSignedCert()
-			if err != nil {
-				log.Fatalf("Failed to generate self-signed certificate: %v", err)
-			}
+	// Setup all routes using centralized router
+	err = server.SetupRoutes(server.Config{
+		Dir:                *dir,
+		Share:              share,
+		BasePath:           basePath,
+		User:               *user,
+		Pass:               *pass,
+		Quiet:              quiet,
+		DisableHiddenFiles: disableHiddenFiles,
+		ReadOnly:           readOnly,
+		MaxUploadSize:      maxUploadSizeBytes,
+		MinFreeBytes:       minFreeBytes,
+		MaxTabs:            *maxTabs,
+		StateDir:           *stateDir,
+		HistoryLimit:       *historyLimit,
+		HistoryMaxAge:      *historyMaxAge,
+		ScreenshotLimits:   screenshotLimits,
+		RateLimits:         rateLimits,
+		CORS:               cors,
+		HSTSMaxAge:         *hstsMaxAge,
+		RawOrigin:          *rawOrigin,
+		EnableMetrics:      *enableMetrics,
+		TLSCert:            tlsCert,
+		CustomPaths:        &customPaths,
+		CustomPathsMutex:   &customPathsMutex,
+		FaviconFS:          &favicon,
+		LogoFS:             &logo,
+	})
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
 
-			cert, err = tls.X509KeyPair(certPEM, keyPEM)
-			if err != nil {
-				log.Fatalf("Failed to create key pair from generated self-signed certificate: %v", err)
-			}
-		}
+	startServers(listeners, tlsCert, *httpsRedirect, *readTimeout, *readHeaderTimeout, *writeTimeout)
+}
 
-		server := &http.Server{
-			Addr: addr,
-			TLSConfig: &tls.Config{
-				Certificates: []tls.Certificate{cert},
-			},
-			ReadTimeout:       readTimeout,
-			ReadHeaderTimeout: readHeaderTimeout,
-			WriteTimeout:      writeTimeout,
-			IdleTimeout:       120 * time.Second,
+// startServers opens every listener up front, so a bad address fails before
+// anything is served, then serves all of them concurrently. The first serve
+// error terminates the process.
+func startServers(listeners []server.Listener, tlsCert *tls.Certificate, httpsRedirect bool, readTimeout time.Duration, readHeaderTimeout time.Duration, writeTimeout time.Duration) {
+	var tlsConfig *tls.Config
+	if tlsCert != nil {
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{*tlsCert}}
+	}
+	var redirectPort string
+	hasPlainTCP := false
+	for _, l := range listeners {
+		if l.TLS && l.Network == "tcp" && redirectPort == "" {
+			redirectPort = l.Port()
+		}
+		if !l.TLS && l.Network == "tcp" {
+			hasPlainTCP = true
 		}
+	}
+	if httpsRedirect && (redirectPort == "" || !hasPlainTCP) {
+		log.Fatalf("-https-redirect needs at least one plain HTTP and one HTTPS TCP listener")
+	}
 
-		if !quiet {
-			log.Printf("[%s] Starting HTTPS server on %s", time.Now().Format("2006-01-02 15:04:05"), addr)
+	errCh := make(chan error, len(listeners))
+	for _, l := range listeners {
+		ln, err := l.Listen()
+		if err != nil {
+			log.Fatalf("Error listening on %s: %v", l, err)
 		}
-		if err := server.ListenAndServeTLS("", ""); err != nil {
-			log.Fatalf("Error starting HTTPS server: %v", err)
+
+		var handler http.Handler // nil means http.DefaultServeMux
+		if httpsRedirect && !l.TLS && l.Network == "tcp" {
+			handler = server.HTTPSRedirect(redirectPort)
 		}
-	} else {
-		server := &http.Server{
-			Addr:              addr,
+
+		srv := &http.Server{
+			Handler:           handler,
+			TLSConfig:         tlsConfig,
 			ReadTimeout:       readTimeout,
 			ReadHeaderTimeout: readHeaderTimeout,
 			WriteTimeout:      writeTimeout,
@@ -164,12 +363,53 @@ func startServer(addr string, useTLS bool, certFile, keyFile string, readTimeout
 		}
 
 		if !quiet {
-			log.Printf("[%s] Starting HTTP server on %s", time.Now().Format("2006-01-02 15:04:05"), addr)
+			proto := "HTTP"
+			if l.TLS {
+				proto = "HTTPS"
+			}
+			if handler != nil {
+				log.Printf("[%s] Starting HTTP->HTTPS redirect on %s", time.Now().Format("2006-01-02 15:04:05"), l)
+			} else {
+				log.Printf("[%s] Starting %s server on %s", time.Now().Format("2006-01-02 15:04:05"), proto, l)
+			}
 		}
-		if err := server.ListenAndServe(); err != nil {
-			log.Fatalf("Error starting HTTP server: %v", err)
+
+		go func(l server.Listener, ln net.Listener, srv *http.Server) {
+			var err error
+			if l.TLS {
+				err = srv.ServeTLS(ln, "", "")
+			} else {
+				err = srv.Serve(ln)
+			}
+			errCh <- fmt.Errorf("error serving %s: %v", l, err)
+		}(l, ln, srv)
+	}
+
+	log.Fatalf("%v", <-errCh)
+}
+
+// loadCertificate loads the configured key pair, or generates a self-signed
+// certificate when none is provided.
+func loadCertificate(certFile, keyFile string) tls.Certificate {
+	if certFile != "" && keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			log.Fatalf("Failed to load certificate and key pair: %v", err)
 		}
+		return cert
+	}
+
+	log.Println("No certificate or key file provided, generating a self-signed certificate.")
+	certPEM, keyPEM, err := generateSelfSignedCert()
+	if err != nil {
+		log.Fatalf("Failed to generate self-signed certificate: %v", err)
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		log.Fatalf("Failed to create key pair from generated self-signed certificate: %v", err)
 	}
+	return cert
 }
 
 func generateSelfSignedCert() ([]byte, []byte, error) {
@@ -8,6 +8,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/wanetty/upgopher/internal/handlers"
@@ -209,20 +210,21 @@ func TestBase64PathEncoding(t *testing.T) {
 	}
 }
 
-// TestCheckRateLimit tests the rate limiting functionality
-func TestCheckRateLimit(t *testing.T) {
+// TestClipboardWriteRateLimit tests the default clipboard write policy
+func TestClipboardWriteRateLimit(t *testing.T) {
 	// Use unique IP for this test to avoid interference
 	ip := "test.192.168.1.100"
+	limiter := security.NewRateLimiter(nil)
 
 	// First 20 requests should pass
 	for i := 0; i < 20; i++ {
-		if !security.CheckRateLimit(ip) {
+		if !limiter.Allow(security.PolicyClipboardWrite, ip).Allowed {
 			t.Errorf("Request %d should be allowed", i+1)
 		}
 	}
 
 	// 21st request should be blocked
-	if security.CheckRateLimit(ip) {
+	if limiter.Allow(security.PolicyClipboardWrite, ip).Allowed {
 		t.Error("Request 21 should be blocked by rate limit")
 	}
 }
@@ -282,7 +284,7 @@ func TestFileUpload(t *testing.T) {
 	tempDir := t.TempDir()
 
 	// Create FileHandlers instance
-	fh := handlers.NewFileHandlers(tempDir, true, false, false, 0, &showHiddenFiles, &customPaths, &customPathsMutex)
+	fh := handlers.NewFileHandlers(tempDir, true, false, false, 0, &customPaths, &customPathsMutex)
 	handler := fh.List()
 
 	// Create a test file to upload
@@ -342,7 +344,7 @@ func TestFileUploadMaxSizeExceeded(t *testing.T) {
 	tempDir := t.TempDir()
 
 	const maxUploadSize int64 = 8
-	fh := handlers.NewFileHandlers(tempDir, true, false, false, maxUploadSize, &showHiddenFiles, &customPaths, &customPathsMutex)
+	fh := handlers.NewFileHandlers(tempDir, true, false, false, maxUploadSize, &customPaths, &customPathsMutex)
 	handler := fh.List()
 
 	fileContent := []byte("this-content-is-too-large")
@@ -384,7 +386,7 @@ func TestFileUploadMaxSizeExceeded(t *testing.T) {
 func TestFileUploadSubdirectory(t *testing.T) {
 	tempDir := t.TempDir()
 
-	fh := handlers.NewFileHandlers(tempDir, true, false, false, 0, &showHiddenFiles, &customPaths, &customPathsMutex)
+	fh := handlers.NewFileHandlers(tempDir, true, false, false, 0, &customPaths, &customPathsMutex)
 	handler := fh.List()
 
 	fileContent := []byte("nested file content")
@@ -432,7 +434,7 @@ func TestFileUploadSubdirectory(t *testing.T) {
 func TestFileUploadEmptyDirectory(t *testing.T) {
 	tempDir := t.TempDir()
 
-	fh := handlers.NewFileHandlers(tempDir, true, false, false, 0, &showHiddenFiles, &customPaths, &customPathsMutex)
+	fh := handlers.NewFileHandlers(tempDir, true, false, false, 0, &customPaths, &customPathsMutex)
 	handler := fh.List()
 
 	body := &bytes.Buffer{}
@@ -467,3 +469,45 @@ func TestFileUploadEmptyDirectory(t *testing.T) {
 		t.Error("Path exists but is not a directory")
 	}
 }
+
+// TestBasePathLinks tests that page links and redirects carry the -base-path prefix
+func TestBasePathLinks(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(tempDir, "docs"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "docs", "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	fh := handlers.NewFileHandlers(tempDir, true, false, false, 0, &customPaths, &customPathsMutex)
+	fh.BasePath = "/files"
+
+	req := httptest.NewRequest("GET", "/files/", nil)
+	w := httptest.NewRecorder()
+	fh.List()(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		`window.location.href='/files/zip'`,
+		`src="/files/static/logopher.webp"`,
+		`var BASE_PATH = "/files";`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Listing is missing %q", want)
+		}
+	}
+
+	filePath := base64.StdEncoding.EncodeToString([]byte("docs/a.txt"))
+	req = httptest.NewRequest("POST", "/delete/?path="+filePath, nil)
+	w = httptest.NewRecorder()
+	fh.Delete()(w, req)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("Expected 303 after delete, got %d", w.Code)
+	}
+	if got := w.Header().Get("Location"); !strings.HasPrefix(got, "/files/?path=") {
+		t.Errorf("Delete redirect = %q, want prefix /files/?path=", got)
+	}
+}